@@ -0,0 +1,101 @@
+package chatter
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AccessLog logs every request once it has been handled: its method, path, status, size,
+// how long it took, and where it came from (behind TrustedProxies, the client it was forwarded
+// for). A websocket upgrade is logged when it is done, with a status of 101, the connection
+// itself is logged when it closes (see readPump)
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start),
+			"remote_ip", clientIP(r),
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+// responseRecorder records the status and size of a response. It is still a Hijacker
+// (the websocket upgrade takes the connection over) and a Flusher (the event streams flush
+// every event), and unwraps for http.ResponseController
+type responseRecorder struct {
+	http.ResponseWriter
+	status int   // status written (0 until it is)
+	bytes  int64 // bytes of the body written
+}
+
+// WriteHeader records the status
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes what was written so far, if the writer can
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over, the upgrade then writes its 101 on it
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && rr.status == 0 {
+		rr.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the writer we record, for http.ResponseController
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// logClosed logs the end of the connection of the client, with how long it lasted
+// and how many frames (and bytes) went each way
+func (c *Client) logClosed() {
+	c.logger.Info("connection closed",
+		"duration", time.Since(c.connectedAt),
+		"received", c.received.Load(),
+		"sent", c.sent.Load(),
+		"bytes_in", c.bytesIn.Load(),
+		"bytes_out", c.bytesOut.Load(),
+		"dropped", c.dropped.Load(),
+	)
+}
@@ -0,0 +1,60 @@
+package chatter_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+func TestConnectionsUpgradeThroughTheAccessLog(t *testing.T) {
+	// the requests are logged with the default logger, the connections with the one of the rooms
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	manager := chatter.NewHubManager(time.Hour, chatter.WithLogger(logger))
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws", chatter.Handler(manager, nil, nil))
+	mux.Handle("GET /events", chatter.EventsHandler(manager, nil))
+	srv := &chattertest.Server{Server: httptest.NewServer(chatter.AccessLog(mux)), Manager: manager}
+	t.Cleanup(func() {
+		manager.Close(5 * time.Second)
+		srv.Close()
+	})
+
+	// the websocket connections are still upgraded, and so is the access log line
+	alice, _, err := srv.Dial(t, "/ws?name=alice", http.Header{"User-Agent": {"chatter-test"}})
+	if err != nil {
+		t.Fatalf("the upgrade failed through the access log: %v", err)
+	}
+	// the event streams are still flushed as they go
+	events := subscribe(t, srv, "bob", "")
+	alice.Send("hello")
+	alice.Expect("hello", waitTimeout)
+	expectEvent(t, events, "hello")
+
+	// the request is logged once the connection is closed, with how long it lasted
+	alice.Close()
+	line := logs.Find(t, "request", "path", "/ws", "status", float64(http.StatusSwitchingProtocols))
+	if line["method"] != http.MethodGet || line["remote_ip"] != "127.0.0.1" || line["user_agent"] != "chatter-test" {
+		t.Errorf("the upgrade was logged as %v", line)
+	}
+	line = logs.Find(t, "connection closed", "received", float64(1))
+	if line["duration"] == nil || line["sent"] == nil {
+		t.Errorf("the end of the connection was logged as %v", line)
+	}
+
+	if resp, err := http.Get(srv.URL + "/nowhere"); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+	logs.Find(t, "request", "path", "/nowhere", "status", float64(http.StatusNotFound))
+}
@@ -0,0 +1,34 @@
+package chatter
+
+import "strings"
+
+// maxRefLength is the longest reference a client can send a message with
+const maxRefLength = 64
+
+// messageRef returns the reference a client sent a message with, references that
+// are too long are ignored (the message is still sent, its ack just has no reference)
+func messageRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if len(ref) > maxRefLength {
+		return ""
+	}
+	return ref
+}
+
+// acknowledge lets the client that sent the message know it was accepted, with the id and
+// time the hub gave it and the reference the client sent it with (so the page can swap its
+// pending copy for the real one). Nobody else gets the ack, and messages that didn't come
+// from one of our clients (e.g. published over HTTP) aren't acknowledged
+func (h *Hub) acknowledge(msg *Message) {
+	if msg.Origin != "" {
+		return
+	}
+	sender, ok := h.ids[msg.ClientID]
+	if !ok {
+		return
+	}
+
+	if rendered := getAckTemplate(msg); rendered != nil {
+		h.deliver(sender, Frame{Data: rendered})
+	}
+}
@@ -0,0 +1,131 @@
+package chatter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminTokenHeader carries the token of the admin endpoints
+const AdminTokenHeader = "X-Admin-Token"
+
+// adminCookie keeps the admins signed in to the dashboard (see AdminLoginHandler)
+const adminCookie = "chatter_admin"
+
+// AdminAuth only lets the requests carrying the admin token through to next,
+// without a token the admin endpoints are disabled. Browsers signed in to the dashboard
+// send the admin cookie instead
+func AdminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(token, r) {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin tells whether the request carries the admin token, or the cookie made from it
+func isAdmin(token string, r *http.Request) bool {
+	if token == "" {
+		return false
+	}
+	// we compare in constant time so the token can't be guessed byte by byte
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(token)) == 1 {
+		return true
+	}
+	cookie, err := r.Cookie(adminCookie)
+	return err == nil && verifyAdminSession(token, cookie.Value)
+}
+
+// adminSessionTTL is how long the admin cookie keeps an admin signed in
+const adminSessionTTL = 12 * time.Hour
+
+// adminSession is a value of the admin cookie expiring at expires, signed with a key derived
+// from the token so the cookie doesn't hold the token itself and stops working when the
+// token changes. The nonce makes every sign in get a cookie of its own
+func adminSession(token string, expires time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(nonce)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(macOf(adminSessionKey(token), purposeAdmin, payload)), nil
+}
+
+// verifyAdminSession tells whether we signed the value of the admin cookie with the token
+// and it hasn't expired
+func verifyAdminSession(token, value string) bool {
+	at := strings.LastIndexByte(value, '.')
+	if at < 0 {
+		return false
+	}
+	payload, signature := value[:at], value[at+1:]
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sum, macOf(adminSessionKey(token), purposeAdmin, payload)) {
+		return false
+	}
+
+	expires, _, _ := strings.Cut(payload, ".")
+	seconds, err := strconv.ParseInt(expires, 10, 64)
+	return err == nil && time.Now().Before(time.Unix(seconds, 0))
+}
+
+// adminSessionKey returns the key the admin cookies are signed with, derived from the token
+func adminSessionKey(token string) []byte {
+	return deriveKey([]byte(token), purposeAdmin)
+}
+
+// adminKey is the context key marking the requests of an admin
+type adminKey struct{}
+
+// AdminIdentify marks the requests carrying the admin token as coming from an admin (e.g. so
+// they get into a full room) and lets every request through to next, with or without it
+func AdminIdentify(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdmin(token, r) {
+			r = r.WithContext(context.WithValue(r.Context(), adminKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAdmin tells whether the request comes from an admin (see AdminIdentify)
+func requestAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminKey{}).(bool)
+	return admin
+}
+
+// AdminLoginHandler handles POST /admin/login, the dashboard form sending the admin token:
+// the browser gets the admin cookie and goes back to the dashboard. It should be wrapped
+// in CSRF.Middleware, the admin endpoints then need the CSRF token of the browser
+func AdminLoginHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(token)) != 1 {
+			http.Redirect(w, r, "/admin?failed=1", http.StatusSeeOther)
+			return
+		}
+		expires := time.Now().Add(adminSessionTTL)
+		value, err := adminSession(token, expires)
+		if err != nil {
+			http.Error(w, "Could not sign in", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     adminCookie,
+			Value:    value,
+			Path:     "/",
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,75 @@
+package chatter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminCookies(t *testing.T) {
+	const token = "s3cret"
+
+	// signing in to the dashboard gives the browser the admin cookie, expiring
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	AdminLoginHandler(token).ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != adminCookie || cookies[0].Expires.IsZero() {
+		t.Fatalf("signing in gave the cookies %v", cookies)
+	}
+	signedIn := cookies[0].Value
+
+	sign := func(key []byte, data string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	expired, err := adminSession(token, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := adminSession("other", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, _ := strings.Cut(signedIn, ".")
+
+	tests := []struct {
+		name  string
+		value string
+		admin bool
+	}{
+		{"signed in", signedIn, true},
+		{"expired", expired, false},
+		{"another token", otherToken, false},
+		{"later expiry", "4102444800." + rest, false},
+		{"no signature", strings.Join(strings.Split(signedIn, ".")[:2], "."), false},
+		// the cookies the admins were given before never expired
+		{"fixed value", sign([]byte(token), "chatter admin session"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			req.AddCookie(&http.Cookie{Name: adminCookie, Value: tt.value})
+			if admin := isAdmin(token, req); admin != tt.admin {
+				t.Errorf("the cookie made an admin: %v, want %v", admin, tt.admin)
+			}
+		})
+	}
+
+	// every sign in gets a cookie of its own
+	again, err := adminSession(token, cookies[0].Expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == signedIn {
+		t.Error("signing in twice gave the same cookie")
+	}
+}
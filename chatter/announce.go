@@ -0,0 +1,171 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxAnnouncements is the number of announcements a room shows at once,
+// a new one pushes the oldest out
+const maxAnnouncements = 5
+
+// Announcement is a message of the server shown above the room (as well as in it) until
+// it expires, e.g. "restarting in 5 minutes"
+type Announcement struct {
+	ID      uint64    `json:"id"` // id of the message it was broadcast as
+	Room    string    `json:"room"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	Expires time.Time `json:"expires"` // zero if it stays until the room closes
+}
+
+// announceRequest asks the hub to broadcast an announcement, the result is sent on done
+type announceRequest struct {
+	text string
+	ttl  time.Duration // how long the announcement stays (0 until the room closes)
+	done chan *Announcement
+}
+
+// Announce broadcasts the announcement to the room, it stays above the room for ttl
+// (until the room closes if zero) and the clients joining in the meantime get it too.
+// It is recorded in the audit log as taken by actor
+func (h *Hub) Announce(actor, text string, ttl time.Duration) (*Announcement, error) {
+	req := &announceRequest{text: text, ttl: ttl, done: make(chan *Announcement, 1)}
+
+	select {
+	case h.announces <- req:
+	case <-h.stop:
+		err := errors.New("hub is shut down")
+		h.audit.record(actor, AuditAnnounce, h.room, "", text, err)
+		return nil, err
+	}
+	announcement := <-req.done
+	h.audit.record(actor, AuditAnnounce, h.room, "", text, nil)
+	return announcement, nil
+}
+
+// Announce broadcasts the announcement to every open room (see Hub.Announce)
+func (m *HubManager) Announce(actor, text string, ttl time.Duration) []*Announcement {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	list := []*Announcement{}
+	for _, hub := range hubs {
+		if announcement, err := hub.Announce(actor, text, ttl); err == nil {
+			list = append(list, announcement)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Room < list[j].Room })
+	return list
+}
+
+// announce handles an announce request on the hub goroutine: the announcement is broadcast
+// like any other message (so it is in the history) and every page gets the new banners
+func (h *Hub) announce(req *announceRequest) *Announcement {
+	msg := &Message{Kind: KindAnnouncement, Text: req.text}
+	h.broadcastMessage(msg)
+
+	announcement := &Announcement{ID: msg.ID, Room: h.room, Text: msg.Text, Time: msg.Timestamp}
+	if req.ttl > 0 {
+		announcement.Expires = msg.Timestamp.Add(req.ttl)
+	}
+	h.announced = append(h.announced, announcement)
+	if len(h.announced) > maxAnnouncements {
+		h.announced = slices.Delete(h.announced, 0, len(h.announced)-maxAnnouncements)
+	}
+
+	if rendered := h.renderAnnouncements(); rendered != nil {
+		h.sendAll(rendered, nil)
+	}
+	return announcement
+}
+
+// expireAnnouncements takes the announcements that expired down, every page gets the banners
+// without them. It is called on every sweep of the hub goroutine
+func (h *Hub) expireAnnouncements(now time.Time) {
+	active := slices.DeleteFunc(h.announced, func(a *Announcement) bool {
+		return !a.Expires.IsZero() && !now.Before(a.Expires)
+	})
+	if len(active) == len(h.announced) {
+		return
+	}
+	h.announced = active
+
+	if rendered := h.renderAnnouncements(); rendered != nil {
+		h.sendAll(rendered, nil)
+	}
+}
+
+// renderAnnouncements renders the banners of the announcements of the room, they replace the
+// ones the pages show. It must only be called from the hub goroutine
+func (h *Hub) renderAnnouncements() []byte {
+	return getAnnouncementsTemplate(h.announced)
+}
+
+// AnnounceRequest is the body of POST /admin/announce
+type AnnounceRequest struct {
+	Room    string `json:"room"`    // room to announce to (every open room if empty)
+	Text    string `json:"text"`    // what to announce
+	Expires string `json:"expires"` // how long it stays, e.g. "5m" (empty until the room closes)
+}
+
+// AnnounceHandler handles POST /admin/announce (with a JSON body or a form), it broadcasts
+// the announcement and answers with what was announced to each room.
+// It should be wrapped in AdminAuth
+func AnnounceHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &AnnounceRequest{}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Room = r.FormValue("room")
+			req.Text = r.FormValue("text")
+			req.Expires = r.FormValue("expires")
+		}
+		if req.Text = strings.TrimSpace(req.Text); req.Text == "" {
+			http.Error(w, "text is required", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if req.Expires != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.Expires); err != nil || ttl <= 0 {
+				http.Error(w, "expires must be a positive duration (e.g. 5m)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var announced []*Announcement
+		if room := strings.TrimSpace(req.Room); room != "" {
+			hub, err := manager.Get(room)
+			if err != nil {
+				serveRoomUnavailable(w, err)
+				return
+			}
+			announcement, err := hub.Announce(auditActor(r), req.Text, ttl)
+			if err != nil {
+				http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			announced = []*Announcement{announcement}
+		} else {
+			announced = manager.Announce(auditActor(r), req.Text, ttl)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(announced)
+	})
+}
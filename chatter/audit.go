@@ -0,0 +1,243 @@
+package chatter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAuditCapacity is the number of entries the audit log keeps in memory by default
+	DefaultAuditCapacity = 1000
+	// auditBuffer is the number of entries waiting to be written to the file, an entry that
+	// doesn't fit is only kept in memory
+	auditBuffer = 256
+	// auditPage and auditMaxPage are the default and largest pages of GET /admin/audit
+	auditPage    = 50
+	auditMaxPage = 500
+)
+
+// actions recorded in the audit log
+const (
+	AuditKick         = "kick"
+	AuditBan          = "ban"
+	AuditUnban        = "unban"
+	AuditMute         = "mute"
+	AuditUnmute       = "unmute"
+	AuditDelete       = "delete"
+	AuditFilterReload = "filter_reload"
+	AuditAnnounce     = "announce"
+	AuditReadOnly     = "read_only"
+	AuditDrain        = "drain"
+)
+
+// AuditEntry is an action of a moderator or an admin, recorded whether it worked or not
+type AuditEntry struct {
+	ID     uint64    `json:"id"`   // strictly increasing
+	Time   time.Time `json:"time"` // when the action was taken
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Room   string    `json:"room,omitempty"`
+	Target string    `json:"target,omitempty"` // who or what the action was taken on
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"` // why the action failed (empty if it didn't)
+}
+
+// AuditLog keeps the moderation and admin actions: the most recent in memory and,
+// optionally, all of them in a file of JSON lines. Recording never waits on the file,
+// so it can be done from anywhere. A nil *AuditLog records nothing
+type AuditLog struct {
+	sync.Mutex
+	entries  []AuditEntry // the most recent entries, oldest first
+	capacity int          // entries kept in memory
+	lastID   uint64       // id of the last entry recorded
+
+	writes chan AuditEntry // entries waiting to be written to the file (nil without a file)
+	done   chan struct{}   // closed once every entry was written and the file closed
+	closed bool            // whether the file was closed, entries are then only kept in memory
+}
+
+// NewAuditLog creates the audit log keeping capacity entries in memory (DefaultAuditCapacity
+// if zero). With a path the entries are appended to the file too, and the most recent
+// entries already in it are read back
+func NewAuditLog(capacity int, path string) (*AuditLog, error) {
+	if capacity <= 0 {
+		capacity = DefaultAuditCapacity
+	}
+	l := &AuditLog{capacity: capacity}
+	if path == "" {
+		return l, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening the audit log: %w", err)
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		l.keep(entry)
+		l.lastID = max(l.lastID, entry.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading the audit log: %w", err)
+	}
+
+	l.writes = make(chan AuditEntry, auditBuffer)
+	l.done = make(chan struct{})
+	go l.write(file)
+	return l, nil
+}
+
+// Record adds the entry to the log, with an id and the current time. It never blocks
+func (l *AuditLog) Record(entry AuditEntry) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	l.lastID++
+	entry.ID = l.lastID
+	entry.Time = time.Now()
+	l.keep(entry)
+	// an entry that can't be written right away stays in memory only
+	behind := false
+	if l.writes != nil && !l.closed {
+		select {
+		case l.writes <- entry:
+		default:
+			behind = true
+		}
+	}
+	l.Unlock()
+
+	slog.Info("audit", "actor", entry.Actor, "action", entry.Action, "room", entry.Room, "target", entry.Target, "reason", entry.Reason, "err", entry.Error)
+	if behind {
+		slog.Warn("audit log file is behind, entry only kept in memory", "id", entry.ID)
+	}
+}
+
+// record records the action, failed if err isn't nil
+func (l *AuditLog) record(actor, action, room, target, reason string, err error) {
+	entry := AuditEntry{Actor: actor, Action: action, Room: room, Target: target, Reason: reason}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.Record(entry)
+}
+
+// keep adds the entry to the ones in memory, forgetting the oldest past capacity.
+// It is called with the lock held
+func (l *AuditLog) keep(entry AuditEntry) {
+	if len(l.entries) >= l.capacity {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-l.capacity+1:]...)
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// write appends the entries to the file until the log is closed
+func (l *AuditLog) write(file *os.File) {
+	defer close(l.done)
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for entry := range l.writes {
+		if err := encoder.Encode(entry); err != nil {
+			slog.Error("writing the audit log", "id", entry.ID, "err", err)
+		}
+	}
+}
+
+// Close writes the entries still waiting and closes the file
+func (l *AuditLog) Close() error {
+	if l == nil || l.writes == nil {
+		return nil
+	}
+	l.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.writes)
+	}
+	l.Unlock()
+	<-l.done
+	return nil
+}
+
+// Entries returns up to limit entries older than the entry with the id before (the most
+// recent ones if zero), newest first
+func (l *AuditLog) Entries(before uint64, limit int) []AuditEntry {
+	if l == nil {
+		return []AuditEntry{}
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	page := []AuditEntry{}
+	for i := len(l.entries) - 1; i >= 0 && len(page) < limit; i-- {
+		if before == 0 || l.entries[i].ID < before {
+			page = append(page, l.entries[i])
+		}
+	}
+	return page
+}
+
+// AuditHandler handles GET /admin/audit, the most recent entries of the log first:
+// ?limit= entries per page and ?before= the id of the last entry of the previous page
+// (the "next" of its response). It should be wrapped in AdminAuth
+func AuditHandler(audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := auditPage
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, auditMaxPage)
+		}
+		var before uint64
+		if value := r.URL.Query().Get("before"); value != "" {
+			var err error
+			if before, err = strconv.ParseUint(value, 10, 64); err != nil {
+				http.Error(w, "before must be the id of an entry", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page := struct {
+			Entries []AuditEntry `json:"entries"`
+			Next    uint64       `json:"next,omitempty"` // before of the next page (zero if this is the last)
+		}{Entries: audit.Entries(before, limit)}
+		if len(page.Entries) == limit {
+			page.Next = page.Entries[len(page.Entries)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(page)
+	})
+}
+
+// WithAudit records the kicks, mutes and deletions of the hub in the audit log
+func WithAudit(audit *AuditLog) Option {
+	return func(h *Hub) {
+		h.audit = audit
+	}
+}
+
+// auditActor is who took the action of the request: the visitor it signed in as,
+// otherwise the admin token holder behind its address
+func auditActor(r *http.Request) string {
+	if p, ok := requestPrincipal(r); ok {
+		return p.name
+	}
+	return "admin@" + clientIP(r)
+}
@@ -0,0 +1,120 @@
+package chatter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// auditPage gets a page of the audit log from its handler
+func auditPage(t *testing.T, audit *chatter.AuditLog, query string) ([]chatter.AuditEntry, uint64) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	chatter.AuditHandler(audit).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil))
+	var page struct {
+		Entries []chatter.AuditEntry `json:"entries"`
+		Next    uint64               `json:"next"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding the page of %q (%d): %v", query, rec.Code, err)
+	}
+	return page.Entries, page.Next
+}
+
+// summary sums the entries up as "actor action target reason (failed)", oldest first
+func summary(entries []chatter.AuditEntry) string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		line := strings.TrimSpace(fmt.Sprintf("%s %s %s %s", entry.Actor, entry.Action, entry.Target, entry.Reason))
+		if entry.Error != "" {
+			line += " (failed)"
+		}
+		lines[len(entries)-1-i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestModerationIsAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := chatter.NewAuditLog(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := chattertest.NewServer(t, chatter.WithAudit(audit))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	alice.Send("oops")
+	bob.Expect("oops", waitTimeout)
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, _ := hub.History(0, 10)
+	oops := messages[len(messages)-1]
+
+	// a few actions, some of which fail
+	hub.Mute("mod", "bob", time.Minute)
+	hub.Mute("mod", "nobody", time.Minute)
+	hub.Delete("mod", oops.ID)
+	// (a message no longer in the history is still taken off the pages showing it)
+	hub.Delete("mod", 9999)
+	hub.Announce("mod", "maintenance at noon", 0)
+	hub.Kick("mod", "alice", "spam", false)
+	hub.Kick("mod", "alice", "again", false)
+	bans, _ := chatter.NewBanList("", nil)
+	req := httptest.NewRequest(http.MethodPost, "/admin/bans", strings.NewReader(`{"ip":"192.0.2.7","reason":"flood"}`))
+	req.Header.Set("Content-Type", "application/json")
+	chatter.BansHandler(srv.Manager, bans, audit).ServeHTTP(httptest.NewRecorder(), req)
+
+	want := strings.Join([]string{
+		"mod mute bob for 1m0s",
+		"mod mute nobody (failed)",
+		fmt.Sprintf("mod delete %d", oops.ID),
+		"mod delete 9999",
+		"mod announce  maintenance at noon",
+		"mod kick alice spam",
+		"mod kick alice again (failed)",
+		"admin@192.0.2.1 ban 192.0.2.7 flood",
+	}, "\n")
+	entries := audit.Entries(0, 100)
+	if got := summary(entries); got != want {
+		t.Errorf("the audit log is:\n%s\nwant:\n%s", got, want)
+	}
+	for _, entry := range entries {
+		if entry.Time.IsZero() || (entry.Action != chatter.AuditBan && entry.Room != chatter.DefaultRoom) {
+			t.Errorf("the entry %+v has no time or the wrong room", entry)
+		}
+	}
+
+	// the pages go from the most recent entry back
+	first, next := auditPage(t, audit, "limit=5")
+	second, last := auditPage(t, audit, fmt.Sprintf("limit=5&before=%d", next))
+	if got := summary(append(first, second...)); got != want || last != 0 {
+		t.Errorf("the pages are:\n%s\n(next %d)", got, last)
+	}
+
+	// the file has every entry, and the log carries on after them
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := chatter.NewAuditLog(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if got := summary(again.Entries(0, 100)); got != want {
+		t.Errorf("the audit log read back is:\n%s", got)
+	}
+	again.Record(chatter.AuditEntry{Actor: "mod", Action: chatter.AuditUnban, Target: "192.0.2.7"})
+	if latest := again.Entries(0, 1); len(latest) != 1 || latest[0].ID != entries[0].ID+1 {
+		t.Errorf("the entry after the ones read back is %+v", latest)
+	}
+}
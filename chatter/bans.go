@@ -0,0 +1,272 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban keeps an IP address out of the chat
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until"` // when the ban expires (zero never expires)
+}
+
+// expired reports whether the ban is over
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Until.IsZero() && !now.Before(b.Until)
+}
+
+// BanList is the list of banned IP addresses, saved to a JSON file on every change
+// so bans survive restarts
+type BanList struct {
+	sync.RWMutex
+	path    string          // file the list is saved to (empty keeps it in memory)
+	bans    map[string]*Ban // bans by IP address
+	metrics *Metrics
+}
+
+// NewBanList loads the bans saved in the file at path (a missing file is an empty list)
+func NewBanList(path string, metrics *Metrics) (*BanList, error) {
+	l := &BanList{path: path, bans: make(map[string]*Ban), metrics: metrics}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		if ip := normalizeIP(ban.IP); ip != "" {
+			ban.IP = ip
+			l.bans[ip] = ban
+		}
+	}
+	return l, nil
+}
+
+// Add bans the IP address (for d, zero bans it for good)
+func (l *BanList) Add(ip, reason string, d time.Duration) (*Ban, error) {
+	ip = normalizeIP(ip)
+	if ip == "" {
+		return nil, errors.New("invalid IP address")
+	}
+
+	ban := &Ban{IP: ip, Reason: reason}
+	if d > 0 {
+		ban.Until = time.Now().Add(d)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.bans[ip] = ban
+	return ban, l.save()
+}
+
+// Remove lifts the ban of the IP address, it returns false if it wasn't banned
+func (l *BanList) Remove(ip string) (bool, error) {
+	ip = normalizeIP(ip)
+
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.bans[ip]; !ok {
+		return false, nil
+	}
+	delete(l.bans, ip)
+	return true, l.save()
+}
+
+// Banned returns the ban of the IP address, if it is banned
+func (l *BanList) Banned(ip string) (*Ban, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	ban, ok := l.bans[normalizeIP(ip)]
+	if !ok || ban.expired(time.Now()) {
+		return nil, false
+	}
+	return ban, true
+}
+
+// List returns the bans still in force, sorted by IP address
+func (l *BanList) List() []*Ban {
+	l.RLock()
+	defer l.RUnlock()
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(l.bans))
+	for _, ban := range l.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// save writes the bans still in force to the file, it must be called with the lock held.
+// We write a temporary file and rename it, so a crash never leaves half a list behind
+func (l *BanList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(l.bans))
+	for ip, ban := range l.bans {
+		if ban.expired(now) {
+			delete(l.bans, ip)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".bans-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// Guard rejects the requests of banned IP addresses before they reach next
+// (so a banned client never gets its connection upgraded). A nil list bans nobody
+func (l *BanList) Guard(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ban, ok := l.Banned(clientIP(r)); ok {
+			slog.Info("connection from a banned address rejected", "remote_ip", ban.IP)
+			l.metrics.banRejected()
+			http.Error(w, "You are banned", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client making the request
+// (see TrustedProxies for clients behind a proxy)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return normalizeIP(host)
+}
+
+// normalizeIP returns the canonical form of the IP address (IPv4 addresses mapped to
+// IPv6 become plain IPv4, IPv6 addresses are compressed), or "" if it isn't one
+func normalizeIP(ip string) string {
+	parsed := net.ParseIP(strings.Trim(strings.TrimSpace(ip), "[]"))
+	if parsed == nil {
+		return ""
+	}
+	return parsed.String()
+}
+
+// BanRequest is what POST /admin/bans takes, as JSON
+type BanRequest struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // e.g. "24h", empty bans for good
+}
+
+// BansHandler serves the admin endpoints of the bans, it should be wrapped in AdminAuth:
+// GET /admin/bans lists them, POST /admin/bans adds one (with a JSON body or a form, and drops
+// the clients connected from the address) and DELETE /admin/bans/{ip} lifts one.
+// Both are recorded in the audit log
+func BansHandler(manager *HubManager, bans *BanList, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(bans.List())
+
+		case http.MethodPost:
+			// forms (the dashboard) send fields, everyone else JSON
+			req := &BanRequest{}
+			if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+				req.IP = r.FormValue("ip")
+				req.Reason = r.FormValue("reason")
+				req.Duration = r.FormValue("duration")
+			} else if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			var d time.Duration
+			if req.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(req.Duration); err != nil || d < 0 {
+					http.Error(w, "duration must be a positive duration (e.g. 24h)", http.StatusBadRequest)
+					return
+				}
+			}
+			ban, err := bans.Add(req.IP, req.Reason, d)
+			if ban == nil {
+				audit.record(auditActor(r), AuditBan, "", req.IP, req.Reason, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				// the ban holds, it just won't survive a restart
+				slog.Error("saving bans", "err", err)
+			}
+			audit.record(auditActor(r), AuditBan, "", ban.IP, ban.Reason, nil)
+
+			// whoever is connected from the address goes right away
+			manager.KickIP(ban.IP, "banned")
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ban)
+
+		case http.MethodDelete:
+			removed, err := bans.Remove(r.PathValue("ip"))
+			if err != nil {
+				slog.Error("saving bans", "err", err)
+			}
+			if !removed {
+				audit.record(auditActor(r), AuditUnban, "", r.PathValue("ip"), "", errors.New("not banned"))
+				http.NotFound(w, r)
+				return
+			}
+			audit.record(auditActor(r), AuditUnban, "", r.PathValue("ip"), "", nil)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBansSurviveRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	bans, err := chatter.NewBanList(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"2001:DB8:0:0::1", "::ffff:10.0.0.1", "192.0.2.7"} {
+		if _, err := bans.Add(ip, "spam", 0); err != nil {
+			t.Fatalf("banning %s: %v", ip, err)
+		}
+	}
+	if _, err := bans.Add("not an address", "", 0); err == nil {
+		t.Error("an invalid address was banned")
+	}
+	if _, err := bans.Add("198.51.100.1", "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := bans.Remove("192.0.2.7"); !removed || err != nil {
+		t.Errorf("removing a ban returned %v, %v", removed, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// the list read back has the bans still in force, whatever way the addresses are written
+	again, err := chatter.NewBanList(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"2001:db8::1":   true,
+		"[2001:db8::1]": true,
+		"10.0.0.1":      true,
+		"192.0.2.7":     false,
+		"198.51.100.1":  false,
+	} {
+		if _, banned := again.Banned(ip); banned != want {
+			t.Errorf("%s is banned: %v, want %v", ip, banned, want)
+		}
+	}
+	if list := again.List(); len(list) != 2 {
+		t.Errorf("the list has %d bans, want 2: %v", len(list), list)
+	}
+}
+
+func TestBannedAddressesAreRefused(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	bans, _ := chatter.NewBanList("", chatter.NewMetrics(reg))
+	bans.Add("2001:db8::1", "spam", 0)
+	handler := bans.Guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+
+	for addr, want := range map[string]int{
+		"[2001:db8:0::1]:4242": http.StatusForbidden,
+		"[2001:db8::2]:4242":   http.StatusSwitchingProtocols,
+		"192.0.2.1:4242":       http.StatusSwitchingProtocols,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s got %d, want %d", addr, rec.Code, want)
+		}
+	}
+	if refused := metricValue(t, reg, "chatter_banned_connections_total"); refused != 1 {
+		t.Errorf("%v refused connections were counted, want 1", refused)
+	}
+}
+
+func TestBanningDropsTheOpenConnections(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bans, _ := chatter.NewBanList("", nil)
+	handler := chatter.BansHandler(srv.Manager, bans, nil)
+
+	// the test clients all come from the loopback address
+	req := httptest.NewRequest(http.MethodPost, "/admin/bans", strings.NewReader(`{"ip":"127.0.0.1","reason":"spam","duration":"1h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("banning got %d %s", rec.Code, rec.Body)
+	}
+
+	closed := alice.ExpectClosed(waitTimeout)
+	if closed == nil || closed.Code != websocket.ClosePolicyViolation || closed.Text != "banned" {
+		t.Errorf("alice was closed with %v", closed)
+	}
+}
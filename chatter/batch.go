@@ -0,0 +1,90 @@
+package chatter
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// batchWriter writes fragments to a websocket connection in as few messages as it takes:
+// the fragments are separated by a newline (the htmx ws extension swaps every top level
+// element of a message, the JSON clients read a message per line) and a message is cut
+// before it grows past limit bytes. A fragment longer than limit gets a message of its own
+type batchWriter struct {
+	conn     wsConn
+	limit    int
+	deadline func() time.Time // the write deadline of the next message
+
+	w        io.WriteCloser // the message being written, nil between messages
+	size     int            // bytes in the message being written
+	written  int            // bytes written so far
+	messages int            // messages written so far
+}
+
+// batch returns a batch writer for the connection of the client
+func (c *Client) batch() *batchWriter {
+	return &batchWriter{
+		conn:     c.conn,
+		limit:    c.hub.config.MaxBatchSize,
+		deadline: func() time.Time { return c.hub.clock.Now().Add(c.hub.config.WriteWait) },
+	}
+}
+
+// add writes the fragment, in the current message if it fits
+func (b *batchWriter) add(fragment []byte) error {
+	if b.w != nil && b.size+len(newline)+len(fragment) > b.limit {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	if b.w == nil {
+		b.conn.SetWriteDeadline(b.deadline())
+		w, err := b.conn.NextWriter(websocket.TextMessage)
+		if err != nil {
+			return err
+		}
+		b.w, b.size = w, 0
+	} else {
+		if _, err := b.w.Write(newline); err != nil {
+			return err
+		}
+		b.size += len(newline)
+		b.written += len(newline)
+	}
+
+	if _, err := b.w.Write(fragment); err != nil {
+		return err
+	}
+	b.size += len(fragment)
+	b.written += len(fragment)
+	return nil
+}
+
+// flush ends the current message, if there is one
+func (b *batchWriter) flush() error {
+	if b.w == nil {
+		return nil
+	}
+	err := b.w.Close()
+	b.w = nil
+	b.messages++
+	return err
+}
+
+// joinFrames joins the frames into one, for the streams that only send whole frames (see
+// serveEvents). Its id is the id of the last message in it
+func joinFrames(frames []Frame) Frame {
+	var joined Frame
+	fragments := make([][]byte, 0, len(frames))
+	for _, frame := range frames {
+		fragments = append(fragments, frame.Data)
+		if frame.ID > 0 {
+			joined.ID = frame.ID
+		}
+	}
+	joined.Data = bytes.Join(fragments, newline)
+	return joined
+}
@@ -0,0 +1,111 @@
+package chatter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fragments returns n fragments of 30 bytes
+func fragments(n int) []string {
+	var list []string
+	for i := 0; i < n; i++ {
+		list = append(list, fmt.Sprintf(`<div id="f%04d">%s</div>`, i, strings.Repeat("x", 8)))
+	}
+	return list
+}
+
+// batchLimit fits three fragments of 30 bytes (and their newlines) in a message, not four
+const batchLimit = 100
+
+func TestBatchWriterCutsTheMessages(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		fragments []string
+		messages  int
+	}{
+		{"one", fragments(1), 1},
+		{"a full message", fragments(3), 1},
+		{"one past a full message", fragments(4), 2},
+		{"ten", fragments(10), 4},
+		{"a hundred", fragments(100), 34},
+		{"longer than the limit", []string{strings.Repeat("y", 150)}, 1},
+		{"long between short ones", append(append(fragments(2), strings.Repeat("y", 150)), fragments(2)...), 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newFakeConn()
+			batch := &batchWriter{conn: conn, limit: batchLimit, deadline: time.Now}
+			for _, fragment := range tt.fragments {
+				if err := batch.add([]byte(fragment)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := batch.flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := len(conn.Messages()); got != tt.messages || batch.messages != tt.messages {
+				t.Errorf("%d messages were written (%d counted), want %d", got, batch.messages, tt.messages)
+			}
+			if got := conn.Fragments(); !slices.Equal(got, tt.fragments) {
+				t.Errorf("the fragments written are %q, want %q", got, tt.fragments)
+			}
+			for _, message := range conn.Messages() {
+				if len(message) > batchLimit && strings.Contains(message, "\n") {
+					t.Errorf("a message of %d bytes batches fragments", len(message))
+				}
+			}
+		})
+	}
+}
+
+func TestWritePumpBatchesWhatIsQueued(t *testing.T) {
+	for _, tt := range []struct {
+		depth    int
+		messages int
+	}{
+		{1, 1},
+		{3, 1},
+		{4, 2},
+		{50, 17},
+		{200, 67},
+	} {
+		t.Run(fmt.Sprint(tt.depth), func(t *testing.T) {
+			hub := NewHub(WithSendBuffer(tt.depth), WithConfig(Config{MaxBatchSize: batchLimit}))
+			want := fragments(tt.depth)
+
+			// the history replayed to a client joining
+			conn := newFakeConn()
+			client := pumpClient(hub, conn)
+			client.jsonFrames = true // no sequence number after the replay
+			for _, fragment := range want {
+				client.replay = append(client.replay, Frame{Data: []byte(fragment)})
+			}
+			close(client.send)
+			client.writePump()
+			if got := len(conn.Messages()); got != tt.messages {
+				t.Errorf("the replay took %d messages, want %d", got, tt.messages)
+			}
+			if got := conn.Fragments(); !slices.Equal(got, want) {
+				t.Errorf("the replay wrote %q", got)
+			}
+
+			// the frames that piled up in the send channel
+			conn = newFakeConn()
+			client = pumpClient(hub, conn)
+			for _, fragment := range want {
+				client.send <- Frame{Data: []byte(fragment)}
+			}
+			close(client.send)
+			client.writePump()
+			if got := len(conn.Messages()); got != tt.messages {
+				t.Errorf("the queue took %d messages, want %d", got, tt.messages)
+			}
+			if got := conn.Fragments(); !slices.Equal(got, want) {
+				t.Errorf("the queue wrote %q", got)
+			}
+		})
+	}
+}
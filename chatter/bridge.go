@@ -0,0 +1,58 @@
+package chatter
+
+import (
+	"context"
+	"time"
+)
+
+// bridgeTimeout is how long a hub waits for the bridge when it reads the shared history
+const bridgeTimeout = 2 * time.Second
+
+// Bridge connects the hubs of a room running in different instances (e.g. replicas
+// behind a load balancer), so their clients end up in the same chat
+type Bridge interface {
+	// Publish hands a message broadcast by this instance to the other instances,
+	// it must not block (the hub calls it for every message)
+	Publish(room string, msg *Message)
+	// Subscribe calls deliver with every message broadcast in the room by the other
+	// instances (with their Origin set) until ctx is cancelled
+	Subscribe(ctx context.Context, room string, deliver func(*Message))
+	// Recent returns up to n of the most recent messages of the room, oldest first
+	Recent(ctx context.Context, room string, n int) ([]*Message, error)
+}
+
+// WithBridge shares the rooms with the other instances through the bridge
+func WithBridge(bridge Bridge) Option {
+	return func(h *Hub) {
+		h.bridge = bridge
+	}
+}
+
+// attachBridge seeds the history of a fresh room with the shared history
+// and starts receiving the messages of the other instances, until ctx is cancelled
+func (h *Hub) attachBridge(ctx context.Context) {
+
+	// a room this instance has never seen starts with what the others have said
+	if recent, err := h.store.RecentN(h.room, 1); err == nil && len(recent) == 0 {
+		seedCtx, cancel := context.WithTimeout(ctx, bridgeTimeout)
+		messages, err := h.bridge.Recent(seedCtx, h.room, h.historyReplay)
+		cancel()
+		if err != nil {
+			h.logger.Error("reading the shared history", "err", err)
+		}
+		for _, msg := range messages {
+			// the messages get an id of ours, ids are only ever compared within a hub
+			msg.ID = h.nextID()
+			if err := h.store.Append(h.room, msg); err != nil {
+				h.logger.Error("storing message", "msg_id", msg.ID, "err", err)
+			}
+		}
+	}
+
+	go h.bridge.Subscribe(ctx, h.room, func(msg *Message) {
+		select {
+		case h.remote <- msg:
+		case <-ctx.Done():
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package chatter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// largeMessage returns a message of the form with the text whose payload is size bytes,
+// the current url of its headers takes up what the text doesn't
+func largeMessage(text string, size int) map[string]any {
+	headers := map[string]string{"HX-Request": "true", "HX-Current-URL": ""}
+	msg := map[string]any{"text": text, "HEADERS": headers}
+	// encoded with the keys sorted, the way encoding/json does a map
+	overhead := len(`{"HEADERS":{"HX-Current-URL":"","HX-Request":"true"},"text":""}`) + len(text)
+	headers["HX-Current-URL"] = "http://chat.example/?" + strings.Repeat("p", size-overhead-len("http://chat.example/?"))
+	return msg
+}
+
+func TestLargeMessagesGoThroughSmallBuffers(t *testing.T) {
+	srv := chattertest.NewServer(t, chatter.WithConfig(chatter.Config{ReadBufferSize: 64, WriteBufferSize: 64}))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	bob.Expect("Online (2)", waitTimeout)
+
+	// the longest text, in a payload just under the maximum size
+	text := strings.Repeat("a", 999) + "z"
+	alice.SendJSON(largeMessage(text, chatter.DefaultMaxMessageSize-1))
+	if got := bob.Expect("aaaz", waitTimeout); !strings.Contains(got, text) {
+		t.Errorf("bob got the text cut:\n%s", got)
+	}
+
+	// and one byte past it is refused, the connection stays open
+	alice.SendJSON(largeMessage(text, chatter.DefaultMaxMessageSize+1))
+	alice.Expect("message too long", waitTimeout)
+	alice.Send("still here")
+	bob.Expect("still here", waitTimeout)
+}
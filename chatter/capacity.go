@@ -0,0 +1,83 @@
+package chatter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fullRoomRetry is how long a client turned away from a full room is told to wait
+const fullRoomRetry = 30 * time.Second
+
+// RoomFull is what the "room is full" fragment is rendered from
+type RoomFull struct {
+	Room     string // the room that is full
+	Capacity int    // clients the room holds
+}
+
+// WithCapacity sets how many clients can be in the room at once, zero (the default) doesn't
+// limit them. Clients connecting to a full room are turned away with a 503 before the upgrade,
+// unless closeWhenFull is set: their connection is then accepted to tell them the room is
+// full, and closed. Admins (see AdminIdentify) always get in
+func WithCapacity(n int, closeWhenFull bool) Option {
+	return func(h *Hub) {
+		if n < 0 {
+			n = 0
+		}
+		h.capacity = n
+		h.closeWhenFull = closeWhenFull
+	}
+}
+
+// reserve keeps a place in the room for a client about to join, false if the room is full.
+// Places are kept until the client registers (see the register case of Run) or unreserve
+// gives them back, so clients connecting at the same time can't take more than there are
+func (h *Hub) reserve(admin bool) bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.capacity > 0 && !admin && len(h.clients)+h.reserved >= h.capacity {
+		return false
+	}
+	h.reserved++
+	return true
+}
+
+// unreserve gives back the place kept for a client that didn't make it into the room
+func (h *Hub) unreserve() {
+	h.Lock()
+	defer h.Unlock()
+	h.reserved--
+}
+
+// renderFull renders the fragment telling a client the room is full
+func (h *Hub) renderFull() []byte {
+	return getFullTemplate(&RoomFull{Room: h.room, Capacity: h.capacity})
+}
+
+// refuseFull turns a client away from the full room before the upgrade
+func refuseFull(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	hub.logger.Info("connection rejected: the room is full", "remote_ip", clientIP(r))
+	hub.metrics.connectionRejected("room_full")
+	w.Header().Set("Retry-After", strconv.Itoa(int(fullRoomRetry.Seconds())))
+	if fragment := hub.renderFull(); fragment != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(fragment)
+		return
+	}
+	http.Error(w, "The room is full", http.StatusServiceUnavailable)
+}
+
+// closeFull tells a client on an upgraded connection that the room is full, and closes it
+func closeFull(conn *websocket.Conn, r *http.Request, hub *Hub) {
+	hub.logger.Info("connection closed: the room is full", "remote_ip", clientIP(r))
+	hub.metrics.connectionRejected("room_full")
+	conn.SetWriteDeadline(time.Now().Add(hub.config.WriteWait))
+	if fragment := hub.renderFull(); fragment != nil {
+		conn.WriteMessage(websocket.TextMessage, fragment)
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "room is full"), time.Now().Add(hub.config.WriteWait))
+	conn.Close()
+}
@@ -0,0 +1,113 @@
+package chatter_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cappedServer starts a chat whose rooms hold capacity clients, admins are known by the admin token
+func cappedServer(t *testing.T, reg *prometheus.Registry, capacity int, closeWhenFull bool) *chattertest.Server {
+	t.Helper()
+	manager := chatter.NewHubManager(time.Hour, chatter.WithCapacity(capacity, closeWhenFull), chatter.WithMetrics(chatter.NewMetrics(reg)))
+	handler := chatter.AdminIdentify("admin-token", chatter.Handler(manager, nil, nil))
+	srv := &chattertest.Server{Server: httptest.NewServer(handler), Manager: manager}
+	t.Cleanup(func() {
+		manager.Close(5 * time.Second)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestFullRoomsTurnClientsAway(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := cappedServer(t, reg, 2, false)
+	srv.Connect(t, "alice")
+	srv.Connect(t, "bob")
+
+	// the third client gets the fragment saying the room is full, and no connection
+	_, resp, err := srv.Dial(t, "/ws?name=carol", nil)
+	if err == nil {
+		t.Fatal("carol got into the full room")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("carol got %v", resp)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "is full (it holds 2)") {
+		t.Errorf("carol was told:\n%s", body)
+	}
+	if rejected := metricValue(t, reg, "chatter_rejected_connections_total"); rejected != 1 {
+		t.Errorf("%v rejected connections were counted, want 1", rejected)
+	}
+
+	// admins always get in, and the stats show the room over its capacity
+	if _, _, err := srv.Dial(t, "/ws?name=admin", http.Header{chatter.AdminTokenHeader: {"admin-token"}}); err != nil {
+		t.Fatalf("the admin didn't get in: %v", err)
+	}
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(waitTimeout)
+	for hub.Stats().Clients != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := hub.Stats(); stats.Clients != 3 || stats.Capacity != 2 {
+		t.Errorf("the stats show %d clients of %d", stats.Clients, stats.Capacity)
+	}
+}
+
+func TestClientsConnectingAtOnceDontOverfillTheRoom(t *testing.T) {
+	srv := cappedServer(t, prometheus.NewRegistry(), 5, false)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined, refused := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, resp, err := srv.Dial(t, "/ws?name=guest", nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				joined++
+			case resp != nil && resp.StatusCode == http.StatusServiceUnavailable:
+				refused++
+			default:
+				t.Errorf("connecting: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if joined != 5 || refused != 15 {
+		t.Errorf("%d clients joined and %d were refused, want 5 and 15", joined, refused)
+	}
+}
+
+func TestFullRoomsCanTellTheClientsOverTheConnection(t *testing.T) {
+	srv := cappedServer(t, prometheus.NewRegistry(), 1, true)
+	srv.Connect(t, "alice")
+
+	// the connection is accepted to say the room is full, then closed
+	bob, _, err := srv.Dial(t, "/ws?name=bob", nil)
+	if err != nil {
+		t.Fatalf("bob's connection wasn't accepted: %v", err)
+	}
+	bob.Expect("is full (it holds 1)", waitTimeout)
+	closed := bob.ExpectClosed(waitTimeout)
+	if closed == nil || closed.Code != websocket.CloseTryAgainLater {
+		t.Errorf("bob was closed with %v, want %d", closed, websocket.CloseTryAgainLater)
+	}
+}
@@ -0,0 +1,260 @@
+// Package chattertest runs a chat in process for tests: a server with the endpoints of the rooms
+// and websocket clients dialing it, the way the pages of the chat do.
+//
+//	srv := chattertest.NewServer(t)
+//	alice, bob := srv.Connect(t, "alice"), srv.Connect(t, "bob")
+//	alice.Send("hello")
+//	bob.Expect("hello", time.Second)
+//
+// Everything it starts is stopped when the test ends, and the test fails if any goroutine
+// it started is still running by then.
+package chattertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+const (
+	// roomTTL is how long the rooms of the server stay once everyone left,
+	// long enough for a test not to see them go
+	roomTTL = time.Hour
+	// closeTimeout is how long closing the server waits for its clients to disconnect
+	closeTimeout = 5 * time.Second
+	// dialTimeout is how long connecting a client can take
+	dialTimeout = 5 * time.Second
+)
+
+// Server is a chat served in process, the rooms of Manager are behind the websocket (/ws, /ws/{room}),
+// event stream (/events), history (/history), directory (/rooms) and post (/messages) endpoints of its URL
+type Server struct {
+	*httptest.Server
+	Manager *chatter.HubManager
+}
+
+// NewServer starts a chat whose rooms are created with the options, it is closed
+// when the test ends (the goroutines running before it was started are left alone)
+func NewServer(tb testing.TB, opts ...chatter.Option) *Server {
+	tb.Helper()
+	running := goleak.IgnoreCurrent()
+	manager := chatter.NewHubManager(roomTTL, opts...)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws", chatter.Handler(manager, nil, nil))
+	mux.Handle("GET /ws/{room}", chatter.Handler(manager, nil, nil))
+	mux.Handle("GET /events", chatter.EventsHandler(manager, nil))
+	mux.Handle("GET /history", chatter.HistoryHandler(manager))
+	mux.Handle("GET /rooms", chatter.RoomsHandler(manager))
+	mux.Handle("POST /messages", chatter.PostHandler(manager, ""))
+
+	srv := &Server{Server: httptest.NewServer(mux), Manager: manager}
+	tb.Cleanup(func() {
+		// the rooms first, so the websocket clients get their close frames
+		if err := manager.Close(closeTimeout); err != nil {
+			tb.Errorf("closing the rooms: %v", err)
+		}
+		srv.Close()
+		// by then every hub, pump and connection has to be gone
+		goleak.VerifyNone(tb, running)
+	})
+	return srv
+}
+
+// Client is a websocket client of the server, its frames are read as they come
+type Client struct {
+	tb     testing.TB
+	conn   *websocket.Conn
+	frames chan string   // the frames read so far (closed once the connection is)
+	err    error         // why the connection ended, set before frames is closed
+	seen   []string      // frames Expect went through without a match, most recent last
+	done   chan struct{} // closed once read returned
+
+	closing   chan struct{} // closed by Close, read stops handing frames over
+	closeOnce sync.Once
+}
+
+// Connect connects a client named name to the default room
+func (s *Server) Connect(tb testing.TB, name string) *Client {
+	tb.Helper()
+	return s.ConnectRoom(tb, chatter.DefaultRoom, name)
+}
+
+// ConnectRoom connects a client named name to the room, the client is disconnected
+// when the test ends
+func (s *Server) ConnectRoom(tb testing.TB, room, name string) *Client {
+	tb.Helper()
+	query := url.Values{"room": {room}, "name": {name}}
+	c, resp, err := s.Dial(tb, "/ws?"+query.Encode(), nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		tb.Fatalf("connecting %s to %s: %v (status %d)", name, room, err, status)
+	}
+	return c
+}
+
+// Dial connects a client to the path (with its query, e.g. "/ws/lobby?name=alice") with the
+// headers of the handshake, the client is disconnected when the test ends. Unlike ConnectRoom
+// it leaves a failed handshake to the test, with the response of the server (if any)
+func (s *Server) Dial(tb testing.TB, path string, header http.Header) (*Client, *http.Response, error) {
+	tb.Helper()
+	endpoint := "ws" + strings.TrimPrefix(s.URL, "http") + path
+
+	dialer := &websocket.Dialer{HandshakeTimeout: dialTimeout}
+	conn, resp, err := dialer.Dial(endpoint, header)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	// we answer the close frame of the server the way browsers do, but the server may have
+	// hung up by then: that's no reason to lose the close frame it sent
+	conn.SetCloseHandler(func(code int, text string) error {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		return nil
+	})
+
+	c := &Client{tb: tb, conn: conn, frames: make(chan string, 256), done: make(chan struct{}), closing: make(chan struct{})}
+	go c.read()
+	tb.Cleanup(c.Close)
+	return c, resp, nil
+}
+
+// read reads the frames of the server until the connection ends
+func (c *Client) read() {
+	defer close(c.done)
+	defer close(c.frames)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		// a test that stopped reading doesn't keep us from noticing the connection ended
+		select {
+		case c.frames <- string(data):
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// Send sends a chat message, the way the form of the page does
+func (c *Client) Send(text string) {
+	c.tb.Helper()
+	c.SendJSON(map[string]any{"text": text, "HEADERS": map[string]string{"HX-Request": "true"}})
+}
+
+// SendJSON sends v as a JSON text frame, e.g. the other forms of the page
+func (c *Client) SendJSON(v any) {
+	c.tb.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.tb.Fatalf("encoding %v: %v", v, err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		c.tb.Fatalf("sending %s: %v", data, err)
+	}
+}
+
+// Expect waits up to timeout for a frame containing substring and returns it, the frames
+// before it are skipped. The test fails if none comes
+func (c *Client) Expect(substring string, timeout time.Duration) string {
+	c.tb.Helper()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case frame, ok := <-c.frames:
+			if !ok {
+				c.tb.Fatalf("no frame containing %q, the connection ended: %v", substring, c.err)
+				return ""
+			}
+			if strings.Contains(frame, substring) {
+				return frame
+			}
+			c.seen = append(c.seen, frame)
+		case <-timer.C:
+			c.tb.Fatalf("no frame containing %q within %s, %d frames came:\n%s", substring, timeout, len(c.seen), strings.Join(c.seen, "\n"))
+			return ""
+		}
+	}
+}
+
+// Next waits up to timeout for the next frame and returns it, the test fails if none comes
+func (c *Client) Next(timeout time.Duration) string {
+	c.tb.Helper()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case frame, ok := <-c.frames:
+		if !ok {
+			c.tb.Fatalf("no frame, the connection ended: %v", c.err)
+		}
+		return frame
+	case <-timer.C:
+		c.tb.Fatalf("no frame within %s", timeout)
+	}
+	return ""
+}
+
+// ExpectNone waits for d and fails the test if a frame containing substring comes
+// in the meantime, the frames that come are skipped
+func (c *Client) ExpectNone(substring string, d time.Duration) {
+	c.tb.Helper()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case frame, ok := <-c.frames:
+			if !ok {
+				return
+			}
+			if strings.Contains(frame, substring) {
+				c.tb.Errorf("a frame containing %q came:\n%s", substring, frame)
+				return
+			}
+			c.seen = append(c.seen, frame)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// ExpectClosed waits up to timeout for the server to close the connection, and returns
+// the close frame it sent (nil if the connection ended without one). The frames before it are skipped
+func (c *Client) ExpectClosed(timeout time.Duration) *websocket.CloseError {
+	c.tb.Helper()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for open := true; open; {
+		select {
+		case _, open = <-c.frames:
+		case <-timer.C:
+			c.tb.Fatalf("the connection is still open after %s", timeout)
+			return nil
+		}
+	}
+	closeErr, _ := c.err.(*websocket.CloseError)
+	return closeErr
+}
+
+// Close disconnects the client the way a browser leaving the page does, and waits for
+// it to stop reading
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.closing) })
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.conn.Close()
+	<-c.done
+}
@@ -0,0 +1,839 @@
+package chatter
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// Frame is a rendered payload queued for a client, ID is the id of the message it renders
+// (zero for fragments that aren't messages, like the presence list or an error).
+// Seq is the sequence number of the frames broadcast to the room (zero for the frames
+// sent to a single client), websocket pages always know the last one they got.
+// JSON is set when Data is a JSONMessage for the JSON clients (see JSONProtocol)
+type Frame struct {
+	ID   uint64
+	Seq  uint64
+	Data []byte
+	JSON bool
+}
+
+// Client is a connection to a hub. The hub only ever queues frames on the send channel
+// and closes it when the client is removed, so the same struct serves websocket clients
+// (see serveWs) and SSE subscribers (see serveEvents), only the pumps differ
+type Client struct {
+	id   string     // unique identifier for the client
+	name string     // display name of the client (unique within the hub)
+	hub  *Hub       // the hub that the client is connected to
+	conn wsConn     // the websocket connection (nil for SSE subscribers, only the pumps use it)
+	ip   string     // IP address the client connected from
+	ua   string     // user agent the client connected with
+	role clientRole // what the client is to the hub (a member of the room unless set)
+	send chan Frame // buffered channel of outbound messages
+
+	// transport is how the client is connected, the hub reaches the connection only through it
+	transport transport
+
+	// logger is the logger of the hub, with the id and address of the client
+	logger *slog.Logger
+
+	// release gives back the connection slot of the address (see ConnLimit),
+	// nil if the connection didn't take one
+	release func()
+	// reserved is the hub that kept a place for the client (see Hub.reserve), nil if none did
+	reserved *Hub
+
+	// identityID is the stable identity of the visitor (see Identities), the same
+	// across its connections. It is empty when the connection didn't come with one
+	identityID string
+
+	// user is the name the visitor signed in with (see Sessions and JWTAuth), empty if it didn't.
+	// Signed in clients go by it and can't change their name. expires is when the credentials
+	// it connected with expire, they are disconnected some time after (zero if they aren't)
+	user    string
+	avatar  string // url of the picture of the visitor, from where it signed in (empty if none)
+	expires time.Time
+
+	// constrained is set when the client told us it is on a slow or metered
+	// connection, in which case it gets compact, compressed messages
+	constrained bool
+	// jsonFrames is set when the client picked JSONProtocol, it gets the messages as JSON
+	jsonFrames bool
+
+	// replay holds the rendered message history (and what goes with it), it is set by the hub
+	// when the client registers and written by writePump before anything else. The hub hands
+	// it over by closing registered, the pumps only start once it did (see join). replaySeq is
+	// the sequence number of the room once the client got it
+	replay    []Frame
+	replaySeq uint64
+
+	// resumeFrom is the id of the last message the client has seen (e.g. the Last-Event-ID
+	// of a reconnecting SSE client), the history replay then starts right after it
+	resumeFrom uint64
+
+	// resumeSeq is the sequence number of the last frame a reconnecting page got,
+	// the frames after it are replayed instead of the history if the hub still has them
+	resumeSeq uint64
+
+	// closeCode and closeReason are sent in the close frame when the hub
+	// closes the send channel, they are set before the channel is closed
+	closeCode   int
+	closeReason string
+
+	// lastTyping is when the client last forwarded a typing event to the hub
+	lastTyping time.Time
+	// dropped is the number of frames the client missed because its send buffer was full,
+	// received and sent the number of frames it sent us and we sent it since connectedAt,
+	// bytesIn and bytesOut the size of their payloads
+	dropped     atomic.Uint64
+	received    atomic.Uint64
+	sent        atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	connectedAt time.Time
+	// lastActivity is when the client last sent anything, in nanoseconds since the epoch
+	// (see WithIdleTimeout)
+	lastActivity atomic.Int64
+	// rttNanos is the smoothed round-trip time of our pings, in nanoseconds (zero until
+	// the client answered one, see pong)
+	rttNanos atomic.Int64
+
+	// limiter limits how fast the client can send chat messages,
+	// violations counts the messages in a row that went over the limit
+	limiter    *rate.Limiter
+	violations int
+
+	// registered is closed by the hub once the client is set up
+	registered chan struct{}
+	// done is closed when writePump has returned
+	done chan struct{}
+}
+
+const (
+	// messages larger than the hub's maximum message size get an error back,
+	// but anything larger than this many times the maximum closes the connection
+	readLimitFactor = 4
+	// maximum length (in runes) of a client name
+	maxNameLength = 32
+	// maximum length (in runes) of the text of a message
+	maxTextLength = 1000
+	// number of rate limited messages in a row after which the client is disconnected
+	rateLimitStrikes = 10
+	// maximum nesting depth allowed in the JSON sent by the peer,
+	// the htmx HEADERS object is flat so anything deeper is rejected
+	maxPayloadDepth = 3
+)
+
+// newline separates the fragments written in a single websocket message
+var newline = []byte{'\n'}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  DefaultBufferSize,
+	WriteBufferSize: DefaultBufferSize,
+	// we only negotiate compression here (unless the config turns it off, see upgraderFor),
+	// how hard it compresses is decided per connection in serveWs
+	EnableCompression: true,
+	// serveWs checks the origin against the origin policy before upgrading
+	CheckOrigin: func(r *http.Request) bool { return true },
+	// the subprotocol is picked by serveWs (see withProtocol): browsers sending their token
+	// as a subprotocol need one of theirs picked (see JWTAuth), other clients may want JSON
+}
+
+// writeBufferPools are the write buffers of the connections by size, a connection only holds
+// one while it writes a message so the idle ones don't keep theirs
+var writeBufferPools sync.Map
+
+// upgraderFor returns the upgrader of the connections to a hub with the config
+func upgraderFor(config Config) *websocket.Upgrader {
+	u := upgrader
+	u.EnableCompression = !config.NoCompression
+	u.ReadBufferSize = config.ReadBufferSize
+	u.WriteBufferSize = config.WriteBufferSize
+	pool, _ := writeBufferPools.LoadOrStore(config.WriteBufferSize, &sync.Pool{})
+	u.WriteBufferPool = pool.(*sync.Pool)
+	return &u
+}
+
+// messageID parses the id of a message the way clients send it ("12", "#12" or the
+// element id "msg-12"), it returns false if it isn't one
+func messageID(value string) (uint64, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "#"), "msg-")
+	id, err := strconv.ParseUint(value, 10, 64)
+	return id, err == nil && id != 0
+}
+
+// WSID is the id of a message the way a client sends it, a JSON string (the value of a form
+// field) or a number
+type WSID string
+
+// UnmarshalJSON decodes the id from a string or a number
+func (id *WSID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*id = WSID(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*id = WSID(s)
+	return nil
+}
+
+// afterID returns the id of the last message the page was rendered with (?after=), the
+// history replay starts right after it so the messages already on the page aren't repeated
+func afterID(r *http.Request) uint64 {
+	id, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+func serveWs(manager *HubManager, origins *OriginPolicy, limit *ConnLimit, w http.ResponseWriter, r *http.Request) {
+
+	// we only accept connections from the pages we trust
+	if !origins.Allowed(r) {
+		slog.Warn("websocket connection rejected: origin not allowed", "remote_ip", clientIP(r), "origin", r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// once the server is draining the clients have to wait for it to restart
+	if refuseDraining(manager, w, r) {
+		return
+	}
+
+	// the rooms are created on demand, we only create the ones with a name we'd give them
+	// (see ValidateRoomName), whether it comes in the path or with ?room=
+	room := roomName(r)
+	if err := ValidateRoomName(room); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// an address can only keep so many connections open, the count goes down
+	// again when the client's readPump returns
+	ip := clientIP(r)
+	if !limit.acquire(ip) {
+		slog.Warn("websocket connection rejected: too many connections", "remote_ip", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(connLimitRetry.Seconds())))
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	release := func() { limit.release(ip) }
+
+	// a private room only lets its members in, and whoever brings one of its invites.
+	// Without a hub we're shutting down, joinRoom says so
+	hub, err := manager.Get(room)
+	if err == nil {
+		if ok, reason := hub.letIn(w, r); !ok {
+			release()
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+	}
+
+	// a full room turns new clients away (admins still get in), the place of the others
+	// is kept for them until they join
+	reserved := err == nil && hub.reserve(requestAdmin(r))
+	if err == nil && !reserved && !hub.closeWhenFull {
+		release()
+		refuseFull(w, r, hub)
+		return
+	}
+
+	// upgrade the HTTP server connection to a websocket connection
+	// (without a hub we're shutting down, whatever we negotiate we won't use it)
+	config := DefaultConfig()
+	if hub != nil {
+		config = hub.config
+	}
+	conn, err := upgraderFor(config).Upgrade(w, r, withProtocol(handshakeHeader(w), r))
+	if err != nil {
+		release()
+		if reserved {
+			hub.unreserve()
+		}
+		// the upgrader has already written an error response, so all we do is log it.
+		// Handshake errors are the client's fault (not a websocket request, bad version, ...),
+		// anything else went wrong on our side
+		var handshakeErr websocket.HandshakeError
+		if errors.As(err, &handshakeErr) {
+			slog.Info("websocket handshake rejected", "remote_ip", clientIP(r), "err", err)
+		} else {
+			slog.Error("websocket upgrade failed", "remote_ip", clientIP(r), "err", err)
+		}
+		return
+	}
+	if hub != nil && !reserved {
+		closeFull(conn, r, hub)
+		release()
+		return
+	}
+
+	id := uuid.New().String()
+
+	// the clients supporting it get their messages compressed (the replay of the history
+	// is a lot of markup repeating itself), the constrained ones as hard as we can
+	constrained := isConstrained(r)
+	conn.EnableWriteCompression(!config.NoCompression)
+	if constrained {
+		conn.SetCompressionLevel(flate.BestCompression)
+	} else {
+		conn.SetCompressionLevel(config.Compression)
+	}
+
+	// create the client
+	client := &Client{
+		id:          id,
+		identityID:  requestIdentity(r),
+		name:        sanitizeName(r.URL.Query().Get("name")),
+		conn:        conn,
+		transport:   wsTransport{conn},
+		ip:          ip,
+		ua:          r.UserAgent(),
+		release:     release,
+		reserved:    hub,
+		constrained: constrained,
+		jsonFrames:  conn.Subprotocol() == JSONProtocol,
+		resumeFrom:  afterID(r),
+		resumeSeq:   resumeSeq(r),
+		closeCode:   websocket.CloseNormalClosure,
+		connectedAt: time.Now(),
+		done:        make(chan struct{}),
+	}
+	client.signIn(r)
+
+	// register the client with the hub of the room it asked for,
+	// if we're shutting down we politely tell the client to go away
+	if err := joinRoom(manager, client, room); err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(DefaultWriteWait))
+		conn.Close()
+		release()
+		return
+	}
+
+	// start the client write and read pumps
+	go client.writePump()
+	go client.readPump()
+}
+
+// tagPattern matches anything that looks like an HTML tag
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// sanitizeName cleans up the name a client asked for: HTML and control characters
+// are stripped, whitespace is trimmed and the result is cut to maxNameLength runes.
+// An empty result means the hub will pick a guest name
+func sanitizeName(name string) string {
+
+	// we strip anything that looks like HTML
+	name = tagPattern.ReplaceAllString(name, "")
+
+	// we drop control characters (newlines, tabs, ...)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// we cut the name to the maximum length
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = strings.TrimSpace(string(runes[:maxNameLength]))
+	}
+
+	return name
+}
+
+// joinRoom registers the client with the hub of the room,
+// if the room was removed while we were joining we try again with a fresh hub.
+// It returns ErrClosed when the manager is shut down
+func joinRoom(manager *HubManager, client *Client, room string) error {
+	for {
+		hub, err := manager.Get(room)
+		if err != nil {
+			return err
+		}
+		client.hub = hub
+		// the lines the client logs say who it is and which room it is in
+		client.logger = hub.logger.With("client_id", client.id, "remote_ip", client.ip)
+		// the send buffer size depends on the hub the client joins
+		client.send = make(chan Frame, client.hub.sendBuffer)
+		client.registered = make(chan struct{})
+		// and so does the rate limit
+		client.limiter = rate.NewLimiter(client.hub.rateLimit, client.hub.rateBurst)
+		// the client hasn't been idle yet
+		client.touch()
+		if client.hub.join(client) {
+			return nil
+		}
+	}
+}
+
+// roomName returns the room asked for in the path (/ws/{room}) or with the ?room= query param
+func roomName(r *http.Request) string {
+	if room := r.PathValue("room"); room != "" {
+		return room
+	}
+	if room := strings.TrimSpace(r.URL.Query().Get("room")); room != "" {
+		return room
+	}
+	return DefaultRoom
+}
+
+// isConstrained reports whether the request asks for the lean delivery mode,
+// either with the ?lite=1 query param or the standard Save-Data header
+func isConstrained(r *http.Request) bool {
+	if lite := r.URL.Query().Get("lite"); lite == "1" || lite == "true" {
+		return true
+	}
+	return strings.EqualFold(r.Header.Get("Save-Data"), "on")
+}
+
+// readPump pumps messages from the websocket connection to the hub.
+func (c *Client) readPump() {
+
+	defer func() {
+		// we unregister the client from the hub first (unless the hub is already gone,
+		// in which case it removed every client on its way out)
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.stop:
+		}
+		// removing the client closes its send channel, so we wait for writePump
+		// to send the close frame and return, after that nobody writes to the connection
+		<-c.done
+		// and only then we close the connection
+		c.conn.Close()
+		if c.release != nil {
+			c.release()
+		}
+		c.logClosed()
+	}()
+	// a panic only takes this connection down (the deferred calls above still run)
+	defer func() {
+		if r := recover(); r != nil {
+			c.hub.recovered("readPump", r)
+		}
+	}()
+
+	// set the read limit for the connection,
+	// this is to prevent the client from sending huge messages.
+	// We read a bit more than the maximum message size so that a message that
+	// is just too long gets an error back instead of closing the connection
+	limit := c.hub.config.MaxMessageSize * readLimitFactor
+	c.conn.SetReadLimit(limit)
+	// set the read deadline for the connection,
+	// this is to prevent the client from hanging the connection open
+	c.conn.SetReadDeadline(c.hub.clock.Now().Add(c.hub.config.PongWait))
+	// set the pong handler for the connection,
+	// this is to handle the pong message the client sends back for each of our pings
+	// (we leave gorilla's default ping handler in place, it answers pings with a pong)
+	c.conn.SetPongHandler(func(appData string) error {
+		// the pong echoes the time its ping was written, which gives us the round-trip time
+		c.pong(appData, c.hub.clock.Now())
+		// set the read deadline for the connection,
+		// this is to prevent the client from hanging the connection open
+		c.conn.SetReadDeadline(c.hub.clock.Now().Add(c.hub.config.PongWait))
+		return nil
+	})
+
+	// we start listening for messages from the client
+	for {
+		// read a message from the connection
+		text, err := readMessage(c.conn, limit)
+		// we have to handle the error here otherwise the connection will hang open,
+		// and the client will not be able to send any more messages
+		if err != nil {
+			c.hub.metrics.readError(err)
+			// we log the error, and check if it is an unexpected close error (client disconnected)
+			// if it is not, we break the loop and close the connection
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.logger.Warn("reading from the connection", "err", err)
+			}
+			break // break the loop if there is an error (client disconnected)
+		}
+		// what the clients write is theirs, it only shows up in the debug logs
+		c.logger.Debug("frame received", "payload", string(text))
+		c.received.Add(1)
+		c.bytesIn.Add(uint64(len(text)))
+
+		// a dashboard only watches, there is nothing it can send
+		if c.role == roleObserver {
+			continue
+		}
+
+		// if the message is too long we tell the client instead of broadcasting it
+		if int64(len(text)) > c.hub.config.MaxMessageSize {
+			if !c.hub.notice(c, "message too long") {
+				return
+			}
+			continue
+		}
+
+		// before decoding we make sure the payload is not nested too deeply,
+		// this is to prevent the client from making us walk huge HEADERS objects
+		if err := checkPayloadDepth(text, maxPayloadDepth); err != nil {
+			c.logger.Warn("frame rejected", "err", err)
+			continue
+		}
+
+		// create a message from the text sent by the client
+		msg := &WSMessage{}
+		// create a reader from the text
+		reader := bytes.NewReader(text)
+		// create a decoder from the reader
+		decoder := json.NewDecoder(reader)
+		// decode the message from the decoder
+		err = decoder.Decode(&msg)
+		if err != nil {
+			// we don't broadcast anything we couldn't decode
+			c.logger.Warn("decoding frame", "err", err)
+			continue
+		}
+		// whatever the client sent, someone is still there
+		c.touch()
+
+		// typing events are not chat messages, we forward them to the hub (at most once
+		// every typingDebounce) so it can show the typing indicator to everyone else
+		if msg.Type == TypeTyping {
+			if c.hub.clock.Now().Sub(c.lastTyping) < typingDebounce {
+				continue
+			}
+			c.lastTyping = c.hub.clock.Now()
+
+			select {
+			case c.hub.typing <- c:
+			case <-c.hub.stop:
+				return
+			}
+			continue
+		}
+
+		// clients can delete their own messages, the hub tells them if they can't
+		if msg.Type == TypeDelete {
+			err := ErrNoSuchMessage
+			if id, ok := messageID(string(msg.ID)); ok {
+				err = c.hub.requestDelete(id, c.id)
+			}
+			if err != nil && !c.hub.notice(c, err.Error()) {
+				return
+			}
+			continue
+		}
+
+		// read receipts come in as the messages scroll into view, they never fail
+		if msg.Type == TypeRead {
+			if id, ok := messageID(string(msg.ID)); ok {
+				select {
+				case c.hub.receipts <- &readReceipt{client: c, id: id}:
+				case <-c.hub.stop:
+					return
+				}
+			}
+			continue
+		}
+
+		// reactions are toggled by the hub, they are rate limited along with the messages
+		if msg.Type == TypeReact {
+			id, ok := messageID(string(msg.ID))
+			if !ok {
+				if !c.hub.notice(c, ErrNoSuchMessage.Error()) {
+					return
+				}
+				continue
+			}
+			if !c.limiter.Allow() {
+				if !c.hub.notice(c, "you're reacting too quickly") {
+					return
+				}
+				continue
+			}
+
+			select {
+			case c.hub.react <- &reactRequest{client: c, id: id, emoji: strings.TrimSpace(msg.Emoji)}:
+			case <-c.hub.stop:
+				return
+			}
+			continue
+		}
+
+		// anything else has to be a chat message, or the new text of one
+		if msg.Type != "" && msg.Type != TypeChat && msg.Type != TypeEdit {
+			c.logger.Warn("unknown message type", "type", msg.Type)
+			continue
+		}
+		var edit, replyTo uint64
+		var ok bool
+		if strings.TrimSpace(msg.ReplyTo) != "" && msg.Type != TypeEdit {
+			if replyTo, ok = messageID(msg.ReplyTo); !ok {
+				if !c.hub.notice(c, ErrNoSuchMessage.Error()) {
+					return
+				}
+				continue
+			}
+		}
+		if msg.Type == TypeEdit {
+			if edit, ok = messageID(string(msg.ID)); !ok {
+				if !c.hub.notice(c, ErrNoSuchMessage.Error()) {
+					return
+				}
+				continue
+			}
+		}
+
+		// we validate the text before going any further, a rejected message
+		// is only reported back to the client that sent it
+		chat, err := validateText(msg.Text)
+		if err != nil {
+			if !c.hub.notice(c, err.Error()) {
+				return
+			}
+			continue
+		}
+
+		// muted clients stay connected but their messages bounce
+		if until, muted := c.hub.mutes.mutedUntil(c); muted {
+			if !c.hub.notice(c, fmt.Sprintf("you are muted until %s", until.Format("15:04"))) {
+				return
+			}
+			continue
+		}
+
+		// chat messages are rate limited, a client sending too quickly gets a warning
+		// instead of its message being broadcast, is muted if it does it too often
+		// and is disconnected if it keeps going
+		if !c.limiter.Allow() {
+			// (the name can change with /nick, the hub only does that under its lock)
+			c.hub.RLock()
+			name := c.name
+			c.hub.RUnlock()
+			if until, muted := c.hub.mutes.strike(c.hub.room, c.identity(), name); muted {
+				c.logger.Info("client muted for sending too many messages")
+				if !c.hub.notice(c, fmt.Sprintf("you're sending messages too quickly, you are muted until %s", until.Format("15:04"))) {
+					return
+				}
+				continue
+			}
+			c.violations++
+			if c.violations >= rateLimitStrikes {
+				c.logger.Info("client disconnected for sending too many messages")
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "sending messages too quickly"),
+					c.hub.clock.Now().Add(c.hub.config.WriteWait))
+				return
+			}
+			if !c.hub.notice(c, "you're sending messages too quickly") {
+				return
+			}
+			continue
+		}
+		c.violations = 0
+
+		// create a message with the client id and the message text
+		select {
+		case c.hub.broadcast <- &Message{
+			Kind:     KindChat,
+			ClientID: c.id,
+			Text:     chat,
+			To:       strings.TrimSpace(msg.To),
+			Edit:     edit,
+			ReplyTo:  replyTo,
+			Ref:      messageRef(msg.Ref),
+		}:
+		case <-c.hub.stop:
+			// the hub is shutting down, there is nobody to send the message to
+			return
+		}
+	}
+
+}
+
+// writePump pumps messages from the hub to the websocket connection.
+func (c *Client) writePump() {
+
+	ticks, stopTicker := c.hub.clock.NewTicker(c.hub.config.PingPeriod)
+	// a client whose credentials expire is disconnected once they do
+	var expired <-chan time.Time
+	if !c.expires.IsZero() {
+		var stopTimer func()
+		expired, stopTimer = c.hub.clock.NewTimer(c.expires.Sub(c.hub.clock.Now()))
+		defer stopTimer()
+	}
+	defer func() {
+		stopTicker()
+		// close the connection when the function returns, if we stopped because a write
+		// failed this is what makes readPump return and unregister the client
+		c.conn.Close()
+		// let the hub know we're done writing
+		close(c.done)
+	}()
+	// a panic only takes this connection down (the deferred calls above still run)
+	defer func() {
+		if r := recover(); r != nil {
+			c.hub.recovered("writePump", r)
+		}
+	}()
+
+	// we start by writing the message history the hub gave us on registration,
+	// along with the sequence number the page is at from now on
+	// (the JSON clients don't resume, they have no use for it)
+	if len(c.replay) > 0 || (c.replaySeq > 0 && !c.jsonFrames) {
+		batch := c.batch()
+		for _, frame := range c.replay {
+			if err := batch.add(frame.Data); err != nil {
+				return
+			}
+		}
+		if !c.jsonFrames {
+			if err := batch.add(seqMarker(c.replaySeq)); err != nil {
+				return
+			}
+		}
+		if err := batch.flush(); err != nil {
+			return
+		}
+		c.sent.Add(uint64(len(c.replay)))
+		c.bytesOut.Add(uint64(batch.written))
+		// we don't need the history anymore
+		c.replay = nil
+	}
+
+	for {
+		select {
+		case frame, ok := <-c.send:
+			// set the write deadline for the connection,
+			// this is to prevent the client from hanging the connection open
+			c.conn.SetWriteDeadline(c.hub.clock.Now().Add(c.hub.config.WriteWait))
+			if !ok {
+				// we can send a close message to the client
+				// and return if the channel is closed (hub closed the channel)
+				c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason), c.hub.clock.Now().Add(c.hub.config.WriteWait))
+				return
+			}
+
+			// write the frame sent to us from the hub to the connection
+			batch := c.batch()
+			if err := batch.add(frame.Data); err != nil {
+				return
+			}
+			seq := frame.Seq
+			c.sent.Add(1)
+
+			// along with the frames queued behind it, in the same websocket message
+			// as long as it doesn't grow too big (see batchWriter)
+			n := len(c.send)
+			for i := 0; i < n; i++ {
+				frame := <-c.send
+				if err := batch.add(frame.Data); err != nil {
+					return
+				}
+				seq = max(seq, frame.Seq)
+			}
+			c.sent.Add(uint64(n))
+
+			// the page keeps the sequence number of the last broadcast frame it got
+			if seq > 0 && !c.jsonFrames {
+				if err := batch.add(seqMarker(seq)); err != nil {
+					return
+				}
+			}
+			c.bytesOut.Add(uint64(batch.written))
+
+			if err := batch.flush(); err != nil {
+				return // this should be handled better
+			}
+
+		case <-ticks:
+			// set the write deadline for the connection,
+			// this is to prevent the client from hanging the connection open
+			c.conn.SetWriteDeadline(c.hub.clock.Now().Add(c.hub.config.WriteWait))
+			if err := c.conn.WriteControl(websocket.PingMessage, pingPayload(c.hub.clock.Now()), c.hub.clock.Now().Add(c.hub.config.WriteWait)); err != nil {
+				return // this should be handled better
+			}
+
+		case <-expired:
+			// closing the connection makes readPump return and unregister the client
+			c.logger.Info("client disconnected: its token expired")
+			c.conn.SetWriteDeadline(c.hub.clock.Now().Add(c.hub.config.WriteWait))
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"), c.hub.clock.Now().Add(c.hub.config.WriteWait))
+			return
+		}
+	}
+}
+
+// validateText trims the text of a chat message and checks it is neither empty nor too long,
+// the error is meant to be shown to the client
+func validateText(text string) (string, error) {
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("message is empty")
+	}
+	if utf8.RuneCountInString(text) > maxTextLength {
+		return "", fmt.Errorf("message is too long (at most %d characters)", maxTextLength)
+	}
+
+	return text, nil
+}
+
+// checkPayloadDepth returns an error if the JSON payload is nested deeper than max
+func checkPayloadDepth(data []byte, max int) error {
+
+	depth := 0
+	inString := false
+	escaped := false
+
+	for _, b := range data {
+		// we skip over anything inside a string, including escaped quotes
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return errors.New("payload nested too deeply")
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package chatter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestValidateText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"empty", "", "", true},
+		{"whitespace", " \t\n ", "", true},
+		{"too long", strings.Repeat("é", maxTextLength+1), "", true},
+		{"at the limit", strings.Repeat("é", maxTextLength), strings.Repeat("é", maxTextLength), false},
+		{"valid", "  hello  ", "hello", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateText(tt.text)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("validateText() = %q, %v, want %q (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPayloadDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr bool
+	}{
+		{"flat headers", `{"text":"hi","HEADERS":{"HX-Request":"true"}}`, false},
+		{"at the limit", `{"HEADERS":{"a":{"b":1}}}`, false},
+		{"over the limit", `{"HEADERS":{"a":{"b":{"c":1}}}}`, true},
+		{"arrays count too", `{"HEADERS":[[[1]]]}`, true},
+		{"brackets in strings don't count", `{"text":"{{{{[[[[","HEADERS":{}}`, false},
+		{"escaped quotes stay in the string", `{"text":"\"{{{{\"","HEADERS":{}}`, false},
+		{"deeply nested", `{"HEADERS":` + strings.Repeat(`{"a":`, 10000) + `1` + strings.Repeat(`}`, 10000) + `}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPayloadDepth([]byte(tt.payload), maxPayloadDepth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPayloadDepth() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWritePumpSendsQueuedFramesOnce(t *testing.T) {
+	conn := newFakeConn()
+	conn.gate = make(chan struct{})
+	client := pumpClient(NewHub(), conn)
+	go client.writePump()
+
+	// the first frame is being written while the others pile up behind it
+	var want []string
+	for i := 0; i < 10; i++ {
+		frame := fmt.Sprintf(`<div id="f%d"></div>`, i)
+		want = append(want, frame)
+		client.send <- Frame{Data: []byte(frame)}
+	}
+	close(conn.gate)
+	close(client.send)
+	<-client.done
+
+	// every frame came once and in order, the queued ones coalesced in as few messages as it took
+	if got := conn.Fragments(); !slices.Equal(got, want) {
+		t.Errorf("the fragments written are %q, want %q", got, want)
+	}
+	if messages := len(conn.Messages()); messages >= len(want) {
+		t.Errorf("the frames took %d messages, the queued ones weren't coalesced", messages)
+	}
+}
+
+// waitPings waits for n pings to be written to the connection
+func waitPings(t *testing.T, conn *fakeConn, n int) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for len(conn.Pings()) < n {
+		select {
+		case <-conn.wrote:
+		case <-timeout:
+			t.Fatalf("%d pings were written, want %d", len(conn.Pings()), n)
+		}
+	}
+}
+
+func TestWritePumpPingsEveryPeriod(t *testing.T) {
+	clock := newFakeClock()
+	hub := runHub(t, NewHub(WithClock(clock)))
+	conn := newFakeConn()
+	startClient(t, hub, conn, "alice")
+	// the sweep of the hub and the pings of the pump
+	clock.WaitTickers(t, 2)
+
+	clock.Advance(hub.config.PingPeriod - time.Millisecond)
+	if pings := conn.Pings(); len(pings) != 0 {
+		t.Fatalf("pings %q were written before the period", pings)
+	}
+	clock.Advance(time.Millisecond)
+	waitPings(t, conn, 1)
+	clock.Advance(hub.config.PingPeriod)
+	waitPings(t, conn, 2)
+
+	// the pings carry the time they were written, for the round-trip times
+	pings := conn.Pings()
+	first, _ := strconv.ParseInt(pings[0], 10, 64)
+	second, _ := strconv.ParseInt(pings[1], 10, 64)
+	if time.Duration(second-first) != hub.config.PingPeriod {
+		t.Errorf("the pings %q aren't a period apart", pings)
+	}
+}
+
+func TestWritePumpClosesWhenTheSendChannelIs(t *testing.T) {
+	conn := newFakeConn()
+	client := pumpClient(NewHub(WithClock(newFakeClock())), conn)
+	client.closeCode, client.closeReason = websocket.CloseGoingAway, "server restarting"
+	go client.writePump()
+
+	// the hub closes the channel when it removes the client
+	client.send <- Frame{Data: []byte(`<div id="last"></div>`)}
+	close(client.send)
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("writePump didn't return")
+	}
+
+	// the frames queued before still go out, then the close frame with the hub's reason
+	if got := conn.Fragments(); !slices.Equal(got, []string{`<div id="last"></div>`}) {
+		t.Errorf("the fragments written are %q", got)
+	}
+	if code, reason, ok := conn.CloseFrame(); !ok || code != websocket.CloseGoingAway || reason != "server restarting" {
+		t.Errorf("the close frame is %d %q (written: %v)", code, reason, ok)
+	}
+	if !conn.Closed() {
+		t.Error("the connection is still open")
+	}
+}
+
+func TestReadPumpUnregistersOnReadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fail func(conn *fakeConn)
+	}{
+		// the peer sent a close frame
+		{"closed by the peer", func(conn *fakeConn) { close(conn.incoming) }},
+		// the connection broke (e.g. the read deadline passed)
+		{"broken connection", func(conn *fakeConn) { conn.Close() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := runHub(t, NewHub(WithClock(newFakeClock())))
+			conn := newFakeConn()
+			client := startClient(t, hub, conn, "alice")
+			waitWritten(t, conn, "alice")
+
+			tt.fail(conn)
+			select {
+			case <-client.done:
+			case <-time.After(time.Second):
+				t.Fatal("the pumps didn't stop")
+			}
+			waitClosed(t, conn)
+			for deadline := time.Now().Add(time.Second); hub.Stats().Clients != 0; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("the hub still has %d clients", hub.Stats().Clients)
+				}
+			}
+		})
+	}
+}
+
+func TestClientsAreDisconnectedWhenTheirCredentialsExpire(t *testing.T) {
+	clock := newFakeClock()
+	hub := runHub(t, NewHub(WithClock(clock)))
+	conn := newFakeConn()
+	client := joinHub(t, hub, conn, "alice")
+	client.expires = clock.Now().Add(time.Hour)
+	go client.writePump()
+	go client.readPump()
+	clock.WaitTickers(t, 3)
+
+	clock.Advance(time.Hour - time.Second)
+	if _, _, ok := conn.CloseFrame(); ok || conn.Closed() {
+		t.Fatal("alice was disconnected before their token expired")
+	}
+	clock.Advance(time.Second)
+	waitClosed(t, conn)
+	if code, reason, _ := conn.CloseFrame(); code != websocket.ClosePolicyViolation || reason != "token expired" {
+		t.Errorf("the close frame is %d %q", code, reason)
+	}
+}
+
+// idleConnections is the number of connections BenchmarkIdleConnectionMemory holds open
+const idleConnections = 200
+
+// BenchmarkIdleConnectionMemory measures the memory held by connections that wrote a message
+// and went idle, with the shared pool of write buffers of upgraderFor and without it (both ends
+// are in the process, only the server's end changes)
+func BenchmarkIdleConnectionMemory(b *testing.B) {
+	for _, bb := range []struct {
+		name   string
+		size   int
+		pooled bool
+	}{
+		{"1KiB/pooled", 1024, true},
+		{"1KiB/unpooled", 1024, false},
+		{"16KiB/pooled", 16 << 10, true},
+		{"16KiB/unpooled", 16 << 10, false},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			u := upgraderFor(Config{ReadBufferSize: bb.size, WriteBufferSize: bb.size}.withDefaults())
+			if !bb.pooled {
+				u.WriteBufferPool = nil
+			}
+			var mu sync.Mutex
+			var conns []*websocket.Conn
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := u.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(websocket.TextMessage, []byte(`<div id="chat_room"></div>`))
+				mu.Lock()
+				conns = append(conns, conn)
+				mu.Unlock()
+			}))
+			defer srv.Close()
+			endpoint := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+			var held int64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				clients := make([]*websocket.Conn, idleConnections)
+				for j := range clients {
+					client, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, _, err := client.ReadMessage(); err != nil {
+						b.Fatal(err)
+					}
+					clients[j] = client
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				held += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+
+				for _, client := range clients {
+					client.Close()
+				}
+				mu.Lock()
+				for _, conn := range conns {
+					conn.Close()
+				}
+				conns = nil
+				mu.Unlock()
+			}
+			b.ReportMetric(float64(held)/float64(b.N*idleConnections), "bytes/conn")
+		})
+	}
+}
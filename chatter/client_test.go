@@ -0,0 +1,289 @@
+package chatter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/gorilla/websocket"
+)
+
+func TestNestedHeadersAreRejected(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+
+	// the headers nest deeper than htmx would ever send them, the frame is dropped
+	// without being decoded and the connection stays up
+	nested := map[string]any{"leaf": "x"}
+	for i := 0; i < 50; i++ {
+		nested = map[string]any{"a": nested}
+	}
+	alice.SendJSON(map[string]any{"text": "nested headers", "HEADERS": nested})
+	alice.Send("flat headers")
+
+	bob.Expect("flat headers", waitTimeout)
+	assertHistory(t, srv, chatter.DefaultRoom, "flat headers")
+}
+
+// assertHistory fails the test unless the texts of the history of the room are want
+func assertHistory(t *testing.T, srv *chattertest.Server, room string, want ...string) {
+	t.Helper()
+	hub, err := srv.Manager.Get(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := hub.History(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, msg := range messages {
+		if msg.Kind == chatter.KindChat {
+			got = append(got, msg.Text)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("the history of %s is %q, want %q", room, got, want)
+	}
+}
+
+func TestIdleClientsAnsweringPingsStayConnected(t *testing.T) {
+	// a short pong wait, so staying idle past it doesn't take a minute
+	config := chatter.Config{PongWait: 300 * time.Millisecond, PingPeriod: 100 * time.Millisecond}
+	srv := chattertest.NewServer(t, chatter.WithConfig(config))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+
+	// alice doesn't send anything for a few pong waits, her pongs keep her connected
+	time.Sleep(4 * config.PongWait)
+	bob.Send("still there?")
+	alice.Expect("still there?", waitTimeout)
+}
+
+func TestClientsNotAnsweringPingsAreDisconnected(t *testing.T) {
+	config := chatter.Config{PongWait: 300 * time.Millisecond, PingPeriod: 100 * time.Millisecond}
+	srv := chattertest.NewServer(t, chatter.WithConfig(config))
+
+	endpoint := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?name=mute"
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the client reads, but never answers the pings
+	conn.SetPingHandler(func(string) error { return nil })
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(waitTimeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatalf("the client is still connected after %s", waitTimeout)
+			}
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed < config.PongWait {
+		t.Errorf("the client was disconnected after %s, before its pong wait", elapsed)
+	}
+}
+
+// sized returns a chat message with the text, padded in its headers to be exactly size bytes
+func sized(t *testing.T, text string, size int) map[string]any {
+	t.Helper()
+	msg := map[string]any{"text": text, "HEADERS": map[string]string{"HX-Current-URL": ""}}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg["HEADERS"] = map[string]string{"HX-Current-URL": strings.Repeat("x", size-len(data))}
+	return msg
+}
+
+func TestMessageSizeBoundary(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	limit := int(chatter.DefaultMaxMessageSize)
+
+	// right at the limit the message goes through
+	alice.SendJSON(sized(t, "at the limit", limit))
+	alice.Expect("at the limit", waitTimeout)
+
+	// a byte over it the sender is told, and stays connected
+	alice.SendJSON(sized(t, "over the limit", limit+1))
+	alice.Expect("message too long", waitTimeout)
+	alice.Send("still connected")
+	alice.Expect("still connected", waitTimeout)
+	assertHistory(t, srv, chatter.DefaultRoom, "at the limit", "still connected")
+
+	// and way over it the connection is closed
+	alice.SendJSON(sized(t, "way over the limit", 5*limit))
+	if closeErr := alice.ExpectClosed(waitTimeout); closeErr == nil || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("the close frame is %v, want %d", closeErr, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestBurstsAreRateLimited(t *testing.T) {
+	const burst, sent = 3, 8
+	// the bucket doesn't refill during the test
+	srv := chattertest.NewServer(t, chatter.WithRateLimit(0.001, burst))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	alice.Expect("Online (2)", waitTimeout)
+
+	for i := 1; i <= sent; i++ {
+		alice.Send(fmt.Sprintf("burst %d", i))
+	}
+	// the sender is warned about each message over the limit, after that it read them all
+	// (the frames written together come in a single websocket message)
+	if warnings := countUntil(alice, "too quickly", sent-burst); warnings != sent-burst {
+		t.Errorf("alice got %d warnings, want %d", warnings, sent-burst)
+	}
+
+	// the others only got the burst
+	if delivered := countUntil(bob, "burst ", burst); delivered != burst {
+		t.Errorf("bob got %d messages, want %d", delivered, burst)
+	}
+	bob.ExpectNone("burst", 200*time.Millisecond)
+}
+
+// countUntil reads the frames of the client until substring came n times, and returns
+// how many times it came
+func countUntil(client *chattertest.Client, substring string, n int) int {
+	count := 0
+	for count < n {
+		count += strings.Count(client.Next(waitTimeout), substring)
+	}
+	return count
+}
+
+func TestInvalidMessagesOnlyGetTheSenderAnError(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	alice.Expect("Online (2)", waitTimeout)
+
+	for _, tt := range []struct {
+		name, text string
+		valid      bool
+	}{
+		{"empty", "", false},
+		{"whitespace", "   \t ", false},
+		{"too long", strings.Repeat("a", 1001), false},
+		{"valid", "  a valid message  ", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			alice.Send(tt.text)
+			if tt.valid {
+				bob.Expect("<p>a valid message</p>", waitTimeout)
+				return
+			}
+			// the error goes to the #error element of the sender, the others get nothing
+			if frame := alice.Expect(`id="error"`, waitTimeout); !strings.Contains(frame, "message is") {
+				t.Errorf("the sender got the error:\n%s", frame)
+			}
+			bob.ExpectNone(`class="text-base"`, 100*time.Millisecond)
+		})
+	}
+}
+
+// logBuffer keeps the JSON lines logged to it, from any goroutine
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Lines returns the lines logged so far, decoded
+func (b *logBuffer) Lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("decoding the log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// Find waits for a line with the message msg and the attributes (key, value, ...) to be logged,
+// numbers are float64s as JSON has them
+func (b *logBuffer) Find(t *testing.T, msg string, attrs ...any) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+	lines:
+		for _, line := range b.Lines(t) {
+			if line["msg"] != msg {
+				continue
+			}
+			for i := 0; i+1 < len(attrs); i += 2 {
+				if line[attrs[i].(string)] != attrs[i+1] {
+					continue lines
+				}
+			}
+			return line
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q was never logged with %v", msg, attrs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientsAreLoggedWithTheirFields(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		t.Run(level.String(), func(t *testing.T) {
+			logs := &logBuffer{}
+			srv := chattertest.NewServer(t, chatter.WithLogger(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: level}))))
+			alice := srv.Connect(t, "alice")
+			bob := srv.Connect(t, "bob")
+			alice.Send("a secret")
+			bob.Expect("a secret", waitTimeout)
+			alice.Close()
+
+			// the lifecycle of the client is logged with who it is and where it is
+			for _, msg := range []string{"client connected", "client disconnected"} {
+				line := logs.Find(t, msg, "name", "alice")
+				if line["room"] != chatter.DefaultRoom || line["client_id"] == nil || line["remote_ip"] != "127.0.0.1" {
+					t.Errorf("%q was logged as %v", msg, line)
+				}
+			}
+
+			// and what it sends only at the debug level
+			logged := false
+			for _, line := range logs.Lines(t) {
+				if payload, _ := line["payload"].(string); strings.Contains(payload, "a secret") {
+					logged = true
+					if line["level"] != "DEBUG" {
+						t.Errorf("the payload was logged at %v", line["level"])
+					}
+				}
+			}
+			if logged != (level == slog.LevelDebug) {
+				t.Errorf("the payload was logged at the %s level: %t", level, logged)
+			}
+		})
+	}
+}
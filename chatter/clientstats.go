@@ -0,0 +1,121 @@
+package chatter
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ClientStats is a snapshot of a connected client
+type ClientStats struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Room        string    `json:"room"`
+	Transport   string    `json:"transport"` // websocket or sse
+	RemoteIP    string    `json:"remote_ip"`
+	UserAgent   string    `json:"user_agent"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Uptime      float64   `json:"uptime_seconds"`
+	Received    uint64    `json:"received"`  // frames the client sent us
+	Sent        uint64    `json:"sent"`      // frames we sent the client
+	BytesIn     uint64    `json:"bytes_in"`  // size of the frames the client sent us
+	BytesOut    uint64    `json:"bytes_out"` // size of the frames we sent the client
+	Dropped     uint64    `json:"dropped"`   // frames the client missed because it couldn't keep up
+	RTT         float64   `json:"rtt_ms,omitempty"`
+}
+
+// stats returns a snapshot of the client, the counters are atomic so the pumps never wait on it
+func (c *Client) stats(now time.Time) ClientStats {
+	return ClientStats{
+		ID:          c.id,
+		Name:        c.name,
+		Room:        c.hub.room,
+		Transport:   c.transport.name(),
+		RemoteIP:    c.ip,
+		UserAgent:   c.ua,
+		ConnectedAt: c.connectedAt,
+		Uptime:      now.Sub(c.connectedAt).Seconds(),
+		Received:    c.received.Load(),
+		Sent:        c.sent.Load(),
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		Dropped:     c.dropped.Load(),
+		RTT:         float64(c.rtt()) / float64(time.Millisecond),
+	}
+}
+
+// clientStats returns a snapshot of the clients of the room, in the order they joined.
+// It is safe to call from any goroutine
+func (h *Hub) clientStats(now time.Time) []ClientStats {
+	h.RLock()
+	defer h.RUnlock()
+	stats := make([]ClientStats, len(h.order))
+	for i, client := range h.order {
+		stats[i] = client.stats(now)
+	}
+	return stats
+}
+
+// Clients returns a snapshot of the clients of every room, sorted by sortBy: "messages"
+// (most frames both ways first), "bytes" (most bytes both ways first) or, by default,
+// "uptime" (connected the longest first)
+func (m *HubManager) Clients(sortBy string) []ClientStats {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	now := time.Now()
+	list := []ClientStats{}
+	for _, hub := range hubs {
+		list = append(list, hub.clientStats(now)...)
+	}
+
+	var key func(ClientStats) float64
+	switch sortBy {
+	case "messages":
+		key = func(s ClientStats) float64 { return float64(s.Received + s.Sent) }
+	case "bytes":
+		key = func(s ClientStats) float64 { return float64(s.BytesIn + s.BytesOut) }
+	default:
+		key = func(s ClientStats) float64 { return s.Uptime }
+	}
+	slices.SortStableFunc(list, func(a, b ClientStats) int {
+		switch ka, kb := key(a), key(b); {
+		case ka > kb:
+			return -1
+		case ka < kb:
+			return 1
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list
+}
+
+// ClientsHandler handles GET /admin/clients, the table of the connected clients in JSON, or
+// as an HTML fragment for the requests of htmx and browsers. ?sort= orders it (see Clients).
+// It should be wrapped in AdminAuth
+func ClientsHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients := manager.Clients(r.URL.Query().Get("sort"))
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Header.Get("HX-Request") == "true" || strings.Contains(r.Header.Get("Accept"), "text/html") {
+			rendered := getClientsTemplate(clients)
+			if rendered == nil {
+				http.Error(w, "Could not render the clients", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(rendered)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clients)
+	})
+}
@@ -0,0 +1,163 @@
+package chatter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Command is a slash command sent by a client, e.g. "/nick alice"
+type Command struct {
+	Name    string   // name of the command without the slash ("nick")
+	Args    string   // everything after the name, trimmed ("alice")
+	Message *Message // the message the command was sent in (its sender, ...)
+
+	client *Client        // the client that sent the command
+	next   MessageHandler // the rest of the middlewares, for commands that broadcast
+}
+
+// CommandHandler runs a command, it is called on the hub goroutine
+type CommandHandler func(cmd *Command)
+
+// commands are the registered commands by name
+var (
+	commandsMu sync.RWMutex
+	commands   = map[string]CommandHandler{}
+)
+
+// RegisterCommand makes /name run handler, registering a name again replaces its handler
+func RegisterCommand(name string, handler CommandHandler) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	commands[strings.ToLower(name)] = handler
+}
+
+// the commands every hub knows
+func init() {
+	RegisterCommand("help", helpCommand)
+	RegisterCommand("nick", nickCommand)
+	RegisterCommand("me", meCommand)
+	RegisterCommand("pin", pinCommand)
+	RegisterCommand("unpin", unpinCommand)
+}
+
+// Reply sends an error to the sender of the command only
+func (cmd *Command) Reply(text string) {
+	cmd.Message.Reply(text)
+}
+
+// Broadcast sends the message to the room on behalf of the sender of the command,
+// it goes through the same middlewares as a regular message
+func (cmd *Command) Broadcast(msg *Message) {
+	msg.ClientID = cmd.Message.ClientID
+	msg.Username = cmd.client.name
+	msg.accepted, msg.hub = cmd.Message.accepted, cmd.Message.hub
+	cmd.next(msg)
+}
+
+// commandMiddleware runs the messages starting with a slash as commands instead of
+// broadcasting them, "//" escapes a message that really starts with a slash
+func (h *Hub) commandMiddleware(next MessageHandler) MessageHandler {
+	return func(msg *Message) {
+
+		// only our own clients can run commands (bots post text as it is),
+		// and an edit is just the new text of the message
+		client, ok := h.ids[msg.ClientID]
+		if !ok || msg.Kind != KindChat || msg.Edit != 0 || !strings.HasPrefix(msg.Text, "/") {
+			next(msg)
+			return
+		}
+		if strings.HasPrefix(msg.Text, "//") {
+			msg.Text = msg.Text[1:]
+			next(msg)
+			return
+		}
+
+		name, args, _ := strings.Cut(msg.Text[1:], " ")
+		cmd := &Command{
+			Name:    strings.ToLower(name),
+			Args:    strings.TrimSpace(args),
+			Message: msg,
+			client:  client,
+			next:    next,
+		}
+
+		commandsMu.RLock()
+		handler, ok := commands[cmd.Name]
+		commandsMu.RUnlock()
+		if !ok {
+			cmd.Reply(fmt.Sprintf("unknown command /%s, try /help", cmd.Name))
+			return
+		}
+
+		// a broken command shouldn't take the hub down
+		h.hook("command /"+cmd.Name, func() { handler(cmd) })
+	}
+}
+
+// helpCommand lists the commands to the sender
+func helpCommand(cmd *Command) {
+
+	commandsMu.RLock()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, "/"+name)
+	}
+	commandsMu.RUnlock()
+	sort.Strings(names)
+
+	if rendered := getHelpTemplate(names); rendered != nil {
+		deliverNotice(cmd.client, rendered)
+	}
+}
+
+// nickCommand changes the name of the sender
+func nickCommand(cmd *Command) {
+
+	// signed in clients go by the name they signed in with
+	if cmd.client.user != "" {
+		cmd.Reply(fmt.Sprintf("you are signed in as %s", cmd.client.user))
+		return
+	}
+
+	name := sanitizeName(cmd.Args)
+	if name == "" {
+		cmd.Reply("usage: /nick <name>")
+		return
+	}
+
+	h := cmd.Message.hub
+	old := cmd.client.name
+	if name == old {
+		return
+	}
+	if _, taken := h.names[name]; taken {
+		cmd.Reply(fmt.Sprintf("%s is already taken", name))
+		return
+	}
+
+	h.Lock()
+	delete(h.names, old)
+	h.names[name] = cmd.client
+	cmd.client.name = name
+	h.remember(name, cmd.client.identity())
+	h.Unlock()
+
+	// the presence list and the room should know
+	h.schedulePresence()
+	h.broadcastMessage(&Message{
+		Kind: KindSystem,
+		Text: fmt.Sprintf("%s is now known as %s", old, name),
+	})
+}
+
+// meCommand broadcasts an action ("/me waves" shows as "alice waves")
+func meCommand(cmd *Command) {
+	if cmd.Args == "" {
+		cmd.Reply("usage: /me <action>")
+		return
+	}
+
+	cmd.Broadcast(&Message{Kind: KindAction, Text: cmd.Args})
+}
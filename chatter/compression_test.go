@@ -0,0 +1,197 @@
+package chatter_test
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+)
+
+// replayed is the number of messages in the history replayed to the clients of replayServer
+const replayed = 200
+
+// countingListener counts the bytes written to the connections it accepts
+type countingListener struct {
+	net.Listener
+	written *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, written: l.written}, nil
+}
+
+// countingConn is a connection of a countingListener
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// replayServer starts a chat with the config whose lobby has the history replayed to the
+// clients joining it, the bytes the server writes are counted in written
+func replayServer(tb testing.TB, config chatter.Config, written *atomic.Int64) (*httptest.Server, *chatter.HubManager) {
+	tb.Helper()
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := chatter.NewHubManager(time.Hour, chatter.WithConfig(config), chatter.WithHistoryReplay(replayed), chatter.WithLogger(quiet))
+	hub, err := manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < replayed; i++ {
+		msg := &chatter.Message{Kind: chatter.KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("replayed message %d", i)}
+		if _, err := hub.Publish(msg, time.Second); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewUnstartedServer(chatter.Handler(manager, nil, nil))
+	srv.Listener = countingListener{Listener: srv.Listener, written: written}
+	srv.Start()
+	tb.Cleanup(func() {
+		manager.Close(5 * time.Second)
+		srv.Close()
+	})
+	return srv, manager
+}
+
+// dialReplay connects to the server, asking for compression or not, and reads the frames
+// until the whole history was replayed
+func dialReplay(tb testing.TB, srv *httptest.Server, compress bool) *websocket.Conn {
+	tb.Helper()
+	dialer := websocket.Dialer{EnableCompression: compress}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?name=reader", nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	last := fmt.Sprintf("replayed message %d", replayed-1)
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			tb.Fatalf("reading the replay: %v", err)
+		}
+		if strings.Contains(string(frame), last) {
+			return conn
+		}
+	}
+}
+
+// replayBytes returns the number of bytes the server wrote to replay the history to a client
+func replayBytes(tb testing.TB, srv *httptest.Server, written *atomic.Int64, compress bool) int64 {
+	tb.Helper()
+	before := written.Load()
+	conn := dialReplay(tb, srv, compress)
+	defer conn.Close()
+	return written.Load() - before
+}
+
+func TestTheReplayIsCompressed(t *testing.T) {
+	var written atomic.Int64
+	srv, _ := replayServer(t, chatter.Config{}, &written)
+	plain := replayBytes(t, srv, &written, false)
+	compressed := replayBytes(t, srv, &written, true)
+	t.Logf("replaying %d messages took %d bytes, %d compressed (%.0f%% saved)", replayed, plain, compressed, 100*(1-float64(compressed)/float64(plain)))
+	if compressed*4 > plain {
+		t.Errorf("the compressed replay took %d bytes of %d", compressed, plain)
+	}
+
+	// unless the config turns it off
+	srv, _ = replayServer(t, chatter.Config{NoCompression: true}, &written)
+	if uncompressed := replayBytes(t, srv, &written, true); uncompressed*4 < plain*3 {
+		t.Errorf("the replay took %d bytes of %d with the compression turned off", uncompressed, plain)
+	}
+}
+
+func TestCompressedConnectionsPingAndClose(t *testing.T) {
+	var written atomic.Int64
+	config := chatter.Config{PongWait: 500 * time.Millisecond, PingPeriod: 20 * time.Millisecond}
+	srv, manager := replayServer(t, config, &written)
+	conn := dialReplay(t, srv, true)
+	defer conn.Close()
+
+	// the pings come through uncompressed (control frames can't be), we answer them
+	pinged := make(chan struct{}, 16)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// the server may hang up before our close frame gets to it, like chattertest we don't mind
+	conn.SetCloseHandler(func(code int, text string) error {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		return nil
+	})
+	read := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				read <- err
+				return
+			}
+		}
+	}()
+
+	// many pings in, the pongs kept the connection open
+	for i := 0; i < 10; i++ {
+		select {
+		case <-pinged:
+		case err := <-read:
+			t.Fatalf("the connection ended after %d pings: %v", i, err)
+		case <-time.After(waitTimeout):
+			t.Fatalf("%d pings came", i)
+		}
+	}
+
+	// and the close frame of the server comes through too
+	manager.Close(5 * time.Second)
+	select {
+	case err := <-read:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("the connection ended with %v", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("the connection wasn't closed")
+	}
+}
+
+func BenchmarkReplayCompression(b *testing.B) {
+	for _, bb := range []struct {
+		name   string
+		config chatter.Config
+	}{
+		{"none", chatter.Config{NoCompression: true}},
+		{"fastest", chatter.Config{Compression: flate.BestSpeed}},
+		{"middling", chatter.Config{Compression: 5}},
+		{"smallest", chatter.Config{Compression: flate.BestCompression}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			var written atomic.Int64
+			srv, _ := replayServer(b, bb.config, &written)
+			b.ResetTimer()
+			var total int64
+			for i := 0; i < b.N; i++ {
+				total += replayBytes(b, srv, &written, true)
+			}
+			b.ReportMetric(float64(total)/float64(b.N), "bytes/replay")
+		})
+	}
+}
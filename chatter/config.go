@@ -0,0 +1,212 @@
+package chatter
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultPongWait is how long we wait for the pong of the peer by default,
+	// a connection that stays quiet for longer is closed
+	DefaultPongWait = 60 * time.Second
+	// DefaultWriteWait is how long writing a frame to the peer can take by default
+	DefaultWriteWait = 10 * time.Second
+	// DefaultMaxMessageSize is the maximum size of a message sent by a client by default,
+	// the htmx ws extension wraps the text in a JSON object with a HEADERS object
+	// (HX-Request, HX-Current-URL, HX-Trigger, ...) so we budget for both
+	DefaultMaxMessageSize = headersBudget + textBudget
+	// DefaultCompressionLevel is how hard the messages are compressed by default, the fastest
+	// level already shrinks the fragments (and the history replays) a lot for little CPU
+	DefaultCompressionLevel = flate.BestSpeed
+	// DefaultMaxBatchSize is the size past which the fragments queued for a client are cut
+	// in several websocket messages by default
+	DefaultMaxBatchSize = 64 << 10
+	// DefaultBufferSize is the size of the read and write buffers of a connection by default,
+	// the messages can be larger (they just take more than one read or write)
+	DefaultBufferSize = 1024
+)
+
+// Config holds the timeouts of the connections, the size of the messages (and of the buffers
+// they go through) and how they are compressed, the zero value of a field means its default
+// (see DefaultConfig)
+type Config struct {
+	PongWait        time.Duration // how long we wait for the next pong of the peer
+	PingPeriod      time.Duration // how often we ping the peer, shorter than PongWait (9/10 of it if zero)
+	WriteWait       time.Duration // how long writing a frame to the peer can take
+	MaxMessageSize  int64         // maximum size (in bytes) of a message sent by a client, headers included
+	Compression     int           // how hard the messages are compressed, from 1 (fastest) to 9 (smallest)
+	NoCompression   bool          // the messages aren't compressed, not even for the clients supporting it
+	MaxBatchSize    int           // maximum size (in bytes) of a message batching the fragments for a client
+	ReadBufferSize  int           // size (in bytes) of the read buffer of a connection
+	WriteBufferSize int           // size (in bytes) of the write buffers, shared by the connections
+}
+
+// DefaultConfig returns the config the hubs use unless told otherwise
+func DefaultConfig() Config {
+	return Config{
+		PongWait:        DefaultPongWait,
+		PingPeriod:      pingPeriodOf(DefaultPongWait),
+		WriteWait:       DefaultWriteWait,
+		MaxMessageSize:  DefaultMaxMessageSize,
+		Compression:     DefaultCompressionLevel,
+		MaxBatchSize:    DefaultMaxBatchSize,
+		ReadBufferSize:  DefaultBufferSize,
+		WriteBufferSize: DefaultBufferSize,
+	}
+}
+
+// pingPeriodOf is the ping period going with the pong wait, it leaves the pong time to come back
+func pingPeriodOf(pongWait time.Duration) time.Duration {
+	return (pongWait * 9) / 10
+}
+
+// withDefaults returns the config with the defaults in place of the zero fields
+func (c Config) withDefaults() Config {
+	if c.PongWait == 0 {
+		c.PongWait = DefaultPongWait
+	}
+	if c.PingPeriod == 0 {
+		c.PingPeriod = pingPeriodOf(c.PongWait)
+	}
+	if c.WriteWait == 0 {
+		c.WriteWait = DefaultWriteWait
+	}
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if c.Compression == 0 {
+		c.Compression = DefaultCompressionLevel
+	}
+	if c.MaxBatchSize == 0 {
+		c.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if c.ReadBufferSize == 0 {
+		c.ReadBufferSize = DefaultBufferSize
+	}
+	if c.WriteBufferSize == 0 {
+		c.WriteBufferSize = DefaultBufferSize
+	}
+	return c
+}
+
+// Validate returns an error naming every value of the config (once its defaults are in place)
+// that doesn't work, or nil if they all do
+func (c Config) Validate() error {
+	c = c.withDefaults()
+
+	var errs []error
+	if c.PongWait < 0 {
+		errs = append(errs, fmt.Errorf("the pong wait (%s) must be positive", c.PongWait))
+	}
+	if c.PingPeriod < 0 {
+		errs = append(errs, fmt.Errorf("the ping period (%s) must be positive", c.PingPeriod))
+	}
+	if c.WriteWait < 0 {
+		errs = append(errs, fmt.Errorf("the write wait (%s) must be positive", c.WriteWait))
+	}
+	// a peer only pongs when it is pinged, so the pings have to come before we give up on it
+	if c.PingPeriod >= c.PongWait {
+		errs = append(errs, fmt.Errorf("the ping period (%s) must be shorter than the pong wait (%s)", c.PingPeriod, c.PongWait))
+	}
+	// the htmx headers alone take up this much
+	if c.MaxMessageSize <= headersBudget {
+		errs = append(errs, fmt.Errorf("the maximum message size (%d bytes) must leave room for the text past the %d bytes of headers", c.MaxMessageSize, headersBudget))
+	}
+	if c.MaxBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("the maximum batch size (%d bytes) must be positive", c.MaxBatchSize))
+	}
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("the buffer sizes (%d bytes to read, %d to write) must be positive", c.ReadBufferSize, c.WriteBufferSize))
+	}
+	if c.Compression < flate.BestSpeed || c.Compression > flate.BestCompression {
+		errs = append(errs, fmt.Errorf("the compression level (%d) must be between %d and %d", c.Compression, flate.BestSpeed, flate.BestCompression))
+	}
+	return errors.Join(errs...)
+}
+
+// ConfigFromEnv returns the config set by the environment variables CHATTER_PONG_WAIT,
+// CHATTER_PING_PERIOD, CHATTER_WRITE_WAIT (durations, e.g. "30s"), CHATTER_MAX_MESSAGE_SIZE,
+// CHATTER_MAX_BATCH_SIZE, CHATTER_READ_BUFFER_SIZE and CHATTER_WRITE_BUFFER_SIZE (bytes),
+// CHATTER_COMPRESSION_LEVEL (1 to 9) and CHATTER_NO_COMPRESSION (a boolean),
+// the variables that aren't set keep their default
+func ConfigFromEnv() (Config, error) {
+	// the ping period follows the pong wait unless it is set as well
+	c := DefaultConfig()
+	c.PingPeriod = 0
+	var errs []error
+	for _, env := range []struct {
+		name  string
+		field *time.Duration
+	}{
+		{"CHATTER_PONG_WAIT", &c.PongWait},
+		{"CHATTER_PING_PERIOD", &c.PingPeriod},
+		{"CHATTER_WRITE_WAIT", &c.WriteWait},
+	} {
+		value := os.Getenv(env.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q is not a duration", env.name, value))
+			continue
+		}
+		*env.field = d
+	}
+	if value := os.Getenv("CHATTER_MAX_MESSAGE_SIZE"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CHATTER_MAX_MESSAGE_SIZE: %q is not a number of bytes", value))
+		}
+		c.MaxMessageSize = n
+	}
+	for _, env := range []struct {
+		name  string
+		field *int
+	}{
+		{"CHATTER_MAX_BATCH_SIZE", &c.MaxBatchSize},
+		{"CHATTER_READ_BUFFER_SIZE", &c.ReadBufferSize},
+		{"CHATTER_WRITE_BUFFER_SIZE", &c.WriteBufferSize},
+	} {
+		value := os.Getenv(env.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q is not a number of bytes", env.name, value))
+			continue
+		}
+		*env.field = n
+	}
+	if value := os.Getenv("CHATTER_COMPRESSION_LEVEL"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CHATTER_COMPRESSION_LEVEL: %q is not a number", value))
+		}
+		c.Compression = n
+	}
+	if value := os.Getenv("CHATTER_NO_COMPRESSION"); value != "" {
+		off, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CHATTER_NO_COMPRESSION: %q is not a boolean", value))
+		}
+		c.NoCompression = off
+	}
+	return c, errors.Join(errs...)
+}
+
+// WithConfig sets the timeouts of the connections, the size of the messages and of their buffers, their compression,
+// a config that doesn't validate leaves the defaults in place (see Config.Validate)
+func WithConfig(c Config) Option {
+	return func(h *Hub) {
+		if c.Validate() != nil {
+			return
+		}
+		h.config = c.withDefaults()
+	}
+}
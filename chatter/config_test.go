@@ -0,0 +1,85 @@
+package chatter_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config chatter.Config
+		errs   []string // what the error names, nil if the config is valid
+	}{
+		{"defaults", chatter.DefaultConfig(), nil},
+		{"zero", chatter.Config{}, nil},
+		{"millisecond timeouts for the tests", chatter.Config{PongWait: 50 * time.Millisecond, WriteWait: 10 * time.Millisecond}, nil},
+		{"ping period set", chatter.Config{PongWait: time.Minute, PingPeriod: 30 * time.Second}, nil},
+		{"ping period as long as the pong wait", chatter.Config{PongWait: time.Minute, PingPeriod: time.Minute}, []string{"ping period (1m0s) must be shorter than the pong wait (1m0s)"}},
+		{"ping period past the default pong wait", chatter.Config{PingPeriod: 2 * time.Minute}, []string{"ping period (2m0s) must be shorter than the pong wait (1m0s)"}},
+		{"pong wait shortened under the ping period", chatter.Config{PongWait: time.Second, PingPeriod: 5 * time.Second}, []string{"must be shorter than the pong wait"}},
+		{"negative durations", chatter.Config{PongWait: -time.Second, WriteWait: -time.Second}, []string{"pong wait (-1s) must be positive", "write wait (-1s) must be positive"}},
+		{"messages too small for the headers", chatter.Config{MaxMessageSize: 10}, []string{"maximum message size (10 bytes)"}},
+		{"compression out of range", chatter.Config{Compression: 10}, []string{"compression level (10)"}},
+		{"no compression", chatter.Config{NoCompression: true}, nil},
+		{"negative buffers", chatter.Config{ReadBufferSize: -1, MaxBatchSize: -1}, []string{"buffer sizes", "maximum batch size"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errs == nil {
+				if err != nil {
+					t.Errorf("the config doesn't validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("the config validates")
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("the error doesn't say %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CHATTER_PONG_WAIT", "100ms")
+	t.Setenv("CHATTER_MAX_MESSAGE_SIZE", "8192")
+	t.Setenv("CHATTER_NO_COMPRESSION", "true")
+	config, err := chatter.ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the ping period follows the pong wait
+	if config.PongWait != 100*time.Millisecond || config.MaxMessageSize != 8192 || !config.NoCompression || config.WriteWait != chatter.DefaultWriteWait {
+		t.Errorf("the config is %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("the config doesn't validate: %v", err)
+	}
+
+	// a ping period the pong wait doesn't leave room for is only caught by the validation
+	t.Setenv("CHATTER_PING_PERIOD", "1s")
+	if config, err = chatter.ConfigFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "ping period (1s) must be shorter") {
+		t.Errorf("the config validates with %v", err)
+	}
+
+	// values that can't be read are named
+	t.Setenv("CHATTER_WRITE_WAIT", "soon")
+	t.Setenv("CHATTER_READ_BUFFER_SIZE", "big")
+	_, err = chatter.ConfigFromEnv()
+	for _, want := range []string{`CHATTER_WRITE_WAIT: "soon"`, `CHATTER_READ_BUFFER_SIZE: "big"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("the error doesn't say %q: %v", want, err)
+		}
+	}
+}
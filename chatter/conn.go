@@ -0,0 +1,113 @@
+package chatter
+
+import (
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn is the part of a websocket connection the pumps use, *websocket.Conn has it all.
+// The pumps only ever see this, so they can run on a connection of our own (e.g. in tests)
+type wsConn interface {
+	NextReader() (messageType int, r io.Reader, err error)
+	NextWriter(messageType int) (io.WriteCloser, error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+var _ wsConn = (*websocket.Conn)(nil)
+
+// transport is what the hub sees of the connection of a client: the hub only ever queues frames
+// on the send channel of the client and closes it, the transport is how it hangs up on the client
+// without waiting for it (see hangUp) and what tells the clients of a websocket and an event stream apart
+type transport interface {
+	// name is the name of the transport in the stats of the client
+	name() string
+	// interactive reports whether the client sends frames of its own, only these can be idle
+	interactive() bool
+	// hangUp closes the connection without waiting for the frames still queued,
+	// with a close frame if the transport has them
+	hangUp(code int, reason string, deadline time.Time)
+}
+
+// wsTransport is the transport of the websocket clients, hanging up makes both pumps return
+type wsTransport struct {
+	conn wsConn
+}
+
+func (t wsTransport) name() string      { return "websocket" }
+func (t wsTransport) interactive() bool { return true }
+
+func (t wsTransport) hangUp(code int, reason string, deadline time.Time) {
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	t.conn.Close()
+}
+
+// sseTransport is the transport of the event stream clients, they can't send anything and
+// the stream stops as soon as their send channel is closed so there is nothing to hang up
+type sseTransport struct{}
+
+func (sseTransport) name() string                  { return "sse" }
+func (sseTransport) interactive() bool             { return false }
+func (sseTransport) hangUp(int, string, time.Time) {}
+
+// readMessage reads the next message of the connection, closing it if the message is longer
+// than limit. The read limit of the connection ends up counting the compressed bytes of a
+// compressed message, a few kilobytes could still inflate to gigabytes, so we count the bytes
+// we inflated ourselves
+func readMessage(conn wsConn, limit int64) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(DefaultWriteWait))
+		return nil, websocket.ErrReadLimit
+	}
+	return data, nil
+}
+
+// Clock tells the hubs and the pumps the time, ticks for the pings and the sweeps of the idle
+// clients, and times the credentials of the clients out. The hubs use the system clock unless they're given another with WithClock (e.g. one
+// tests move forward themselves)
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a channel ticking every d, and the function stopping it
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+	// NewTimer returns a channel ticking once after d, and the function stopping it
+	NewTimer(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock is the clock of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+func (systemClock) NewTimer(d time.Duration) (<-chan time.Time, func()) {
+	timer := time.NewTimer(d)
+	return timer.C, func() { timer.Stop() }
+}
+
+// WithClock sets the clock the hub and the pumps of its clients read the time from
+func WithClock(clock Clock) Option {
+	return func(h *Hub) {
+		if clock != nil {
+			h.clock = clock
+		}
+	}
+}
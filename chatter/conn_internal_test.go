@@ -0,0 +1,391 @@
+package chatter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// fakeConn is a websocket connection in memory, the peer's messages are fed through incoming
+// and what the pumps write is recorded
+type fakeConn struct {
+	mu       sync.Mutex
+	messages []string      // the data messages written, in order
+	controls []fakeControl // the control frames written, in order
+	closed   bool
+	late     int // the writes attempted once the connection was closed
+	pong     func(appData string) error
+	limit    int64
+
+	incoming chan []byte   // the messages of the peer, reading fails once it is closed
+	gone     chan struct{} // closed by Close
+	wrote    chan struct{} // gets a value for every message and control frame written (if not nil)
+	gate     chan struct{} // when not nil, the data messages are only written once it is closed
+}
+
+// fakeControl is a control frame written to a fakeConn
+type fakeControl struct {
+	kind int
+	data []byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{incoming: make(chan []byte, 16), gone: make(chan struct{}), wrote: make(chan struct{}, 1024)}
+}
+
+var _ wsConn = (*fakeConn)(nil)
+
+func (f *fakeConn) NextReader() (int, io.Reader, error) {
+	select {
+	case data, ok := <-f.incoming:
+		if !ok {
+			return 0, nil, &websocket.CloseError{Code: websocket.CloseGoingAway}
+		}
+		return websocket.TextMessage, bytes.NewReader(data), nil
+	case <-f.gone:
+		return 0, nil, io.ErrClosedPipe
+	}
+}
+
+func (f *fakeConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &fakeWriter{conn: f}, nil
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	w, _ := f.NextWriter(messageType)
+	w.Write(data)
+	return w.Close()
+}
+
+func (f *fakeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		f.late++
+		return websocket.ErrCloseSent
+	}
+	f.controls = append(f.controls, fakeControl{kind: messageType, data: bytes.Clone(data)})
+	f.notify()
+	return nil
+}
+
+func (f *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (f *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+func (f *fakeConn) SetReadLimit(limit int64)           { f.mu.Lock(); f.limit = limit; f.mu.Unlock() }
+
+func (f *fakeConn) SetPongHandler(h func(appData string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pong = h
+}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.gone)
+	}
+	return nil
+}
+
+// notify lets the test know something was written, f has to be locked
+func (f *fakeConn) notify() {
+	select {
+	case f.wrote <- struct{}{}:
+	default:
+	}
+}
+
+// Messages returns the data messages written so far
+func (f *fakeConn) Messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages...)
+}
+
+// Fragments returns the fragments of the data messages written so far, in order
+func (f *fakeConn) Fragments() []string {
+	var fragments []string
+	for _, message := range f.Messages() {
+		fragments = append(fragments, strings.Split(message, "\n")...)
+	}
+	return fragments
+}
+
+// Late returns the number of writes attempted once the connection was closed
+func (f *fakeConn) Late() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.late
+}
+
+// Closed reports whether the connection was closed
+func (f *fakeConn) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// CloseFrame returns the code and the reason of the close frame written, false if none was
+func (f *fakeConn) CloseFrame() (int, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, control := range f.controls {
+		if control.kind == websocket.CloseMessage && len(control.data) >= 2 {
+			return int(control.data[0])<<8 | int(control.data[1]), string(control.data[2:]), true
+		}
+	}
+	return 0, "", false
+}
+
+// Pings returns the payloads of the pings written so far
+func (f *fakeConn) Pings() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pings []string
+	for _, control := range f.controls {
+		if control.kind == websocket.PingMessage {
+			pings = append(pings, string(control.data))
+		}
+	}
+	return pings
+}
+
+// Controls returns the kinds of the control frames written so far
+func (f *fakeConn) Controls() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kinds := make([]int, len(f.controls))
+	for i, control := range f.controls {
+		kinds[i] = control.kind
+	}
+	return kinds
+}
+
+// Pong answers a ping of the pumps with its payload, the way a browser does
+func (f *fakeConn) Pong(appData string) error {
+	f.mu.Lock()
+	pong := f.pong
+	f.mu.Unlock()
+	return pong(appData)
+}
+
+// fakeWriter is a data message being written to a fakeConn
+type fakeWriter struct {
+	conn *fakeConn
+	buf  bytes.Buffer
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *fakeWriter) Close() error {
+	if w.conn.gate != nil {
+		<-w.conn.gate
+	}
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+	if w.conn.closed {
+		w.conn.late++
+		return websocket.ErrCloseSent
+	}
+	w.conn.messages = append(w.conn.messages, w.buf.String())
+	w.conn.notify()
+	return nil
+}
+
+// pumpClient returns a client of the hub on the connection, for the tests driving the pumps
+// themselves (the hub doesn't have to be running, see joinHub)
+func pumpClient(hub *Hub, conn wsConn) *Client {
+	return &Client{
+		id:          "client-" + strconv.Itoa(int(clientCount.Add(1))),
+		hub:         hub,
+		conn:        conn,
+		transport:   wsTransport{conn},
+		logger:      hub.logger,
+		send:        make(chan Frame, hub.sendBuffer),
+		limiter:     rate.NewLimiter(hub.rateLimit, hub.rateBurst),
+		closeCode:   websocket.CloseNormalClosure,
+		registered:  make(chan struct{}),
+		done:        make(chan struct{}),
+		connectedAt: time.Now(),
+	}
+}
+
+// clientCount numbers the clients of pumpClient
+var clientCount atomic.Int64
+
+// runHub runs the hub until the test ends
+func runHub(t *testing.T, hub *Hub) *Hub {
+	t.Helper()
+	go hub.Run(context.Background())
+	t.Cleanup(func() {
+		if err := hub.Close(time.Second); err != nil {
+			t.Errorf("closing the hub: %v", err)
+		}
+	})
+	return hub
+}
+
+// joinHub registers a client named name of the running hub on the connection,
+// its pumps aren't started
+func joinHub(t *testing.T, hub *Hub, conn wsConn, name string) *Client {
+	t.Helper()
+	client := pumpClient(hub, conn)
+	client.name = name
+	client.touch()
+	if !hub.join(client) {
+		t.Fatal("the hub is shut down")
+	}
+	return client
+}
+
+// startClient registers a client named name of the running hub on the connection,
+// and starts its pumps
+func startClient(t *testing.T, hub *Hub, conn wsConn, name string) *Client {
+	t.Helper()
+	client := joinHub(t, hub, conn, name)
+	go client.writePump()
+	go client.readPump()
+	return client
+}
+
+// addClient adds the client to a hub that isn't running, the way registering does
+// (without the history and the announcements), for the tests calling the hub themselves
+func addClient(hub *Hub, client *Client) {
+	hub.Lock()
+	defer hub.Unlock()
+	hub.clients[client] = true
+	hub.names[client.name] = client
+	hub.ids[client.id] = client
+	hub.order = append(hub.order, client)
+}
+
+// fakeClock is a clock the tests move forward themselves, its tickers and timers tick as it
+// goes past them
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// fakeTicker is a ticker of a fakeClock, or a timer when it ticks only once
+type fakeTicker struct {
+	c       chan time.Time
+	every   time.Duration
+	next    time.Time
+	once    bool
+	stopped bool
+}
+
+var _ Clock = (*fakeClock)(nil)
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	return c.newTicker(d, false)
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func()) {
+	return c.newTicker(d, true)
+}
+
+func (c *fakeClock) newTicker(d time.Duration, once bool) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// like time.Ticker, a tick the receiver isn't ready for is dropped
+	ticker := &fakeTicker{c: make(chan time.Time, 1), every: d, next: c.now.Add(d), once: once}
+	c.tickers = append(c.tickers, ticker)
+	if once && d <= 0 {
+		// like time.Timer, a timer for the past fires straight away
+		ticker.c <- c.now
+		ticker.next = time.Unix(1<<62, 0)
+	}
+	return ticker.c, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ticker.stopped = true
+	}
+}
+
+// WaitTickers waits for n tickers to be running, so moving the clock forward ticks them
+func (c *fakeClock) WaitTickers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		running := 0
+		for _, ticker := range c.tickers {
+			if !ticker.stopped {
+				running++
+			}
+		}
+		c.mu.Unlock()
+		if running >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tickers are running, want %d", running, n)
+		}
+	}
+}
+
+// Advance moves the clock forward by d, ticking the tickers it goes past, and waits
+// (a little) for the ticks to be taken so the next Advance doesn't find them still there
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	for _, ticker := range c.tickers {
+		if ticker.stopped || ticker.next.After(c.now) {
+			continue
+		}
+		select {
+		case ticker.c <- c.now:
+		default:
+		}
+		if ticker.once {
+			// the timer has fired, it's done with once its tick is taken
+			ticker.next = time.Unix(1<<62, 0)
+			continue
+		}
+		for !ticker.next.After(c.now) {
+			ticker.next = ticker.next.Add(ticker.every)
+		}
+	}
+	c.mu.Unlock()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if c.pending() == 0 {
+			return
+		}
+	}
+}
+
+// pending returns the number of ticks the running tickers haven't had taken
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ticks := 0
+	for _, ticker := range c.tickers {
+		if !ticker.stopped {
+			ticks += len(ticker.c)
+		}
+	}
+	return ticks
+}
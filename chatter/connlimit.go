@@ -0,0 +1,57 @@
+package chatter
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultConnsPerIP is the number of websocket connections an address can keep open by default
+	DefaultConnsPerIP = 10
+	// connLimitRetry is how long a client over its limit is told to wait before trying again
+	connLimitRetry = 10 * time.Second
+)
+
+// ConnLimit caps the number of websocket connections open from the same address at once,
+// so a single misbehaving script can't open thousands of them. It is safe for concurrent use
+type ConnLimit struct {
+	sync.Mutex
+	max     int            // connections an address can keep open
+	open    map[string]int // connections open by address
+	metrics *Metrics       // where the rejected connections are recorded (nil if they aren't)
+}
+
+// NewConnLimit creates a limit of max connections per address, nil metrics don't record anything
+func NewConnLimit(max int, metrics *Metrics) *ConnLimit {
+	return &ConnLimit{max: max, open: make(map[string]int), metrics: metrics}
+}
+
+// acquire counts a new connection from the address, false if the address already has
+// as many as it can keep open. Nil limits (and limits of zero) let every connection in
+func (l *ConnLimit) acquire(ip string) bool {
+	if l == nil || l.max <= 0 {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.open[ip] >= l.max {
+		l.metrics.connectionRejected("ip_limit")
+		return false
+	}
+	l.open[ip]++
+	return true
+}
+
+// release counts a connection from the address going away
+func (l *ConnLimit) release(ip string) {
+	if l == nil || l.max <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
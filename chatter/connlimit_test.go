@@ -0,0 +1,51 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConnectionsPerAddressAreLimited(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	manager := chatter.NewHubManager(time.Hour)
+	limit := chatter.NewConnLimit(2, chatter.NewMetrics(reg))
+	srv := &chattertest.Server{Server: httptest.NewServer(chatter.Handler(manager, nil, limit)), Manager: manager}
+	t.Cleanup(func() {
+		manager.Close(5 * time.Second)
+		srv.Close()
+	})
+
+	// the test clients all come from the loopback address
+	alice := srv.Connect(t, "alice")
+	srv.Connect(t, "bob")
+	_, resp, err := srv.Dial(t, "/ws?name=carol", nil)
+	if err == nil {
+		t.Fatal("a third connection from the address was upgraded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("the third connection got %v", resp)
+	}
+	if rejected := metricValue(t, reg, "chatter_rejected_connections_total"); rejected != 1 {
+		t.Errorf("%v rejected connections were counted, want 1", rejected)
+	}
+
+	// a connection going away gives its slot back
+	alice.Close()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		_, resp, err := srv.Dial(t, "/ws?name=carol", nil)
+		if err == nil {
+			break
+		}
+		if resp == nil || resp.StatusCode != http.StatusTooManyRequests || time.Now().After(deadline) {
+			t.Fatalf("connecting once alice left: %v (%v)", err, resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package chatter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+)
+
+const (
+	// csrfCookie is the cookie holding the random value the CSRF tokens of a browser are made from
+	csrfCookie = "chatter_csrf"
+	// CSRFHeader carries the CSRF token of the requests sent by scripts (htmx sends it with hx-headers)
+	CSRFHeader = "X-CSRF-Token"
+	// csrfField is the form field carrying the CSRF token of the forms
+	csrfField = "csrf_token"
+)
+
+// csrfKey is the context key of the CSRF token of the request
+type csrfKey struct{}
+
+// CSRF keeps other sites from making the browsers of our visitors change anything on their
+// behalf: the pages are rendered with a token only our pages can know, and the requests
+// changing anything have to send it back
+type CSRF struct {
+	key []byte // key the tokens are signed with, derived from the secret
+}
+
+// NewCSRF creates the CSRF protection signing its tokens with a key of its own derived from
+// the secret: the browsers pick the cookie a token is made from, with the secret itself that
+// would sign anything they like. Without a secret we make one up, the pages rendered before
+// a restart then have to be reloaded
+func NewCSRF(secret string) *CSRF {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("csrf: %v", err)
+		}
+	}
+	return &CSRF{key: deriveKey(key, purposeCSRF)}
+}
+
+// Middleware gives the request its CSRF token (see CSRFToken) and rejects the requests
+// changing anything without it with a 403. The token is tied to the browser and to its session,
+// signing in or out gives it a new one. Requests authenticated with a token header (the admin
+// token, the post secret, a bearer token) can't be forged by another site and don't need it.
+// Nil protection lets every request through
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a browser without the cookie gets one, it can't have a token yet
+		seed := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+			seed = cookie.Value
+		}
+		fresh := seed == ""
+		if fresh {
+			value := make([]byte, 24)
+			if _, err := rand.Read(value); err != nil {
+				http.Error(w, "Could not protect the request", http.StatusInternalServerError)
+				return
+			}
+			seed = base64.RawURLEncoding.EncodeToString(value)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    seed,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		token := c.token(seed, r)
+
+		if !safeMethod(r.Method) && !tokenAuthenticated(r) {
+			sent := r.Header.Get(CSRFHeader)
+			if sent == "" {
+				sent = r.PostFormValue(csrfField)
+			}
+			if fresh || !hmac.Equal([]byte(sent), []byte(token)) {
+				http.Error(w, "invalid CSRF token, reload the page", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	})
+}
+
+// token returns the CSRF token of the browser with the seed, in the session of the request
+func (c *CSRF) token(seed string, r *http.Request) string {
+	data := seed
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		data += "\x00" + cookie.Value
+	}
+	return base64.RawURLEncoding.EncodeToString(macOf(c.key, purposeCSRF, data))
+}
+
+// CSRFToken returns the CSRF token the pages are rendered with, empty if the request
+// didn't go through CSRF.Middleware
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// safeMethod tells whether requests with the method only read
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// tokenAuthenticated tells whether the request carries credentials in a header, which another
+// site can't make a browser send
+func tokenAuthenticated(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(AdminTokenHeader) != "" || r.Header.Get(secretHeader) != ""
+}
@@ -0,0 +1,125 @@
+package chatter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browse sends a request through the CSRF protection and returns its status, the token the
+// handler saw and the seed cookie the response set (nil if none)
+func browse(c *CSRF, req *http.Request) (int, string, *http.Cookie) {
+	var token string
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == csrfCookie {
+			return rec.Code, token, cookie
+		}
+	}
+	return rec.Code, token, nil
+}
+
+func TestCSRFBrowserFlows(t *testing.T) {
+	c := NewCSRF("s3cret")
+
+	// the page gives the browser its cookie, and is rendered with its token
+	code, token, seed := browse(c, httptest.NewRequest(http.MethodGet, "/", nil))
+	if code != http.StatusOK || token == "" || seed == nil || !seed.HttpOnly {
+		t.Fatalf("the page got %d with the token %q and the cookie %v", code, token, seed)
+	}
+	_, _, other := browse(c, httptest.NewRequest(http.MethodGet, "/", nil))
+	session := &http.Cookie{Name: sessionCookie, Value: "signed-in"}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		header  string // the token sent in the header
+		field   string // the token sent in the form
+		want    int
+	}{
+		{"header", []*http.Cookie{seed}, token, "", http.StatusOK},
+		{"form", []*http.Cookie{seed}, "", token, http.StatusOK},
+		{"no token", []*http.Cookie{seed}, "", "", http.StatusForbidden},
+		{"wrong token", []*http.Cookie{seed}, "forged", "", http.StatusForbidden},
+		{"no cookie", nil, token, "", http.StatusForbidden},
+		{"another browser", []*http.Cookie{other}, token, "", http.StatusForbidden},
+		// signing in changes the token, the pages rendered before have to be reloaded
+		{"signed in since", []*http.Cookie{seed, session}, token, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(url.Values{csrfField: {tt.field}, "text": {"hello"}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			for _, cookie := range tt.cookies {
+				req.AddCookie(cookie)
+			}
+			if code, _, _ := browse(c, req); code != tt.want {
+				t.Errorf("the post got %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenAuthenticatedRequests(t *testing.T) {
+	c := NewCSRF("s3cret")
+	for _, header := range []string{"Authorization", AdminTokenHeader, secretHeader} {
+		t.Run(header, func(t *testing.T) {
+			// the scripts have no cookie and no token, their credentials can't be forged
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"text":"hello"}`))
+			req.Header.Set(header, "credentials")
+			if code, _, _ := browse(c, req); code != http.StatusOK {
+				t.Errorf("the request got %d", code)
+			}
+		})
+	}
+
+	// without protection everything goes through
+	var none *CSRF
+	if code, _, _ := browse(none, httptest.NewRequest(http.MethodPost, "/messages", nil)); code != http.StatusOK {
+		t.Errorf("without protection the post got %d", code)
+	}
+}
+
+func TestCSRFTokensAreNeitherSessionsNorIdentities(t *testing.T) {
+	const secret = "s3cret"
+	c := NewCSRF(secret)
+	sessions, err := NewSessions(secret, "open sesame", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := NewIdentities(secret)
+
+	// the browser picks the seed the token is made from: a session payload, then an identity
+	forged, _ := json.Marshal(session{User: "admin", Identity: "user:admin", Expires: 4102444800})
+	for _, seed := range []string{base64.RawURLEncoding.EncodeToString(forged), "someone-else"} {
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: seed})
+		_, token, _ := browse(c, req)
+		if token == "" {
+			t.Fatal("the page got no token")
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: seed + "." + token})
+		rec := httptest.NewRecorder()
+		sessions.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("the token of the seed %q got a session through (%d)", seed, rec.Code)
+		}
+		if identity, ok := ids.verify(seed + "." + token); ok {
+			t.Errorf("the token of the seed %q verified as the identity %q", seed, identity)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package chatter
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// dashboardRefresh is how often the dashboards watching a room get its counters again,
+	// joins and departures are sent right away
+	dashboardRefresh = 2 * time.Second
+	// dashboardMessages is the number of recent messages a dashboard shows
+	dashboardMessages = 10
+)
+
+// clientRole is what a client is to the hub, it decides what the client gets
+type clientRole int
+
+const (
+	// roleMember is a member of the room: it chats and gets everything broadcast to the room
+	roleMember clientRole = iota
+	// roleObserver is an admin dashboard watching the room: it only gets the dashboard
+	// fragments, never the messages of the room, and nobody in the room sees it
+	roleObserver
+)
+
+// Dashboard is what the admin dashboard page is rendered from
+type Dashboard struct {
+	SignedIn bool    // whether the visitor is an admin, the page only asks for the token otherwise
+	Failed   bool    // whether the token the visitor sent was wrong
+	CSRF     string  // the CSRF token the buttons send
+	Room     string  // the room the page watches live
+	Rooms    []Stats // every room, by name
+	Bans     []*Ban  // the bans in force
+}
+
+// DashboardRoom is what the live part of the dashboard is rendered from, the room it watches
+type DashboardRoom struct {
+	Stats    Stats
+	Clients  []ClientStats // in the order they joined
+	Messages []*Message    // the most recent messages, oldest first
+	Mutes    []*Mute       // the mutes in force in the room
+}
+
+// watch registers the dashboard with the hub, it gets the state of the room right away.
+// It must only be called from the hub goroutine
+func (h *Hub) watch(client *Client) {
+	h.Lock()
+	h.observers[client] = true
+	h.Unlock()
+
+	client.logger.Info("dashboard connected")
+	client.replay = []Frame{{Data: h.renderDashboard()}}
+	close(client.registered)
+}
+
+// unwatch removes the dashboard from the hub and closes its send channel (like remove does
+// for the members). It must only be called from the hub goroutine
+func (h *Hub) unwatch(client *Client, code int, reason string) {
+	h.Lock()
+	defer h.Unlock()
+	if !h.observers[client] {
+		return
+	}
+	delete(h.observers, client)
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.send)
+}
+
+// renderDashboard renders the live part of the dashboards watching the room
+func (h *Hub) renderDashboard() []byte {
+	room := &DashboardRoom{
+		Stats:   h.Stats(),
+		Clients: h.clientStats(time.Now()),
+		Mutes:   h.mutes.list(),
+	}
+	messages, err := h.store.RecentN(h.room, dashboardMessages)
+	if err != nil {
+		h.logger.Error("reading the history for the dashboard", "err", err)
+	}
+	room.Messages = messages
+	return getDashboardTemplate(room)
+}
+
+// updateDashboards sends the dashboards watching the room its current state, they only ever
+// get these (the members of the room never do). It is a no-op without dashboards, and must
+// only be called from the hub goroutine
+func (h *Hub) updateDashboards() {
+	if len(h.observers) == 0 {
+		return
+	}
+	h.dashboardAt = h.clock.Now()
+	rendered := h.renderDashboard()
+	if rendered == nil {
+		return
+	}
+	for client := range h.observers {
+		// a dashboard that can't keep up gets the next update
+		select {
+		case client.send <- Frame{Data: rendered}:
+		default:
+		}
+	}
+}
+
+// refreshDashboards updates the dashboards if they haven't been for a while, so the counters
+// keep moving. It is called on every sweep of the hub goroutine
+func (h *Hub) refreshDashboards(now time.Time) {
+	if now.Sub(h.dashboardAt) >= dashboardRefresh {
+		h.updateDashboards()
+	}
+}
+
+// DashboardHandler handles GET /admin, the admin dashboard: the rooms, the bans and, live,
+// the room of ?room= (its clients, its counters, its recent messages and its mutes) with
+// buttons to kick, mute and ban. Visitors without the admin token are asked for it
+// (see AdminLoginHandler). It should be wrapped in CSRF.Middleware
+func DashboardHandler(manager *HubManager, bans *BanList, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := &Dashboard{
+			SignedIn: isAdmin(token, r),
+			Failed:   r.URL.Query().Get("failed") != "",
+			CSRF:     CSRFToken(r),
+			Room:     roomName(r),
+		}
+		if page.SignedIn {
+			page.Rooms = manager.Stats()
+			if bans != nil {
+				page.Bans = bans.List()
+			}
+		}
+
+		rendered := getAdminTemplate(page)
+		if rendered == nil {
+			http.Error(w, "Could not render the dashboard", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if !page.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		w.Write(rendered)
+	})
+}
+
+// DashboardSocketHandler handles GET /admin/ws, the websocket connection the dashboard watches
+// the room of ?room= over. It should be wrapped in AdminAuth
+func DashboardSocketHandler(manager *HubManager, origins *OriginPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !origins.Allowed(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		room := roomName(r)
+		if err := ValidateRoomName(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, withProtocol(nil, r))
+		if err != nil {
+			var handshakeErr websocket.HandshakeError
+			if !errors.As(err, &handshakeErr) {
+				slog.Error("dashboard upgrade failed", "remote_ip", clientIP(r), "err", err)
+			}
+			return
+		}
+
+		client := &Client{
+			id:          uuid.New().String(),
+			name:        "dashboard",
+			role:        roleObserver,
+			conn:        conn,
+			transport:   wsTransport{conn},
+			ip:          clientIP(r),
+			ua:          r.UserAgent(),
+			closeCode:   websocket.CloseNormalClosure,
+			connectedAt: time.Now(),
+			done:        make(chan struct{}),
+		}
+		if err := joinRoom(manager, client, room); err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(DefaultWriteWait))
+			conn.Close()
+			return
+		}
+
+		go client.writePump()
+		go client.readPump()
+	})
+}
@@ -0,0 +1,52 @@
+package chatter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnlyDashboardsGetTheDashboardFragments(t *testing.T) {
+	hub := runHub(t, NewHub(WithRoom("lobby")))
+	dashboard := newFakeConn()
+	observer := pumpClient(hub, dashboard)
+	observer.name, observer.role = "dashboard", roleObserver
+	if !hub.join(observer) {
+		t.Fatal("the hub is shut down")
+	}
+	go observer.writePump()
+	go observer.readPump()
+	waitWritten(t, dashboard, `id="dashboard"`)
+
+	alice, bob := newFakeConn(), newFakeConn()
+	startClient(t, hub, alice, "alice")
+	startClient(t, hub, bob, "bob")
+	waitWritten(t, dashboard, "2 connected")
+	alice.incoming <- []byte(`{"text":"hello"}`)
+	waitWritten(t, bob, "hello")
+	close(bob.incoming)
+	waitWritten(t, dashboard, "1 connected")
+
+	// once the room is done with the message after them, nothing else is on its way
+	if _, err := hub.Publish(&Message{Kind: KindChat, Username: "bot", Text: "last one"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitWritten(t, alice, "last one")
+
+	// the members never got a dashboard fragment, nor saw the dashboard in the room
+	for name, conn := range map[string]*fakeConn{"alice": alice, "bob": bob} {
+		for _, message := range conn.Messages() {
+			if strings.Contains(message, `id="dashboard"`) || strings.Contains(message, "<span>dashboard</span>") {
+				t.Errorf("%s got:\n%s", name, message)
+			}
+		}
+	}
+	// and the dashboard never got what is broadcast to the room
+	for _, message := range dashboard.Messages() {
+		for _, fragment := range []string{`id="chat_room"`, `id="presence"`, `id="typing"`} {
+			if strings.Contains(message, fragment) {
+				t.Errorf("the dashboard got the %s fragment:\n%s", fragment, message)
+			}
+		}
+	}
+}
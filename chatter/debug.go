@@ -0,0 +1,66 @@
+package chatter
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// startedAt is when the process started, for the uptime
+var startedAt = time.Now()
+
+// DebugStats is a snapshot of the whole chat, the totals are summed over the rooms
+type DebugStats struct {
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Goroutines    int     `json:"goroutines"`
+	Clients       int     `json:"clients"`
+	Broadcasts    uint64  `json:"broadcasts"`
+	Dropped       uint64  `json:"dropped"`
+	DroppedFrames uint64  `json:"dropped_frames"`
+	Rooms         []Stats `json:"rooms"` // by name
+}
+
+// Stats returns the stats of every room, by name. It never waits on the hubs, so it
+// answers even when a room is stuck
+func (m *HubManager) Stats() []Stats {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	stats := make([]Stats, 0, len(hubs))
+	for _, hub := range hubs {
+		stats = append(stats, hub.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Room < stats[j].Room })
+	return stats
+}
+
+// DebugStatsHandler returns a JSON snapshot of the chat (see DebugStats), to look at what a
+// running server is up to when the dashboards aren't enough
+func DebugStatsHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uptime := time.Since(startedAt)
+		stats := DebugStats{
+			Uptime:        uptime.Round(time.Second).String(),
+			UptimeSeconds: uptime.Seconds(),
+			Goroutines:    runtime.NumGoroutine(),
+			Rooms:         manager.Stats(),
+		}
+		for _, room := range stats.Rooms {
+			stats.Clients += room.Clients
+			stats.Broadcasts += room.Broadcasts
+			stats.Dropped += room.Dropped
+			stats.DroppedFrames += room.DroppedFrames
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
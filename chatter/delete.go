@@ -0,0 +1,123 @@
+package chatter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// ErrNoSuchMessage is returned when deleting a message the history doesn't have
+	ErrNoSuchMessage = errors.New("no such message")
+	// ErrNotYourMessage is returned when a client deletes a message it didn't send
+	ErrNotYourMessage = errors.New("you can only delete your own messages")
+)
+
+// deleteRequest asks the hub to delete a message, the result is sent on done
+type deleteRequest struct {
+	id     uint64 // id of the message
+	client string // id of the client asking (empty for an admin, who can delete any message)
+	done   chan error
+}
+
+// Delete replaces the message with the id by a tombstone, in the history and on every page.
+// A message that was deleted already is left alone, and one that has been evicted from
+// the history is still replaced on the pages that show it. The deletion is recorded in the
+// audit log as taken by actor
+func (h *Hub) Delete(actor string, id uint64) error {
+	err := h.requestDelete(id, "")
+	h.audit.record(actor, AuditDelete, h.room, strconv.FormatUint(id, 10), "", err)
+	return err
+}
+
+// requestDelete hands the deletion to the hub goroutine and waits for it
+func (h *Hub) requestDelete(id uint64, client string) error {
+	req := &deleteRequest{id: id, client: client, done: make(chan error, 1)}
+
+	select {
+	case h.deletes <- req:
+	case <-h.stop:
+		return errors.New("hub is shut down")
+	}
+	return <-req.done
+}
+
+// deleteMessage handles a delete request on the hub goroutine
+func (h *Hub) deleteMessage(req *deleteRequest) error {
+
+	var original *Message
+	store, editable := h.store.(EditableStore)
+	if editable {
+		var err error
+		if original, err = store.Get(h.room, req.id); err != nil {
+			h.logger.Error("reading message", "msg_id", req.id, "err", err)
+			return errors.New("could not delete the message")
+		}
+	}
+
+	// clients can only delete what they sent, which we can't tell once
+	// the message is gone from the history (admins can)
+	if req.client != "" {
+		if original == nil {
+			return ErrNoSuchMessage
+		}
+		if original.ClientID != req.client || (original.Kind != KindChat && original.Kind != "") {
+			return ErrNotYourMessage
+		}
+	}
+
+	// deleting twice is fine, there is nothing left to do
+	if original != nil && original.Deleted {
+		return nil
+	}
+
+	// the tombstone keeps the id, the sender and the time of the message, not the text
+	tombstone := &Message{ID: req.id, Kind: KindChat, Deleted: true}
+	if original != nil {
+		deleted := *original
+		deleted.Text, deleted.HTML, deleted.Edited, deleted.Deleted = "", "", false, true
+		deleted.Reactions = nil
+		tombstone = &deleted
+		if err := store.Update(h.room, tombstone); err != nil {
+			h.logger.Error("storing message", "msg_id", req.id, "err", err)
+			return errors.New("could not delete the message")
+		}
+	}
+
+	if rendered := getTombstoneTemplate(tombstone); rendered != nil {
+		h.sendAll(rendered, encodeJSON(tombstone, KindDeleted))
+	}
+
+	// a deleted message doesn't stay pinned
+	if _, ok := h.store.(PinStore); ok {
+		if err := h.setPinned(&pinRequest{id: req.id}); err != nil {
+			h.logger.Error("unpinning message", "msg_id", req.id, "err", err)
+		}
+	}
+	return nil
+}
+
+// DeleteHandler handles DELETE /messages/{id}?room=..., it should be wrapped in AdminAuth
+// (clients delete their own messages over the websocket)
+func DeleteHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil || id == 0 {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+
+		hub, err := manager.Get(roomName(r))
+		if err != nil {
+			serveRoomUnavailable(w, err)
+			return
+		}
+
+		if err := hub.Delete(auditActor(r), id); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
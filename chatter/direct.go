@@ -0,0 +1,84 @@
+package chatter
+
+import "fmt"
+
+// sendDirect sends a direct message to its recipient, and a copy back to the sender
+// so it shows up in their chat too. A recipient that was here recently gets it when it comes
+// back (see enqueue), for anyone else the sender gets an error
+func (h *Hub) sendDirect(msg *Message) {
+
+	// the sender may have disconnected in the meantime
+	sender, ok := h.ids[msg.ClientID]
+	if !ok {
+		return
+	}
+
+	// the recipient can be given by name or by client id
+	recipient, ok := h.names[msg.To]
+	if !ok {
+		recipient, ok = h.ids[msg.To]
+	}
+	if !ok {
+		// a recipient that is away gets the message when it's back
+		if identity, away := h.offline(msg.To); away {
+			h.sendOffline(msg, sender, identity)
+			return
+		}
+		h.sendNotice(sender, fmt.Sprintf("%s is not online", msg.To))
+		return
+	}
+
+	// we always address the message by name, whatever the sender used
+	msg.To = recipient.name
+
+	// the sender is done typing, so we clear its indicator
+	h.stopTyping(msg.ClientID)
+
+	h.format(msg)
+	rendered := getDirectTemplate(msg)
+	if rendered == nil {
+		return
+	}
+
+	h.deliver(recipient, directFrame(recipient, msg, rendered))
+	if sender != recipient {
+		h.deliver(sender, directFrame(sender, msg, rendered))
+	}
+	h.acknowledge(msg)
+}
+
+// directFrame returns the frame of the direct message for the client, rendered or encoded
+func directFrame(client *Client, msg *Message, rendered []byte) Frame {
+	if client.jsonFrames {
+		return jsonFrame(msg, KindDirect)
+	}
+	return Frame{ID: msg.ID, Data: rendered}
+}
+
+// deliver sends the rendered payload to a single client, a client that can't keep up
+// is handled the same way a broadcast does (see SlowPolicy)
+func (h *Hub) deliver(client *Client, frame Frame) {
+	wait, release := h.slowWindow()
+	defer release()
+	if h.offer(client, frame, wait) == offerTooSlow {
+		h.drop(client)
+	}
+}
+
+// sendOffline keeps a direct message for its recipient until it is back,
+// the sender gets its copy right away
+func (h *Hub) sendOffline(msg *Message, sender *Client, identity string) {
+
+	h.stopTyping(msg.ClientID)
+
+	h.format(msg)
+	rendered := getDirectTemplate(msg)
+	if rendered == nil {
+		return
+	}
+
+	h.enqueue(identity, msg.To, Frame{ID: msg.ID, Data: rendered})
+	h.deliver(sender, directFrame(sender, msg, rendered))
+	h.sendNotice(sender, fmt.Sprintf("%s is away, they'll get your message when they're back", msg.To))
+	h.acknowledge(msg)
+}
@@ -0,0 +1,105 @@
+package chatter
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// RoomInfo is a room as the directory lists it
+type RoomInfo struct {
+	Room       string    `json:"room"`
+	URL        string    `json:"url"`         // the page of the room (see RoomHandler)
+	Clients    int       `json:"clients"`     // clients currently in the room
+	LastActive time.Time `json:"last_active"` // last time a client joined or left or a message was sent
+}
+
+// SetPrivate leaves the room out of the directory (or puts it back in), it is safe to call
+// from any goroutine
+func (h *Hub) SetPrivate(private bool) {
+	h.private.Store(private)
+}
+
+// Private tells whether the room is left out of the directory, it is safe to call from any goroutine
+func (h *Hub) Private() bool {
+	return h.private.Load()
+}
+
+// roomInfo returns the room as the directory lists it
+func (h *Hub) roomInfo() RoomInfo {
+	h.RLock()
+	clients := len(h.clients)
+	lastActive := h.lastActive
+	h.RUnlock()
+
+	if at := h.lastMessage.Load(); at > 0 && time.Unix(0, at).After(lastActive) {
+		lastActive = time.Unix(0, at)
+	}
+	return RoomInfo{Room: h.room, URL: "/r/" + url.PathEscape(h.room), Clients: clients, LastActive: lastActive}
+}
+
+// Rooms returns the rooms of the directory, the busiest first (and the most recently active
+// first among the rooms as busy). The private rooms aren't listed, neither are the empty ones
+// (they only stay until they're collected) except for the default room where everyone lands.
+// Like Stats it never waits on the hubs
+func (m *HubManager) Rooms() []RoomInfo {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	rooms := make([]RoomInfo, 0, len(hubs))
+	for _, hub := range hubs {
+		if hub.Private() {
+			continue
+		}
+		info := hub.roomInfo()
+		if info.Clients == 0 && info.Room != DefaultRoom {
+			continue
+		}
+		rooms = append(rooms, info)
+	}
+	slices.SortFunc(rooms, func(a, b RoomInfo) int {
+		return cmp.Or(
+			cmp.Compare(b.Clients, a.Clients),
+			b.LastActive.Compare(a.LastActive),
+			cmp.Compare(a.Room, b.Room),
+		)
+	})
+	return rooms
+}
+
+// RoomsHandler serves the directory of the rooms (see Rooms): GET /rooms returns it rendered
+// as a fragment by default, so a page can hx-get it every now and then, and as JSON when
+// asked for it
+func RoomsHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rooms := manager.Rooms()
+
+		// the directory changes all the time, nobody should keep it around
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Vary", "Accept")
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(rooms); err != nil {
+				slog.Warn("writing the rooms", "err", err)
+			}
+			return
+		}
+
+		rendered := getRoomsTemplate(rooms)
+		if rendered == nil {
+			http.Error(w, "Could not render the rooms", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(rendered)
+	})
+}
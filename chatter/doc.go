@@ -0,0 +1,14 @@
+// Package chatter is a chat server for htmx pages: a hub per room fans messages out
+// to websocket (and server-sent events) clients as HTML fragments swapped in out of band.
+//
+// A HubManager owns the rooms, the handlers serve its clients:
+//
+//	manager := chatter.NewHubManager(10*time.Minute, chatter.WithMarkdown(true))
+//	go manager.Run(ctx, 5*time.Second)
+//
+//	mux.Handle("GET /ws", chatter.Handler(manager, nil, nil))
+//	mux.Handle("GET /events", chatter.EventsHandler(manager, nil))
+//
+// The fragments are rendered from the templates embedded in the package,
+// see LoadTemplates to use your own.
+package chatter
@@ -0,0 +1,201 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDrainTimeout is how long the clients have to leave once the server is draining
+const DefaultDrainTimeout = 5 * time.Minute
+
+// drainMarks are the times left before the deadline the rooms are reminded of it at
+var drainMarks = []time.Duration{10 * time.Minute, 5 * time.Minute, 2 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second}
+
+// ErrDraining is returned when asking for a drain while the server already is draining
+var ErrDraining = errors.New("already draining")
+
+// Drain puts the server in drain mode ahead of a restart: new connections are turned away,
+// the clients already connected are told when the server restarts and stay until they leave
+// or the deadline (timeout from now) passes. Drained is closed when either happens,
+// closing the manager then disconnects whoever is left
+func (m *HubManager) Drain(actor string, timeout time.Duration) (time.Time, error) {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return time.Time{}, ErrClosed
+	}
+	if !m.drainUntil.IsZero() {
+		until := m.drainUntil
+		m.Unlock()
+		return until, ErrDraining
+	}
+	until := time.Now().Add(timeout)
+	m.drainUntil = until
+	m.Unlock()
+
+	slog.Info("draining", "deadline", until)
+	m.Announce(actor, fmt.Sprintf("The server restarts in %s, you will be able to reconnect right after", countdown(timeout)), timeout)
+	go m.drain(until)
+	return until, nil
+}
+
+// Draining returns the deadline of the drain, and whether the server is draining
+func (m *HubManager) Draining() (time.Time, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.drainUntil, !m.drainUntil.IsZero()
+}
+
+// Drained is closed once the server drained: every client left or the deadline passed
+func (m *HubManager) Drained() <-chan struct{} {
+	return m.drained
+}
+
+// drain reminds the rooms of the deadline as it nears, until everyone left or it passed
+func (m *HubManager) drain(until time.Time) {
+	defer close(m.drained)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// the marks already past when we started don't get a reminder
+	marks := drainMarks
+	for len(marks) > 0 && until.Sub(time.Now()) <= marks[0] {
+		marks = marks[1:]
+	}
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		left := until.Sub(time.Now())
+		if left <= 0 {
+			slog.Info("drain deadline passed", "clients", m.clientCount())
+			return
+		}
+		if m.clientCount() == 0 {
+			slog.Info("drained, every client left")
+			return
+		}
+		if len(marks) > 0 && left <= marks[0] {
+			m.system(fmt.Sprintf("The server restarts in %s", countdown(marks[0])))
+			for len(marks) > 0 && left <= marks[0] {
+				marks = marks[1:]
+			}
+		}
+	}
+}
+
+// clientCount returns the number of clients connected to every room
+func (m *HubManager) clientCount() int {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	count := 0
+	for _, hub := range hubs {
+		hub.RLock()
+		count += len(hub.clients)
+		hub.RUnlock()
+	}
+	return count
+}
+
+// system broadcasts a system message to every open room
+func (m *HubManager) system(text string) {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	for _, hub := range hubs {
+		if _, err := hub.Publish(&Message{Kind: KindSystem, Text: text}, time.Second); err != nil {
+			hub.logger.Warn("drain reminder not broadcast", "err", err)
+		}
+	}
+}
+
+// countdown says how long d is in words, to the minute or to the second
+func countdown(d time.Duration) string {
+	unit, n := "second", int((d+time.Second-1)/time.Second)
+	if d >= time.Minute {
+		unit, n = "minute", int((d+time.Minute-1)/time.Minute)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// refuseDraining turns the new connection away if the server is draining, telling the client
+// to come back once it restarted. It returns true if it did
+func refuseDraining(manager *HubManager, w http.ResponseWriter, r *http.Request) bool {
+	until, draining := manager.Draining()
+	if !draining {
+		return false
+	}
+	slog.Info("connection rejected: draining", "remote_ip", clientIP(r))
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(until).Seconds())+1)))
+	http.Error(w, "Server is restarting", http.StatusServiceUnavailable)
+	return true
+}
+
+// DrainRequest is the body of POST /admin/drain
+type DrainRequest struct {
+	Timeout string `json:"timeout"` // how long the clients have to leave, e.g. "5m" (empty for the default)
+}
+
+// DrainHandler handles POST /admin/drain (with a JSON body or a form), it puts the server in
+// drain mode with timeout unless the request asks for another and records it in the audit log.
+// It should be wrapped in AdminAuth
+func DrainHandler(manager *HubManager, audit *AuditLog, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &DrainRequest{}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Timeout = r.FormValue("timeout")
+		}
+		d := timeout
+		if req.Timeout != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Timeout); err != nil || d <= 0 {
+				http.Error(w, "timeout must be a positive duration (e.g. 5m)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		until, err := manager.Drain(auditActor(r), d)
+		audit.record(auditActor(r), AuditDrain, "", "", "for "+d.String(), err)
+		if err != nil && !errors.Is(err, ErrDraining) {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(struct {
+			Deadline time.Time `json:"deadline"`
+		}{until})
+	})
+}
@@ -0,0 +1,67 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// drain asks the server to drain through its handler, and returns the status
+func drain(srv *chattertest.Server, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/admin/drain", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	chatter.DrainHandler(srv.Manager, nil, chatter.DefaultDrainTimeout).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestDrainingRefusesNewClientsOnly(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	bob.Expect("Online (2)", waitTimeout)
+	health := chatter.NewHealth(srv.Manager, nil)
+
+	if code := drain(srv, `{"timeout":"10m"}`); code != http.StatusAccepted {
+		t.Fatalf("draining got %d", code)
+	}
+	alice.Expect("The server restarts in 10 minutes", waitTimeout)
+	bob.Expect("The server restarts in 10 minutes", waitTimeout)
+	if code := drain(srv, `{}`); code != http.StatusConflict {
+		t.Errorf("draining again got %d", code)
+	}
+
+	// the new connections are turned away until the restart, and the load balancers told
+	_, resp, err := srv.Dial(t, "/ws?name=carol", nil)
+	if err == nil {
+		t.Fatal("carol connected to a draining server")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("carol got %v", resp)
+	}
+	rec := httptest.NewRecorder()
+	health.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "draining") {
+		t.Errorf("the readiness probe got %d %s", rec.Code, rec.Body)
+	}
+
+	// while the clients already there carry on
+	alice.Send("still chatting")
+	bob.Expect("still chatting", waitTimeout)
+	bob.Send("me too")
+	alice.Expect("me too", waitTimeout)
+
+	// and the drain is over once they left
+	alice.Close()
+	bob.Close()
+	select {
+	case <-srv.Manager.Drained():
+	case <-time.After(waitTimeout):
+		t.Error("the server didn't drain once everyone left")
+	}
+}
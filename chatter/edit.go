@@ -0,0 +1,66 @@
+package chatter
+
+// editMessage replaces the text of a message with the one of msg, if msg comes from its author
+// and the message is recent enough. Every page swaps the message for the new one in place.
+// Edits are not shared with the other instances (see Bridge), their history keeps the original
+func (h *Hub) editMessage(msg *Message) {
+
+	// we need a store we can change
+	store, ok := h.store.(EditableStore)
+	if !ok || h.editWindow == 0 {
+		msg.Reply("messages can't be edited here")
+		return
+	}
+
+	original, err := store.Get(h.room, msg.Edit)
+	if err != nil {
+		h.logger.Error("reading message", "msg_id", msg.Edit, "err", err)
+		msg.Reply("could not edit the message")
+		return
+	}
+	if original == nil || original.Deleted {
+		msg.Reply("no such message")
+		return
+	}
+
+	// only the author of a chat message can edit it (the rest are the server's)
+	if original.ClientID != msg.ClientID || (original.Kind != KindChat && original.Kind != "") {
+		msg.Reply("you can only edit your own messages")
+		return
+	}
+	if h.clock.Now().Sub(original.Timestamp) > h.editWindow {
+		msg.Reply("it's too late to edit this message")
+		return
+	}
+
+	// we change a copy, the original may still be read by other goroutines
+	edited := *original
+	edited.Text = msg.Text
+	edited.HTML = ""
+	edited.Edited = true
+	h.format(&edited)
+
+	rendered := getEditTemplate(h.formatted(&edited))
+	if rendered == nil {
+		return
+	}
+	if err := store.Update(h.room, &edited); err != nil {
+		h.logger.Error("storing message", "msg_id", edited.ID, "err", err)
+		msg.Reply("could not edit the message")
+		return
+	}
+
+	h.sendAll(rendered, encodeJSON(&edited, KindEdit))
+}
+
+// sendAll sends the fragment to every client, dropping the clients that can't keep up.
+// The JSON clients get encoded instead, or nothing if it is nil
+func (h *Hub) sendAll(rendered, encoded []byte) {
+
+	h.fanOut(func(client *Client) (Frame, bool) {
+		if client.jsonFrames {
+			return Frame{JSON: true, Data: encoded}, encoded != nil
+		}
+		return Frame{Data: rendered}, true
+	})
+}
@@ -0,0 +1,23 @@
+package chatter
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// embedded holds the templates shipped with the package, so the binary
+// doesn't depend on the directory it is started from
+//
+//go:embed templates
+var embedded embed.FS
+
+// DefaultTemplates returns the templates shipped with the package (message.html, index.html, ...),
+// they are the ones used unless LoadTemplates is given others
+func DefaultTemplates() fs.FS {
+	templates, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		// the directory is embedded above, this can't happen
+		panic(err)
+	}
+	return templates
+}
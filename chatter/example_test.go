@@ -0,0 +1,84 @@
+package chatter_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+)
+
+func ExampleNewHub() {
+	hub := chatter.NewHub(chatter.WithRoom("lobby"))
+	go hub.Run(context.Background())
+	defer hub.Close(time.Second)
+
+	// a bot publishes a message without a websocket
+	if _, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "deploy-bot", Text: "release v1.2 done"}, time.Second); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	messages, _ := hub.History(0, 10)
+	for _, msg := range messages {
+		fmt.Printf("%s: %s\n", msg.Username, msg.Text)
+	}
+	// Output: deploy-bot: release v1.2 done
+}
+
+func ExampleNewHubManager() {
+	manager := chatter.NewHubManager(10 * time.Minute)
+	defer manager.Close(time.Second)
+
+	// the rooms are created as they're asked for
+	for _, room := range []string{"lobby", "kitchen", "lobby"} {
+		hub, err := manager.Get(room)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(hub.Stats().Room)
+	}
+	// Output:
+	// lobby
+	// kitchen
+	// lobby
+}
+
+func ExampleHandler() {
+	manager := chatter.NewHubManager(10 * time.Minute)
+	defer manager.Close(time.Second)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/{room}", chatter.Handler(manager, nil, nil))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// the pages connect with htmx's ws extension, which sends the fields of the form as JSON
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/lobby?name=alice", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]any{"text": "hello", "HEADERS": map[string]string{"HX-Request": "true"}})
+
+	// and get back HTML fragments to swap in
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, fragment, err := conn.ReadMessage()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if strings.Contains(string(fragment), "<p>hello</p>") {
+			fmt.Println("alice got the message back")
+			return
+		}
+	}
+	// Output: alice got the message back
+}
@@ -0,0 +1,128 @@
+package chatter
+
+import (
+	"sync"
+	"time"
+)
+
+// fanoutThreshold is the number of clients from which a broadcast is spread across the
+// fan-out workers, below it handing the clients to the workers costs more than it saves
+const fanoutThreshold = 512
+
+// fanoutJob is a slice of the clients of a broadcast, sent to a fan-out worker
+type fanoutJob struct {
+	clients   []*Client
+	frame     func(*Client) (Frame, bool) // the frame of a client, false if it gets nothing
+	slow      []*Client                   // the clients that couldn't keep up, filled in by the worker
+	delivered int                         // the clients that got their frame queued, filled in by the worker
+	done      *sync.WaitGroup
+}
+
+// startFanout starts the fan-out workers, they run until stopFanout is called
+func (h *Hub) startFanout() {
+	if h.fanoutWorkers < 2 {
+		return
+	}
+	h.fanoutJobs = make(chan *fanoutJob)
+	for i := 0; i < h.fanoutWorkers; i++ {
+		go h.fanoutWorker(h.fanoutJobs)
+	}
+}
+
+// stopFanout stops the fan-out workers, broadcasts are sent by the hub goroutine from now on
+func (h *Hub) stopFanout() {
+	if h.fanoutJobs != nil {
+		close(h.fanoutJobs)
+		h.fanoutJobs = nil
+	}
+}
+
+// fanoutWorker sends the frames of the jobs it gets
+func (h *Hub) fanoutWorker(jobs <-chan *fanoutJob) {
+	for job := range jobs {
+		h.runFanoutJob(job)
+	}
+}
+
+// runFanoutJob sends the frames of a job, a panic is logged so the hub waiting for the job
+// doesn't wait forever
+func (h *Hub) runFanoutJob(job *fanoutJob) {
+	defer job.done.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			h.recovered("fan-out", r)
+		}
+	}()
+	job.slow, job.delivered = h.sendFrames(job.clients, job.frame)
+}
+
+// parallelFanout reports whether the next broadcast is spread across the fan-out workers
+func (h *Hub) parallelFanout() bool {
+	return h.fanoutJobs != nil && len(h.order) >= fanoutThreshold
+}
+
+// fanOut sends every client its frame and drops the clients that can't keep up.
+// In big rooms the clients are split between the fan-out workers, each one sending the
+// frames of its clients in order, and we wait for all of them before returning so the
+// frames of the next broadcast can't overtake these. frame is called concurrently then.
+// It is only called from the hub goroutine
+func (h *Hub) fanOut(frame func(*Client) (Frame, bool)) {
+
+	// every client gets the frame with the same sequence number, and a client reconnecting
+	// soon enough gets it from the window
+	frame = h.sequence(frame)
+
+	start := time.Now()
+	var slow []*Client
+	var delivered int
+	if !h.parallelFanout() {
+		slow, delivered = h.sendFrames(h.order, frame)
+	} else {
+		// we split the clients in as many chunks as there are workers
+		size := (len(h.order) + h.fanoutWorkers - 1) / h.fanoutWorkers
+		jobs := make([]fanoutJob, 0, h.fanoutWorkers)
+		var wg sync.WaitGroup
+		for i := 0; i < len(h.order); i += size {
+			jobs = append(jobs, fanoutJob{clients: h.order[i:min(i+size, len(h.order))], frame: frame, done: &wg})
+		}
+		wg.Add(len(jobs))
+		for i := range jobs {
+			h.fanoutJobs <- &jobs[i]
+		}
+		wg.Wait()
+		for _, job := range jobs {
+			slow = append(slow, job.slow...)
+			delivered += job.delivered
+		}
+	}
+	h.roomMetrics.fannedOut(time.Since(start), len(h.order), delivered)
+	h.broadcasts.Add(1)
+
+	// the clients that can't keep up, we remove them once everyone got the frame
+	for _, client := range slow {
+		h.drop(client)
+	}
+}
+
+// sendFrames sends the clients their frame, returning the clients that can't keep up
+// and have to be disconnected (see SlowPolicy), and how many got their frame queued
+func (h *Hub) sendFrames(clients []*Client, frame func(*Client) (Frame, bool)) ([]*Client, int) {
+	wait, release := h.slowWindow()
+	defer release()
+
+	var slow []*Client
+	delivered := 0
+	for _, client := range clients {
+		f, ok := frame(client)
+		if !ok {
+			continue
+		}
+		switch h.offer(client, f, wait) {
+		case offerQueued:
+			delivered++
+		case offerTooSlow:
+			slow = append(slow, client)
+		}
+	}
+	return slow, delivered
+}
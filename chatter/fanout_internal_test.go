@@ -0,0 +1,71 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// BenchmarkFanout measures the latency of a broadcast in big rooms, with the clients
+// served by the hub goroutine alone and spread across the fan-out workers
+func BenchmarkFanout(b *testing.B) {
+	for _, clients := range []int{5000, 10000} {
+		for _, workers := range []int{0, 8} {
+			b.Run(fmt.Sprintf("clients=%d/workers=%d", clients, workers), func(b *testing.B) {
+				hub := NewHub(WithFanoutWorkers(workers), WithSendBuffer(1))
+				hub.startFanout()
+				defer hub.stopFanout()
+				all := make([]*Client, clients)
+				for i := range all {
+					all[i] = pumpClient(hub, newFakeConn())
+					addClient(hub, all[i])
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					hub.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "hello, world"})
+					b.StopTimer()
+					for _, client := range all {
+						<-client.send
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+func TestFanoutKeepsTheOrderAndDropsSlowClients(t *testing.T) {
+	hub := NewHub(WithFanoutWorkers(4), WithSendBuffer(5))
+	hub.startFanout()
+	defer hub.stopFanout()
+	clients := make([]*Client, fanoutThreshold*2)
+	for i := range clients {
+		clients[i] = pumpClient(hub, newFakeConn())
+		addClient(hub, clients[i])
+	}
+	// the first client reads nothing
+	slow := clients[0]
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5; i++ {
+			hub.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d-%d", round, i)})
+		}
+		// the others get every message in order
+		for _, client := range clients[1:] {
+			for i := 0; i < 5; i++ {
+				frame := (<-client.send).Data
+				if want := fmt.Sprintf("message %d-%d", round, i); !bytes.Contains(frame, []byte(want)) {
+					t.Fatalf("the client got %s, want %s", frame, want)
+				}
+			}
+		}
+	}
+
+	hub.RLock()
+	_, kept := hub.clients[slow]
+	hub.RUnlock()
+	if kept {
+		t.Error("the client that couldn't keep up is still in the room")
+	}
+}
@@ -0,0 +1,188 @@
+package chatter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const (
+	// oauthStateCookie keeps the state of a GitHub sign in until GitHub sends the visitor back
+	oauthStateCookie = "chatter_oauth_state"
+	// oauthStateTTL is how long the visitor has to sign in on GitHub
+	oauthStateTTL = 10 * time.Minute
+	// githubAPI is where we ask GitHub who signed in
+	githubAPI = "https://api.github.com"
+)
+
+// GitHubAuth signs visitors in with their GitHub account, they then go by their GitHub
+// login and are shown with their GitHub avatar. It can be restricted to the members of an org
+type GitHubAuth struct {
+	config   oauth2.Config
+	org      string    // org the visitors must be members of (empty lets anyone in)
+	sessions *Sessions // the sessions the visitors get once signed in
+}
+
+// EnableGitHub lets the visitors sign in with the GitHub OAuth app of the client id and secret,
+// GitHub sends them back to callbackURL (the url of /auth/callback). With an org only its members get in
+func (s *Sessions) EnableGitHub(clientID, clientSecret, callbackURL, org string) *GitHubAuth {
+	scopes := []string{"read:user"}
+	if org != "" {
+		// the membership of private members is only visible with read:org
+		scopes = append(scopes, "read:org")
+	}
+
+	s.github = &GitHubAuth{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     github.Endpoint,
+			RedirectURL:  callbackURL,
+			Scopes:       scopes,
+		},
+		org:      org,
+		sessions: s,
+	}
+	return s.github
+}
+
+// LoginHandler sends the visitor to GitHub (GET /auth/login?next=/r/x),
+// with a state only its browser knows so the callback can't be forged
+func (g *GitHubAuth) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "Could not sign in", http.StatusInternalServerError)
+			return
+		}
+		state := base64.RawURLEncoding.EncodeToString(b)
+
+		// the state cookie also remembers where to go back to
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthStateCookie,
+			Value:    state + "." + base64.RawURLEncoding.EncodeToString([]byte(localPath(r.FormValue("next")))),
+			Path:     "/auth/",
+			MaxAge:   int(oauthStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, g.config.AuthCodeURL(state), http.StatusFound)
+	})
+}
+
+// CallbackHandler signs in the visitor GitHub sent back to us (GET /auth/callback)
+func (g *GitHubAuth) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// the state has to be the one we gave the browser
+		cookie, err := r.Cookie(oauthStateCookie)
+		if err != nil {
+			http.Error(w, "sign in expired, try again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/auth/", MaxAge: -1})
+		state, encodedNext, _ := strings.Cut(cookie.Value, ".")
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+			http.Error(w, "invalid sign in state", http.StatusBadRequest)
+			return
+		}
+		decoded, _ := base64.RawURLEncoding.DecodeString(encodedNext)
+		next := localPath(string(decoded))
+
+		// the visitor may have said no
+		if reason := r.URL.Query().Get("error"); reason != "" {
+			g.sessions.serveLogin(w, r, http.StatusUnauthorized, next, "GitHub didn't sign you in: "+reason)
+			return
+		}
+
+		user, err := g.authenticate(r.Context(), r.URL.Query().Get("code"))
+		if err != nil {
+			slog.Error("signing in with GitHub", "err", err)
+			g.sessions.serveLogin(w, r, http.StatusUnauthorized, next, "could not sign you in with GitHub")
+			return
+		}
+		if user == nil {
+			g.sessions.serveLogin(w, r, http.StatusForbidden, next, fmt.Sprintf("only the members of %s can sign in", g.org))
+			return
+		}
+
+		g.sessions.start(w, r, *user)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+}
+
+// authenticate exchanges the code for a token and asks GitHub who the visitor is,
+// it returns nil if the visitor isn't a member of our org
+func (g *GitHubAuth) authenticate(ctx context.Context, code string) (*session, error) {
+	token, err := g.config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging the code: %w", err)
+	}
+	client := g.config.Client(ctx, token)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(client, githubAPI+"/user", &user); err != nil {
+		return nil, fmt.Errorf("reading the user: %w", err)
+	}
+	name := sanitizeName(user.Login)
+	if user.ID == 0 || name == "" {
+		return nil, fmt.Errorf("unexpected user %q", user.Login)
+	}
+
+	if g.org != "" {
+		var membership struct {
+			State string `json:"state"`
+		}
+		err := getJSON(client, githubAPI+"/user/memberships/orgs/"+url.PathEscape(g.org), &membership)
+		if err != nil || membership.State != "active" {
+			slog.Info("GitHub user is not a member of the org", "user", user.Login, "org", g.org, "err", err)
+			return nil, nil
+		}
+	}
+
+	return &session{User: name, Identity: "github:" + strconv.FormatInt(user.ID, 10), Avatar: user.AvatarURL}, nil
+}
+
+// getJSON gets the url and decodes its JSON into v
+func getJSON(client *http.Client, url string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// localPath returns the path if it is one of our own pages, the landing page otherwise
+// (so signing in can't send the visitor to another website)
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,36 @@
+package chatter
+
+import "net/http"
+
+// Handler serves the websocket connections of the rooms, the room is picked in the path
+// (/ws/{room}, see ValidateRoomName) or with ?room= and the name with ?name=. A nil origin policy only lets our own pages connect,
+// a nil limit lets an address open as many connections as it wants
+func Handler(manager *HubManager, origins *OriginPolicy, limit *ConnLimit) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(manager, origins, limit, w, r)
+	})
+}
+
+// EventsHandler streams the rooms as server-sent events, for clients without websockets.
+// The streams count against the limit of the websocket connections, a nil limit lets an
+// address open as many as it wants
+func EventsHandler(manager *HubManager, limit *ConnLimit) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(manager, limit, w, r)
+	})
+}
+
+// HistoryHandler serves the message history of the rooms, as JSON or as fragments
+func HistoryHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveHistory(manager, w, r)
+	})
+}
+
+// PostHandler lets scripts and bots post messages without a websocket,
+// if secret isn't empty requests have to carry it in the X-Chatter-Secret header
+func PostHandler(manager *HubManager, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePost(manager, secret, w, r)
+	})
+}
@@ -0,0 +1,33 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// newManager returns a manager closed when the test ends
+func newManager(t *testing.T, opts ...chatter.Option) *chatter.HubManager {
+	t.Helper()
+	manager := chatter.NewHubManager(time.Hour, opts...)
+	t.Cleanup(func() { manager.Close(time.Second) })
+	return manager
+}
+
+func TestPlainGETOnTheWebsocketGetsOneError(t *testing.T) {
+	handler := chatter.Handler(newManager(t), nil, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	// the upgrader wrote its error, and nothing was written after it
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("the status is %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if body, want := rec.Body.String(), http.StatusText(http.StatusBadRequest)+"\n"; body != want {
+		t.Errorf("the body is %q, want %q", body, want)
+	}
+}
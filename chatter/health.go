@@ -0,0 +1,150 @@
+package chatter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthTimeout is how long the readiness probe waits for each of its checks
+const healthTimeout = 2 * time.Second
+
+// Health answers the liveness and readiness probes of load balancers and orchestrators:
+// the process is live as long as it answers, it is ready when its rooms, its templates
+// and its store all work, and stops being ready once it starts shutting down
+type Health struct {
+	manager  *HubManager  // the rooms, every hub has to answer
+	store    MessageStore // the message history, checked when it can be unreachable (see PingStore)
+	stopping atomic.Bool  // whether the server is shutting down
+}
+
+// healthStatus is the body of the probes
+type healthStatus struct {
+	Status string            `json:"status"`           // "ok" or "unavailable"
+	Checks map[string]string `json:"checks,omitempty"` // outcome of each check, "ok" or what went wrong
+}
+
+// NewHealth creates the probes of the rooms of the manager, whose history is kept in store
+func NewHealth(manager *HubManager, store MessageStore) *Health {
+	return &Health{manager: manager, store: store}
+}
+
+// ShuttingDown makes the readiness probe fail from now on, so load balancers stop sending
+// us connections before we stop accepting them
+func (h *Health) ShuttingDown() {
+	h.stopping.Store(true)
+}
+
+// LiveHandler answers the liveness probe (GET /healthz): it only tells the process is up
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, healthStatus{Status: "ok"})
+	})
+}
+
+// ReadyHandler answers the readiness probe (GET /readyz) with a 200 when every check passes,
+// and a 503 naming the ones that didn't otherwise
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus{Status: "ok", Checks: h.check(r.Context())}
+		for _, outcome := range status.Checks {
+			if outcome != "ok" {
+				status.Status = "unavailable"
+			}
+		}
+		writeHealth(w, status)
+	})
+}
+
+// check runs the readiness checks
+func (h *Health) check(ctx context.Context) map[string]string {
+	checks := map[string]string{"hubs": "ok", "templates": "ok"}
+	if h.stopping.Load() {
+		checks["shutdown"] = "shutting down"
+	}
+	if _, draining := h.manager.Draining(); draining {
+		checks["drain"] = "draining"
+	}
+	if err := h.manager.alive(healthTimeout); err != nil {
+		checks["hubs"] = err.Error()
+	}
+	if templates.Load() == nil {
+		checks["templates"] = "not parsed"
+	}
+	if pinger, ok := h.store.(PingStore); ok {
+		ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+		defer cancel()
+		checks["store"] = "ok"
+		if err := pinger.Ping(ctx); err != nil {
+			checks["store"] = err.Error()
+		}
+	}
+	return checks
+}
+
+// writeHealth writes the status of a probe, a 503 unless it is ok
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// Alive tells whether the goroutine of the hub is running and gets to its requests within timeout
+func (h *Hub) Alive(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case h.probes <- struct{}{}:
+		return true
+	case <-h.stop:
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+// alive probes every room at the same time, and returns an error naming the first one that
+// didn't answer within timeout. It returns ErrClosed once the manager has been closed
+func (m *HubManager) alive(timeout time.Duration) error {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return ErrClosed
+	}
+	// we don't hold the lock while probing, a stuck room would hold up every other
+	hubs := make(map[string]*Hub, len(m.hubs))
+	for room, hub := range m.hubs {
+		hubs[room] = hub
+	}
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(hubs))
+	for room, hub := range hubs {
+		wg.Add(1)
+		go func(room string, hub *Hub) {
+			defer wg.Done()
+			if hub.Alive(timeout) {
+				return
+			}
+			// a room removed since we looked isn't a problem
+			select {
+			case <-hub.stop:
+			default:
+				errs <- fmt.Errorf("room %s is not responding", room)
+			}
+		}(room, hub)
+	}
+	wg.Wait()
+	close(errs)
+
+	// we report the first error (if any)
+	return <-errs
+}
@@ -0,0 +1,84 @@
+package chatter_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// pingedStore is a store whose Ping returns err
+type pingedStore struct {
+	chatter.MessageStore
+	err error
+}
+
+func (s *pingedStore) Ping(ctx context.Context) error { return s.err }
+
+// probe asks the handler how things are, and returns the status code and the checks
+func probe(t *testing.T, handler http.Handler) (int, map[string]string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var status struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding the probe: %v", err)
+	}
+	if (status.Status == "ok") != (rec.Code == http.StatusOK) {
+		t.Errorf("the probe is %q with a %d", status.Status, rec.Code)
+	}
+	return rec.Code, status.Checks
+}
+
+func TestReadiness(t *testing.T) {
+	// the templates are parsed before the server starts, as main does
+	if err := chatter.LoadTemplates(chatter.DefaultTemplates()); err != nil {
+		t.Fatal(err)
+	}
+	store := &pingedStore{MessageStore: chatter.NewMemoryStore(0)}
+	manager := chatter.NewHubManager(time.Hour, chatter.WithStore(store))
+	defer manager.Close(time.Second)
+	if _, err := manager.Get("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	health := chatter.NewHealth(manager, store)
+
+	// healthy
+	code, checks := probe(t, health.ReadyHandler())
+	if code != http.StatusOK || checks["hubs"] != "ok" || checks["templates"] != "ok" || checks["store"] != "ok" {
+		t.Errorf("a healthy server got %d %v", code, checks)
+	}
+
+	// the store can't be reached, the process is still live
+	store.err = errors.New("connection refused")
+	code, checks = probe(t, health.ReadyHandler())
+	if code != http.StatusServiceUnavailable || checks["store"] != "connection refused" || checks["hubs"] != "ok" {
+		t.Errorf("with the store down got %d %v", code, checks)
+	}
+	if code, _ := probe(t, health.LiveHandler()); code != http.StatusOK {
+		t.Errorf("the liveness probe got %d with the store down", code)
+	}
+	store.err = nil
+
+	// shutting down, before the rooms are closed
+	health.ShuttingDown()
+	code, checks = probe(t, health.ReadyHandler())
+	if code != http.StatusServiceUnavailable || checks["shutdown"] != "shutting down" || checks["hubs"] != "ok" {
+		t.Errorf("shutting down got %d %v", code, checks)
+	}
+
+	// and once they are
+	manager.Close(time.Second)
+	code, checks = probe(t, health.ReadyHandler())
+	if code != http.StatusServiceUnavailable || checks["hubs"] != chatter.ErrClosed.Error() {
+		t.Errorf("with the rooms closed got %d %v", code, checks)
+	}
+}
@@ -0,0 +1,113 @@
+package chatter
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultHistoryLimit is the number of messages returned by the history endpoint by default
+	defaultHistoryLimit = 50
+	// maxHistoryLimit is the maximum number of messages the history endpoint returns
+	maxHistoryLimit = 500
+)
+
+// serveHistory serves the history of a room: GET /messages?room=general&limit=50&before=<id>,
+// or the replies to a message: GET /messages?room=general&replies_to=<id>.
+// It returns the rendered fragments by default (so htmx can hx-get them) and JSON when asked for it
+func serveHistory(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+
+	// we validate the limit, it has to be a positive number
+	limit := defaultHistoryLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	// we validate before, it has to be a message id
+	var before uint64
+	if value := query.Get("before"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			http.Error(w, "before must be a message id", http.StatusBadRequest)
+			return
+		}
+		before = id
+	}
+
+	// we validate replies_to, it has to be a message id
+	var parent uint64
+	if value := query.Get("replies_to"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			http.Error(w, "replies_to must be a message id", http.StatusBadRequest)
+			return
+		}
+		parent = id
+	}
+
+	hub, err := manager.Get(roomName(r))
+	if err != nil {
+		serveRoomUnavailable(w, err)
+		return
+	}
+	if !hub.admits(r) {
+		http.Error(w, ErrNotInvited.Error(), http.StatusForbidden)
+		return
+	}
+
+	var messages []*Message
+	if parent != 0 {
+		messages, err = hub.Replies(parent, limit)
+	} else {
+		messages, err = hub.History(before, limit)
+	}
+	if err != nil {
+		slog.Error("reading history", "room", hub.room, "err", err)
+		http.Error(w, "Could not read the history", http.StatusInternalServerError)
+		return
+	}
+
+	// clients asking for JSON get the messages as they are
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if messages == nil {
+			messages = []*Message{}
+		}
+		if err := json.NewEncoder(w).Encode(messages); err != nil {
+			slog.Warn("writing history", "room", hub.room, "err", err)
+		}
+		return
+	}
+
+	// everyone else gets the same fragments the websocket sends,
+	// except for a thread which is loaded in its own list
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if parent != 0 {
+		for _, msg := range messages {
+			w.Write([]byte(messageItem(msg)))
+			w.Write(newline)
+		}
+		return
+	}
+	for _, msg := range messages {
+		if rendered := getMessageTemplate(msg, "", false); rendered != nil {
+			w.Write(rendered)
+			w.Write(newline)
+		}
+	}
+}
+
+// wantsJSON reports whether the request prefers JSON over HTML
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
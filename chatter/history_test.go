@@ -0,0 +1,120 @@
+package chatter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// publish publishes the messages to the room of the manager, it returns their ids
+func publish(t *testing.T, manager *chatter.HubManager, room string, texts ...string) []uint64 {
+	t.Helper()
+	hub, err := manager.Get(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for _, text := range texts {
+		id, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, ClientID: "bot", Username: "bot", Text: text}, time.Second)
+		if err != nil {
+			t.Fatalf("publishing %q: %v", text, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// getHistory gets the history at target, as JSON if asked to
+func getHistory(t *testing.T, manager *chatter.HubManager, target string, asJSON bool) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if asJSON {
+		req.Header.Set("Accept", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	chatter.HistoryHandler(manager).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHistoryContentTypes(t *testing.T) {
+	manager := newManager(t)
+	publish(t, manager, "lobby", "first", "second", "third")
+
+	// the fragments by default
+	rec := getHistory(t, manager, "/history?room=lobby&limit=2", false)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("the history is served as %q, want HTML", ct)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "first") || !strings.Contains(body, "second") || strings.Index(body, "second") > strings.Index(body, "third") {
+		t.Errorf("the fragments aren't the last two messages in order:\n%s", body)
+	}
+
+	// the messages when asked for JSON
+	rec = getHistory(t, manager, "/history?room=lobby&limit=2", true)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("the history is served as %q, want JSON", ct)
+	}
+	var messages []chatter.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if len(messages) != 2 || messages[0].Text != "second" || messages[1].Text != "third" {
+		t.Errorf("the JSON history is %+v, want second and third", messages)
+	}
+}
+
+func TestHistoryBeforeTheRetainedStart(t *testing.T) {
+	const capacity = 3
+	manager := newManager(t, chatter.WithStore(chatter.NewMemoryStore(capacity)))
+	ids := publish(t, manager, "lobby", "one", "two", "three", "four", "five")
+
+	for _, test := range []struct {
+		before uint64
+		want   []string
+	}{
+		// paging back stops at the oldest message still kept
+		{ids[4], []string{"three", "four"}},
+		{ids[2], nil},
+		// before evicted messages and before the very first one there is nothing
+		{ids[1], nil},
+		{1, nil},
+	} {
+		rec := getHistory(t, manager, fmt.Sprintf("/history?room=lobby&before=%d", test.before), true)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("before=%d got %d %s", test.before, rec.Code, rec.Body)
+		}
+		var messages []chatter.Message
+		if err := json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+		var texts []string
+		for _, msg := range messages {
+			texts = append(texts, msg.Text)
+		}
+		if fmt.Sprint(texts) != fmt.Sprint(test.want) {
+			t.Errorf("before=%d returned %v, want %v", test.before, texts, test.want)
+		}
+
+		// the fragments agree, an empty page is an empty body
+		rec = getHistory(t, manager, fmt.Sprintf("/history?room=lobby&before=%d", test.before), false)
+		if len(test.want) == 0 && rec.Body.Len() != 0 {
+			t.Errorf("before=%d returned the fragments %q, want none", test.before, rec.Body)
+		}
+	}
+}
+
+func TestHistoryRejectsBadParameters(t *testing.T) {
+	manager := newManager(t)
+	for _, query := range []string{"limit=0", "limit=-3", "limit=many", "before=0", "before=last", "replies_to=x"} {
+		if rec := getHistory(t, manager, "/history?"+query, false); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s got %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package chatter
+
+// ClientInfo describes a client to the hooks
+type ClientInfo struct {
+	ID   string // id of the client
+	Name string // name the client is shown with in the room
+	Room string // room the client is in
+}
+
+// WithOnConnect calls fn every time a client joins the hub.
+// Hooks run on the hub goroutine (outside of its lock) so they should return quickly
+func WithOnConnect(fn func(ClientInfo)) Option {
+	return func(h *Hub) {
+		h.onConnect = fn
+	}
+}
+
+// WithOnDisconnect calls fn every time a client leaves the hub (or is dropped)
+func WithOnDisconnect(fn func(ClientInfo)) Option {
+	return func(h *Hub) {
+		h.onDisconnect = fn
+	}
+}
+
+// WithOnMessage calls fn with every chat message before it is broadcast,
+// fn may change the message or return nil to drop it
+func WithOnMessage(fn func(*Message) *Message) Option {
+	return func(h *Hub) {
+		h.onMessage = fn
+	}
+}
+
+// info returns what the hooks get to know about the client
+func (h *Hub) info(client *Client) ClientInfo {
+	return ClientInfo{ID: client.id, Name: client.name, Room: h.room}
+}
+
+// connected runs the connect hook (if any)
+func (h *Hub) connected(client *Client) {
+	if h.onConnect != nil {
+		h.hook("connect", func() { h.onConnect(h.info(client)) })
+	}
+}
+
+// disconnected runs the disconnect hook (if any)
+func (h *Hub) disconnected(client *Client) {
+	if h.onDisconnect != nil {
+		h.hook("disconnect", func() { h.onDisconnect(h.info(client)) })
+	}
+}
+
+// hook runs fn, a hook that panics is logged instead of taking the hub down
+func (h *Hub) hook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recovered(name+" hook", r)
+		}
+	}()
+	fn()
+}
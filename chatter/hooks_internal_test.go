@@ -0,0 +1,76 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageHookRunsBeforeTheFanOut(t *testing.T) {
+	var queued []int // the frames queued for the client whenever the hook ran
+	var client *Client
+	hub := NewHub(WithOnMessage(func(msg *Message) *Message {
+		queued = append(queued, len(client.send))
+		switch msg.Text {
+		case "drop me":
+			return nil
+		case "shout":
+			msg.Text = strings.ToUpper(msg.Text)
+		}
+		return msg
+	}))
+	client = pumpClient(hub, newFakeConn())
+	client.name = "alice"
+	addClient(hub, client)
+
+	for _, text := range []string{"hello", "drop me", "shout"} {
+		hub.handle(&Message{Kind: KindChat, ClientID: "bob-1", Username: "bob", Text: text})
+	}
+
+	// the hook saw every message before anything was queued for it
+	if fmt.Sprint(queued) != "[0 1 1]" {
+		t.Errorf("the hook ran with %v frames queued, want [0 1 1]", queued)
+	}
+	// the vetoed message went nowhere, the changed one went out changed
+	var frames [][]byte
+	for len(client.send) > 0 {
+		frames = append(frames, (<-client.send).Data)
+	}
+	if len(frames) != 2 || !bytes.Contains(frames[0], []byte("hello")) || !bytes.Contains(frames[1], []byte("SHOUT")) {
+		t.Errorf("the client got %d frames:\n%s", len(frames), bytes.Join(frames, []byte("\n")))
+	}
+	messages, _ := hub.History(0, 10)
+	for _, msg := range messages {
+		if msg.Text == "drop me" {
+			t.Error("the vetoed message is in the history")
+		}
+	}
+}
+
+func TestConnectAndDisconnectHooks(t *testing.T) {
+	events := make(chan string, 4)
+	hub := runHub(t, NewHub(
+		WithRoom("lobby"),
+		WithOnConnect(func(info ClientInfo) { events <- "connect " + info.Name + " " + info.Room }),
+		WithOnDisconnect(func(info ClientInfo) { events <- "disconnect " + info.Name + " " + info.Room }),
+	))
+
+	conn := newFakeConn()
+	client := joinHub(t, hub, conn, "alice")
+	go client.writePump()
+	go client.readPump()
+	close(conn.incoming)
+
+	for _, want := range []string{"connect alice lobby", "disconnect alice lobby"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("got the hook %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the hook %q didn't run", want)
+		}
+	}
+}
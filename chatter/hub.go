@@ -0,0 +1,979 @@
+package chatter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// message kinds, they decide how a message is rendered
+const (
+	KindChat   = "chat"   // a message sent by a client
+	KindSystem = "system" // a message from the hub itself (e.g. someone joined)
+	KindAction = "action" // an action of a client (sent with /me)
+	// KindAnnouncement is an announcement of the server (see Announce)
+	KindAnnouncement = "announcement"
+)
+
+type Message struct {
+	ID       uint64 `json:"id"`               // id assigned by the hub, strictly increasing within a hub
+	Kind     string `json:"kind"`             // kind of message (KindChat, KindSystem)
+	ClientID string `json:"client_id"`        // client id
+	Username string `json:"username"`         // display name of the client
+	Avatar   string `json:"avatar,omitempty"` // url of the picture of the sender (empty if it has none)
+	Text     string `json:"text"`             // message text
+	To       string `json:"to,omitempty"`     // name of the recipient of a direct message (empty for public messages)
+	Origin   string `json:"-"`                // instance the message was broadcast by (empty for our own, see Bridge)
+	Edit     uint64 `json:"-"`                // id of the message this one edits (zero for new messages)
+	Edited   bool   `json:"edited,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`  // the message was deleted, only its tombstone is left
+	ReplyTo  uint64 `json:"reply_to,omitempty"` // id of the message this one replies to (zero if none)
+	Ref      string `json:"-"`                  // reference the client sent the message with, for its ack
+
+	Reactions []Reaction `json:"reactions,omitempty"` // emoji the clients reacted with, in the order they were first used
+	// Mentions are the names the text mentions (set when the text is rendered)
+	Mentions []string `json:"mentions,omitempty"`
+	// Quote is the message this one replies to, only set on the copies being rendered
+	Quote *Quote `json:"quote,omitempty"`
+
+	Timestamp time.Time `json:"ts"` // when the hub received the message
+
+	// HTML is the text rendered as sanitized markdown, empty when the hub has markdown disabled
+	HTML template.HTML `json:"-"`
+
+	// accepted receives the id of the message once the hub has broadcast it,
+	// and is closed without a value if the hub couldn't (only set by Publish)
+	accepted chan uint64
+	// hub is the hub handling the message, so middlewares can reply to the sender
+	hub *Hub
+}
+
+// Notice is an error meant for a single client (e.g. its message was too long)
+type Notice struct {
+	Client *Client // client the notice is for
+	Text   string  // text of the notice
+}
+
+// WSHeaders are the headers the htmx ws extension sends along with every message
+type WSHeaders struct {
+	Request     string `json:"HX-Request"`
+	Trigger     string `json:"HX-Trigger"`
+	TriggerName string `json:"HX-Trigger-Name"`
+	Target      string `json:"HX-Target"`
+	CurrentURL  string `json:"HX-Current-URL"`
+}
+
+// message types a client can send, an empty type is a chat message
+const (
+	TypeChat   = "chat"
+	TypeTyping = "typing"
+	TypeEdit   = "edit"
+	TypeDelete = "delete"
+	TypeReact  = "react"
+	TypeRead   = "read"
+)
+
+type WSMessage struct {
+	Headers WSHeaders `json:"HEADERS"`
+	Type    string    `json:"type"`
+	ID      WSID      `json:"id"` // id of the message, only for edits, deletes, reactions and read receipts
+	Text    string    `json:"text"`
+	Emoji   string    `json:"emoji"`    // only for reactions
+	ReplyTo string    `json:"reply_to"` // id of the message replied to, only for chat messages
+	To      string    `json:"to"`       // username or client id, only for direct messages
+	Ref     string    `json:"ref"`      // reference chosen by the client, echoed back in the ack of a chat message
+}
+
+// Hub keeps track of the clients of a room and broadcasts messages to them.
+// The clients are only ever changed by the Run goroutine and always under the lock,
+// so Run itself can read them freely while other goroutines take the read lock
+type Hub struct {
+	sync.RWMutex
+	clients     map[*Client]bool         // registered clients
+	names       map[string]*Client       // registered clients by name
+	ids         map[string]*Client       // registered clients by id
+	guests      int                      // number of guest names handed out
+	store       MessageStore             // message history
+	broadcast   chan *Message            // broadcast channel (send message to all clients)
+	remote      chan *Message            // remote channel (messages broadcast by other instances)
+	register    chan *Client             // register channel (add client to hub)
+	unregister  chan *Client             // unregister channel (remove client from hub)
+	notify      chan *Notice             // notify channel (send an error to a single client)
+	kick        chan *kickRequest        // kick channel (disconnect a client)
+	deletes     chan *deleteRequest      // deletes channel (delete a message)
+	react       chan *reactRequest       // react channel (toggle a reaction to a message)
+	pins        chan *pinRequest         // pins channel (pin or unpin a message)
+	receipts    chan *readReceipt        // receipts channel (a client read up to a message)
+	typing      chan *Client             // typing channel (a client is composing a message)
+	probes      chan struct{}            // probes channel (a liveness probe, see Alive)
+	announces   chan *announceRequest    // announces channel (broadcast an announcement)
+	modes       chan *modeRequest        // modes channel (turn read-only mode on or off)
+	typers      map[*Client]time.Time    // clients currently typing and when their indicator expires
+	order       []*Client                // registered clients in the order they joined
+	observers   map[*Client]bool         // admin dashboards watching the room (see roleObserver)
+	dashboardAt time.Time                // last time the dashboards got the state of the room
+	announced   []*Announcement          // announcements shown above the room until they expire, oldest first
+	leaving     map[string]time.Time     // names of the clients that left, and when their departure is announced
+	known       map[string]time.Time     // names that can be mentioned, and when their client last joined
+	owners      map[string]string        // identity of the client that last went by each known name
+	outbox      map[string][]queuedFrame // frames kept for the clients that are away, by identity
+	lastReads   map[string]uint64        // last message each reader has read, when the store doesn't keep them
+	presenceDue <-chan time.Time         // fires when a coalesced presence update is due (nil if none is pending)
+	presenceAt  time.Time                // when the last presence update was broadcast
+
+	historyReplay  int             // number of recent messages replayed to a new client
+	sendBuffer     int             // size of the send buffer of each client
+	clock          Clock           // the clock the hub and the pumps of its clients read the time from
+	config         Config          // timeouts of the connections and maximum size of a message sent by a client
+	nextID         func() uint64   // generates the id of the next message
+	metrics        *Metrics        // metrics of the hub (nil records nothing)
+	roomMetrics    *roomMetrics    // metrics of the broadcasts of the room (nil records nothing)
+	logger         *slog.Logger    // where the hub logs (slog.Default() unless set)
+	rateLimit      rate.Limit      // chat messages per second a client may send
+	rateBurst      int             // chat messages a client may send in a burst
+	markdown       bool            // whether message text is rendered as markdown
+	editWindow     time.Duration   // how long after sending a message its author can edit it
+	reactions      []string        // emoji clients can react with
+	echo           bool            // whether the sender of a message gets it back
+	bridge         Bridge          // shares the room with other instances (nil when running alone)
+	webhooks       *Webhooks       // posts the messages to other systems (nil when there are none)
+	mutes          *mutes          // clients muted for flooding the room
+	audit          *AuditLog       // records the kicks, mutes and deletions (nil records nothing)
+	fanoutWorkers  int             // goroutines the broadcasts of big rooms are spread across
+	fanoutJobs     chan *fanoutJob // jobs of the fan-out workers (nil when they aren't running)
+	seq            uint64          // sequence number of the last broadcast frame
+	window         *frameWindow    // the most recent broadcast frames, for the clients reconnecting
+	capacity       int             // clients the room holds at once (0 for no limit)
+	closeWhenFull  bool            // whether clients of a full room are told so over their connection
+	reserved       int             // places kept for the clients about to join (see reserve)
+	idleTimeout    time.Duration   // how long a client can go without sending anything (0 for as long as it wants)
+	slowPolicy     SlowPolicy      // what happens to the clients that can't keep up
+	slowWait       time.Duration   // how long SlowBlock waits for room in the send buffers
+	readOnlyBanner uint64          // id of the announcement saying the room is read-only (0 when it isn't)
+	access         *roomAccess     // who gets into the room when it is private (nil when anyone does)
+
+	// hooks set with the WithOn... options (nil when not set)
+	onConnect    func(ClientInfo)
+	onDisconnect func(ClientInfo)
+	onMessage    func(*Message) *Message
+
+	middleware []MessageMiddleware // middlewares set with WithMiddleware, outermost first
+	handle     MessageHandler      // the middlewares wrapped around broadcastMessage
+	dropped    atomic.Uint64       // number of clients dropped because their send buffer was full
+	// number of frames dropped because a send buffer was full (with SlowDrop)
+	droppedFrames atomic.Uint64
+	// number of frames broadcast to the room
+	broadcasts atomic.Uint64
+	// whether the messages sent to the room are bounced (see SetReadOnly)
+	readOnly atomic.Bool
+	// whether the room is left out of the directory (see SetPrivate)
+	private atomic.Bool
+	// when the last message was broadcast to the room, in nanoseconds since the epoch (0 if none was)
+	lastMessage atomic.Int64
+
+	room       string        // name of the room the hub serves
+	lastActive time.Time     // last time a client joined or left
+	stop       chan struct{} // closed when the hub is shut down
+	stopOnce   sync.Once     // makes sure stop is only closed once
+	done       chan struct{} // closed when Run has returned
+}
+
+// NewHub creates a new hub
+func NewHub(opts ...Option) *Hub {
+	h := &Hub{
+		broadcast:     make(chan *Message),
+		remote:        make(chan *Message),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		notify:        make(chan *Notice),
+		kick:          make(chan *kickRequest),
+		deletes:       make(chan *deleteRequest),
+		react:         make(chan *reactRequest),
+		pins:          make(chan *pinRequest),
+		receipts:      make(chan *readReceipt),
+		typing:        make(chan *Client),
+		probes:        make(chan struct{}),
+		announces:     make(chan *announceRequest),
+		modes:         make(chan *modeRequest),
+		typers:        make(map[*Client]time.Time),
+		leaving:       make(map[string]time.Time),
+		known:         make(map[string]time.Time),
+		owners:        make(map[string]string),
+		outbox:        make(map[string][]queuedFrame),
+		lastReads:     make(map[string]uint64),
+		mutes:         newMutes(),
+		clients:       make(map[*Client]bool),
+		observers:     make(map[*Client]bool),
+		names:         make(map[string]*Client),
+		ids:           make(map[string]*Client),
+		historyReplay: defaultHistoryReplay,
+		sendBuffer:    defaultSendBuffer,
+		config:        DefaultConfig(),
+		clock:         systemClock{},
+		rateLimit:     defaultRateLimit,
+		rateBurst:     defaultRateBurst,
+		markdown:      true,
+		editWindow:    defaultEditWindow,
+		slowPolicy:    SlowDisconnect,
+		slowWait:      DefaultSlowWait,
+		reactions:     DefaultReactions,
+		echo:          true,
+		fanoutWorkers: runtime.GOMAXPROCS(0),
+		seq:           uint64(time.Now().UnixMicro()),
+		window:        newFrameWindow(replayWindow),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	// apply the options on top of the defaults
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	// the room counts as active from its creation, by the clock of the hub
+	h.lastActive = h.clock.Now()
+
+	// every line the hub logs says which room it is about
+	if h.logger == nil {
+		h.logger = slog.Default()
+	}
+	h.logger = h.logger.With("room", h.room)
+	h.roomMetrics = h.metrics.room(h.room)
+
+	// without a store we keep the history in memory
+	if h.store == nil {
+		h.store = NewMemoryStore(DefaultHistoryCapacity)
+	}
+
+	// without an id generator we continue from the last message in the store
+	if h.nextID == nil {
+		var last uint64
+		if recent, err := h.store.RecentN(h.room, 1); err != nil {
+			h.logger.Error("reading the last message", "err", err)
+		} else if len(recent) > 0 {
+			last = recent[0].ID
+		}
+		h.nextID = counter(last)
+	}
+
+	// we chain the middlewares (and the message hook) in front of the broadcast
+	h.handle = h.chain()
+
+	return h
+}
+
+// Run handles the clients and messages of the hub until ctx is cancelled or Close is called,
+// either way every client is removed before it returns
+func (h *Hub) Run(ctx context.Context) {
+	// we let Close know when we're done
+	defer close(h.done)
+
+	// the fan-out workers sending the broadcasts of big rooms
+	h.startFanout()
+	defer h.stopFanout()
+
+	// with a bridge we also broadcast the messages of the other instances
+	if h.bridge != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		h.attachBridge(ctx)
+	}
+
+	// if anything in the loop panics we log it and start the loop again,
+	// one bad message or client shouldn't take the whole room down
+	for !h.loop(ctx) {
+	}
+}
+
+// loop listens for messages and broadcasts them to clients until the hub is stopped,
+// it returns true when the hub was stopped and false if it recovered from a panic
+func (h *Hub) loop(ctx context.Context) (stopped bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			h.recovered("hub", r)
+			stopped = false
+		}
+	}()
+
+	// we regularly clear the typing indicators that expired and announce departures
+	sweepTicks, stopSweep := h.clock.NewTicker(sweepInterval)
+	defer stopSweep()
+
+	// this will listen for messages and broadcast them to clients
+	for {
+		select {
+		case <-ctx.Done(): // the context of the hub was cancelled, this is the same as Close
+
+			// we stop the hub so joining and publishing fail from now on
+			h.stopOnce.Do(func() { close(h.stop) })
+			h.shutdown()
+			return true
+
+		case <-h.stop: // the hub was shut down (server shutdown, or the room was garbage collected)
+			h.shutdown()
+			return true
+
+		case client := <-h.register: // when a client connects, we add the client to the hub
+
+			// a dashboard only watches, it isn't one of the clients of the room
+			if client.role == roleObserver {
+				h.watch(client)
+				continue
+			}
+
+			h.attach(client)
+
+			client.logger.Info("client connected", "name", client.name)
+			h.metrics.clientConnected()
+
+			// when a client connects, we hand the recent message history to the client (if there are any messages).
+			// We don't push it through the send channel here because a slow client would block the hub,
+			// instead the client's writePump writes it out before it starts reading the send channel,
+			// which also guarantees the history arrives before any message broadcast after this point.
+			// A client reconnecting gets the frames it missed instead, if we still have them all
+			if replay, ok := h.resume(client); ok {
+				client.replay = replay
+			} else {
+				client.replay = h.renderHistory(client)
+			}
+			// either way it is now up to date with the last frame we broadcast
+			client.replaySeq = h.seq
+
+			// the JSON clients only get the messages, the pages get what they show around them too
+			if !client.jsonFrames {
+				// the new client gets the current presence list along with the history,
+				// everyone else gets it once the join/leave churn settles
+				if !client.constrained {
+					if presence := h.renderPresence(); presence != nil {
+						client.replay = append(client.replay, Frame{Data: presence})
+					}
+				}
+				// as well as the pinned messages
+				if pins := h.renderPins(); pins != nil {
+					client.replay = append(client.replay, Frame{Data: pins})
+				}
+				// and the announcements still up
+				if len(h.announced) > 0 {
+					if announcements := h.renderAnnouncements(); announcements != nil {
+						client.replay = append(client.replay, Frame{Data: announcements})
+					}
+				}
+				// and how much it missed since it last read the room
+				if unread := h.renderUnread(client.identity()); unread != nil {
+					client.replay = append(client.replay, Frame{Data: unread})
+				}
+				// and what was kept for it while it was away, before anything live
+				client.replay = append(client.replay, h.takeOutbox(client)...)
+			}
+			h.schedulePresence()
+
+			// we let the room know (unless the client is just coming back, e.g. after a page refresh)
+			h.announceJoin(client)
+
+			// we let join know the client is set up, only now can its pumps start
+			close(client.registered)
+
+			h.connected(client)
+			h.updateDashboards()
+
+		case client := <-h.unregister:
+			if client.role == roleObserver {
+				h.unwatch(client, websocket.CloseNormalClosure, "")
+				continue
+			}
+			// we remove the client from the hub (if it wasn't already dropped)
+			if h.remove(client, websocket.CloseNormalClosure, "") {
+				client.logger.Info("client disconnected", "name", client.name)
+				h.announceLeave(client)
+			}
+
+		case req := <-h.kick:
+			req.done <- h.kickClient(req)
+
+		case req := <-h.deletes:
+			// a message is deleted, its tombstone replaces it everywhere
+			req.done <- h.deleteMessage(req)
+
+		case req := <-h.react:
+			// a client reacts to a message (or takes its reaction back)
+			h.toggleReaction(req)
+
+		case req := <-h.pins:
+			// a message is pinned or unpinned, every page gets the new pins
+			req.done <- h.setPinned(req)
+
+		case req := <-h.receipts:
+			// a client read up to a message, its unread count goes down
+			h.markRead(req)
+
+		case client := <-h.typing:
+			// the client is typing, we show the indicator to everyone else
+			h.startTyping(client)
+
+		case <-h.probes:
+			// a liveness probe, getting here is all it asks
+
+		case req := <-h.announces:
+			// the server announces something, it stays above the room until it expires
+			req.done <- h.announce(req)
+
+		case req := <-h.modes:
+			// the room goes read-only (or opens again), everyone sees a banner about it
+			h.setMode(req)
+
+		case <-h.presenceDue:
+			// the presence list changed, we let everyone know
+			h.presenceDue = nil
+			h.broadcastPresence()
+
+		case now := <-sweepTicks:
+			// we clear the indicators of the clients that stopped typing
+			h.expireTyping(now)
+			// and announce the clients that left and didn't come back
+			h.announceDepartures(now)
+			// and forget what was kept for the clients that never came back
+			h.expireOutboxes(now)
+			// and disconnect the clients that walked away
+			h.disconnectIdle(now)
+			// and take the announcements that expired down
+			h.expireAnnouncements(now)
+			// and let the dashboards know how the room is doing
+			h.refreshDashboards(now)
+
+		case notice := <-h.notify:
+			h.sendNotice(notice.Client, notice.Text)
+
+		case msg := <-h.broadcast:
+			// the message goes through the middlewares before it is broadcast,
+			// messages of our clients carry the name the client currently goes by
+			received := time.Now()
+			msg.hub = h
+			if sender, ok := h.ids[msg.ClientID]; ok {
+				msg.Username = sender.name
+				msg.Avatar = sender.avatar
+			}
+			h.handle(msg)
+			if msg.ID != 0 {
+				h.roomMetrics.messageBroadcast(time.Since(received))
+			}
+			// if it didn't make it its publisher (if any) learns it was dropped
+			msg.settle()
+
+		case msg := <-h.remote:
+			// messages from the other instances were filtered where they were sent
+			h.broadcastMessage(msg)
+		}
+	}
+}
+
+// broadcastMessage stamps the message, adds it to the history and sends it to every client
+// (direct messages only go to their recipient). It must only be called from the hub goroutine
+func (h *Hub) broadcastMessage(msg *Message) {
+	// edits replace a message that was already broadcast
+	if msg.Edit != 0 {
+		h.editMessage(msg)
+		return
+	}
+
+	// we stamp the message with its id and the time we received it
+	// (messages of other instances keep the time they were sent at)
+	msg.ID = h.nextID()
+	if msg.Origin == "" || msg.Timestamp.IsZero() {
+		msg.Timestamp = h.clock.Now()
+	}
+
+	// direct messages only go to their recipient (and back to the sender),
+	// they never make it into the public history
+	if msg.To != "" {
+		h.sendDirect(msg)
+		return
+	}
+
+	// we render the markdown (if enabled) once and keep it with the message
+	h.format(msg)
+
+	// we render the message once per variant, the compact one is only rendered if there
+	// is a constrained client to send it to and the sender's own if the sender is here
+	// (a reply is rendered with the quote of its parent, which isn't stored with it)
+	renders := newVariants(h.formatted(msg))
+	if renders.render("", false) == nil {
+		return
+	}
+
+	// we add the message to the message history
+	// (if the store fails we still broadcast, the message just won't be in the history)
+	if err := h.store.Append(h.room, msg); err != nil {
+		h.logger.Error("storing message", "msg_id", msg.ID, "err", err)
+	}
+	h.lastMessage.Store(msg.Timestamp.UnixNano())
+
+	// we let the publisher know which id the message got
+	msg.accept()
+
+	// our own messages go to the other instances as well
+	if h.bridge != nil && msg.Origin == "" {
+		h.bridge.Publish(h.room, msg)
+	}
+	// and to the webhooks (the instance a message was sent to posts it)
+	if h.webhooks != nil && msg.Origin == "" {
+		h.webhooks.Send(h.room, msg)
+	}
+
+	// the sender is done typing, so we clear its indicator
+	h.stopTyping(msg.ClientID)
+
+	// with the fan-out workers the variants are rendered concurrently, so we render them first
+	if h.parallelFanout() {
+		renders.prepare()
+	}
+
+	// we send the message to each client in the hub
+	h.fanOut(func(client *Client) (Frame, bool) {
+		// the JSON clients have no page showing the message, the sender gets it as well
+		if client.jsonFrames {
+			return Frame{ID: msg.ID, JSON: true, Data: renders.json()}, true
+		}
+		// the sender gets its own variant, or nothing if its page shows the message already
+		if client.id == msg.ClientID && !client.constrained && !h.echo {
+			return Frame{}, false
+		}
+		rendered := renders.render(client.id, client.constrained)
+		if rendered == nil {
+			return Frame{}, false
+		}
+		// the other clients get the message rendered with the HTMX template
+		return Frame{ID: msg.ID, Data: rendered}, true
+	})
+
+	// the sender learns its message made it
+	h.acknowledge(msg)
+
+	// the clients the message mentions get a notification of their own
+	h.notifyMentions(msg)
+}
+
+// shutdown removes every client, telling them we're going away
+// (their writePump sends the close frame)
+func (h *Hub) shutdown() {
+	for client := range h.observers {
+		h.unwatch(client, websocket.CloseGoingAway, "server shutting down")
+	}
+	for client := range h.clients {
+		h.remove(client, websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// remove removes the client from the hub and closes its send channel,
+// the close frame its writePump sends carries code and reason.
+// This is the only place the send channel is closed, so a client that is removed twice
+// (e.g. dropped for being slow and then unregistering) is only closed once.
+// It returns false if the client wasn't in the hub
+func (h *Hub) remove(client *Client, code int, reason string) bool {
+	if !h.detach(client, code, reason) {
+		return false
+	}
+
+	// the hook runs once the hub is unlocked
+	h.disconnected(client)
+	h.updateDashboards()
+	return true
+}
+
+// attach adds the client to the hub while holding the lock, making sure its name is
+// unique in the hub
+func (h *Hub) attach(client *Client) {
+
+	// we perform a lock on the hub to prevent concurrent access, the deferred unlock
+	// releases it even if the hub panics and recovers
+	h.Lock()
+	defer h.Unlock()
+
+	client.name = h.uniqueName(client.name)
+	h.clients[client] = true
+	h.names[client.name] = client
+	h.ids[client.id] = client
+	h.order = append(h.order, client)
+	h.remember(client.name, client.identity())
+	h.lastActive = h.clock.Now()
+
+	// the place kept for the client is now taken
+	if client.reserved == h {
+		h.reserved--
+	}
+}
+
+// detach does the work of remove while holding the lock
+func (h *Hub) detach(client *Client, code int, reason string) bool {
+
+	// we perform a lock on the hub to prevent concurrent access
+	h.Lock()
+	defer h.Unlock()
+
+	// we check the client is still in the hub
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+
+	// we remove the client from the hub
+	h.metrics.clientDisconnected()
+	delete(h.clients, client)
+	delete(h.names, client.name)
+	delete(h.ids, client.id)
+	for i, c := range h.order {
+		if c == client {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+	h.lastActive = h.clock.Now()
+	h.schedulePresence()
+
+	// if the client was typing, it isn't anymore
+	if _, ok := h.typers[client]; ok {
+		delete(h.typers, client)
+		defer h.broadcastTyping()
+	}
+
+	// we close the send channel, which makes the writePump send the close frame and return
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.send)
+
+	return true
+}
+
+// uniqueName returns the name, or a variant of it with a numeric suffix,
+// that is not yet used in the hub. Empty names become a generated guest name
+func (h *Hub) uniqueName(name string) string {
+
+	// clients that didn't pick a name get a guest name
+	if name == "" {
+		h.guests++
+		name = fmt.Sprintf("Guest-%d", h.guests)
+	}
+
+	// if the name is free we can use it as is
+	if _, taken := h.names[name]; !taken {
+		return name
+	}
+
+	// otherwise we look for the first free suffix
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if _, taken := h.names[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+// Close shuts the hub down, every client is sent a close frame and we wait
+// (up to timeout) for their writePumps to flush before returning
+func (h *Hub) Close(timeout time.Duration) error {
+
+	// we grab the clients before Run lets go of them
+	h.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.RUnlock()
+
+	// we stop the hub and wait for Run to return
+	h.stopOnce.Do(func() { close(h.stop) })
+
+	deadline := time.After(timeout)
+	select {
+	case <-h.done:
+	case <-deadline:
+		return errors.New("timed out waiting for the hub to stop")
+	}
+
+	// we wait for each client to flush its close frame
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-deadline:
+			return errors.New("timed out waiting for clients to disconnect")
+		}
+	}
+
+	return nil
+}
+
+// Publish broadcasts a message that didn't come from a websocket client (e.g. a bot),
+// it waits up to timeout for the hub to broadcast it and returns the id it was given
+func (h *Hub) Publish(msg *Message, timeout time.Duration) (uint64, error) {
+
+	msg.accepted = make(chan uint64, 1)
+
+	deadline := time.After(timeout)
+	select {
+	case h.broadcast <- msg:
+	case <-h.stop:
+		return 0, errors.New("hub is shut down")
+	case <-deadline:
+		return 0, errors.New("timed out waiting for the hub")
+	}
+
+	select {
+	case id, ok := <-msg.accepted:
+		if !ok {
+			return 0, errors.New("message could not be broadcast")
+		}
+		return id, nil
+	case <-deadline:
+		return 0, errors.New("timed out waiting for the hub")
+	}
+}
+
+// accept tells the publisher of the message (if any) the message was broadcast
+func (m *Message) accept() {
+	if m.accepted != nil {
+		select {
+		case m.accepted <- m.ID:
+		default: // the message was broadcast twice, the publisher already got its id
+		}
+	}
+}
+
+// settle closes the channel the publisher of the message (if any) waits on,
+// if the message wasn't accepted by then the publisher learns it was not broadcast
+func (m *Message) settle() {
+	if m.accepted != nil {
+		close(m.accepted)
+	}
+}
+
+// sendNotice queues an error for a single client (if it is still around),
+// if its buffer is full the notice isn't worth dropping the client for.
+// It must only be called from the hub goroutine
+func (h *Hub) sendNotice(client *Client, text string) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	if client.jsonFrames {
+		queueNotice(client, jsonFrame(&Message{Kind: KindError, Text: text, Timestamp: h.clock.Now()}, ""))
+		return
+	}
+	if rendered := getErrorTemplate(text); rendered != nil {
+		deliverNotice(client, rendered)
+	}
+}
+
+// deliverNotice queues a fragment meant only for the client, skipping it if the buffer is full
+func deliverNotice(client *Client, rendered []byte) {
+	queueNotice(client, Frame{Data: rendered})
+}
+
+// queueNotice queues a frame meant only for the client, unless it isn't for it
+// or the buffer is full
+func queueNotice(client *Client, frame Frame) {
+	if !client.takes(frame) {
+		return
+	}
+	select {
+	case client.send <- frame:
+	default:
+	}
+}
+
+// notice sends an error to a single client,
+// it returns false if the hub has been shut down
+func (h *Hub) notice(client *Client, text string) bool {
+	select {
+	case h.notify <- &Notice{Client: client, Text: text}:
+		return true
+	case <-h.stop:
+		return false
+	}
+}
+
+// History returns up to limit messages of the history, oldest first.
+// If before is not zero only messages with an id lower than before are returned.
+// It is safe to call from any goroutine
+func (h *Hub) History(before uint64, limit int) ([]*Message, error) {
+
+	var messages []*Message
+	var err error
+	if before == 0 {
+		messages, err = h.store.RecentN(h.room, limit)
+	} else {
+		messages, err = h.store.Before(h.room, before, limit)
+	}
+
+	// messages read back from a database haven't had their markdown rendered yet
+	for i, msg := range messages {
+		messages[i] = h.formatted(msg)
+	}
+
+	return messages, err
+}
+
+// Stats is a snapshot of the state of a hub
+type Stats struct {
+	Room            string `json:"room"`
+	Clients         int    `json:"clients"`
+	Capacity        int    `json:"capacity"`         // clients the room holds at once (0 for no limit)
+	Dropped         uint64 `json:"dropped"`          // clients disconnected because they couldn't keep up
+	DroppedFrames   uint64 `json:"dropped_frames"`   // frames the clients that couldn't keep up missed
+	Broadcasts      uint64 `json:"broadcasts"`       // frames broadcast to the room since it was created
+	HistoryLength   int    `json:"history_length"`   // messages currently kept (-1 if the store doesn't say)
+	HistoryCapacity int    `json:"history_capacity"` // messages kept at most (-1 if unbounded or unknown)
+	ReadOnly        bool   `json:"read_only"`        // whether the messages sent to the room are bounced
+
+	// RTT is the smoothed ping round-trip time of the clients that answered a ping,
+	// in milliseconds (rounded up) by name
+	RTT map[string]int `json:"rtt_ms,omitempty"`
+}
+
+// boundedStore is implemented by the stores that keep a bounded history
+type boundedStore interface {
+	Len(room string) int
+	Capacity() int
+}
+
+// Stats returns a snapshot of the state of the hub, it is safe to call from any goroutine
+func (h *Hub) Stats() Stats {
+
+	h.RLock()
+	clients := len(h.clients)
+	rtts := h.rttMillis()
+	h.RUnlock()
+
+	stats := Stats{
+		Room:            h.room,
+		Clients:         clients,
+		Capacity:        h.capacity,
+		Dropped:         h.dropped.Load(),
+		DroppedFrames:   h.droppedFrames.Load(),
+		Broadcasts:      h.broadcasts.Load(),
+		ReadOnly:        h.readOnly.Load(),
+		RTT:             rtts,
+		HistoryLength:   -1,
+		HistoryCapacity: -1,
+	}
+	if bounded, ok := h.store.(boundedStore); ok {
+		stats.HistoryLength = bounded.Len(h.room)
+		stats.HistoryCapacity = bounded.Capacity()
+	}
+
+	return stats
+}
+
+// Snapshot is what a page needs to render a room before it connects
+type Snapshot struct {
+	Room     string
+	Clients  int        // clients currently in the room
+	Messages []*Message // the most recent messages, oldest first
+	LastID   uint64     // id of the last message in Messages (0 if there are none)
+
+	Reactions []string // the emoji clients can react with
+}
+
+// Snapshot returns the most recent messages of the room (at most limit) and the number of
+// clients in it, it is safe to call from any goroutine. A client connecting with the LastID
+// of the snapshot only gets the messages after it replayed
+func (h *Hub) Snapshot(limit int) (Snapshot, error) {
+
+	h.RLock()
+	clients := len(h.clients)
+	h.RUnlock()
+
+	messages, err := h.History(0, limit)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot := Snapshot{Room: h.room, Clients: clients, Messages: messages, Reactions: h.reactions}
+	if len(messages) > 0 {
+		snapshot.LastID = messages[len(messages)-1].ID
+	}
+	return snapshot, nil
+}
+
+// Dropped returns the number of clients dropped because they couldn't keep up
+func (h *Hub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// join registers the client with the hub, it returns false if the hub
+// has been shut down in the meantime
+func (h *Hub) join(client *Client) bool {
+	select {
+	case h.register <- client:
+	case <-h.stop:
+		return false
+	}
+
+	// we wait for Run to finish setting up the client (name, history),
+	// otherwise the pumps would race with it
+	select {
+	case <-client.registered:
+		return true
+	case <-h.stop:
+		return false
+	}
+}
+
+// idleSince returns since when the hub has had no clients,
+// ok is false if there are clients connected (or about to join)
+func (h *Hub) idleSince() (since time.Time, ok bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	if len(h.clients) > 0 || h.reserved > 0 {
+		return time.Time{}, false
+	}
+	return h.lastActive, true
+}
+
+// renderHistory renders the most recent messages of the history for the client, a frame per
+// message, a client resuming from a message only gets the messages after it
+func (h *Hub) renderHistory(client *Client) []Frame {
+
+	// we only replay the most recent messages
+	if h.historyReplay == 0 {
+		return nil
+	}
+
+	var history []*Message
+	var err error
+	if client.resumeFrom > 0 {
+		history, err = h.store.Since(h.room, client.resumeFrom)
+		if len(history) > h.historyReplay {
+			history = history[len(history)-h.historyReplay:]
+		}
+	} else {
+		history, err = h.store.RecentN(h.room, h.historyReplay)
+	}
+	if err != nil {
+		h.logger.Error("reading the history", "err", err)
+		return nil
+	}
+
+	// each message is its own fragment, writePump batches them in as few messages as it can
+	// (messages read back from a database haven't had their markdown rendered yet)
+	replay := make([]Frame, 0, len(history))
+	for _, msg := range history {
+		if client.jsonFrames {
+			if encoded := encodeJSON(msg, ""); encoded != nil {
+				replay = append(replay, Frame{ID: msg.ID, JSON: true, Data: encoded})
+			}
+			continue
+		}
+		if rendered := getMessageTemplate(h.formatted(msg), client.id, client.constrained); rendered != nil {
+			replay = append(replay, Frame{ID: msg.ID, Data: rendered})
+		}
+	}
+	return replay
+}
@@ -0,0 +1,266 @@
+package chatter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+func TestMessageIDsAreStrictlyIncreasing(t *testing.T) {
+	store := NewMemoryStore(DefaultHistoryCapacity)
+	hub := NewHub(WithStore(store))
+
+	var last uint64
+	for i := 0; i < 50; i++ {
+		msg := &Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d", i)}
+		hub.broadcastMessage(msg)
+		if msg.ID <= last {
+			t.Fatalf("message %d got id %d after %d", i, msg.ID, last)
+		}
+		last = msg.ID
+	}
+
+	// a hub opening the same history carries on from its last id
+	again := NewHub(WithStore(store))
+	msg := &Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "after a restart"}
+	again.broadcastMessage(msg)
+	if msg.ID != last+1 {
+		t.Errorf("the first message after a restart got id %d, want %d", msg.ID, last+1)
+	}
+}
+
+// TestTeardownNeverWritesToClosedConnections is meant to run with -race: the peers go away
+// while the hub broadcasts, the client has to be out of the hub and its writePump done
+// before its connection is closed. The clients that can't keep up with the flood miss frames
+// rather than being hung up on, which closes their connection under their writePump on purpose
+func TestTeardownNeverWritesToClosedConnections(t *testing.T) {
+	hub := runHub(t, NewHub(WithSlowPolicy(SlowDrop, 0)))
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case hub.broadcast <- &Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d", i)}:
+			}
+		}
+	}()
+
+	const clients = 20
+	conns := make([]*fakeConn, clients)
+	pumped := make([]*Client, clients)
+	for i := range conns {
+		conns[i] = newFakeConn()
+		pumped[i] = joinHub(t, hub, conns[i], fmt.Sprintf("client%d", i))
+		go pumped[i].writePump()
+		go pumped[i].readPump()
+	}
+	// the peers hang up, each after getting a few frames
+	for _, conn := range conns {
+		<-conn.wrote
+		close(conn.incoming)
+	}
+	for _, client := range pumped {
+		select {
+		case <-client.done:
+		case <-time.After(time.Second):
+			t.Fatal("a client is still writing after its peer went away")
+		}
+	}
+	close(stop)
+	<-done
+
+	for i, conn := range conns {
+		// readPump closes the connection once writePump returned
+		deadline := time.Now().Add(time.Second)
+		for !conn.Closed() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if late := conn.Late(); late > 0 {
+			t.Errorf("client %d got %d writes once its connection was closed", i, late)
+		}
+	}
+	if clients := hub.Stats().Clients; clients != 0 {
+		t.Errorf("%d clients are still in the hub", clients)
+	}
+}
+
+func TestStoppedHubsLeaveNoGoroutines(t *testing.T) {
+	for _, stop := range []struct {
+		name string
+		stop func(hub *Hub, cancel context.CancelFunc) error
+	}{
+		{"Close", func(hub *Hub, cancel context.CancelFunc) error { defer cancel(); return hub.Close(time.Second) }},
+		{"cancelled context", func(hub *Hub, cancel context.CancelFunc) error {
+			cancel()
+			select {
+			case <-hub.done:
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("Run didn't return")
+			}
+		}},
+	} {
+		t.Run(stop.name, func(t *testing.T) {
+			defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+			hub := NewHub(WithFanoutWorkers(4))
+			ctx, cancel := context.WithCancel(context.Background())
+			go hub.Run(ctx)
+
+			var conns []*fakeConn
+			for i := 0; i < 3; i++ {
+				conn := newFakeConn()
+				client := joinHub(t, hub, conn, fmt.Sprintf("client%d", i))
+				go client.writePump()
+				go client.readPump()
+				conns = append(conns, conn)
+			}
+			for i := 0; i < 10; i++ {
+				if _, err := hub.Publish(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "traffic"}, time.Second); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := stop.stop(hub, cancel); err != nil {
+				t.Fatal(err)
+			}
+			// the clients got their close frames and their pumps returned
+			for i, conn := range conns {
+				deadline := time.Now().Add(time.Second)
+				for !conn.Closed() && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				if !slices.Contains(conn.Controls(), websocket.CloseMessage) {
+					t.Errorf("client %d didn't get a close frame", i)
+				}
+			}
+
+			// joining and publishing fail right away from now on
+			start := time.Now()
+			if hub.join(pumpClient(hub, newFakeConn())) {
+				t.Error("a client joined the stopped hub")
+			}
+			if _, err := hub.Publish(&Message{Kind: KindChat, Text: "too late"}, time.Second); err == nil {
+				t.Error("a message was published to the stopped hub")
+			}
+			if took := time.Since(start); took > 100*time.Millisecond {
+				t.Errorf("the stopped hub took %v to turn them away", took)
+			}
+		})
+	}
+}
+
+func TestWhoGetsTheMessageBack(t *testing.T) {
+	tests := []struct {
+		name        string
+		echo        bool
+		constrained bool   // whether the sender is a constrained client
+		sender      string // what the sender gets, "" for nothing
+	}{
+		{"echo", true, false, `data-own="true"`},
+		{"no echo", false, false, ""},
+		// a constrained client always gets its message, in the compact variant
+		{"no echo constrained", false, true, "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHub(WithEcho(tt.echo))
+			alice, bob := pumpClient(hub, newFakeConn()), pumpClient(hub, newFakeConn())
+			alice.constrained = tt.constrained
+			addClient(hub, alice)
+			addClient(hub, bob)
+
+			hub.broadcastMessage(&Message{Kind: KindChat, ClientID: alice.id, Username: "alice", Text: "hello"})
+
+			// the others get the message for everyone, telling who sent it
+			frames := drain(bob)
+			if len(frames) != 1 || !bytes.Contains(frames[0], []byte(`data-sender="`+alice.id+`"`)) || bytes.Contains(frames[0], []byte("data-own")) {
+				t.Errorf("bob got:\n%s", bytes.Join(frames, []byte("\n")))
+			}
+
+			// the sender always gets the ack of its message (see acknowledge)
+			frames = slices.DeleteFunc(drain(alice), func(frame []byte) bool { return bytes.Contains(frame, []byte(`id="ack"`)) })
+			switch {
+			case tt.sender == "" && len(frames) != 0:
+				t.Errorf("alice got the message back:\n%s", bytes.Join(frames, []byte("\n")))
+			case tt.sender != "" && (len(frames) != 1 || !bytes.Contains(frames[0], []byte(tt.sender))):
+				t.Errorf("alice got:\n%s", bytes.Join(frames, []byte("\n")))
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcast measures broadcasting a message to the clients of the room,
+// rendering it (with the pooled buffers) and queueing it for every client
+func BenchmarkBroadcast(b *testing.B) {
+	for _, clients := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			hub := NewHub(WithSendBuffer(1))
+			all := make([]*Client, clients)
+			for i := range all {
+				all[i] = pumpClient(hub, newFakeConn())
+				addClient(hub, all[i])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				hub.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "hello, world"})
+				b.StopTimer()
+				for _, client := range all {
+					<-client.send
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestBroadcastFramesAreIntact(t *testing.T) {
+	// enough clients for the broadcasts to be spread across the fan-out workers
+	hub := NewHub(WithFanoutWorkers(4), WithSendBuffer(30))
+	hub.startFanout()
+	defer hub.stopFanout()
+	clients := make([]*Client, fanoutThreshold+10)
+	for i := range clients {
+		clients[i] = pumpClient(hub, newFakeConn())
+		addClient(hub, clients[i])
+	}
+
+	// the clients read their frames while the next ones are rendered
+	messages := make([]*Message, 30)
+	var wg sync.WaitGroup
+	received := make([][][]byte, len(clients))
+	for i, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for len(received[i]) < len(messages) {
+				received[i] = append(received[i], (<-client.send).Data)
+			}
+		}()
+	}
+	for i := range messages {
+		messages[i] = &Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: strings.Repeat(fmt.Sprintf("m%d ", i), 1+i*10)}
+		hub.broadcastMessage(messages[i])
+	}
+	wg.Wait()
+
+	for i, frames := range received {
+		for n, frame := range frames {
+			if want := getMessageTemplate(messages[n], "", false); !bytes.Equal(frame, want) {
+				t.Fatalf("frame %d of client %d is mangled:\n%s", n, i, frame)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package chatter_test
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// messageIDs matches the ids of the messages in a frame
+var messageIDs = regexp.MustCompile(`id="msg-(\d+)"`)
+
+func TestHistoryComesBeforeTheMessagesBroadcastWhileJoining(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages keep coming while the clients join
+	const messages = 200
+	published := make(chan error, 1)
+	go func() {
+		for i := 0; i < messages; i++ {
+			if _, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "bot", Text: fmt.Sprintf("live %d", i)}, time.Second); err != nil {
+				published <- err
+				return
+			}
+		}
+		published <- nil
+	}()
+
+	for i := 0; i < 10; i++ {
+		client := srv.Connect(t, fmt.Sprintf("client-%d", i))
+		// whatever the client gets, history or live, comes once and in order
+		var last uint64
+		for done := false; !done; {
+			frame := client.Next(waitTimeout)
+			for _, match := range messageIDs.FindAllStringSubmatch(frame, -1) {
+				id, _ := strconv.ParseUint(match[1], 10, 64)
+				if id <= last {
+					t.Fatalf("client-%d got message %d after %d", i, id, last)
+				}
+				last = id
+			}
+			done = strings.Contains(frame, fmt.Sprintf("live %d<", messages-1))
+		}
+	}
+	if err := <-published; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownSendsCloseFrames(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.ConnectRoom(t, "lobby", "bob")
+	alice.Send("hello")
+	alice.Expect("hello", waitTimeout)
+
+	if err := srv.Manager.Close(time.Second); err != nil {
+		t.Fatalf("closing the rooms: %v", err)
+	}
+	for _, client := range []*chattertest.Client{alice, bob} {
+		closeErr := client.ExpectClosed(waitTimeout)
+		if closeErr == nil || closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "server shutting down" {
+			t.Errorf("the close frame is %v, want %d server shutting down", closeErr, websocket.CloseGoingAway)
+		}
+	}
+
+	// and the rooms can't be opened anymore
+	if _, err := srv.Manager.Get("lobby"); !errors.Is(err, chatter.ErrClosed) {
+		t.Errorf("opening a room after the shutdown: %v, want %v", err, chatter.ErrClosed)
+	}
+}
+
+// churn connects and disconnects clients to the room from workers goroutines, rounds times
+// each, reading a few frames every time. It returns the first error
+func churn(srv *chattertest.Server, room string, workers, rounds int) error {
+	endpoint := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?room=" + room
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(waitTimeout))
+				for j := 0; j < i%3; j++ {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						break
+					}
+				}
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// hammer publishes messages to the hub until stop is closed, and then closes done
+func hammer(hub *chatter.Hub, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "bot", Text: fmt.Sprintf("message %d", i)}, time.Second)
+	}
+}
+
+// waitEmpty waits for every client of the hub to be gone
+func waitEmpty(t *testing.T, hub *chatter.Hub) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for hub.Stats().Clients > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients are still in the room", hub.Stats().Clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestConnectsAndDisconnectsWhileBroadcasting is meant to run with -race
+func TestConnectsAndDisconnectsWhileBroadcasting(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go hammer(hub, stop, done)
+
+	// the stats read the clients from other goroutines while the hub changes them
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				hub.Stats()
+				hub.Snapshot(10)
+			}
+		}
+	}()
+
+	err = churn(srv, chatter.DefaultRoom, 8, 20)
+	close(stop)
+	<-done
+	<-statsDone
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, hub)
+}
+
+// TestDisconnectsMidBroadcast is meant to run with -race: clients that can't keep up are
+// removed by the broadcasts while they disconnect on their own
+func TestDisconnectsMidBroadcast(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := chattertest.NewServer(t, chatter.WithSendBuffer(1), chatter.WithMetrics(chatter.NewMetrics(reg)))
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go hammer(hub, stop, done)
+
+	err = churn(srv, chatter.DefaultRoom, 8, 25)
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, hub)
+
+	if panics := metricValue(t, reg, "chatter_panics_recovered_total"); panics > 0 {
+		t.Errorf("the hub recovered from %v panics", panics)
+	}
+	// and the room still works: a client would get too slow again with a buffer of 1,
+	// so we check the hub itself broadcasts and keeps the message
+	id, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "alice", Text: "still up"}, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := hub.Snapshot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.LastID != id || snap.Messages[0].Text != "still up" {
+		t.Errorf("the last message of the room is %+v, want %q", snap.Messages, "still up")
+	}
+}
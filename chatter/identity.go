@@ -0,0 +1,115 @@
+package chatter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// identityCookie is the cookie the identity of a visitor is kept in
+	identityCookie = "chatter_id"
+	// identityMaxAge is how long a visitor keeps its identity without coming back
+	identityMaxAge = 365 * 24 * time.Hour
+)
+
+// identityKey is the context key of the identity of the request
+type identityKey struct{}
+
+// Identities gives every visitor a stable identity, kept in a signed cookie, so the same
+// person is recognized across page refreshes and reconnects (its mutes, what it read,
+// the messages kept for it while it was away, ...)
+type Identities struct {
+	key []byte // key the cookies are signed with, derived from the secret
+}
+
+// NewIdentities creates the identities signing their cookies with a key of their own derived
+// from the secret (see deriveKey). Without a secret we make one up, the identities are then
+// lost when the server restarts
+func NewIdentities(secret string) *Identities {
+	key := []byte(secret)
+	if secret == "" {
+		slog.Warn("no identity secret, the visitors get a new identity every time the server restarts")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("identities: %v", err)
+		}
+	}
+	return &Identities{key: deriveKey(key, purposeIdentity)}
+}
+
+// Middleware finds the identity of the visitor in its cookie, a visitor without one (or with
+// one we didn't sign) gets a new identity and the cookie to keep it in. The websocket
+// connections pass the cookie on in their handshake response. Nil identities leave every
+// visitor without one
+func (ids *Identities) Middleware(next http.Handler) http.Handler {
+	if ids == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := "", false
+		if cookie, err := r.Cookie(identityCookie); err == nil {
+			identity, ok = ids.verify(cookie.Value)
+		}
+		if !ok {
+			identity = uuid.New().String()
+			http.SetCookie(w, &http.Cookie{
+				Name:     identityCookie,
+				Value:    ids.sign(identity),
+				Path:     "/",
+				MaxAge:   int(identityMaxAge.Seconds()),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+}
+
+// sign returns the cookie value of the identity: the identity and its signature
+func (ids *Identities) sign(identity string) string {
+	return identity + "." + base64.RawURLEncoding.EncodeToString(macOf(ids.key, purposeIdentity, identity))
+}
+
+// verify returns the identity of the cookie value, if we signed it
+func (ids *Identities) verify(value string) (string, bool) {
+	identity, signature, ok := strings.Cut(value, ".")
+	if !ok || identity == "" {
+		return "", false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", false
+	}
+
+	if !hmac.Equal(sum, macOf(ids.key, purposeIdentity, identity)) {
+		return "", false
+	}
+	return identity, true
+}
+
+// requestIdentity returns the identity Identities.Middleware found for the request,
+// empty if the request didn't go through it
+func requestIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey{}).(string)
+	return identity
+}
+
+// handshakeHeader returns the headers of the websocket handshake response,
+// the cookie of a new identity has to make it to the browser
+func handshakeHeader(w http.ResponseWriter) http.Header {
+	cookies := w.Header().Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return nil
+	}
+	return http.Header{"Set-Cookie": cookies}
+}
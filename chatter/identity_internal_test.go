@@ -0,0 +1,76 @@
+package chatter
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// identify sends a request with the cookie value (none if empty) through the middleware,
+// and returns the identity the handler saw and the cookie the response set (nil if none)
+func identify(ids *Identities, value string) (string, *http.Cookie) {
+	var identity string
+	handler := ids.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = requestIdentity(r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		req.AddCookie(&http.Cookie{Name: identityCookie, Value: value})
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == identityCookie {
+			return identity, cookie
+		}
+	}
+	return identity, nil
+}
+
+func TestIdentityCookies(t *testing.T) {
+	ids := NewIdentities("s3cret")
+
+	// a first visit gets an identity and the cookie keeping it
+	identity, cookie := identify(ids, "")
+	if identity == "" || cookie == nil {
+		t.Fatalf("the first visit got the identity %q and the cookie %v", identity, cookie)
+	}
+	if !cookie.HttpOnly || cookie.Path != "/" || cookie.MaxAge <= 0 {
+		t.Errorf("the cookie is %+v", cookie)
+	}
+
+	// coming back with it keeps the identity, without a new cookie
+	if again, renewed := identify(ids, cookie.Value); again != identity || renewed != nil {
+		t.Errorf("coming back got the identity %q (want %q) and the cookie %v", again, identity, renewed)
+	}
+
+	// anything we didn't sign gets a new identity
+	signature := cookie.Value[strings.Index(cookie.Value, ".")+1:]
+	tampered := map[string]string{
+		"another identity":     "someone-else." + signature,
+		"no signature":         identity,
+		"empty signature":      identity + ".",
+		"broken signature":     identity + ".!!!",
+		"no identity":          "." + signature,
+		"another server's one": NewIdentities("another secret").sign(identity),
+		// what other features sign with the same secret isn't an identity cookie
+		"signed with the secret": identity + "." + base64.RawURLEncoding.EncodeToString(macOf([]byte("s3cret"), purposeIdentity, identity)),
+		"signed for sessions":    identity + "." + base64.RawURLEncoding.EncodeToString(macOf(deriveKey([]byte("s3cret"), "session"), "session", identity)),
+	}
+	for name, value := range tampered {
+		t.Run(name, func(t *testing.T) {
+			got, renewed := identify(ids, value)
+			if got == identity || got == "" || renewed == nil {
+				t.Errorf("the tampered cookie got the identity %q and the cookie %v", got, renewed)
+			}
+		})
+	}
+
+	// nil identities leave the visitors without one
+	var none *Identities
+	if got, cookie := identify(none, ""); got != "" || cookie != nil {
+		t.Errorf("without identities the visitor got %q and the cookie %v", got, cookie)
+	}
+}
@@ -0,0 +1,56 @@
+package chatter
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Idle is what the "disconnected due to inactivity" notice is rendered from
+type Idle struct {
+	After time.Duration // how long the client went without doing anything
+}
+
+// WithIdleTimeout disconnects the clients that didn't send anything (a message, a typing event,
+// a read receipt, ...) for d, zero (the default) keeps them connected. Browsers answer our pings
+// for as long as the tab is open, so abandoned tabs would otherwise keep their place forever
+func WithIdleTimeout(d time.Duration) Option {
+	return func(h *Hub) {
+		if d < 0 {
+			d = 0
+		}
+		h.idleTimeout = d
+	}
+}
+
+// touch records that the client just did something, on the clock of its hub. It is called
+// when the client joins and by readPump, and read by the hub goroutine
+func (c *Client) touch() {
+	c.lastActivity.Store(c.hub.clock.Now().UnixNano())
+}
+
+// disconnectIdle tells the clients that haven't done anything for the idle timeout they're
+// being disconnected, and disconnects them. It must only be called from the hub goroutine
+func (h *Hub) disconnectIdle(now time.Time) {
+	if h.idleTimeout <= 0 {
+		return
+	}
+
+	// the clients of event streams can't send anything, they are never idle
+	var idle []*Client
+	for _, client := range h.order {
+		if client.transport.interactive() && now.Sub(time.Unix(0, client.lastActivity.Load())) >= h.idleTimeout {
+			idle = append(idle, client)
+		}
+	}
+
+	for _, client := range idle {
+		client.logger.Info("client disconnected for inactivity", "name", client.name, "idle", h.idleTimeout)
+		// the notice goes out before the close frame, unless the send buffer is full
+		if notice := getIdleTemplate(&Idle{After: h.idleTimeout}); notice != nil {
+			deliverNotice(client, notice)
+		}
+		// a normal closure, so the page doesn't reconnect on its own
+		h.remove(client, websocket.CloseNormalClosure, "disconnected due to inactivity")
+	}
+}
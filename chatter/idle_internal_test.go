@@ -0,0 +1,83 @@
+package chatter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitWritten waits for a message holding substring to be written to the connection
+func waitWritten(t *testing.T, conn *fakeConn, substring string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		for _, message := range conn.Messages() {
+			if strings.Contains(message, substring) {
+				return
+			}
+		}
+		select {
+		case <-conn.wrote:
+		case <-timeout:
+			t.Fatalf("%q was never written, the messages are %q", substring, conn.Messages())
+		}
+	}
+}
+
+// waitClosed waits for the connection to be closed
+func waitClosed(t *testing.T, conn *fakeConn) {
+	t.Helper()
+	select {
+	case <-conn.gone:
+	case <-time.After(time.Second):
+		t.Fatal("the connection is still open")
+	}
+}
+
+func TestIdleClientsAreDisconnected(t *testing.T) {
+	clock := newFakeClock()
+	hub := runHub(t, NewHub(WithIdleTimeout(time.Hour), WithClock(clock)))
+	alice, bob := newFakeConn(), newFakeConn()
+	startClient(t, hub, alice, "alice")
+	startClient(t, hub, bob, "bob")
+
+	// bob says something after 50 minutes, alice never does
+	clock.Advance(50 * time.Minute)
+	bob.incoming <- []byte(`{"text":"still here"}`)
+	waitWritten(t, alice, "still here")
+
+	// an hour in, alice is told why and closed normally, so the page doesn't reconnect
+	clock.Advance(10 * time.Minute)
+	waitWritten(t, alice, "disconnected for inactivity")
+	waitClosed(t, alice)
+	if code, _, ok := alice.CloseFrame(); !ok || code != websocket.CloseNormalClosure {
+		t.Errorf("alice was closed with %d (close frame %t), want %d", code, ok, websocket.CloseNormalClosure)
+	}
+	if bob.Closed() {
+		t.Fatal("bob was disconnected 10 minutes after saying something")
+	}
+
+	// and bob an hour after saying something
+	clock.Advance(50 * time.Minute)
+	waitWritten(t, bob, "disconnected for inactivity")
+	waitClosed(t, bob)
+}
+
+func TestClientsAreNeverIdleByDefault(t *testing.T) {
+	clock := newFakeClock()
+	hub := runHub(t, NewHub(WithClock(clock)))
+	alice := newFakeConn()
+	startClient(t, hub, alice, "alice")
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(24 * time.Hour)
+	}
+	// the sweeps are done by the time a message sent after them is broadcast
+	hub.Publish(&Message{Kind: KindChat, Username: "bot", Text: "anyone there?"}, time.Second)
+	waitWritten(t, alice, "anyone there?")
+	if alice.Closed() {
+		t.Error("alice was disconnected without an idle timeout")
+	}
+}
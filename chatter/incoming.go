@@ -0,0 +1,217 @@
+package chatter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// HookTokens are the tokens of the incoming webhooks, each one posts into a single room
+type HookTokens struct {
+	sync.RWMutex
+	rooms map[string]string // room of each token
+}
+
+// NewHookTokens creates an empty set of tokens
+func NewHookTokens() *HookTokens {
+	return &HookTokens{rooms: make(map[string]string)}
+}
+
+// Create generates a new token posting into the room
+func (t *HookTokens) Create(room string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	t.Lock()
+	defer t.Unlock()
+	t.rooms[token] = room
+	return token, nil
+}
+
+// Revoke removes the token, it returns false if there was no such token
+func (t *HookTokens) Revoke(token string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.rooms[token]; !ok {
+		return false
+	}
+	delete(t.rooms, token)
+	return true
+}
+
+// Room returns the room the token posts into
+func (t *HookTokens) Room(token string) (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	room, ok := t.rooms[token]
+	return room, ok
+}
+
+// IncomingPayload is the payload of a Slack style incoming webhook, the fields we don't know are ignored
+type IncomingPayload struct {
+	Text     string `json:"text"`
+	Username string `json:"username"`
+}
+
+// IncomingHandler handles POST /hooks/{token} with the payload Slack incoming webhooks take,
+// so tools set up to post to Slack can post into a room unchanged
+func IncomingHandler(manager *HubManager, tokens *HookTokens) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveIncoming(manager, tokens, w, r)
+	})
+}
+
+// serveIncoming posts the payload into the room of the token
+func serveIncoming(manager *HubManager, tokens *HookTokens, w http.ResponseWriter, r *http.Request) {
+
+	// unknown (or revoked) tokens look like there is nothing here
+	room, ok := tokens.Room(r.PathValue("token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	hub, err := manager.Get(room)
+	if err != nil {
+		serveRoomUnavailable(w, err)
+		return
+	}
+
+	// we don't read more than a websocket message could be
+	r.Body = http.MaxBytesReader(w, r.Body, hub.config.MaxMessageSize)
+
+	payload, err := decodeIncoming(r)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(slackLinks(payload.Text))
+	if text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	from := sanitizeName(payload.Username)
+	if from == "" {
+		from = defaultBotName
+	}
+
+	if _, err := hub.Publish(&Message{
+		Kind:     KindChat,
+		ClientID: "hook:" + from,
+		Username: from,
+		Text:     text,
+	}, publishTimeout); err != nil {
+		slog.Error("posting incoming webhook", "err", err)
+		http.Error(w, "Could not post the message", http.StatusServiceUnavailable)
+		return
+	}
+
+	// this is what Slack answers
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// decodeIncoming reads the payload, posted as JSON or as a form with a JSON payload field
+// (Slack takes both, and plenty of tools post JSON without setting the content type)
+func decodeIncoming(r *http.Request) (*IncomingPayload, error) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// anything that doesn't look like JSON has to be a form
+	if body = bytes.TrimSpace(body); !bytes.HasPrefix(body, []byte("{")) {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		if body = []byte(form.Get("payload")); len(body) == 0 {
+			return nil, errors.New("missing payload")
+		}
+	}
+
+	payload := &IncomingPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// slackLink matches the links of Slack formatting: <https://example.com|label> or <https://example.com>
+var slackLink = regexp.MustCompile(`<(https?://[^|>\s]+)(?:\|([^>]*))?>`)
+
+// slackLinks turns the Slack links into markdown links, which end up as anchors
+// once the message is rendered (and sanitized) like any other
+func slackLinks(text string) string {
+	return slackLink.ReplaceAllStringFunc(text, func(link string) string {
+		parts := slackLink.FindStringSubmatch(link)
+		href, label := parts[1], parts[2]
+		if label == "" {
+			label = href
+		}
+		// the label can't close the link early
+		label = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(label)
+		return "[" + label + "](" + href + ")"
+	})
+}
+
+// HookTokensHandler lets whoever knows the secret manage the incoming webhook tokens:
+// POST /rooms/{room}/hooks creates a token for the room and DELETE /hooks/{token} revokes one
+func HookTokensHandler(tokens *HookTokens, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// managing tokens always takes the secret, without one it is disabled
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			// a token only ever posts to a room the name of which checks out
+			room := r.PathValue("room")
+			if err := ValidateRoomName(room); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			token, err := tokens.Create(room)
+			if err != nil {
+				slog.Error("creating hook token", "err", err)
+				http.Error(w, "Could not create the token", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(struct {
+				Token string `json:"token"`
+				URL   string `json:"url"`
+			}{token, "/hooks/" + token})
+
+		case http.MethodDelete:
+			if !tokens.Revoke(r.PathValue("token")) {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package chatter_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// waitTimeout is how long the integration tests wait for a frame
+const waitTimeout = 5 * time.Second
+
+func TestChatDeliversMessages(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+
+	alice.Send("hello bob")
+	bob.Expect("hello bob", waitTimeout)
+	// the sender gets its message back too (the hub echoes by default)
+	alice.Expect("hello bob", waitTimeout)
+
+	bob.Send("hi alice")
+	alice.Expect("hi alice", waitTimeout)
+}
+
+func TestChatForgetsDisconnectedClients(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	alice.Expect("Online (2)", waitTimeout)
+
+	bob.Close()
+	alice.Expect("Online (1)", waitTimeout)
+
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients := hub.Stats().Clients; clients != 1 {
+		t.Errorf("the room has %d clients, want 1", clients)
+	}
+
+	// the room goes on without bob
+	alice.Send("anyone there?")
+	alice.Expect("anyone there?", waitTimeout)
+}
+
+func TestChatReplaysHistory(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	for _, text := range []string{"first", "second", "third"} {
+		alice.Send(text)
+		alice.Expect(text, waitTimeout)
+	}
+
+	// a client joining later gets the history first, oldest first, in a single batch
+	bob := srv.Connect(t, "bob")
+	replay := bob.Expect("third", waitTimeout)
+	first, second := strings.Index(replay, "first"), strings.Index(replay, "second")
+	if first < 0 || second < 0 || first > second || second > strings.Index(replay, "third") {
+		t.Errorf("the history isn't replayed in order:\n%s", replay)
+	}
+}
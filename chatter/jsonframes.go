@@ -0,0 +1,70 @@
+package chatter
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// JSONProtocol is the websocket subprotocol of the clients that want the messages as JSON
+// (bots, command line clients, apps) rather than as the HTML fragments the pages swap in.
+// They get a JSONMessage per line, and only the messages: none of the widgets of the page
+// (presence, typing, reactions, pins, banners, ...)
+const JSONProtocol = "chatter.json"
+
+// JSONMessage is a message the way the JSON clients get it. Kind is the kind of the message
+// (chat, system, action, announcement, dm), edit for a message replacing the one with
+// the same id, deleted for what is left of a deleted one and error for a notice of the
+// hub meant only for the client (with no id and no sender)
+type JSONMessage struct {
+	ID   uint64    `json:"id"`
+	From string    `json:"from"`
+	Text string    `json:"text"`
+	TS   time.Time `json:"ts"`
+	Kind string    `json:"kind"`
+}
+
+// encodeJSON returns the message encoded for the JSON clients, as kind if it isn't empty.
+// It returns nil if the message could not be encoded
+func encodeJSON(msg *Message, kind string) []byte {
+	if kind == "" {
+		kind = messageKind(msg, "", false)
+	}
+	data, err := json.Marshal(&JSONMessage{ID: msg.ID, From: msg.Username, Text: msg.Text, TS: msg.Timestamp, Kind: kind})
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// jsonFrame returns the frame of the message for the JSON clients (see encodeJSON)
+func jsonFrame(msg *Message, kind string) Frame {
+	return Frame{ID: msg.ID, JSON: true, Data: encodeJSON(msg, kind)}
+}
+
+// takes tells whether the client gets the frame: the JSON clients only get the JSON frames,
+// everyone else only the fragments
+func (c *Client) takes(frame Frame) bool {
+	return c.jsonFrames == frame.JSON
+}
+
+// withProtocol adds the subprotocol we pick among the ones the client offered to the header
+// of the handshake. A client offering both wants JSON and sends its token (see bearerToken),
+// so JSONProtocol goes first
+func withProtocol(header http.Header, r *http.Request) http.Header {
+	offered := websocket.Subprotocols(r)
+	for _, protocol := range []string{JSONProtocol, bearerProtocol} {
+		if !slices.Contains(offered, protocol) {
+			continue
+		}
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Sec-WebSocket-Protocol", protocol)
+		break
+	}
+	return header
+}
@@ -0,0 +1,95 @@
+package chatter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// expectJSON waits for a JSON message with the text, every line of the frames that came
+// until then has to be a JSON message
+func expectJSON(t *testing.T, c *chattertest.Client, text string) chatter.JSONMessage {
+	t.Helper()
+	for {
+		frame := c.Next(waitTimeout)
+		var found *chatter.JSONMessage
+		for _, line := range strings.Split(frame, "\n") {
+			var msg chatter.JSONMessage
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("the JSON client got a line that isn't JSON: %q", line)
+			}
+			if msg.Text == text {
+				found = &msg
+			}
+		}
+		if found != nil {
+			return *found
+		}
+	}
+}
+
+func TestJSONAndHTMLClientsGetTheSameMessages(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bot, resp, err := srv.Dial(t, "/ws?name=bot", http.Header{"Sec-WebSocket-Protocol": {chatter.JSONProtocol}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != chatter.JSONProtocol {
+		t.Fatalf("the server accepted the subprotocol %q", protocol)
+	}
+	alice.Expect("Online (2)", waitTimeout)
+
+	// the page's message, as a fragment (escaped) and as JSON (as it was typed)
+	alice.Send("fish & chips")
+	msg := expectJSON(t, bot, "fish & chips")
+	if msg.ID == 0 || msg.From != "alice" || msg.Kind != chatter.KindChat || msg.TS.IsZero() {
+		t.Errorf("the bot got %+v", msg)
+	}
+	fragment := alice.Expect("fish &amp; chips", waitTimeout)
+	if !strings.Contains(fragment, fmt.Sprintf(`id="msg-%d"`, msg.ID)) {
+		t.Errorf("the page got another message than the bot's %d:\n%s", msg.ID, fragment)
+	}
+
+	// and the other way round, the bot sends what the form does
+	bot.Send("beep")
+	mine := expectJSON(t, bot, "beep")
+	fragment = alice.Expect("beep", waitTimeout)
+	if mine.From != "bot" || !strings.Contains(fragment, fmt.Sprintf(`id="msg-%d"`, mine.ID)) {
+		t.Errorf("the bot's message %+v reached the page as:\n%s", mine, fragment)
+	}
+
+	// the notices meant only for the client are errors without an id
+	bot.Send("/nosuchcommand")
+	for {
+		notice := chatter.JSONMessage{}
+		frame := bot.Next(waitTimeout)
+		if err := json.Unmarshal([]byte(frame), &notice); err != nil {
+			t.Fatalf("the JSON client got %q", frame)
+		}
+		if notice.Kind == chatter.KindError {
+			if notice.ID != 0 || notice.From != "" || notice.Text == "" {
+				t.Errorf("the notice is %+v", notice)
+			}
+			break
+		}
+	}
+}
+
+func TestClientsAskingForOtherProtocolsGetFragments(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	page, resp, err := srv.Dial(t, "/ws?name=page", http.Header{"Sec-WebSocket-Protocol": {"chatter.xml"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		t.Errorf("the server accepted the subprotocol %q", protocol)
+	}
+	page.Send("hello")
+	page.Expect(`<li id="msg-`, waitTimeout)
+}
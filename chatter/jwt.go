@@ -0,0 +1,281 @@
+package chatter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// bearerProtocol is the websocket subprotocol a browser offers along with its token
+	// (new WebSocket(url, ["bearer", token])), the token itself is never selected
+	bearerProtocol = "bearer"
+	// jwksRefresh is how often we fetch the keys again, a token signed with a key we don't
+	// know yet makes us fetch them sooner (but not more than once every jwksRetry)
+	jwksRefresh = time.Hour
+	jwksRetry   = time.Minute
+)
+
+// JWTAuth lets the websocket connections authenticate with a JWT issued by another app,
+// sent as the ?token= query param or as a websocket subprotocol. The token is signed with
+// a shared secret (HS256) or a key of a JWKS, its sub is the identity of the client and its
+// name (or preferred_username) the name it goes by
+type JWTAuth struct {
+	secret   []byte        // shared secret of the HMAC signed tokens (nil if none)
+	keys     *jwks         // keys of the tokens signed by the issuer (nil if none)
+	audience string        // audience the tokens must be for (empty accepts any)
+	grace    time.Duration // how long a client stays connected once its token expired (zero keeps it connected)
+}
+
+// NewJWTAuth creates the JWT authentication of the tokens signed with the secret or with the
+// keys of the JWKS served at jwksURL. With a grace period the clients are disconnected once
+// their token expired for that long
+func NewJWTAuth(secret, jwksURL, audience string, grace time.Duration) (*JWTAuth, error) {
+	if secret == "" && jwksURL == "" {
+		return nil, errors.New("verifying tokens needs a secret or a JWKS url")
+	}
+
+	a := &JWTAuth{audience: audience, grace: grace}
+	if secret != "" {
+		a.secret = []byte(secret)
+	}
+	if jwksURL != "" {
+		a.keys = &jwks{url: jwksURL, client: &http.Client{Timeout: 10 * time.Second}}
+		if err := a.keys.fetch(); err != nil {
+			return nil, fmt.Errorf("fetching the keys: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// jwtClaims are the claims of the tokens we look at
+type jwtClaims struct {
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// Require only lets the requests with a valid token through, the others get a 401 before
+// the upgrade. Nil JWT authentication lets everyone through
+func (a *JWTAuth) Require(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			http.Error(w, "token required", http.StatusUnauthorized)
+			return
+		}
+		p, err := a.verify(token)
+		if err != nil {
+			slog.Info("websocket connection rejected: invalid token", "remote_ip", clientIP(r), "err", err)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withPrincipal(r, p))
+	})
+}
+
+// verify checks the signature, expiry and audience of the token and returns who it is for
+func (a *JWTAuth) verify(token string) (principal, error) {
+
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithValidMethods(a.methods())}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	var claims jwtClaims
+	if _, err := jwt.ParseWithClaims(token, &claims, a.key, opts...); err != nil {
+		return principal{}, err
+	}
+	if claims.Subject == "" {
+		return principal{}, errors.New("token has no subject")
+	}
+
+	// the name is shown in the room, so it gets the same treatment as the ones picked by hand
+	name := sanitizeName(claims.Name)
+	if name == "" {
+		name = sanitizeName(claims.PreferredUsername)
+	}
+	if name == "" {
+		name = sanitizeName(claims.Subject)
+	}
+
+	p := principal{name: name, identity: "jwt:" + claims.Subject}
+	if a.grace > 0 {
+		p.expires = claims.ExpiresAt.Add(a.grace)
+	}
+	return p, nil
+}
+
+// methods returns the signing methods we accept, the ones of the keys we have
+func (a *JWTAuth) methods() []string {
+	var methods []string
+	if a.secret != nil {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if a.keys != nil {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+	return methods
+}
+
+// key returns the key the token was signed with
+func (a *JWTAuth) key(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	return a.keys.key(kid)
+}
+
+// bearerToken returns the token of the request: the ?token= query param, or the subprotocol
+// offered after "bearer" (browsers can't set the Authorization header of a websocket)
+func bearerToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == bearerProtocol {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+	return ""
+}
+
+// jwks are the public keys of an issuer, fetched from its JWKS url
+type jwks struct {
+	sync.Mutex
+	url     string
+	client  *http.Client
+	keys    map[string]any // keys by id
+	fetched time.Time      // last time we fetched the keys
+}
+
+// key returns the key with the id, fetching the keys again if they're old or the id is new to us
+func (k *jwks) key(kid string) (any, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	key, ok := k.keys[kid]
+	since := time.Since(k.fetched)
+	if (!ok && since > jwksRetry) || since > jwksRefresh {
+		if err := k.fetchLocked(); err != nil {
+			slog.Error("fetching the keys", "url", k.url, "err", err)
+		}
+		key, ok = k.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch fetches the keys
+func (k *jwks) fetch() error {
+	k.Lock()
+	defer k.Unlock()
+	return k.fetchLocked()
+}
+
+// fetchLocked fetches the keys, with the lock held. Keys we can't use are skipped
+func (k *jwks) fetchLocked() error {
+	k.fetched = time.Now()
+
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	k.keys = keys
+	return nil
+}
+
+// jsonWebKey is a public key of a JWKS (RSA or elliptic curve)
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key
+func (jwk jsonWebKey) publicKey() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unknown key type %q", jwk.Kty)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,140 @@
+package chatter
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testSecret is the secret the HMAC signed tokens of the tests are signed with
+const testSecret = "jwt-s3cret"
+
+// claims returns the claims of a token for alice of the chat, valid for d
+func claims(d time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{"sub": "u42", "name": "alice", "aud": "chat", "exp": time.Now().Add(d).Unix()}
+}
+
+// hmacToken signs the claims with the secret
+func hmacToken(t *testing.T, secret string, c jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authenticate sends the request through the JWT authentication and returns the status
+// and who the request was authenticated as
+func authenticate(auth *JWTAuth, req *http.Request) (int, principal) {
+	var p principal
+	handler := auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ = requestPrincipal(r)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, p
+}
+
+func TestJWTAuth(t *testing.T) {
+	auth, err := NewJWTAuth(testSecret, "", "chat", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noSubject := claims(time.Hour)
+	delete(noSubject, "sub")
+	wrongAudience := claims(time.Hour)
+	wrongAudience["aud"] = "another app"
+	noExpiry := claims(time.Hour)
+	delete(noExpiry, "exp")
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims(time.Hour)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"valid", hmacToken(t, testSecret, claims(time.Hour)), http.StatusOK},
+		{"expired", hmacToken(t, testSecret, claims(-time.Minute)), http.StatusUnauthorized},
+		{"no expiry", hmacToken(t, testSecret, noExpiry), http.StatusUnauthorized},
+		{"wrong audience", hmacToken(t, testSecret, wrongAudience), http.StatusUnauthorized},
+		{"no subject", hmacToken(t, testSecret, noSubject), http.StatusUnauthorized},
+		{"another secret", hmacToken(t, "guess", claims(time.Hour)), http.StatusUnauthorized},
+		{"unsigned", unsigned, http.StatusUnauthorized},
+		{"malformed", "not.a.token", http.StatusUnauthorized},
+		{"garbage", "garbage", http.StatusUnauthorized},
+		{"none", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, p := authenticate(auth, httptest.NewRequest(http.MethodGet, "/ws?token="+tt.token, nil))
+			if code != tt.want {
+				t.Fatalf("the request got %d, want %d", code, tt.want)
+			}
+			if code == http.StatusOK && (p.name != "alice" || p.identity != "jwt:u42" || p.expires.IsZero()) {
+				t.Errorf("the request was authenticated as %+v", p)
+			}
+		})
+	}
+
+	// browsers send the token as a subprotocol
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", "bearer, "+hmacToken(t, testSecret, claims(time.Hour)))
+	if code, _ := authenticate(auth, req); code != http.StatusOK {
+		t.Errorf("the token of the subprotocol got %d", code)
+	}
+}
+
+func TestJWTAuthWithTheKeysOfAnIssuer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer issuer.Close()
+	auth, err := NewJWTAuth("", issuer.URL, "chat", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(kid string, c jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	for name, tt := range map[string]struct {
+		token string
+		want  int
+	}{
+		"valid":         {sign("test", claims(time.Hour)), http.StatusOK},
+		"expired":       {sign("test", claims(-time.Minute)), http.StatusUnauthorized},
+		"unknown key":   {sign("other", claims(time.Hour)), http.StatusUnauthorized},
+		"shared secret": {hmacToken(t, testSecret, claims(time.Hour)), http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if code, _ := authenticate(auth, httptest.NewRequest(http.MethodGet, "/ws?token="+tt.token, nil)); code != tt.want {
+				t.Errorf("the request got %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package chatter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// The features signing with the same secret each get a key of their own, derived from it,
+// and label what they sign with their purpose: a value signed for one of them never verifies
+// for another (e.g. a value the visitor picked, signed for them as a CSRF token, as a session)
+const (
+	purposeIdentity = "identity"
+	purposeSession  = "session"
+	purposeCSRF     = "csrf"
+	purposeAdmin    = "admin"
+)
+
+// deriveKey returns the key of the purpose derived from the secret
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// macOf returns the MAC of the data with the key, labelled with the purpose
+func macOf(key []byte, purpose, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
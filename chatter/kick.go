@@ -0,0 +1,146 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNoSuchClient is returned when kicking a client that isn't in the hub
+var ErrNoSuchClient = errors.New("no such client")
+
+// kickRequest asks the hub to disconnect a client, the result is sent on done
+type kickRequest struct {
+	target   string // id or name of the client
+	ip       string // IP address of the clients, set instead of target
+	reason   string // sent to the client in the close frame
+	announce bool   // whether the room is told
+	done     chan error
+}
+
+// Kick disconnects the client with the id (or name) target, sending it a close frame
+// with the reason. If announce is set the room gets a system message about it.
+// The kick is recorded in the audit log as taken by actor, whether it worked or not
+func (h *Hub) Kick(actor, target, reason string, announce bool) error {
+	err := h.requestKick(&kickRequest{target: target, reason: reason, announce: announce, done: make(chan error, 1)})
+	h.audit.record(actor, AuditKick, h.room, target, reason, err)
+	return err
+}
+
+// requestKick hands the kick to the hub goroutine and waits for it
+func (h *Hub) requestKick(req *kickRequest) error {
+	select {
+	case h.kick <- req:
+	case <-h.stop:
+		return errors.New("hub is shut down")
+	}
+	return <-req.done
+}
+
+// KickIP disconnects every client connected from the IP address
+func (h *Hub) KickIP(ip, reason string) error {
+	return h.requestKick(&kickRequest{ip: normalizeIP(ip), reason: reason, done: make(chan error, 1)})
+}
+
+// KickIP disconnects every client connected from the IP address, in every room
+func (m *HubManager) KickIP(ip, reason string) {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	for _, hub := range hubs {
+		hub.KickIP(ip, reason)
+	}
+}
+
+// kickClient handles a kick request on the hub goroutine
+func (h *Hub) kickClient(req *kickRequest) error {
+
+	// kicking by address kicks every client connected from it
+	if req.ip != "" {
+		for client := range h.clients {
+			if client.ip == req.ip {
+				h.remove(client, websocket.ClosePolicyViolation, req.reason)
+			}
+		}
+		return nil
+	}
+
+	// we look the client up by id first, names can change
+	client, ok := h.ids[req.target]
+	if !ok {
+		client, ok = h.names[req.target]
+	}
+	if !ok {
+		return ErrNoSuchClient
+	}
+
+	// the close frame carries the reason, its pumps return once it is sent
+	h.remove(client, websocket.ClosePolicyViolation, req.reason)
+
+	if req.announce {
+		text := fmt.Sprintf("%s was kicked", client.name)
+		if req.reason != "" {
+			text += " (" + req.reason + ")"
+		}
+		h.broadcastMessage(&Message{Kind: KindSystem, Text: text})
+	}
+	return nil
+}
+
+// KickRequest is what POST /admin/kick takes, as JSON or form data
+type KickRequest struct {
+	Room     string `json:"room"`     // room of the client (the default room when empty)
+	Client   string `json:"client"`   // id or name of the client
+	Reason   string `json:"reason"`   // sent to the client
+	Announce bool   `json:"announce"` // whether the room is told
+}
+
+// KickHandler handles POST /admin/kick, it should be wrapped in AdminAuth
+func KickHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		req := &KickRequest{}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Room = r.FormValue("room")
+			req.Client = r.FormValue("client")
+			req.Reason = r.FormValue("reason")
+			req.Announce = r.FormValue("announce") == "true" || r.FormValue("announce") == "1"
+		}
+
+		if req.Client = strings.TrimSpace(req.Client); req.Client == "" {
+			http.Error(w, "client is required", http.StatusBadRequest)
+			return
+		}
+		if req.Room = strings.TrimSpace(req.Room); req.Room == "" {
+			req.Room = DefaultRoom
+		}
+
+		hub, err := manager.Get(req.Room)
+		if err != nil {
+			serveRoomUnavailable(w, err)
+			return
+		}
+
+		switch err := hub.Kick(auditActor(r), req.Client, req.Reason, req.Announce); {
+		case errors.Is(err, ErrNoSuchClient):
+			http.Error(w, "no such client", http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
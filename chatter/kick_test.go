@@ -0,0 +1,51 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/gorilla/websocket"
+)
+
+// kick posts the kick request to the kick endpoint of the server with the admin token
+func kick(srv *chattertest.Server, token, body string) int {
+	handler := chatter.AdminAuth("admin-token", chatter.KickHandler(srv.Manager))
+	req := httptest.NewRequest(http.MethodPost, "/admin/kick", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(chatter.AdminTokenHeader, token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestKickedClientsGetTheReason(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	bob.Expect("Online (2)", waitTimeout)
+
+	if code := kick(srv, "guess", `{"client":"alice"}`); code != http.StatusUnauthorized {
+		t.Errorf("kicking without the admin token got %d", code)
+	}
+	if code := kick(srv, "admin-token", `{"client":"nobody"}`); code != http.StatusNotFound {
+		t.Errorf("kicking an unknown client got %d", code)
+	}
+	if code := kick(srv, "admin-token", `{"client":"alice","reason":"spamming","announce":true}`); code != http.StatusNoContent {
+		t.Fatalf("kicking alice got %d", code)
+	}
+
+	// the victim gets the reason in its close frame
+	closed := alice.ExpectClosed(waitTimeout)
+	if closed == nil || closed.Code != websocket.ClosePolicyViolation || closed.Text != "spamming" {
+		t.Errorf("alice was closed with %v, want %d spamming", closed, websocket.ClosePolicyViolation)
+	}
+
+	// the others are told and carry on
+	bob.Expect("alice was kicked (spamming)", waitTimeout)
+	bob.Send("good riddance")
+	bob.Expect("good riddance", waitTimeout)
+}
@@ -0,0 +1,185 @@
+package chatter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultRoom is the room clients end up in when they don't ask for one
+const DefaultRoom = "general"
+
+// ErrClosed is returned when asking for a room once the manager has been closed
+var ErrClosed = errors.New("chat is shutting down")
+
+// HubManager owns a hub per named room
+type HubManager struct {
+	sync.Mutex
+	hubs    map[string]*Hub // hubs by room name
+	opts    []Option        // options applied to every new hub
+	roomTTL time.Duration   // how long a room can stay empty before it is removed
+	stop    chan struct{}   // closed when the manager is shut down
+	closed  bool            // whether the manager has been shut down
+
+	// the private rooms stay empty for privateTTL, and there are so many of them (see CreatePrivate)
+	privateTTL         time.Duration
+	maxPrivate         int
+	maxPrivatePerOwner int
+
+	drainUntil time.Time     // deadline of the drain (zero unless draining, see Drain)
+	drained    chan struct{} // closed once the server drained
+}
+
+// NewHubManager creates a new hub manager, empty rooms are removed after roomTTL
+func NewHubManager(roomTTL time.Duration, opts ...Option) *HubManager {
+	return &HubManager{
+		hubs:    make(map[string]*Hub),
+		opts:    opts,
+		roomTTL: roomTTL,
+		stop:    make(chan struct{}),
+		drained: make(chan struct{}),
+
+		privateTTL:         privateRoomTTL,
+		maxPrivate:         maxPrivateRooms,
+		maxPrivatePerOwner: maxPrivatePerOwner,
+	}
+}
+
+// Get returns the hub for the room, creating and starting it if needed. The rooms are
+// created on demand, so the name has to be one ValidateRoomName accepts (the error wraps
+// ErrInvalidRoom). It returns ErrClosed once the manager has been closed
+func (m *HubManager) Get(room string) (*Hub, error) {
+	if err := ValidateRoomName(room); err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	// we don't open new rooms while shutting down
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	// if the room already exists we return its hub
+	if hub, ok := m.hubs[room]; ok {
+		return hub, nil
+	}
+
+	// otherwise we create a new hub for the room and start it
+	return m.start(room), nil
+}
+
+// start creates and starts the hub of the room with the options of the manager and then
+// opts, the manager has to be locked
+func (m *HubManager) start(room string, opts ...Option) *Hub {
+	// (the hubs are stopped by collect and Close, not by a context)
+	hub := NewHub(slices.Concat([]Option{WithRoom(room)}, m.opts, opts)...)
+	m.hubs[room] = hub
+	go hub.Run(context.Background())
+
+	hub.logger.Info("room created")
+
+	return hub
+}
+
+// lookup returns the hub of the room, nil if it isn't open (unlike Get it doesn't open it)
+func (m *HubManager) lookup(room string) *Hub {
+	m.Lock()
+	defer m.Unlock()
+	return m.hubs[room]
+}
+
+// Run periodically removes the rooms that have been empty for longer than roomTTL.
+// When ctx is cancelled it closes every room, waiting up to timeout for their clients
+// to disconnect, and returns
+func (m *HubManager) Run(ctx context.Context, timeout time.Duration) error {
+
+	// we check a few times per TTL so rooms don't linger for much longer than that
+	ticker := time.NewTicker(m.roomTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.collect()
+		case <-ctx.Done():
+			return m.Close(timeout)
+		case <-m.stop:
+			return nil
+		}
+	}
+}
+
+// Close shuts down every room, waiting up to timeout for their clients to disconnect
+func (m *HubManager) Close(timeout time.Duration) error {
+	m.Lock()
+
+	// closing twice is a no-op
+	if m.closed {
+		m.Unlock()
+		return nil
+	}
+	m.closed = true
+
+	// we stop collecting rooms, and take the hubs out so nobody can get them anymore:
+	// we don't hold the lock while waiting for them, lookups would block until the timeout
+	close(m.stop)
+	hubs := make(map[string]*Hub, len(m.hubs))
+	for room, hub := range m.hubs {
+		hubs[room] = hub
+	}
+	clear(m.hubs)
+	m.Unlock()
+
+	// and close every hub at the same time, so the timeout covers all of them
+	var wg sync.WaitGroup
+	errs := make(chan error, len(hubs))
+	for room, hub := range hubs {
+		wg.Add(1)
+		go func(room string, hub *Hub) {
+			defer wg.Done()
+			if err := hub.Close(timeout); err != nil {
+				errs <- fmt.Errorf("room %s: %w", room, err)
+			}
+		}(room, hub)
+	}
+	wg.Wait()
+	close(errs)
+
+	// we report the first error (if any)
+	return <-errs
+}
+
+// collect removes and stops the hubs of rooms that have been idle for too long
+func (m *HubManager) collect() {
+	m.Lock()
+	defer m.Unlock()
+
+	for room, hub := range m.hubs {
+		// the private rooms stay longer, their invites and members go with them and
+		// their name is anyone's again
+		ttl := m.roomTTL
+		if hub.access != nil {
+			ttl = m.privateTTL
+		}
+		since, idle := hub.idleSince()
+		if !idle || hub.clock.Now().Sub(since) < ttl {
+			continue
+		}
+
+		// we remove the room first so nobody can get the hub anymore,
+		// and then stop it (anyone still trying to join will see it stopped)
+		delete(m.hubs, room)
+		hub.stopOnce.Do(func() { close(hub.stop) })
+		hub.metrics.forgetRoom(room)
+		if hub.access != nil {
+			hub.access.revokeAll()
+		}
+
+		hub.logger.Info("room removed")
+	}
+}
@@ -0,0 +1,86 @@
+package chatter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManagerCollectsIdleRooms(t *testing.T) {
+	clock := newFakeClock()
+	manager := NewHubManager(time.Hour, WithClock(clock))
+	defer manager.Close(time.Second)
+
+	idle, err := manager.Get("idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := manager.Get("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a client is about to join the busy room, it stays
+	busy.reserve(false)
+
+	// the rooms go by the clock of their hub
+	clock.Advance(time.Hour - time.Second)
+	manager.collect()
+	if manager.lookup("idle") != idle {
+		t.Fatal("the idle room was collected before it expired")
+	}
+	clock.Advance(time.Second)
+	manager.collect()
+
+	if manager.lookup("idle") != nil {
+		t.Error("the idle room wasn't collected")
+	}
+	select {
+	case <-idle.done:
+	case <-time.After(time.Second):
+		t.Error("the hub of the idle room is still running")
+	}
+	if manager.lookup("busy") != busy {
+		t.Error("the busy room was collected")
+	}
+
+	// asking for the room again opens a new one
+	again, err := manager.Get("idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == idle {
+		t.Error("the collected hub was handed out again")
+	}
+}
+
+func TestManagerAnswersWhileClosing(t *testing.T) {
+	manager := NewHubManager(time.Hour)
+	hub, err := manager.Get("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pumps of alice don't run, closing waits for her until we let her go
+	alice := joinHub(t, hub, newFakeConn(), "alice")
+	closed := make(chan error, 1)
+	go func() { closed <- manager.Close(time.Minute) }()
+	<-hub.done
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := manager.Get("lobby")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("asking for a room while closing returned %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("asking for a room waited for the rooms to close")
+	}
+
+	close(alice.done)
+	if err := <-closed; err != nil {
+		t.Errorf("closing the manager: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package chatter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+func TestMessagesStayInTheirRoom(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.ConnectRoom(t, "lobby", "alice")
+	bob := srv.ConnectRoom(t, "lobby", "bob")
+	carol := srv.ConnectRoom(t, "kitchen", "carol")
+	dave := srv.ConnectRoom(t, "kitchen", "dave")
+
+	alice.Send("hello lobby")
+	carol.Send("hello kitchen")
+	bob.Expect("hello lobby", waitTimeout)
+	dave.Expect("hello kitchen", waitTimeout)
+
+	// after a message of each room, each room got its own only
+	alice.Send("bye lobby")
+	carol.Send("bye kitchen")
+	bob.Expect("bye lobby", waitTimeout)
+	bob.ExpectNone("kitchen", 100*time.Millisecond)
+	dave.Expect("bye kitchen", waitTimeout)
+	dave.ExpectNone("lobby", 100*time.Millisecond)
+
+	assertHistory(t, srv, "lobby", "hello lobby", "bye lobby")
+	assertHistory(t, srv, "kitchen", "hello kitchen", "bye kitchen")
+}
@@ -0,0 +1,95 @@
+package chatter
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown renders message text, goldmark never passes raw HTML through
+// (it is replaced with a comment) but we still sanitize everything it produces.
+// Mentions of the names the hub knows are highlighted
+var markdown = goldmark.New(
+	goldmark.WithParserOptions(parser.WithInlineParsers(util.Prioritized(mentionParser{}, 500))),
+	goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(mentionRenderer{}, 500))),
+)
+
+// markdownPolicy is the safe subset of HTML a message may contain:
+// bold, italics, inline code, code blocks, links and mentions
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "code", "pre")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("span")
+	p.AllowStandardURLs()
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// renderMarkdown renders the text as markdown and sanitizes the result,
+// only the sanitized HTML is ever trusted by the templates.
+// The names the text mentions are collected in scan
+func renderMarkdown(text string, scan *mentionScan) template.HTML {
+
+	pc := parser.NewContext()
+	pc.Set(mentionsKey, scan)
+
+	var rendered bytes.Buffer
+	if err := markdown.Convert([]byte(text), &rendered, parser.WithContext(pc)); err != nil {
+		// if the text can't be rendered we show it as plain (escaped) text
+		slog.Warn("rendering markdown", "err", err)
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	// this is the only place we turn a string into template.HTML, after sanitizing it
+	return template.HTML(markdownPolicy.SanitizeBytes(rendered.Bytes()))
+}
+
+// format renders the markdown of a new message if the hub has markdown enabled
+// (or just its mentions if it hasn't) and notes who it mentions,
+// it must only be called before the message is shared (stored or broadcast)
+func (h *Hub) format(msg *Message) {
+	msg.HTML, msg.Mentions = h.render(msg.Text)
+}
+
+// render renders the text the way the hub is set up to, and returns the names it mentions
+func (h *Hub) render(text string) (template.HTML, []string) {
+	scan := h.mentionables()
+	if h.markdown {
+		return renderMarkdown(text, scan), scan.mentioned
+	}
+
+	// without markdown we only need the HTML for the mentions
+	rendered := plainMentions(text, scan)
+	if len(scan.mentioned) == 0 {
+		return "", nil
+	}
+	return rendered, scan.mentioned
+}
+
+// formatted returns the message ready to be rendered: messages read back from a database
+// haven't had their markdown rendered yet, and replies need the quote of their parent
+// (which may have changed since), so we render a copy (messages in the store may be read concurrently)
+func (h *Hub) formatted(msg *Message) *Message {
+	render := h.markdown && msg.HTML == ""
+	if !render && msg.ReplyTo == 0 {
+		return msg
+	}
+
+	formatted := *msg
+	if render {
+		formatted.HTML, formatted.Mentions = h.render(msg.Text)
+	}
+	if msg.ReplyTo != 0 {
+		formatted.Quote = h.quote(msg.ReplyTo)
+	}
+	return &formatted
+}
@@ -0,0 +1,56 @@
+package chatter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string // what the HTML has to contain
+		notWant []string // what it must not contain
+	}{
+		{"bold and italics", "**bold** and _italic_", []string{"<strong>bold</strong>", "<em>italic</em>"}, nil},
+		{"inline code", "run `rm -rf <dir>`", []string{"<code>rm -rf &lt;dir&gt;</code>"}, []string{"<dir>"}},
+		{"fenced code", "```\n<script>alert(1)</script>\n```", []string{"<pre><code>", "&lt;script&gt;"}, []string{"<script>"}},
+		{"nested formatting", "**bold _and italic_ [link](https://example.com)**", []string{"<strong>bold <em>and italic</em>", `href="https://example.com"`}, nil},
+		{"links open safely", "[docs](https://example.com)", []string{`rel="nofollow noopener"`, `target="_blank"`}, nil},
+		{"script through a link title", `[click](https://example.com "\"><script>alert(1)</script>")`, []string{`href="https://example.com"`}, []string{"<script", "title="}},
+		{"javascript link", "[click](javascript:alert(1))", nil, []string{"javascript:", "href"}},
+		{"raw html block", "<div onclick=\"alert(1)\">hi</div>", nil, []string{"<div", "onclick"}},
+		{"raw inline html", "hi <img src=x onerror=alert(1)>", nil, []string{"<img", "onerror"}},
+		{"script block", "<script>alert(1)</script>", nil, []string{"<script"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := string(renderMarkdown(tt.text, &mentionScan{}))
+			for _, want := range tt.want {
+				if !strings.Contains(html, want) {
+					t.Errorf("the HTML doesn't have %q:\n%s", want, html)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(html, notWant) {
+					t.Errorf("the HTML has %q:\n%s", notWant, html)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownCanBeDisabled(t *testing.T) {
+	hub := NewHub(WithMarkdown(false))
+	msg := &Message{Kind: KindChat, ClientID: "alice-1", Username: "alice", Text: "**not bold** <b>nor this</b>"}
+	hub.format(msg)
+	if msg.HTML != "" {
+		t.Errorf("the message was rendered as %q", msg.HTML)
+	}
+
+	// the template shows the text as it was sent, escaped
+	rendered := string(getMessageTemplate(msg, "", false))
+	if !strings.Contains(rendered, "**not bold** &lt;b&gt;nor this&lt;/b&gt;") {
+		t.Errorf("the text isn't shown as it was sent:\n%s", rendered)
+	}
+}
@@ -0,0 +1,195 @@
+package chatter
+
+import (
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// knownFor is how long a name is still mentioned after its client joined the room for the last time
+const knownFor = 24 * time.Hour
+
+// remember adds the name to the names that can be mentioned, along with the identity of the
+// client going by it, forgetting the names nobody used in a while.
+// It must be called on the hub goroutine with the lock held
+func (h *Hub) remember(name, identity string) {
+	now := h.clock.Now()
+	for known, seen := range h.known {
+		if _, connected := h.names[known]; !connected && now.Sub(seen) > knownFor {
+			delete(h.known, known)
+			delete(h.owners, known)
+		}
+	}
+	h.known[name] = now
+	h.owners[name] = identity
+}
+
+// mentionables returns the names that can be mentioned, the longest first so that
+// "@Bob Smith" mentions Bob Smith rather than Bob. It is safe to call from any goroutine
+func (h *Hub) mentionables() *mentionScan {
+	h.RLock()
+	names := make([]string, 0, len(h.known))
+	for name := range h.known {
+		names = append(names, name)
+	}
+	h.RUnlock()
+
+	slices.SortFunc(names, func(a, b string) int { return len(b) - len(a) })
+	return &mentionScan{names: names}
+}
+
+// mentionScan is what we know about the mentions of a text while rendering it
+type mentionScan struct {
+	names     []string // the names that can be mentioned, the longest first
+	mentioned []string // the names the text mentions, in order and without duplicates
+}
+
+// match returns the name mentioned at the start of b (just after the @), or "".
+// Names match whatever their case, and must not run into a word ("@bobby" isn't "@bob")
+func (s *mentionScan) match(b []byte) string {
+	for _, name := range s.names {
+		if len(b) < len(name) || !strings.EqualFold(string(b[:len(name)]), name) {
+			continue
+		}
+		if next, _ := utf8.DecodeRune(b[len(name):]); next != utf8.RuneError && inWord(next) {
+			continue
+		}
+
+		if !slices.Contains(s.mentioned, name) {
+			s.mentioned = append(s.mentioned, name)
+		}
+		return name
+	}
+	return ""
+}
+
+// inWord reports whether r can be part of a word, for telling where a mention ends
+func inWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// plainMentions renders the text as plain (escaped) text with the mentions highlighted,
+// for hubs that don't render markdown
+func plainMentions(content string, scan *mentionScan) template.HTML {
+
+	var rendered strings.Builder
+	for {
+		at := strings.IndexByte(content, '@')
+		if at < 0 {
+			break
+		}
+
+		// an @ in a word is not a mention (e.g. an email address)
+		before, _ := utf8.DecodeLastRuneInString(content[:at])
+		name := ""
+		if at == 0 || !inWord(before) {
+			name = scan.match([]byte(content[at+1:]))
+		}
+		if name == "" {
+			rendered.WriteString(template.HTMLEscapeString(content[:at+1]))
+			content = content[at+1:]
+			continue
+		}
+
+		rendered.WriteString(template.HTMLEscapeString(content[:at]))
+		writeMention(&rendered, content[at+1:at+1+len(name)])
+		content = content[at+1+len(name):]
+	}
+	rendered.WriteString(template.HTMLEscapeString(content))
+
+	// the text was escaped piece by piece, the only markup is the mentions'
+	return template.HTML(rendered.String())
+}
+
+// writeMention writes the highlighted mention of the name (as it was typed)
+func writeMention(w interface{ WriteString(string) (int, error) }, name string) {
+	w.WriteString(`<span class="mention">@`)
+	w.WriteString(template.HTMLEscapeString(name))
+	w.WriteString(`</span>`)
+}
+
+// mentionsKey is where the mentionScan of the text is kept while goldmark parses it
+var mentionsKey = parser.NewContextKey()
+
+// kindMention is the kind of the mention nodes
+var kindMention = ast.NewNodeKind("Mention")
+
+// mentionNode is a mention in the markdown, Name is the name as it was typed
+type mentionNode struct {
+	ast.BaseInline
+	Name string
+}
+
+// Kind implements ast.Node
+func (n *mentionNode) Kind() ast.NodeKind { return kindMention }
+
+// Dump implements ast.Node
+func (n *mentionNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+// mentionParser parses the mentions of known names in the markdown
+type mentionParser struct{}
+
+// Trigger implements parser.InlineParser
+func (mentionParser) Trigger() []byte { return []byte{'@'} }
+
+// Parse implements parser.InlineParser
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	scan, ok := pc.Get(mentionsKey).(*mentionScan)
+	if !ok || inWord(block.PrecendingCharacter()) {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	name := scan.match(line[1:])
+	if name == "" {
+		return nil
+	}
+
+	block.Advance(1 + len(name))
+	return &mentionNode{Name: string(line[1 : 1+len(name)])}
+}
+
+// mentionRenderer renders the mention nodes
+type mentionRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMention, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			writeMention(w, node.(*mentionNode).Name)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// notifyMentions lets the connected clients the message mentions know,
+// a client mentioning itself isn't notified
+func (h *Hub) notifyMentions(msg *Message) {
+	if len(msg.Mentions) == 0 {
+		return
+	}
+
+	rendered := getMentionTemplate(msg)
+	if rendered == nil {
+		return
+	}
+	for _, name := range msg.Mentions {
+		if client, ok := h.names[name]; ok && client.id != msg.ClientID {
+			deliverNotice(client, rendered)
+		} else if identity, ok := h.offline(name); ok {
+			// the ones that are away get it when they're back
+			h.enqueue(identity, name, Frame{Data: rendered})
+		}
+	}
+}
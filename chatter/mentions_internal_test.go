@@ -0,0 +1,82 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		names     []string // the names known to the hub, the longest first (see mentionables)
+		want      string   // the plain rendering
+		mentioned []string
+	}{
+		{"a mention", "hi @bob", []string{"bob"}, `hi <span class="mention">@bob</span>`, []string{"bob"}},
+		{"any case", "@BOB!", []string{"bob"}, `<span class="mention">@BOB</span>!`, []string{"bob"}},
+		{"unknown name", "hi @carol", []string{"bob"}, `hi @carol`, nil},
+		{"longer word", "hi @bobby", []string{"bob"}, `hi @bobby`, nil},
+		{"email address", "write to alice@bob.com", []string{"bob"}, `write to alice@bob.com`, nil},
+		{"longest name", "@Bob Smith and @Bob", []string{"Bob Smith", "Bob"},
+			`<span class="mention">@Bob Smith</span> and <span class="mention">@Bob</span>`, []string{"Bob Smith", "Bob"}},
+		{"punctuation", "@o'brien, @dr.who.", []string{"o'brien", "dr.who"},
+			`<span class="mention">@o&#39;brien</span>, <span class="mention">@dr.who</span>.`, []string{"o'brien", "dr.who"}},
+		{"twice", "@bob @bob", []string{"bob"}, `<span class="mention">@bob</span> <span class="mention">@bob</span>`, []string{"bob"}},
+		{"escaped around", "<b>@bob</b>", []string{"bob"}, `&lt;b&gt;<span class="mention">@bob</span>&lt;/b&gt;`, []string{"bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan := &mentionScan{names: tt.names}
+			if got := string(plainMentions(tt.text, scan)); got != tt.want {
+				t.Errorf("plainMentions() = %s, want %s", got, tt.want)
+			}
+			if fmt.Sprint(scan.mentioned) != fmt.Sprint(tt.mentioned) {
+				t.Errorf("the text mentions %v, want %v", scan.mentioned, tt.mentioned)
+			}
+
+			// the markdown finds the same mentions
+			scan = &mentionScan{names: tt.names}
+			html := string(renderMarkdown(tt.text, scan))
+			if fmt.Sprint(scan.mentioned) != fmt.Sprint(tt.mentioned) {
+				t.Errorf("the markdown mentions %v, want %v", scan.mentioned, tt.mentioned)
+			}
+			if spans := strings.Count(html, `<span class="mention">`); spans != strings.Count(tt.want, `<span class="mention">`) {
+				t.Errorf("the markdown has %d mentions:\n%s", spans, html)
+			}
+		})
+	}
+}
+
+func TestMentionedClientsAreNotified(t *testing.T) {
+	hub := NewHub()
+	alice, bob := pumpClient(hub, newFakeConn()), pumpClient(hub, newFakeConn())
+	alice.name, bob.name = "alice", "bob"
+	for _, client := range []*Client{alice, bob} {
+		addClient(hub, client)
+		hub.remember(client.name, client.name+"-identity")
+	}
+	// carol was here and is away now
+	hub.remember("carol", "carol-identity")
+
+	hub.broadcastMessage(&Message{Kind: KindChat, ClientID: alice.id, Username: "alice", Text: "@bob @carol @alice @dave"})
+
+	// bob gets the message highlighted and the notification
+	frames := bytes.Join(drain(bob), nil)
+	if !bytes.Contains(frames, []byte(`<span class="mention">@bob</span>`)) || !bytes.Contains(frames, []byte("mentioned you")) {
+		t.Errorf("bob got:\n%s", frames)
+	}
+	// alice isn't notified of mentioning alice
+	if frames := bytes.Join(drain(alice), nil); bytes.Contains(frames, []byte("mentioned you")) {
+		t.Errorf("alice was notified:\n%s", frames)
+	}
+	// carol gets the notification on coming back, nobody knows dave
+	if box := hub.outbox["carol-identity"]; len(box) != 1 || !bytes.Contains(box[0].frame.Data, []byte("mentioned you")) {
+		t.Errorf("carol's outbox is %v", box)
+	}
+	if len(hub.outbox) != 1 {
+		t.Errorf("the outboxes are %v", hub.outbox)
+	}
+}
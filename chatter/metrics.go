@@ -0,0 +1,273 @@
+package chatter
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the prometheus metrics of the hubs and their connections.
+// A nil *Metrics is valid and records nothing, so the hub doesn't have to check
+type Metrics struct {
+	connected   prometheus.Gauge
+	connections prometheus.Counter
+	broadcast   prometheus.Counter
+	dropped     prometheus.Counter
+	fanout      *prometheus.HistogramVec
+	latency     *prometheus.HistogramVec
+	fanoutSize  *prometheus.GaugeVec
+	deliveries  *prometheus.CounterVec
+	readErrors  *prometheus.CounterVec
+	webhooks    *prometheus.CounterVec
+	banned      prometheus.Counter
+	offline     *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+	slow        prometheus.Counter
+	panics      *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics and registers them with reg
+// (tests can pass their own registry and read the values back)
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chatter_clients_connected",
+			Help: "Number of clients currently connected.",
+		}),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chatter_connections_total",
+			Help: "Number of clients that connected since the server started.",
+		}),
+		broadcast: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chatter_messages_broadcast_total",
+			Help: "Number of messages broadcast.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chatter_messages_dropped_total",
+			Help: "Number of messages dropped because a client's send buffer was full.",
+		}),
+		fanout: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chatter_broadcast_fanout_seconds",
+			Help:    "Time it takes to hand a broadcast message to every client, by room.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"room"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chatter_broadcast_latency_seconds",
+			Help:    "Time from a message reaching the hub to every client having it queued, by room.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"room"}),
+		fanoutSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "chatter_broadcast_fanout_clients",
+			Help: "Number of clients the last broadcast of the room was handed to, by room.",
+		}, []string{"room"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_deliveries_total",
+			Help: "Number of frames handed to the clients, by room and result (delivered or dropped).",
+		}, []string{"room", "result"}),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_websocket_read_errors_total",
+			Help: "Number of websocket read errors by type.",
+		}, []string{"type"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_webhook_posts_total",
+			Help: "Number of outgoing webhook posts by result (delivered or failed).",
+		}, []string{"result"}),
+		banned: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chatter_banned_connections_total",
+			Help: "Number of connections rejected because their address is banned.",
+		}),
+		offline: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_offline_messages_total",
+			Help: "Number of messages kept for clients that are away, by result (queued, delivered, expired or overflowed).",
+		}, []string{"result"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_rejected_connections_total",
+			Help: "Number of websocket connections rejected before the upgrade, by reason (ip_limit or room_full).",
+		}, []string{"reason"}),
+		slow: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chatter_slow_clients_disconnected_total",
+			Help: "Number of clients disconnected because they couldn't keep up.",
+		}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatter_panics_recovered_total",
+			Help: "Number of panics recovered, by where they happened (hub, fan-out, readPump, writePump or a hook).",
+		}, []string{"where"}),
+	}
+
+	reg.MustRegister(m.connected, m.connections, m.broadcast, m.dropped, m.fanout, m.latency, m.fanoutSize, m.deliveries, m.readErrors, m.webhooks, m.banned, m.offline, m.rejected, m.slow, m.panics)
+
+	return m
+}
+
+// clientConnected records a client joining a hub
+func (m *Metrics) clientConnected() {
+	if m == nil {
+		return
+	}
+	m.connected.Inc()
+	m.connections.Inc()
+}
+
+// clientDisconnected records a client leaving a hub
+func (m *Metrics) clientDisconnected() {
+	if m == nil {
+		return
+	}
+	m.connected.Dec()
+}
+
+// messageDropped records a message that couldn't be queued for a client
+func (m *Metrics) messageDropped() {
+	if m == nil {
+		return
+	}
+	m.dropped.Inc()
+}
+
+// slowClientDropped records a client disconnected because it couldn't keep up
+func (m *Metrics) slowClientDropped() {
+	if m == nil {
+		return
+	}
+	m.slow.Inc()
+}
+
+// panicRecovered records a panic recovered in where
+func (m *Metrics) panicRecovered(where string) {
+	if m == nil {
+		return
+	}
+	m.panics.WithLabelValues(where).Inc()
+}
+
+// readError records an error reading from a websocket connection
+func (m *Metrics) readError(err error) {
+	if m == nil {
+		return
+	}
+	m.readErrors.WithLabelValues(readErrorType(err)).Inc()
+}
+
+// webhookPosted records an outgoing webhook post, failed ones gave up after their retries
+func (m *Metrics) webhookPosted(delivered bool) {
+	if m == nil {
+		return
+	}
+	result := "delivered"
+	if !delivered {
+		result = "failed"
+	}
+	m.webhooks.WithLabelValues(result).Inc()
+}
+
+// banRejected records a connection rejected because its address is banned
+func (m *Metrics) banRejected() {
+	if m == nil {
+		return
+	}
+	m.banned.Inc()
+}
+
+// offlineMessage records what happened to a message kept for a client that is away
+func (m *Metrics) offlineMessage(result string) {
+	if m == nil {
+		return
+	}
+	m.offline.WithLabelValues(result).Inc()
+}
+
+// connectionRejected records a websocket connection turned away before the upgrade
+func (m *Metrics) connectionRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(reason).Inc()
+}
+
+// roomMetrics are the metrics of the broadcasts of a single room, with their labels looked up
+// once so a broadcast only costs a few atomic adds. A nil *roomMetrics records nothing
+type roomMetrics struct {
+	broadcast prometheus.Counter
+	fanout    prometheus.Observer
+	latency   prometheus.Observer
+	size      prometheus.Gauge
+	delivered prometheus.Counter
+	dropped   prometheus.Counter
+}
+
+// room returns the metrics of the broadcasts of the room, nil when m is
+func (m *Metrics) room(name string) *roomMetrics {
+	if m == nil {
+		return nil
+	}
+	return &roomMetrics{
+		broadcast: m.broadcast,
+		fanout:    m.fanout.WithLabelValues(name),
+		latency:   m.latency.WithLabelValues(name),
+		size:      m.fanoutSize.WithLabelValues(name),
+		delivered: m.deliveries.WithLabelValues(name, "delivered"),
+		dropped:   m.deliveries.WithLabelValues(name, "dropped"),
+	}
+}
+
+// forgetRoom removes the series of a room that is gone, so rooms coming and going don't
+// pile up series
+func (m *Metrics) forgetRoom(name string) {
+	if m == nil {
+		return
+	}
+	m.fanout.DeleteLabelValues(name)
+	m.latency.DeleteLabelValues(name)
+	m.fanoutSize.DeleteLabelValues(name)
+	m.deliveries.DeleteLabelValues(name, "delivered")
+	m.deliveries.DeleteLabelValues(name, "dropped")
+}
+
+// fannedOut records a broadcast handed to clients clients, delivered of which got their frame
+// queued, and how long it took
+func (r *roomMetrics) fannedOut(took time.Duration, clients, delivered int) {
+	if r == nil {
+		return
+	}
+	r.broadcast.Inc()
+	r.fanout.Observe(took.Seconds())
+	r.size.Set(float64(clients))
+	r.delivered.Add(float64(delivered))
+}
+
+// frameDropped records a frame that couldn't be queued for a client of the room
+func (r *roomMetrics) frameDropped() {
+	if r == nil {
+		return
+	}
+	r.dropped.Inc()
+}
+
+// messageBroadcast records how long after reaching the hub a message was queued for every client
+func (r *roomMetrics) messageBroadcast(took time.Duration) {
+	if r == nil {
+		return
+	}
+	r.latency.Observe(took.Seconds())
+}
+
+// readErrorType classifies a websocket read error for the metrics
+func readErrorType(err error) string {
+
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return "closed"
+	case errors.Is(err, websocket.ErrReadLimit):
+		return "read_limit"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case websocket.IsUnexpectedCloseError(err):
+		return "unexpected_close"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,94 @@
+package chatter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roomValue returns the value of the series of the metric with the labels (the sample count
+// of a histogram), zero if there is none
+func roomValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue series
+				}
+			}
+			switch {
+			case m.Counter != nil:
+				return m.Counter.GetValue()
+			case m.Gauge != nil:
+				return m.Gauge.GetValue()
+			case m.Histogram != nil:
+				return float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestBroadcastsAreRecordedByRoom(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg)
+	lobby := NewHub(WithRoom("lobby"), WithMetrics(metrics), WithSendBuffer(2), WithSlowPolicy(SlowDrop, 0))
+	other := NewHub(WithRoom("other"), WithMetrics(metrics))
+	fast, slow, elsewhere := pumpClient(lobby, newFakeConn()), pumpClient(lobby, newFakeConn()), pumpClient(other, newFakeConn())
+	fast.name, slow.name, elsewhere.name = "fast", "slow", "elsewhere"
+	addClient(lobby, fast)
+	addClient(lobby, slow)
+	addClient(other, elsewhere)
+
+	// the slow client never reads, its buffer holds the first two
+	for i := 0; i < 4; i++ {
+		lobby.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d", i)})
+		drain(fast)
+	}
+	other.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "hello"})
+
+	for _, tt := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"chatter_broadcast_fanout_seconds", map[string]string{"room": "lobby"}, 4},
+		{"chatter_broadcast_fanout_clients", map[string]string{"room": "lobby"}, 2},
+		{"chatter_deliveries_total", map[string]string{"room": "lobby", "result": "delivered"}, 6},
+		{"chatter_deliveries_total", map[string]string{"room": "lobby", "result": "dropped"}, 2},
+		{"chatter_broadcast_fanout_seconds", map[string]string{"room": "other"}, 1},
+		{"chatter_broadcast_fanout_clients", map[string]string{"room": "other"}, 1},
+		{"chatter_deliveries_total", map[string]string{"room": "other", "result": "delivered"}, 1},
+		{"chatter_deliveries_total", map[string]string{"room": "other", "result": "dropped"}, 0},
+	} {
+		if got := roomValue(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v is %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestBroadcastsWithoutMetricsCostNothing(t *testing.T) {
+	hub := NewHub()
+	if hub.roomMetrics != nil {
+		t.Fatal("a hub without metrics records its broadcasts")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		hub.roomMetrics.fannedOut(time.Millisecond, 10, 10)
+		hub.roomMetrics.frameDropped()
+		hub.roomMetrics.messageBroadcast(time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Errorf("recording without metrics allocates %v times", allocs)
+	}
+}
@@ -0,0 +1,80 @@
+package chatter_test
+
+import (
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricValue returns the sum of the values of the metric (its count for a histogram)
+// over every label, zero if the registry doesn't have it
+func metricValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			switch {
+			case m.Counter != nil:
+				sum += m.Counter.GetValue()
+			case m.Gauge != nil:
+				sum += m.Gauge.GetValue()
+			case m.Histogram != nil:
+				sum += float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return sum
+}
+
+func TestMetricsCountTheTraffic(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := chattertest.NewServer(t, chatter.WithMetrics(chatter.NewMetrics(reg)))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+
+	for _, text := range []string{"one", "two", "three"} {
+		alice.Send(text)
+		bob.Expect(text, waitTimeout)
+	}
+	alice.Expect("three", waitTimeout)
+	bob.Close()
+	alice.Expect("Online (1)", waitTimeout)
+
+	if connected := metricValue(t, reg, "chatter_clients_connected"); connected != 1 {
+		t.Errorf("%v clients are connected, want 1", connected)
+	}
+	if connections := metricValue(t, reg, "chatter_connections_total"); connections != 2 {
+		t.Errorf("%v connections were counted, want 2", connections)
+	}
+	// every broadcast is timed, the three messages went to both clients
+	broadcast := metricValue(t, reg, "chatter_messages_broadcast_total")
+	if broadcast < 3 {
+		t.Errorf("%v broadcasts were counted, want at least 3", broadcast)
+	}
+	if fanouts := metricValue(t, reg, "chatter_broadcast_fanout_seconds"); fanouts != broadcast {
+		t.Errorf("%v of the %v broadcasts were timed", fanouts, broadcast)
+	}
+	// and so is the time it took the messages to get queued for everyone
+	if latencies := metricValue(t, reg, "chatter_broadcast_latency_seconds"); latencies < 3 {
+		t.Errorf("%v messages were timed from the hub to the clients, want at least 3", latencies)
+	}
+	if delivered := metricValue(t, reg, "chatter_deliveries_total"); delivered < 6 {
+		t.Errorf("%v deliveries were counted, want at least 6", delivered)
+	}
+	if dropped := metricValue(t, reg, "chatter_messages_dropped_total"); dropped != 0 {
+		t.Errorf("%v messages were dropped, want none", dropped)
+	}
+	// bob hanging up ended its read loop
+	if errors := metricValue(t, reg, "chatter_websocket_read_errors_total"); errors != 1 {
+		t.Errorf("%v read errors were counted, want 1", errors)
+	}
+}
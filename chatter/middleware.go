@@ -0,0 +1,105 @@
+package chatter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageHandler handles a chat message on its way to the room
+type MessageHandler func(msg *Message)
+
+// MessageMiddleware wraps a handler, it can change the message before passing it on to next,
+// or not call next at all to drop it. Middlewares run on the hub goroutine
+type MessageMiddleware func(next MessageHandler) MessageHandler
+
+// WithMiddleware adds middlewares in front of the broadcast, the first one runs first
+func WithMiddleware(middleware ...MessageMiddleware) Option {
+	return func(h *Hub) {
+		h.middleware = append(h.middleware, middleware...)
+	}
+}
+
+// chain wraps the slash commands and the middlewares around broadcastMessage,
+// the message hook (if any) runs last so it sees the message the way it will be broadcast
+func (h *Hub) chain() MessageHandler {
+	handler := MessageHandler(h.broadcastMessage)
+	if h.onMessage != nil {
+		handler = h.messageHook(handler)
+	}
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	// nothing gets through while the room is read-only
+	handler = h.readOnlyMiddleware(handler)
+	// commands come first, what they broadcast goes through the other middlewares
+	return h.commandMiddleware(handler)
+}
+
+// messageHook runs the message hook before next, the hook may change the message
+// or return nil to drop it. A hook that panics keeps the message as it was
+func (h *Hub) messageHook(next MessageHandler) MessageHandler {
+	return func(msg *Message) {
+		hooked := msg
+		h.hook("message", func() { hooked = h.onMessage(msg) })
+		if hooked == nil {
+			return
+		}
+
+		// the publisher (if any) waits on the original message,
+		// and an edit stays an edit of the same message
+		hooked.accepted, hooked.hub, hooked.Edit = msg.accepted, msg.hub, msg.Edit
+		next(hooked)
+	}
+}
+
+// Reply sends an error to the client that sent the message (and nobody else),
+// it is meant for middlewares telling the sender why its message was dropped.
+// Messages that weren't sent by a connected client (e.g. posted by a bot) can't be replied to
+func (m *Message) Reply(text string) {
+	if m.hub == nil {
+		return
+	}
+	if sender, ok := m.hub.ids[m.ClientID]; ok {
+		m.hub.sendNotice(sender, text)
+	}
+}
+
+// MaxLength drops the messages longer than n characters and tells their sender
+func MaxLength(n int) MessageMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg *Message) {
+			if utf8.RuneCountInString(msg.Text) > n {
+				msg.Reply(fmt.Sprintf("message too long (%d characters at most)", n))
+				return
+			}
+			next(msg)
+		}
+	}
+}
+
+// WordFilter masks the words (whole words, whatever their case) with asterisks
+func WordFilter(words ...string) MessageMiddleware {
+
+	// we match all the words at once
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return func(next MessageHandler) MessageHandler { return next }
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+
+	return func(next MessageHandler) MessageHandler {
+		return func(msg *Message) {
+			msg.Text = pattern.ReplaceAllStringFunc(msg.Text, func(word string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(word))
+			})
+			next(msg)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// recording is a middleware noting its name in calls and passing the message on,
+// unless the text is stop
+func recording(name string, calls *[]string) MessageMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg *Message) {
+			*calls = append(*calls, name)
+			if msg.Text == "stop" {
+				return
+			}
+			next(msg)
+		}
+	}
+}
+
+// drain returns the data of the frames queued for the client
+func drain(client *Client) [][]byte {
+	var frames [][]byte
+	for len(client.send) > 0 {
+		frames = append(frames, (<-client.send).Data)
+	}
+	return frames
+}
+
+func TestMiddlewaresRunInOrder(t *testing.T) {
+	var calls []string
+	hub := NewHub(WithMiddleware(recording("first", &calls), recording("second", &calls)), WithMiddleware(recording("third", &calls)))
+	client := pumpClient(hub, newFakeConn())
+	addClient(hub, client)
+
+	hub.handle(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "hello"})
+	if fmt.Sprint(calls) != "[first second third]" {
+		t.Errorf("the middlewares ran as %v", calls)
+	}
+	if frames := drain(client); len(frames) != 1 {
+		t.Errorf("the message was broadcast %d times, want once", len(frames))
+	}
+
+	// a middleware not calling the next one stops the message there
+	calls = nil
+	hub.handle(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "stop"})
+	if fmt.Sprint(calls) != "[first]" {
+		t.Errorf("the middlewares ran as %v after the first one stopped", calls)
+	}
+	if frames := drain(client); len(frames) != 0 {
+		t.Errorf("the stopped message was broadcast:\n%s", bytes.Join(frames, []byte("\n")))
+	}
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	hub := NewHub(WithMiddleware(MaxLength(10), WordFilter("darn", "heck")))
+	sender, other := pumpClient(hub, newFakeConn()), pumpClient(hub, newFakeConn())
+	sender.name, other.name = "alice", "bob"
+	addClient(hub, sender)
+	addClient(hub, other)
+
+	// the word filter masks whole words whatever their case
+	hub.handle(&Message{Kind: KindChat, ClientID: sender.id, Username: "alice", Text: "DARN it"})
+	if frames := drain(other); len(frames) != 1 || !bytes.Contains(frames[0], []byte("**** it")) {
+		t.Errorf("the filtered message went out as:\n%s", bytes.Join(frames, []byte("\n")))
+	}
+	drain(sender)
+
+	// a message that's too long only gets its sender an error
+	hub.handle(&Message{Kind: KindChat, ClientID: sender.id, Username: "alice", Text: "far too long a message", hub: hub})
+	if frames := drain(other); len(frames) != 0 {
+		t.Errorf("the others got the long message:\n%s", bytes.Join(frames, []byte("\n")))
+	}
+	if frames := drain(sender); len(frames) != 1 || !bytes.Contains(frames[0], []byte("message too long")) {
+		t.Errorf("the sender got:\n%s", bytes.Join(frames, []byte("\n")))
+	}
+}
@@ -0,0 +1,286 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMuteStrikes is how many times a client can hit the rate limit within
+	// defaultMuteWindow before it is muted for defaultMuteDuration
+	defaultMuteStrikes  = 5
+	defaultMuteWindow   = 30 * time.Second
+	defaultMuteDuration = 5 * time.Minute
+)
+
+// Mute is a client that can't send messages for a while
+type Mute struct {
+	Room     string    `json:"room"`
+	Identity string    `json:"identity"` // IP address (or id) of the client
+	Name     string    `json:"name"`     // name of the client when it was muted
+	Until    time.Time `json:"until"`
+}
+
+// mutes keeps track of the clients hitting the rate limit and mutes those doing it too often.
+// They are keyed by identity (the IP address of the client) so reconnecting doesn't help
+type mutes struct {
+	sync.Mutex
+	strikes  int           // hits of the rate limit within window that get a client muted
+	window   time.Duration // window the strikes are counted in
+	duration time.Duration // how long a client stays muted
+
+	hits  map[string][]time.Time // recent rate limit hits by identity
+	muted map[string]*Mute       // muted clients by identity
+
+	now func() time.Time // the time the hits and mutes are counted from (time.Now but in tests)
+}
+
+// newMutes creates the mutes with the default escalation
+func newMutes() *mutes {
+	return &mutes{
+		strikes:  defaultMuteStrikes,
+		window:   defaultMuteWindow,
+		duration: defaultMuteDuration,
+		hits:     make(map[string][]time.Time),
+		muted:    make(map[string]*Mute),
+		now:      time.Now,
+	}
+}
+
+// WithAutoMute mutes the clients hitting the rate limit more than strikes times within window
+// for duration, their messages are bounced while they stay connected. Zero strikes disables it
+func WithAutoMute(strikes int, window, duration time.Duration) Option {
+	return func(h *Hub) {
+		h.mutes.strikes = strikes
+		if window > 0 {
+			h.mutes.window = window
+		}
+		if duration > 0 {
+			h.mutes.duration = duration
+		}
+	}
+}
+
+// identity is who is behind the client: its stable identity if it has one, its address
+// otherwise. It is what a client is muted by, and what it read or was sent while away is kept by
+func (c *Client) identity() string {
+	if c.identityID != "" {
+		return c.identityID
+	}
+	if c.ip != "" {
+		return c.ip
+	}
+	return c.id
+}
+
+// mutedUntil returns until when the client is muted, if it is
+func (m *mutes) mutedUntil(client *Client) (time.Time, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	mute, ok := m.muted[client.identity()]
+	if !ok {
+		return time.Time{}, false
+	}
+	// mutes expire on their own
+	if !m.now().Before(mute.Until) {
+		delete(m.muted, client.identity())
+		return time.Time{}, false
+	}
+	return mute.Until, true
+}
+
+// strike records the client (with the identity and name) hitting the rate limit,
+// it returns until when the client is muted if this was one hit too many
+func (m *mutes) strike(room, identity, name string) (time.Time, bool) {
+	if m.strikes <= 0 {
+		return time.Time{}, false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	// we only keep the hits within the window
+	now := m.now()
+	hits := m.hits[identity][:0]
+	for _, hit := range m.hits[identity] {
+		if now.Sub(hit) < m.window {
+			hits = append(hits, hit)
+		}
+	}
+	hits = append(hits, now)
+
+	if len(hits) <= m.strikes {
+		m.hits[identity] = hits
+		return time.Time{}, false
+	}
+
+	// one too many, the client is muted and starts from scratch afterwards
+	delete(m.hits, identity)
+	mute := &Mute{Room: room, Identity: identity, Name: name, Until: now.Add(m.duration)}
+	m.muted[identity] = mute
+	return mute.Until, true
+}
+
+// mute mutes the client (with the identity and name) for d, whatever its strikes
+func (m *mutes) mute(room, identity, name string, d time.Duration) *Mute {
+	m.Lock()
+	defer m.Unlock()
+	mute := &Mute{Room: room, Identity: identity, Name: name, Until: m.now().Add(d)}
+	m.muted[identity] = mute
+	return mute
+}
+
+// unmute lifts the mute of the identity, false if it wasn't muted
+func (m *mutes) unmute(identity string) bool {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.muted[identity]
+	delete(m.muted, identity)
+	return ok
+}
+
+// list returns the mutes still in force
+func (m *mutes) list() []*Mute {
+	m.Lock()
+	defer m.Unlock()
+
+	now := m.now()
+	list := make([]*Mute, 0, len(m.muted))
+	for identity, mute := range m.muted {
+		if !now.Before(mute.Until) {
+			delete(m.muted, identity)
+			continue
+		}
+		list = append(list, mute)
+	}
+	return list
+}
+
+// Mutes returns the clients currently muted, in every room
+func (m *HubManager) Mutes() []*Mute {
+	m.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	m.Unlock()
+
+	var list []*Mute
+	for _, hub := range hubs {
+		list = append(list, hub.mutes.list()...)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// ErrNotMuted is returned when lifting a mute that isn't in force
+var ErrNotMuted = errors.New("not muted")
+
+// Mute mutes the client of the room (by id or name) for d, its messages are bounced
+// while it stays connected. It returns ErrNoSuchClient if the client isn't in the room.
+// The mute is recorded in the audit log as taken by actor
+func (h *Hub) Mute(actor, target string, d time.Duration) (*Mute, error) {
+	h.RLock()
+	client, ok := h.ids[target]
+	if !ok {
+		client, ok = h.names[target]
+	}
+	h.RUnlock()
+	if !ok {
+		h.audit.record(actor, AuditMute, h.room, target, "", ErrNoSuchClient)
+		return nil, ErrNoSuchClient
+	}
+	mute := h.mutes.mute(h.room, client.identity(), client.name, d)
+	h.audit.record(actor, AuditMute, h.room, client.name, "for "+d.String(), nil)
+	return mute, nil
+}
+
+// Unmute lifts the mute of the identity in the room, it returns ErrNotMuted if it wasn't muted.
+// It is recorded in the audit log as taken by actor
+func (h *Hub) Unmute(actor, identity string) error {
+	var err error
+	if !h.mutes.unmute(identity) {
+		err = ErrNotMuted
+	}
+	h.audit.record(actor, AuditUnmute, h.room, identity, "", err)
+	return err
+}
+
+// MuteRequest is the body of POST /admin/mutes
+type MuteRequest struct {
+	Room     string `json:"room"`     // room the client is in (DefaultRoom if empty)
+	Client   string `json:"client"`   // id or name of the client
+	Duration string `json:"duration"` // e.g. "10m", empty for the duration of the automatic mutes
+}
+
+// MutesHandler serves the admin endpoints of the mutes, it should be wrapped in AdminAuth:
+// GET /admin/mutes lists them, POST /admin/mutes mutes a client (with a JSON body or a form)
+// and DELETE /admin/mutes/{identity}?room=... lifts a mute
+func MutesHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(manager.Mutes())
+
+		case http.MethodPost:
+			req := &MuteRequest{}
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+				if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+					http.Error(w, "invalid request", http.StatusBadRequest)
+					return
+				}
+			} else {
+				req.Room = r.FormValue("room")
+				req.Client = r.FormValue("client")
+				req.Duration = r.FormValue("duration")
+			}
+			if req.Client = strings.TrimSpace(req.Client); req.Client == "" {
+				http.Error(w, "client is required", http.StatusBadRequest)
+				return
+			}
+			if req.Room = strings.TrimSpace(req.Room); req.Room == "" {
+				req.Room = DefaultRoom
+			}
+			hub, err := manager.Get(req.Room)
+			if err != nil {
+				serveRoomUnavailable(w, err)
+				return
+			}
+			d := hub.mutes.duration
+			if req.Duration != "" {
+				if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+					http.Error(w, "duration must be a positive duration (e.g. 10m)", http.StatusBadRequest)
+					return
+				}
+			}
+
+			mute, err := hub.Mute(auditActor(r), req.Client, d)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(mute)
+
+		case http.MethodDelete:
+			hub, err := manager.Get(roomName(r))
+			if err != nil {
+				serveRoomUnavailable(w, err)
+				return
+			}
+			if err := hub.Unmute(auditActor(r), r.PathValue("identity")); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package chatter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMutesEscalateAndExpire(t *testing.T) {
+	now := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	m := newMutes()
+	m.strikes, m.window, m.duration = 3, 10*time.Second, time.Minute
+	m.now = func() time.Time { return now }
+	alice := &Client{id: "alice-1", ip: "192.0.2.1"}
+	reconnected := &Client{id: "alice-2", ip: "192.0.2.1"}
+
+	// hits spread further apart than the window never add up
+	for i := 0; i < 5; i++ {
+		if _, muted := m.strike("lobby", alice.identity(), "alice"); muted {
+			t.Fatalf("muted after %d hits spread out", i+1)
+		}
+		now = now.Add(6 * time.Second)
+	}
+
+	// one hit too many within the window mutes the client
+	m.hits = make(map[string][]time.Time)
+	for i := 0; i < 3; i++ {
+		if _, muted := m.strike("lobby", alice.identity(), "alice"); muted {
+			t.Fatalf("muted after %d hits", i+1)
+		}
+	}
+	until, muted := m.strike("lobby", alice.identity(), "alice")
+	if !muted || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("the fourth hit returned %v, %v, want muted until %v", until, muted, now.Add(time.Minute))
+	}
+
+	// reconnecting doesn't help, and the mute is listed
+	if _, muted := m.mutedUntil(reconnected); !muted {
+		t.Error("reconnecting lifted the mute")
+	}
+	if list := m.list(); len(list) != 1 || list[0].Name != "alice" || list[0].Room != "lobby" {
+		t.Errorf("the mutes are %+v", list)
+	}
+
+	// the mute expires on its own
+	now = until
+	if _, muted := m.mutedUntil(alice); muted {
+		t.Error("the mute didn't expire")
+	}
+	if list := m.list(); len(list) != 0 {
+		t.Errorf("expired mutes are listed: %+v", list)
+	}
+	// and the client starts from scratch
+	if _, muted := m.strike("lobby", alice.identity(), "alice"); muted {
+		t.Error("a single hit after the mute muted the client again")
+	}
+}
+
+func TestAutoMuteCanBeDisabled(t *testing.T) {
+	hub := NewHub(WithAutoMute(0, 0, 0))
+	for i := 0; i < 100; i++ {
+		if _, muted := hub.mutes.strike("lobby", "192.0.2.1", "alice"); muted {
+			t.Fatal("a client was muted with the auto mute disabled")
+		}
+	}
+}
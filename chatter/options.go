@@ -0,0 +1,160 @@
+package chatter
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultHistoryReplay is the number of messages replayed to a new client by default
+	defaultHistoryReplay = 100
+	// defaultSendBuffer is the number of messages that can be queued for a client by default
+	defaultSendBuffer = 256
+	// headersBudget is the space we allow for the JSON wrapping the text
+	headersBudget = 2048
+	// textBudget is the space we allow for the text itself
+	textBudget = 1024
+	// defaultRateLimit is the number of chat messages per second a client may send by default
+	defaultRateLimit = 5
+	// defaultRateBurst is the number of chat messages a client may send in a burst by default
+	defaultRateBurst = 10
+	// defaultEditWindow is how long after sending a message its author can edit it by default
+	defaultEditWindow = 15 * time.Minute
+)
+
+// Option configures a hub
+type Option func(*Hub)
+
+// WithHistoryReplay sets how many of the most recent messages are replayed
+// to a client when it connects, zero disables the replay
+func WithHistoryReplay(n int) Option {
+	return func(h *Hub) {
+		if n < 0 {
+			n = 0
+		}
+		h.historyReplay = n
+	}
+}
+
+// WithSendBuffer sets how many messages can be queued for a client
+// before it is considered too slow and dropped from the hub
+func WithSendBuffer(n int) Option {
+	return func(h *Hub) {
+		if n < 0 {
+			n = 0
+		}
+		h.sendBuffer = n
+	}
+}
+
+// WithMaxMessageSize sets the maximum size (in bytes) of a message sent by a client,
+// including the JSON and headers the htmx ws extension sends along with the text
+func WithMaxMessageSize(n int64) Option {
+	return func(h *Hub) {
+		if n > 0 {
+			h.config.MaxMessageSize = n
+		}
+	}
+}
+
+// WithIDGenerator sets the function generating message ids, the ids it returns
+// must be strictly increasing (e.g. continuing from the last id of a persisted history)
+func WithIDGenerator(next func() uint64) Option {
+	return func(h *Hub) {
+		if next != nil {
+			h.nextID = next
+		}
+	}
+}
+
+// counter returns an id generator counting up from start (the first id is start+1).
+// It is only called from the hub goroutine so it doesn't need to be safe for concurrent use
+func counter(start uint64) func() uint64 {
+	return func() uint64 {
+		start++
+		return start
+	}
+}
+
+// WithStore sets the store keeping the message history
+func WithStore(store MessageStore) Option {
+	return func(h *Hub) {
+		h.store = store
+	}
+}
+
+// WithRoom sets the name of the room the hub serves
+func WithRoom(room string) Option {
+	return func(h *Hub) {
+		h.room = room
+	}
+}
+
+// WithMetrics sets the metrics the hub records to
+func WithMetrics(metrics *Metrics) Option {
+	return func(h *Hub) {
+		h.metrics = metrics
+	}
+}
+
+// WithRateLimit sets how many chat messages per second a client may send,
+// and how many it may send in a single burst
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(h *Hub) {
+		if perSecond > 0 && burst > 0 {
+			h.rateLimit = rate.Limit(perSecond)
+			h.rateBurst = burst
+		}
+	}
+}
+
+// WithMarkdown enables or disables rendering message text as (sanitized) markdown,
+// it is enabled by default
+func WithMarkdown(enabled bool) Option {
+	return func(h *Hub) {
+		h.markdown = enabled
+	}
+}
+
+// WithEditWindow sets how long after sending a message its author can edit it,
+// zero disables editing
+func WithEditWindow(d time.Duration) Option {
+	return func(h *Hub) {
+		if d < 0 {
+			d = 0
+		}
+		h.editWindow = d
+	}
+}
+
+// WithEcho sets whether the sender of a message gets it back, it does by default
+// (rendered with its own variant of the template, see RegisterTemplate). Pages showing
+// their messages as soon as they're sent don't want them twice.
+// Constrained clients always get their messages back
+func WithEcho(enabled bool) Option {
+	return func(h *Hub) {
+		h.echo = enabled
+	}
+}
+
+// WithFanoutWorkers sets how many goroutines the broadcasts of big rooms are spread across,
+// it defaults to GOMAXPROCS. One sends every broadcast from the hub goroutine
+func WithFanoutWorkers(n int) Option {
+	return func(h *Hub) {
+		if n > 0 {
+			h.fanoutWorkers = n
+		}
+	}
+}
+
+// WithLogger sets the logger of the hub, it logs to slog.Default() otherwise.
+// The lines of the hub carry its room, the lines of its clients their id and address too
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Hub) {
+		if logger != nil {
+			h.logger = logger
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package chatter
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which web pages may open a websocket connection.
+// Browsers always send the Origin of the page, so without a check any website
+// could connect to the chat with the cookies of its visitors
+type OriginPolicy struct {
+	allowed  map[string]bool // the origins allowed on top of our own (scheme://host[:port], lower case)
+	allowAll bool            // development mode, every origin is allowed
+}
+
+// NewOriginPolicy creates a policy allowing our own origin and the comma separated origins,
+// allowAll lets every origin in and is only meant for development
+func NewOriginPolicy(origins string, allowAll bool) *OriginPolicy {
+	p := &OriginPolicy{allowed: make(map[string]bool), allowAll: allowAll}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = normalizeOrigin(origin); origin != "" {
+			p.allowed[origin] = true
+		}
+	}
+
+	if allowAll {
+		slog.Warn("websocket connections are accepted from ANY origin, don't run like this in production")
+	}
+	return p
+}
+
+// Allowed reports whether the request may be upgraded to a websocket connection,
+// a nil policy only allows our own origin
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+
+	origin := r.Header.Get("Origin")
+	// clients that aren't browsers (bots, scripts, ...) don't send an origin,
+	// and they can't be abused by another website either
+	if origin == "" || (p != nil && p.allowAll) {
+		return true
+	}
+
+	// our own pages are always allowed
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	return p != nil && p.allowed[normalizeOrigin(origin)]
+}
+
+// normalizeOrigin makes origins comparable: lower case and without a trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
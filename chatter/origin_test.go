@@ -0,0 +1,73 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := chatter.NewOriginPolicy("https://embed.example.com, HTTPS://Other.Example.com/", false)
+
+	for _, tt := range []struct {
+		name   string
+		policy *chatter.OriginPolicy
+		origin string
+		want   bool
+	}{
+		{"missing origin", policy, "", true},
+		{"our own origin", policy, "http://chat.example.com", true},
+		{"allowed origin", policy, "https://embed.example.com", true},
+		{"allowed whatever the case", policy, "https://other.example.com", true},
+		{"disallowed origin", policy, "https://evil.example.com", false},
+		{"allowed host on another scheme", policy, "http://embed.example.com", false},
+		{"no policy allows our own origin", nil, "http://chat.example.com", true},
+		{"no policy allows no other", nil, "https://embed.example.com", false},
+		{"development mode allows any", chatter.NewOriginPolicy("", true), "https://evil.example.com", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://chat.example.com/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := tt.policy.Allowed(r); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisallowedOriginsCantUpgrade(t *testing.T) {
+	policy := chatter.NewOriginPolicy("https://embed.example.com", false)
+	srv := httptest.NewServer(chatter.Handler(newManager(t), policy, nil))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?name=alice"
+
+	for _, tt := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://embed.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+	} {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("dialing with origin %q: %v", tt.origin, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("the handshake with origin %q got %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
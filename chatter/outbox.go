@@ -0,0 +1,91 @@
+package chatter
+
+import (
+	"time"
+)
+
+const (
+	// outboxSize is the number of frames kept for a client while it is away, the oldest go first
+	outboxSize = 50
+	// outboxTTL is how long a frame is kept for a client that is away
+	outboxTTL = 24 * time.Hour
+)
+
+// queuedFrame is a frame kept for a client that is away
+type queuedFrame struct {
+	name    string // name the frame is for (a direct message or a mention of it)
+	frame   Frame
+	expires time.Time
+}
+
+// offline returns the identity of the client that last went by the name,
+// if nobody goes by it right now (and it was used recently, see remember)
+func (h *Hub) offline(name string) (string, bool) {
+	if _, online := h.names[name]; online {
+		return "", false
+	}
+	identity, ok := h.owners[name]
+	return identity, ok
+}
+
+// enqueue keeps the frame for the client with the identity and the name until it comes back,
+// a full outbox makes room by dropping its oldest frame
+func (h *Hub) enqueue(identity, name string, frame Frame) {
+	now := h.clock.Now()
+	box := h.expireOutbox(identity, now)
+	if len(box) >= outboxSize {
+		box = box[1:]
+		h.metrics.offlineMessage("overflowed")
+	}
+	h.outbox[identity] = append(box, queuedFrame{name: name, frame: frame, expires: now.Add(outboxTTL)})
+	h.metrics.offlineMessage("queued")
+}
+
+// takeOutbox returns the frames kept for the client, in the order they were queued, and forgets them.
+// A client only gets the frames of the name it goes by, the others are kept for their own name
+func (h *Hub) takeOutbox(client *Client) []Frame {
+	identity := client.identity()
+	box := h.expireOutbox(identity, h.clock.Now())
+
+	var frames []Frame
+	var kept []queuedFrame
+	for _, queued := range box {
+		if queued.name != client.name {
+			kept = append(kept, queued)
+			continue
+		}
+		frames = append(frames, queued.frame)
+		h.metrics.offlineMessage("delivered")
+	}
+	if len(kept) == 0 {
+		delete(h.outbox, identity)
+	} else {
+		h.outbox[identity] = kept
+	}
+	return frames
+}
+
+// expireOutbox drops the frames kept for the identity that expired, and returns the others
+func (h *Hub) expireOutbox(identity string, now time.Time) []queuedFrame {
+	box := h.outbox[identity]
+	i := 0
+	for i < len(box) && now.After(box[i].expires) {
+		h.metrics.offlineMessage("expired")
+		i++
+	}
+	box = box[i:]
+	if len(box) == 0 {
+		delete(h.outbox, identity)
+		return nil
+	}
+	h.outbox[identity] = box
+	return box
+}
+
+// expireOutboxes drops the frames that expired from every outbox,
+// for the clients that never came back
+func (h *Hub) expireOutboxes(now time.Time) {
+	for identity := range h.outbox {
+		h.expireOutbox(identity, now)
+	}
+}
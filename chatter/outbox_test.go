@@ -0,0 +1,86 @@
+package chatter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// identifiedServer starts a chat whose visitors get their identity cookie, the names
+// of the clients leaving are sent to left
+func identifiedServer(t *testing.T, left chan<- string) *chattertest.Server {
+	t.Helper()
+	manager := chatter.NewHubManager(time.Hour, chatter.WithOnDisconnect(func(info chatter.ClientInfo) { left <- info.Name }))
+	ids := chatter.NewIdentities("s3cret")
+	srv := &chattertest.Server{Server: httptest.NewServer(ids.Middleware(chatter.Handler(manager, nil, nil))), Manager: manager}
+	t.Cleanup(func() {
+		manager.Close(5 * time.Second)
+		srv.Close()
+	})
+	return srv
+}
+
+// connectAs connects the client named name with the cookies, and returns the cookies it was given
+func connectAs(t *testing.T, srv *chattertest.Server, name string, cookies ...*http.Cookie) (*chattertest.Client, []*http.Cookie) {
+	t.Helper()
+	header := http.Header{}
+	for _, cookie := range cookies {
+		header.Add("Cookie", cookie.String())
+	}
+	client, resp, err := srv.Dial(t, "/ws?"+url.Values{"name": {name}}.Encode(), header)
+	if err != nil {
+		t.Fatalf("connecting %s: %v", name, err)
+	}
+	return client, resp.Cookies()
+}
+
+func TestDirectMessagesWaitForTheirRecipient(t *testing.T) {
+	left := make(chan string, 10)
+	srv := identifiedServer(t, left)
+	// leave disconnects the client and waits for the room to let go of its name
+	leave := func(client *chattertest.Client) {
+		client.Close()
+		select {
+		case <-left:
+		case <-time.After(waitTimeout):
+			t.Fatal("the client never left")
+		}
+	}
+	alice, _ := connectAs(t, srv, "alice")
+	bob, cookies := connectAs(t, srv, "bob")
+	if len(cookies) == 0 {
+		t.Fatal("bob got no identity cookie")
+	}
+	leave(bob)
+
+	for _, text := range []string{"first while away", "second while away"} {
+		alice.SendJSON(map[string]any{"text": text, "to": "bob", "HEADERS": map[string]string{"HX-Request": "true"}})
+	}
+	alice.ExpectNone("is not online", 200*time.Millisecond)
+
+	// someone else going by bob doesn't get them
+	impostor, _ := connectAs(t, srv, "bob")
+	impostor.ExpectNone("while away", 300*time.Millisecond)
+	leave(impostor)
+
+	// bob coming back with the identity cookie gets them, in order
+	bob, _ = connectAs(t, srv, "bob", cookies...)
+	var got string
+	for !strings.Contains(got, "second while away") {
+		got += bob.Next(waitTimeout)
+	}
+	if first := strings.Index(got, "first while away"); first < 0 || first > strings.Index(got, "second while away") {
+		t.Errorf("bob got the messages out of order:\n%s", got)
+	}
+
+	// and only once
+	leave(bob)
+	bob, _ = connectAs(t, srv, "bob", cookies...)
+	bob.ExpectNone("while away", 300*time.Millisecond)
+}
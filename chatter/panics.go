@@ -0,0 +1,11 @@
+package chatter
+
+import "runtime/debug"
+
+// recovered logs a panic recovered in the part of the hub named where, along with the stack
+// of the goroutine that panicked, and counts it. It must be called from the deferred function
+// that recovered
+func (h *Hub) recovered(where string, r any) {
+	h.logger.Error("recovered from a panic", "where", where, "panic", r, "stack", string(debug.Stack()))
+	h.metrics.panicRecovered(where)
+}
@@ -0,0 +1,46 @@
+package chatter_test
+
+import (
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPanickingHooksDontStopTheRoom(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := chattertest.NewServer(t,
+		chatter.WithMetrics(chatter.NewMetrics(reg)),
+		chatter.WithOnMessage(func(msg *chatter.Message) *chatter.Message {
+			if msg.Text == "boom" {
+				panic("the hook blew up")
+			}
+			return msg
+		}),
+		chatter.WithOnConnect(func(info chatter.ClientInfo) {
+			if info.Name == "crash" {
+				panic("the hook blew up")
+			}
+		}),
+	)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	bob.Expect("Online (2)", waitTimeout)
+
+	// the message the hook panicked on goes out as it was, and the next ones still do
+	alice.Send("boom")
+	bob.Expect("boom", waitTimeout)
+	alice.Send("still flowing")
+	bob.Expect("still flowing", waitTimeout)
+
+	// so do the clients the connect hook panicked on
+	crash := srv.Connect(t, "crash")
+	bob.Expect("crash joined", waitTimeout)
+	crash.Send("made it")
+	alice.Expect("made it", waitTimeout)
+
+	if panics := metricValue(t, reg, "chatter_panics_recovered_total"); panics != 2 {
+		t.Errorf("%v panics were recovered, want 2", panics)
+	}
+}
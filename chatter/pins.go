@@ -0,0 +1,176 @@
+package chatter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// maxPins is the number of messages a room can have pinned
+const maxPins = 10
+
+// pinRequest asks the hub to pin (or unpin) a message, the result is sent on done
+type pinRequest struct {
+	id     uint64 // id of the message
+	pin    bool   // whether the message is pinned or unpinned
+	client string // id of the client asking (empty for an admin, who can pin any message)
+	done   chan error
+}
+
+// Pin pins the message with the id to the top of the room, pinning it again does nothing
+func (h *Hub) Pin(id uint64) error {
+	return h.requestPin(id, true)
+}
+
+// Unpin unpins the message with the id, unpinning a message that isn't pinned does nothing
+func (h *Hub) Unpin(id uint64) error {
+	return h.requestPin(id, false)
+}
+
+// requestPin hands the pin to the hub goroutine and waits for it
+func (h *Hub) requestPin(id uint64, pin bool) error {
+	req := &pinRequest{id: id, pin: pin, done: make(chan error, 1)}
+
+	select {
+	case h.pins <- req:
+	case <-h.stop:
+		return errors.New("hub is shut down")
+	}
+	return <-req.done
+}
+
+// setPinned handles a pin request on the hub goroutine, every page is
+// sent the new pinned messages when they change
+func (h *Hub) setPinned(req *pinRequest) error {
+
+	// the pins are kept with the history
+	pins, ok := h.store.(PinStore)
+	if !ok {
+		return errors.New("pins aren't available here")
+	}
+	pinned, err := pins.Pins(h.room)
+	if err != nil {
+		h.logger.Error("reading the pins", "err", err)
+		return errors.New("could not read the pinned messages")
+	}
+
+	// clients can only pin what they sent (and only messages still there), admins anything
+	if req.client != "" || req.pin {
+		var original *Message
+		if store, ok := h.store.(EditableStore); ok {
+			if original, err = store.Get(h.room, req.id); err != nil {
+				h.logger.Error("reading message", "msg_id", req.id, "err", err)
+				return errors.New("could not read the message")
+			}
+		}
+		if original == nil || original.Deleted {
+			return ErrNoSuchMessage
+		}
+		if req.client != "" && original.ClientID != req.client {
+			return errors.New("you can only pin your own messages")
+		}
+	}
+
+	// pinning twice (or unpinning what isn't pinned) changes nothing
+	i := slices.Index(pinned, req.id)
+	switch {
+	case req.pin && i >= 0, !req.pin && i < 0:
+		return nil
+	case req.pin && len(pinned) >= maxPins:
+		return fmt.Errorf("a room can have at most %d pinned messages", maxPins)
+	case req.pin:
+		pinned = append(pinned, req.id)
+	default:
+		pinned = slices.Delete(pinned, i, i+1)
+	}
+
+	if err := pins.SetPins(h.room, pinned); err != nil {
+		h.logger.Error("storing the pins", "err", err)
+		return errors.New("could not store the pinned messages")
+	}
+
+	if rendered := h.renderPins(); rendered != nil {
+		h.sendAll(rendered, nil)
+	}
+	return nil
+}
+
+// renderPins renders the pinned messages of the room, messages that have been evicted
+// since they were pinned are left out. It returns nil if they could not be rendered
+func (h *Hub) renderPins() []byte {
+	pins, ok := h.store.(PinStore)
+	if !ok {
+		return nil
+	}
+	pinned, err := pins.Pins(h.room)
+	if err != nil {
+		h.logger.Error("reading the pins", "err", err)
+		return nil
+	}
+
+	quotes := make([]*Quote, 0, len(pinned))
+	for _, id := range pinned {
+		if quote := h.quote(id); !quote.Missing {
+			quotes = append(quotes, quote)
+		}
+	}
+	return getPinnedTemplate(quotes)
+}
+
+// pinCommand pins one of the messages of the sender ("/pin 12")
+func pinCommand(cmd *Command) {
+	pinMessage(cmd, true)
+}
+
+// unpinCommand unpins one of the messages of the sender ("/unpin 12")
+func unpinCommand(cmd *Command) {
+	pinMessage(cmd, false)
+}
+
+// pinMessage pins or unpins the message the command names for its sender
+func pinMessage(cmd *Command, pin bool) {
+	id, ok := messageID(cmd.Args)
+	if !ok {
+		cmd.Reply(fmt.Sprintf("usage: /%s <message number>", cmd.Name))
+		return
+	}
+
+	h := cmd.Message.hub
+	if err := h.setPinned(&pinRequest{id: id, pin: pin, client: cmd.Message.ClientID}); err != nil {
+		cmd.Reply(err.Error())
+	}
+}
+
+// PinHandler handles PUT /messages/{id}/pin (pin) and DELETE /messages/{id}/pin (unpin)
+// with ?room=..., it should be wrapped in AdminAuth (clients pin with /pin)
+func PinHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id, ok := messageID(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+
+		hub, err := manager.Get(roomName(r))
+		if err != nil {
+			serveRoomUnavailable(w, err)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = hub.Unpin(id)
+		} else {
+			err = hub.Pin(id)
+		}
+		switch {
+		case errors.Is(err, ErrNoSuchMessage):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package chatter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// secretHeader is the header carrying the shared secret required to post messages (if one is set)
+	secretHeader = "X-Chatter-Secret"
+	// publishTimeout is how long a posted message waits for the hub to broadcast it
+	publishTimeout = 5 * time.Second
+	// defaultBotName is the name posted messages are shown with when they don't give one
+	defaultBotName = "bot"
+)
+
+// PostMessage is what scripts and bots post to /messages, as JSON or form data
+type PostMessage struct {
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+// servePost handles POST /messages?room=general, so scripts can send messages without a websocket.
+// The message goes through the hub exactly like a websocket message and we answer 202 with its id
+func servePost(manager *HubManager, secret string, w http.ResponseWriter, r *http.Request) {
+
+	// if a secret is set the request has to carry it
+	// (we compare in constant time so the secret can't be guessed byte by byte)
+	if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+		http.Error(w, "invalid secret", http.StatusUnauthorized)
+		return
+	}
+
+	hub, err := manager.Get(roomName(r))
+	if err != nil {
+		serveRoomUnavailable(w, err)
+		return
+	}
+	// the secret doesn't open the private rooms, only their members post into them
+	if !hub.admits(r) {
+		http.Error(w, ErrNotInvited.Error(), http.StatusForbidden)
+		return
+	}
+
+	// we don't read more than a websocket message could be
+	r.Body = http.MaxBytesReader(w, r.Body, hub.config.MaxMessageSize)
+
+	post, err := decodePost(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "message too long", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+
+	// we validate the message the same way we do for websocket clients
+	text, err := validateText(post.Text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// signed in visitors post under their own name
+	from := sanitizeName(post.From)
+	if p, ok := requestPrincipal(r); ok {
+		from = p.name
+	}
+	if from == "" {
+		from = defaultBotName
+	}
+
+	id, err := hub.Publish(&Message{
+		Kind:     KindChat,
+		ClientID: "http:" + from,
+		Username: from,
+		Text:     text,
+	}, publishTimeout)
+	if err != nil {
+		slog.Error("posting message", "err", err)
+		http.Error(w, "Could not post the message", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		ID uint64 `json:"id"`
+	}{id})
+}
+
+// decodePost reads the posted message, as JSON or as form data depending on the content type
+func decodePost(r *http.Request) (*PostMessage, error) {
+
+	post := &PostMessage{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(post); err != nil {
+			return nil, err
+		}
+		return post, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	post.From = r.PostForm.Get("from")
+	post.Text = r.PostForm.Get("text")
+
+	return post, nil
+}
@@ -0,0 +1,75 @@
+package chatter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+func TestPostedMessagesReachTheWebsocketClients(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+
+	for _, post := range []struct {
+		contentType, body, text string
+	}{
+		{"application/json", `{"from":"deploy-bot","text":"release v1.2 done"}`, "release v1.2 done"},
+		{"application/x-www-form-urlencoded", url.Values{"from": {"cron"}, "text": {"backup done"}}.Encode(), "backup done"},
+	} {
+		resp, err := http.Post(srv.URL+"/messages", post.contentType, strings.NewReader(post.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var accepted struct {
+			ID uint64 `json:"id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&accepted)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted || err != nil || accepted.ID == 0 {
+			t.Fatalf("posting %s got %d with id %d (%v)", post.contentType, resp.StatusCode, accepted.ID, err)
+		}
+
+		// the fragment is the one of a websocket message, with the id it was given
+		fragment := alice.Expect(post.text, waitTimeout)
+		if want := fmt.Sprintf(`id="msg-%d"`, accepted.ID); !strings.Contains(fragment, want) {
+			t.Errorf("the fragment of message %d isn't the one of a message:\n%s", accepted.ID, fragment)
+		}
+	}
+}
+
+func TestPostRejectsBadMessages(t *testing.T) {
+	handler := chatter.PostHandler(newManager(t), "s3cret")
+
+	for _, test := range []struct {
+		name, secret, body string
+		want               int
+	}{
+		{"no secret", "", `{"text":"hi"}`, http.StatusUnauthorized},
+		{"wrong secret", "guess", `{"text":"hi"}`, http.StatusUnauthorized},
+		{"empty text", "s3cret", `{"text":"   "}`, http.StatusBadRequest},
+		{"too long", "s3cret", `{"text":"` + strings.Repeat("a", 1001) + `"}`, http.StatusBadRequest},
+		{"not JSON", "s3cret", `{"text":`, http.StatusBadRequest},
+		{"oversized", "s3cret", `{"text":"` + strings.Repeat("a", 1<<20) + `"}`, http.StatusBadRequest},
+		{"valid", "s3cret", `{"text":"hi"}`, http.StatusAccepted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.secret != "" {
+				req.Header.Set("X-Chatter-Secret", test.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("got %d %q, want %d", rec.Code, rec.Body, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package chatter
+
+import "time"
+
+const (
+	// presenceCoalesce is how long the hub waits after a client joins or leaves
+	// before broadcasting the presence list, so a burst of churn results in a single update
+	presenceCoalesce = 500 * time.Millisecond
+	// presenceInterval is the least time between two presence updates,
+	// a room that keeps churning gets one every presenceInterval
+	presenceInterval = 2 * time.Second
+)
+
+// Presence is what the presence template renders, the names of the connected clients
+type Presence struct {
+	Names []string
+	Count int
+	// RTT is the ping round-trip time of the clients that answered a ping,
+	// in milliseconds (rounded up) by name
+	RTT map[string]int
+}
+
+// schedulePresence makes sure a presence update is broadcast shortly,
+// if one is already pending we don't schedule another one
+func (h *Hub) schedulePresence() {
+	if h.presenceDue == nil {
+		h.presenceDue = time.After(presenceDelay(h.presenceAt, time.Now()))
+	}
+}
+
+// presenceDelay returns how long to wait before the next presence update if the last one
+// was broadcast at last: presenceCoalesce, or longer if it's not yet presenceInterval since then
+func presenceDelay(last, now time.Time) time.Duration {
+	return max(presenceCoalesce, last.Add(presenceInterval).Sub(now))
+}
+
+// renderPresence renders the presence list, the clients are listed in the order they joined
+func (h *Hub) renderPresence() []byte {
+
+	names := make([]string, len(h.order))
+	for i, client := range h.order {
+		names[i] = client.name
+	}
+
+	return getPresenceTemplate(&Presence{Names: names, Count: len(names), RTT: h.rttMillis()})
+}
+
+// broadcastPresence sends the presence list to every client, except the constrained and JSON ones
+func (h *Hub) broadcastPresence() {
+
+	rendered := h.renderPresence()
+	if rendered == nil {
+		return
+	}
+	h.presenceAt = time.Now()
+
+	for client := range h.clients {
+		if client.constrained || client.jsonFrames {
+			continue
+		}
+
+		// the presence list isn't worth dropping a slow client for, we just skip it
+		select {
+		case client.send <- Frame{Data: rendered}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package chatter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPresenceDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		last time.Time
+		want time.Duration
+	}{
+		{"never broadcast", time.Time{}, presenceCoalesce},
+		{"broadcast long ago", now.Add(-time.Minute), presenceCoalesce},
+		{"just broadcast", now, presenceInterval},
+		{"broadcast a second ago", now.Add(-time.Second), presenceInterval - time.Second},
+		{"almost due", now.Add(-presenceInterval + 100*time.Millisecond), presenceCoalesce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := presenceDelay(tt.last, now); got != tt.want {
+				t.Errorf("presenceDelay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresenceListsTheClientsInTheirJoinOrder(t *testing.T) {
+	hub := NewHub()
+	clients := make(map[string]*Client)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		client := pumpClient(hub, newFakeConn())
+		client.name = name
+		addClient(hub, client)
+		clients[name] = client
+	}
+	assertPresence(t, hub, "Online (3): <span>alice</span>, <span>bob</span>, <span>carol</span>")
+
+	hub.remove(clients["bob"], websocket.CloseNormalClosure, "")
+	assertPresence(t, hub, "Online (2): <span>alice</span>, <span>carol</span>")
+
+	hub.remove(clients["alice"], websocket.CloseNormalClosure, "")
+	hub.remove(clients["carol"], websocket.CloseNormalClosure, "")
+	assertPresence(t, hub, "Online (0): ")
+}
+
+// assertPresence fails the test unless the presence list of the hub shows want
+func assertPresence(t *testing.T, hub *Hub, want string) {
+	t.Helper()
+	if got := string(hub.renderPresence()); !strings.Contains(got, want) {
+		t.Errorf("the presence list doesn't show %q:\n%s", want, got)
+	}
+}
@@ -0,0 +1,66 @@
+package chatter_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+func TestPresenceIsRateLimited(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	alice.Expect("Online (1)", waitTimeout)
+
+	// the room keeps churning, alice gets at most an update every couple of seconds
+	// instead of one per join
+	start := time.Now()
+	bob := srv.Connect(t, "bob")
+	alice.Expect("Online (2)", waitTimeout)
+	bob.Close()
+	alice.Expect("Online (1)", waitTimeout)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("two presence updates came within %s", elapsed)
+	}
+}
+
+func TestConstrainedClientsGetTheLeanStream(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	lite, _, err := srv.Dial(t, "/ws?name=lite&lite=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveData, _, err := srv.Dial(t, "/ws?name=saver", http.Header{"Save-Data": {"on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob.Expect("Online (4)", waitTimeout)
+
+	// the typing indicator and the presence list only go to the full clients
+	alice.SendJSON(map[string]any{"type": "typing"})
+	bob.Expect("is typing", waitTimeout)
+
+	// and everyone gets the message, the constrained clients in its compact form
+	alice.Send("hello everyone")
+	full := bob.Expect("hello everyone", waitTimeout)
+	if !strings.Contains(full, `class="flex my-2"`) {
+		t.Errorf("the full client got the compact message:\n%s", full)
+	}
+	for _, client := range []*chattertest.Client{lite, saveData} {
+		compact := client.Expect("hello everyone", waitTimeout)
+		if !strings.Contains(compact, "<b>alice</b>") || strings.Contains(compact, `class="flex my-2"`) {
+			t.Errorf("the constrained client didn't get the compact message:\n%s", compact)
+		}
+		client.ExpectNone("is typing", 100*time.Millisecond)
+	}
+
+	bob.Close()
+	alice.Expect("Online (3)", waitTimeout)
+	for _, client := range []*chattertest.Client{lite, saveData} {
+		client.ExpectNone("Online (", 100*time.Millisecond)
+	}
+}
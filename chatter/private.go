@@ -0,0 +1,398 @@
+package chatter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// grantCookiePrefix names the cookie the members of a private room get in with ("chatter_room_<room>")
+	grantCookiePrefix = "chatter_room_"
+	// grantMaxAge is how long the browser keeps the cookie, the grant itself lasts as long as the room
+	grantMaxAge = 30 * 24 * time.Hour
+	// privateRoomTTL is how long a private room stays once everyone left, it goes with its
+	// invites and its members' grants (the visitors' rooms would pile up otherwise)
+	privateRoomTTL = 7 * 24 * time.Hour
+	// maxPrivateRooms is how many private rooms can be open at once,
+	// maxPrivatePerOwner how many of them a visitor can own
+	maxPrivateRooms    = 1000
+	maxPrivatePerOwner = 5
+)
+
+var (
+	// ErrRoomExists is returned when creating a room that is already open
+	ErrRoomExists = errors.New("room already exists")
+	// ErrNotPrivate is returned when asking for the invites of a room anyone can join
+	ErrNotPrivate = errors.New("room isn't private")
+	// ErrNotInvited is what the visitors of a private room without an invite are told
+	ErrNotInvited = errors.New("this room is private, it takes an invite to get in")
+	// ErrTooManyRooms is returned when creating a private room past the limits (see CreatePrivate)
+	ErrTooManyRooms = errors.New("too many private rooms")
+)
+
+// Invite lets whoever follows its link into a private room (and back in later, see letIn),
+// until it expires, runs out of uses or is revoked
+type Invite struct {
+	Token   string    `json:"token"`
+	Room    string    `json:"room"`
+	URL     string    `json:"url"` // the link to hand out, the page of the room with the token
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`  // zero if it doesn't expire
+	MaxUses int       `json:"max_uses"` // how many visitors it lets in (0 for as many as follow it)
+	Uses    int       `json:"uses"`     // how many it let in so far
+}
+
+// roomAccess is who gets into a private room: its owner, the visitors who followed one
+// of its invites and whoever follows one next
+type roomAccess struct {
+	sync.Mutex
+	owner   string             // identity of the visitor who created the room
+	invites map[string]*Invite // the invites by token
+	members map[string]bool    // the grants of the visitors who got in, kept in their cookie
+}
+
+// withAccess makes the room private, owned by the identity. Its history is kept in memory
+// and it isn't shared with the other instances: whoever opens a room of the same name after
+// a restart (or on another instance) shouldn't read it
+func withAccess(owner string) Option {
+	return func(h *Hub) {
+		h.access = &roomAccess{owner: owner, invites: make(map[string]*Invite), members: make(map[string]bool)}
+		h.private.Store(true)
+		h.store = NewMemoryStore(DefaultHistoryCapacity)
+		h.bridge = nil
+	}
+}
+
+// CreatePrivate opens the room as a private room owned by the identity: it isn't listed in
+// the directory and only lets in the owner and the visitors it invites (see CreateInvite).
+// It returns ErrRoomExists if the room is already open, and there's only one default room.
+// A visitor only owns so many private rooms, and only so many are open at once: past
+// that it returns ErrTooManyRooms until some have expired (see collect)
+func (m *HubManager) CreatePrivate(room, owner string) (*Hub, error) {
+	if err := ValidateRoomName(room); err != nil {
+		return nil, err
+	}
+	if room == DefaultRoom {
+		return nil, ErrRoomExists
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := m.hubs[room]; ok {
+		return nil, ErrRoomExists
+	}
+	private, owned := 0, 0
+	for _, hub := range m.hubs {
+		if hub.access != nil {
+			private++
+			if hub.access.owner == owner {
+				owned++
+			}
+		}
+	}
+	if private >= m.maxPrivate || owned >= m.maxPrivatePerOwner {
+		return nil, ErrTooManyRooms
+	}
+	return m.start(room, withAccess(owner)), nil
+}
+
+// revokeAll drops the invites and the grants of the room, once it is removed nobody gets
+// into it anymore (nor into the room opened next with its name)
+func (a *roomAccess) revokeAll() {
+	a.Lock()
+	defer a.Unlock()
+	clear(a.invites)
+	clear(a.members)
+}
+
+// CreateInvite creates an invite to the private room, it expires after ttl (never if zero)
+// and lets in up to maxUses visitors (as many as follow it if zero)
+func (h *Hub) CreateInvite(ttl time.Duration, maxUses int) (*Invite, error) {
+	if h.access == nil {
+		return nil, ErrNotPrivate
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &Invite{
+		Token:   token,
+		Room:    h.room,
+		URL:     "/r/" + url.PathEscape(h.room) + "?invite=" + token,
+		Created: h.clock.Now(),
+		MaxUses: max(maxUses, 0),
+	}
+	if ttl > 0 {
+		invite.Expires = invite.Created.Add(ttl)
+	}
+
+	h.access.Lock()
+	defer h.access.Unlock()
+	h.access.invites[token] = invite
+	copied := *invite
+	return &copied, nil
+}
+
+// RevokeInvite revokes the invite, it returns false if the room has no such invite.
+// The visitors it already let in stay members
+func (h *Hub) RevokeInvite(token string) bool {
+	if h.access == nil {
+		return false
+	}
+	h.access.Lock()
+	defer h.access.Unlock()
+	if _, ok := h.access.invites[token]; !ok {
+		return false
+	}
+	delete(h.access.invites, token)
+	return true
+}
+
+// Invites returns the invites of the private room, the oldest first
+func (h *Hub) Invites() []Invite {
+	if h.access == nil {
+		return nil
+	}
+	h.access.Lock()
+	invites := make([]Invite, 0, len(h.access.invites))
+	for _, invite := range h.access.invites {
+		invites = append(invites, *invite)
+	}
+	h.access.Unlock()
+
+	sort.Slice(invites, func(i, j int) bool { return invites[i].Created.Before(invites[j].Created) })
+	return invites
+}
+
+// admits tells whether the request gets into the room: anyone gets into a room that isn't
+// private, admins, the owner and the members (by their cookie) into the private ones
+func (h *Hub) admits(r *http.Request) bool {
+	if h.access == nil || requestAdmin(r) {
+		return true
+	}
+	cookie, err := r.Cookie(grantCookiePrefix + h.room)
+
+	h.access.Lock()
+	defer h.access.Unlock()
+	if identity := requestIdentity(r); identity != "" && identity == h.access.owner {
+		return true
+	}
+	return err == nil && h.access.members[cookie.Value]
+}
+
+// letIn tells whether the request gets into the room (see admits), a visitor who isn't
+// a member yet gets in with the invite in ?invite= and becomes one: it takes a use of the
+// invite, and the visitor gets the cookie they're let in with from then on. If it doesn't
+// get in it returns why
+func (h *Hub) letIn(w http.ResponseWriter, r *http.Request) (bool, string) {
+	if h.admits(r) {
+		return true, ""
+	}
+	token := r.URL.Query().Get("invite")
+	if token == "" {
+		return false, ErrNotInvited.Error()
+	}
+	grant, err := randomToken()
+	if err != nil {
+		return false, "we couldn't let you in, try again"
+	}
+
+	h.access.Lock()
+	defer h.access.Unlock()
+	invite, ok := h.access.invites[token]
+	switch {
+	case !ok:
+		return false, "the invite isn't valid (anymore), ask for another one"
+	case !invite.Expires.IsZero() && !h.clock.Now().Before(invite.Expires):
+		return false, "the invite has expired, ask for another one"
+	case invite.MaxUses > 0 && invite.Uses >= invite.MaxUses:
+		return false, "the invite has been used up, ask for another one"
+	}
+	invite.Uses++
+	h.access.members[grant] = true
+	setGrant(w, r, h.room, grant)
+	return true, ""
+}
+
+// grant makes the visitor a member of the private room, the creator of the room gets in
+// with it like the visitors it invites
+func (h *Hub) grant(w http.ResponseWriter, r *http.Request) error {
+	grant, err := randomToken()
+	if err != nil {
+		return err
+	}
+	h.access.Lock()
+	h.access.members[grant] = true
+	h.access.Unlock()
+	setGrant(w, r, h.room, grant)
+	return nil
+}
+
+// setGrant gives the visitor the cookie it gets into the room with
+func setGrant(w http.ResponseWriter, r *http.Request, room, grant string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     grantCookiePrefix + room,
+		Value:    grant,
+		Path:     "/",
+		MaxAge:   int(grantMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomToken returns a random token, for the invites and the grants
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// PrivateRoomRequest is the body of POST /rooms
+type PrivateRoomRequest struct {
+	Room string `json:"room"`
+}
+
+// PrivateRoomHandler handles POST /rooms, creating a private room owned by the visitor
+// (see CreatePrivate), who gets into it from then on. A form is sent to the room, JSON clients
+// get it back with its page. It has to be behind Identities.Middleware
+func PrivateRoomHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &PrivateRoomRequest{}
+		isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+		if isJSON {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Room = r.FormValue("room")
+		}
+
+		owner := requestIdentity(r)
+		if owner == "" {
+			http.Error(w, "creating a private room takes an identity", http.StatusUnauthorized)
+			return
+		}
+		hub, err := manager.CreatePrivate(strings.TrimSpace(req.Room), owner)
+		switch {
+		case errors.Is(err, ErrInvalidRoom):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrRoomExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrTooManyRooms):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if err := hub.grant(w, r); err != nil {
+			hub.logger.Error("granting the owner", "err", err)
+		}
+
+		info := hub.roomInfo()
+		if !isJSON {
+			http.Redirect(w, r, info.URL, http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	})
+}
+
+// InviteRequest is the body of POST /rooms/{room}/invites, Expires is a duration (e.g. 24h,
+// empty for an invite that doesn't expire) and MaxUses zero lets in as many as follow it
+type InviteRequest struct {
+	Expires string `json:"expires"`
+	MaxUses int    `json:"max_uses"`
+}
+
+// InvitesHandler manages the invites of the private rooms, for their owner (or an admin):
+// GET /rooms/{room}/invites lists them, POST /rooms/{room}/invites creates one and
+// DELETE /rooms/{room}/invites/{token} revokes one. It has to be behind Identities.Middleware
+func InvitesHandler(manager *HubManager, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub := manager.lookup(r.PathValue("room"))
+		if hub == nil || hub.access == nil {
+			http.Error(w, "no such private room", http.StatusNotFound)
+			return
+		}
+		if identity := requestIdentity(r); !isAdmin(adminToken, r) && (identity == "" || identity != hub.access.owner) {
+			http.Error(w, "only the owner of the room manages its invites", http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(hub.Invites())
+
+		case http.MethodPost:
+			req := &InviteRequest{}
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+				if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+					http.Error(w, "invalid request", http.StatusBadRequest)
+					return
+				}
+			} else {
+				req.Expires = r.FormValue("expires")
+				if value := r.FormValue("max_uses"); value != "" {
+					n, err := strconv.Atoi(value)
+					if err != nil {
+						http.Error(w, "max_uses must be a number", http.StatusBadRequest)
+						return
+					}
+					req.MaxUses = n
+				}
+			}
+			var ttl time.Duration
+			if req.Expires != "" {
+				var err error
+				if ttl, err = time.ParseDuration(req.Expires); err != nil || ttl <= 0 {
+					http.Error(w, "expires must be a positive duration (e.g. 24h)", http.StatusBadRequest)
+					return
+				}
+			}
+			if req.MaxUses < 0 {
+				http.Error(w, "max_uses can't be negative", http.StatusBadRequest)
+				return
+			}
+
+			invite, err := hub.CreateInvite(ttl, req.MaxUses)
+			if err != nil {
+				hub.logger.Error("creating an invite", "err", err)
+				http.Error(w, "Could not create the invite", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(invite)
+
+		case http.MethodDelete:
+			if !hub.RevokeInvite(r.PathValue("token")) {
+				http.Error(w, "no such invite", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
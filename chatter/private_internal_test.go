@@ -0,0 +1,203 @@
+package chatter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// visitor is a browser visiting private rooms, it keeps the cookies it is given
+type visitor struct {
+	cookies []*http.Cookie
+}
+
+// follow visits the page of the room with the invite (none if empty), and tells whether the
+// visitor got in and why not
+func (v *visitor) follow(hub *Hub, invite string) (bool, string) {
+	target := "/r/" + hub.room
+	if invite != "" {
+		target += "?invite=" + invite
+	}
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range v.cookies {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	ok, reason := hub.letIn(rec, r)
+	v.cookies = append(v.cookies, rec.Result().Cookies()...)
+	return ok, reason
+}
+
+// privateRoom opens a private room owned by owner-id, its invites are timed by the clock
+func privateRoom(t *testing.T, clock Clock) *Hub {
+	t.Helper()
+	manager := NewHubManager(time.Hour, WithClock(clock))
+	t.Cleanup(func() { manager.Close(time.Second) })
+	hub, err := manager.CreatePrivate("secret", "owner-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub
+}
+
+func TestInvitesExpire(t *testing.T) {
+	clock := newFakeClock()
+	hub := privateRoom(t, clock)
+	if ok, reason := new(visitor).follow(hub, ""); ok || reason != ErrNotInvited.Error() {
+		t.Fatalf("a visitor without an invite got %v %q", ok, reason)
+	}
+
+	invite, err := hub.CreateInvite(time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := new(visitor), new(visitor)
+	clock.Advance(time.Hour - time.Second)
+	if ok, reason := alice.follow(hub, invite.Token); !ok {
+		t.Fatalf("alice wasn't let in before the invite expired: %s", reason)
+	}
+	clock.Advance(time.Second)
+	if ok, reason := bob.follow(hub, invite.Token); ok || reason != "the invite has expired, ask for another one" {
+		t.Errorf("bob got %v %q once the invite expired", ok, reason)
+	}
+	// the members it let in stay members
+	if ok, _ := alice.follow(hub, ""); !ok {
+		t.Error("alice was turned away once the invite expired")
+	}
+}
+
+func TestInvitesRunOutOfUses(t *testing.T) {
+	hub := privateRoom(t, newFakeClock())
+	invite, err := hub.CreateInvite(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob, carol := new(visitor), new(visitor), new(visitor)
+	for _, v := range []*visitor{alice, bob} {
+		if ok, reason := v.follow(hub, invite.Token); !ok {
+			t.Fatalf("the invite didn't let a visitor in: %s", reason)
+		}
+	}
+	// coming back takes no use of the invite, the cookie lets them in
+	if ok, _ := alice.follow(hub, invite.Token); !ok {
+		t.Error("alice didn't get back in")
+	}
+	if ok, reason := carol.follow(hub, invite.Token); ok || reason != "the invite has been used up, ask for another one" {
+		t.Errorf("carol got %v %q with the invite used up", ok, reason)
+	}
+	if invites := hub.Invites(); len(invites) != 1 || invites[0].Uses != 2 || invites[0].MaxUses != 2 {
+		t.Errorf("the invites are %+v", invites)
+	}
+}
+
+func TestRevokedInvitesLetNobodyElseIn(t *testing.T) {
+	clock := newFakeClock()
+	hub := privateRoom(t, clock)
+	invite, err := hub.CreateInvite(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := new(visitor)
+	if ok, reason := alice.follow(hub, invite.Token); !ok {
+		t.Fatalf("alice wasn't let in: %s", reason)
+	}
+	conn := newFakeConn()
+	startClient(t, hub, conn, "alice")
+	waitWritten(t, conn, "alice")
+
+	if !hub.RevokeInvite(invite.Token) {
+		t.Fatal("the invite wasn't revoked")
+	}
+	if hub.RevokeInvite(invite.Token) {
+		t.Error("the invite was revoked twice")
+	}
+	if ok, reason := new(visitor).follow(hub, invite.Token); ok || reason != "the invite isn't valid (anymore), ask for another one" {
+		t.Errorf("bob got %v %q with a revoked invite", ok, reason)
+	}
+
+	// alice is still inside, and gets back in
+	if conn.Closed() || hub.Stats().Clients != 1 {
+		t.Error("alice was disconnected")
+	}
+	if ok, _ := alice.follow(hub, ""); !ok {
+		t.Error("alice was turned away once the invite was revoked")
+	}
+}
+
+func TestPrivateRoomsAreCapped(t *testing.T) {
+	manager := NewHubManager(time.Hour)
+	defer manager.Close(time.Second)
+	manager.maxPrivate, manager.maxPrivatePerOwner = 3, 2
+
+	for _, tt := range []struct {
+		room, owner string
+		err         error
+	}{
+		{"alice-1", "alice", nil},
+		{"alice-2", "alice", nil},
+		{"alice-3", "alice", ErrTooManyRooms},
+		{"bob-1", "bob", nil},
+		{"carol-1", "carol", ErrTooManyRooms},
+	} {
+		if _, err := manager.CreatePrivate(tt.room, tt.owner); !errors.Is(err, tt.err) {
+			t.Errorf("creating %s returned %v, want %v", tt.room, err, tt.err)
+		}
+	}
+	// the rooms anyone can join don't count
+	if _, err := manager.Get("lobby"); err != nil {
+		t.Errorf("opening the lobby returned %v", err)
+	}
+}
+
+func TestEmptyPrivateRoomsExpire(t *testing.T) {
+	manager := NewHubManager(time.Hour)
+	defer manager.Close(time.Second)
+	manager.maxPrivatePerOwner = 1
+	hub, err := manager.CreatePrivate("secret", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, err := hub.CreateInvite(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := new(visitor)
+	if ok, reason := bob.follow(hub, invite.Token); !ok {
+		t.Fatalf("bob wasn't let in: %s", reason)
+	}
+
+	// it stays longer than the rooms anyone can join
+	manager.collect()
+	if manager.lookup("secret") != hub {
+		t.Fatal("the private room was removed before it expired")
+	}
+	manager.privateTTL = 0
+	manager.collect()
+	if manager.lookup("secret") != nil {
+		t.Fatal("the private room didn't expire")
+	}
+
+	// its invites and grants went with it, and its owner can open another one
+	if ok, _ := bob.follow(hub, ""); ok {
+		t.Error("bob's grant outlived the room")
+	}
+	if len(hub.Invites()) != 0 {
+		t.Errorf("the invites outlived the room: %+v", hub.Invites())
+	}
+	again, err := manager.CreatePrivate("secret", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := bob.follow(again, ""); ok {
+		t.Error("bob got into the room opened next with the name")
+	}
+	if ok, _ := new(visitor).follow(again, invite.Token); ok {
+		t.Error("the invite got into the room opened next with the name")
+	}
+	if _, err := manager.CreatePrivate("other", "alice"); err != nil {
+		t.Errorf("alice couldn't open another room: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package chatter
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// what the profanity filter does with the messages it catches
+const (
+	FilterMask   = "mask"   // the offending words are replaced with asterisks
+	FilterReject = "reject" // the message is dropped and the sender is told why
+)
+
+// minInnerWord is the length a word of the list needs to be caught inside other words
+const minInnerWord = 4
+
+// leet are the characters commonly used in place of letters to get past filters
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// ProfanityFilter catches the words of a list in the messages, whatever their case,
+// with letters repeated ("baaad") or swapped for look-alikes ("b4d"). Words on the
+// allow-list are never caught, even if they contain a word of the list
+type ProfanityFilter struct {
+	sync.RWMutex
+	path    string          // file the words are read from
+	mode    string          // FilterMask or FilterReject
+	words   []string        // normalized words to catch
+	allowed map[string]bool // lower case words that are never caught
+}
+
+// NewProfanityFilter creates a filter with the words of the file at path, see Reload for its format
+func NewProfanityFilter(path, mode string) (*ProfanityFilter, error) {
+	if mode != FilterMask && mode != FilterReject {
+		return nil, fmt.Errorf("unknown filter mode %q", mode)
+	}
+
+	f := &ProfanityFilter{path: path, mode: mode}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the words again. The file has a word per line, lines starting with "!"
+// are allowed words (e.g. "!scunthorpe") and lines starting with "#" are comments
+func (f *ProfanityFilter) Reload() error {
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var words []string
+	allowed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "!"):
+			allowed[strings.TrimSpace(line[1:])] = true
+		default:
+			if word := normalizeWord(line); word != "" {
+				words = append(words, word)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.Lock()
+	f.words, f.allowed = words, allowed
+	f.Unlock()
+
+	slog.Info("profanity filter loaded", "words", len(words), "allowed", len(allowed))
+	return nil
+}
+
+// normalizeWord undoes the usual tricks: case, look-alike characters and repeated letters
+func normalizeWord(word string) string {
+	word = leet.Replace(strings.ToLower(word))
+
+	var b strings.Builder
+	var last rune
+	for _, r := range word {
+		if r == last {
+			continue
+		}
+		last = r
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isWordRune reports whether r is part of a word for the filter (look-alikes included)
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@' || r == '$'
+}
+
+// offensive reports whether the word should be caught
+func (f *ProfanityFilter) offensive(word string) bool {
+	if f.allowed[strings.ToLower(word)] {
+		return false
+	}
+
+	// short words are only caught on their own, inside longer words they
+	// are far more likely to be part of something innocent
+	normalized := normalizeWord(word)
+	for _, bad := range f.words {
+		if normalized == bad || (len(bad) >= minInnerWord && strings.Contains(normalized, bad)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the text with the offending words masked, and whether there were any
+func (f *ProfanityFilter) filter(text string) (string, bool) {
+	f.RLock()
+	defer f.RUnlock()
+
+	var b strings.Builder
+	caught := false
+	for len(text) > 0 {
+		// we copy anything that isn't part of a word as it is
+		end := strings.IndexFunc(text, isWordRune)
+		if end < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:end])
+		text = text[end:]
+
+		// and check the words one by one
+		end = strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+
+		if f.offensive(word) {
+			caught = true
+			word = strings.Repeat("*", utf8.RuneCountInString(word))
+		}
+		b.WriteString(word)
+	}
+	return b.String(), caught
+}
+
+// Middleware is the middleware applying the filter to every message,
+// a nil filter (the filter disabled) lets every message through as it is
+func (f *ProfanityFilter) Middleware() MessageMiddleware {
+	if f == nil {
+		return func(next MessageHandler) MessageHandler { return next }
+	}
+	return func(next MessageHandler) MessageHandler {
+		return func(msg *Message) {
+			masked, caught := f.filter(msg.Text)
+			if caught && f.mode == FilterReject {
+				msg.Reply("your message contains words that aren't allowed here")
+				return
+			}
+			msg.Text = masked
+			next(msg)
+		}
+	}
+}
+
+// ReloadHandler handles POST /admin/profanity/reload, recorded in the audit log.
+// It should be wrapped in AdminAuth
+func (f *ProfanityFilter) ReloadHandler(audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := f.Reload()
+		audit.record(auditActor(r), AuditFilterReload, "", f.path, "", err)
+		if err != nil {
+			slog.Error("reloading the profanity filter", "err", err)
+			http.Error(w, "Could not reload the word list", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
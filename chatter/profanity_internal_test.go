@@ -0,0 +1,79 @@
+package chatter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newFilter returns a filter in the mode with the lines as its word list
+func newFilter(t *testing.T, mode string, lines string) *ProfanityFilter {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewProfanityFilter(path, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestProfanityFilter(t *testing.T) {
+	filter := newFilter(t, FilterMask, "# the words\nbad\nshoot\n!shootout\n")
+
+	tests := []struct {
+		name, text, want string
+	}{
+		{"clean", "a good day", "a good day"},
+		{"word", "a bad day", "a *** day"},
+		{"upper case", "a BAD day", "a *** day"},
+		{"repeated letters", "a baaaad day", "a ****** day"},
+		{"look-alikes", "a b4d day, b@d", "a *** day, ***"},
+		{"punctuation around", "bad! (bad)", "***! (***)"},
+		{"short words inside others", "a badge", "a badge"},
+		{"long words inside others", "bullshooting", "************"},
+		{"allowed words", "a ShootOut", "a ShootOut"},
+		{"everything but the words kept", "  bad\tbad  ", "  ***\t***  "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, caught := filter.filter(tt.text)
+			if got != tt.want || caught != (tt.text != tt.want) {
+				t.Errorf("filter(%q) = %q, %v, want %q", tt.text, got, caught, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfanityFilterModes(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		filter *ProfanityFilter
+		others string // what the others get
+		sender string // what the sender gets told
+	}{
+		{"mask", newFilter(t, FilterMask, "bad\n"), "a *** day", ""},
+		{"reject", newFilter(t, FilterReject, "bad\n"), "", "words that aren"},
+		{"disabled", nil, "a bad day", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHub(WithMiddleware(tt.filter.Middleware()))
+			sender, other := pumpClient(hub, newFakeConn()), pumpClient(hub, newFakeConn())
+			sender.name, other.name = "alice", "bob"
+			addClient(hub, sender)
+			addClient(hub, other)
+
+			hub.handle(&Message{Kind: KindChat, ClientID: sender.id, Username: "alice", Text: "a bad day", hub: hub})
+			frames := bytes.Join(drain(other), nil)
+			if (tt.others == "") != (len(frames) == 0) || !bytes.Contains(frames, []byte(tt.others)) {
+				t.Errorf("the others got %q, want %q", frames, tt.others)
+			}
+			if told := bytes.Join(drain(sender), nil); tt.sender != "" && !bytes.Contains(told, []byte(tt.sender)) {
+				t.Errorf("the sender got %q, want %q", told, tt.sender)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package chatter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies we take the address of the clients from: behind one
+// every connection comes from the proxy, which tells us who it forwards in X-Forwarded-For
+// or X-Real-IP. Anyone can send the headers, so we only look at them when the request came
+// from one of our proxies
+type TrustedProxies struct {
+	nets []*net.IPNet // networks of the proxies
+}
+
+// NewTrustedProxies creates the trusted proxies of the comma separated CIDRs
+// (e.g. "10.0.0.0/8,fd00::/8"), a plain address is a single proxy
+func NewTrustedProxies(cidrs string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an address or a CIDR", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an address or a CIDR", cidr)
+		}
+		p.nets = append(p.nets, network)
+	}
+	return p, nil
+}
+
+// trusted tells whether the address is one of our proxies
+func (p *TrustedProxies) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range p.nets {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware makes the address of the request the one of the client the proxy forwards,
+// everything after it (bans, limits, logs, ...) then sees the client. Nil proxies leave
+// every request with the address it came from
+func (p *TrustedProxies) Middleware(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.resolve(r); ip != "" {
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolve returns the address of the client the request was forwarded for, empty if it
+// didn't come from one of our proxies (or they didn't say). In X-Forwarded-For every proxy
+// adds the address it got the request from, so we walk it from the right and stop at the
+// first address that isn't one of ours: anything to its left was sent by the client
+func (p *TrustedProxies) resolve(r *http.Request) string {
+	if !p.trusted(clientIP(r)) {
+		return ""
+	}
+
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		hops = strings.Split(strings.Join(hops, ","), ",")
+		ip := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := normalizeIP(hops[i])
+			if hop == "" {
+				// our proxy wouldn't add garbage, the client did: the last good hop is all we know
+				break
+			}
+			ip = hop
+			if !p.trusted(hop) {
+				break
+			}
+		}
+		if ip != "" {
+			return ip
+		}
+	}
+	return normalizeIP(r.Header.Get("X-Real-IP"))
+}
@@ -0,0 +1,69 @@
+package chatter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8, fd00::/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		peer      string   // the address the request came from
+		forwarded []string // the X-Forwarded-For headers
+		realIP    string   // the X-Real-IP header
+		want      string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"forwarded", "10.0.0.1:1234", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		{"real ip", "10.0.0.1:1234", nil, "203.0.113.5", "203.0.113.5"},
+		{"forwarded first", "10.0.0.1:1234", []string{"203.0.113.5"}, "198.51.100.7", "203.0.113.5"},
+		{"single proxy", "192.0.2.1:1234", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"203.0.113.5, 10.1.1.1, 10.2.2.2"}, "", "203.0.113.5"},
+		{"split headers", "10.0.0.1:1234", []string{"203.0.113.5", "10.1.1.1"}, "", "203.0.113.5"},
+		{"proxies all the way", "10.0.0.1:1234", []string{"10.1.1.1"}, "", "10.1.1.1"},
+		{"ipv6 client", "10.0.0.1:1234", []string{"2001:DB8::1"}, "", "2001:db8::1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"[2001:db8::1]"}, "", "2001:db8::1"},
+		{"mapped ipv4", "10.0.0.1:1234", []string{"::ffff:203.0.113.5"}, "", "203.0.113.5"},
+		{"nothing forwarded", "10.0.0.1:1234", nil, "", "10.0.0.1"},
+		// the client can write anything to the left of what our proxy added
+		{"spoofed hop", "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.5"}, "", "203.0.113.5"},
+		{"spoofed proxy hop", "10.0.0.1:1234", []string{"203.0.113.5, 10.9.9.9, 198.51.100.7"}, "", "198.51.100.7"},
+		{"garbage hop", "10.0.0.1:1234", []string{"<script>, 203.0.113.5"}, "", "203.0.113.5"},
+		{"garbage last", "10.0.0.1:1234", []string{"203.0.113.5, nonsense"}, "", "10.0.0.1"},
+		// and only our proxies are listened to
+		{"untrusted forwarded", "203.0.113.5:1234", []string{"1.2.3.4"}, "", "203.0.113.5"},
+		{"untrusted real ip", "203.0.113.5:1234", nil, "1.2.3.4", "203.0.113.5"},
+		{"untrusted ipv6", "[2001:db8::2]:1234", []string{"1.2.3.4"}, "1.2.3.4", "2001:db8::2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := proxies.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			req.RemoteAddr = tt.peer
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("the client is %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not an address", "10.0.0"} {
+		if _, err := NewTrustedProxies(bad); err == nil {
+			t.Errorf("%q was taken as a proxy", bad)
+		}
+	}
+}
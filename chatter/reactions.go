@@ -0,0 +1,114 @@
+package chatter
+
+// DefaultReactions are the emoji clients can react with unless WithReactions says otherwise
+var DefaultReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// Reaction is an emoji clients reacted to a message with
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	Clients []string `json:"clients"` // ids of the clients that reacted, in the order they did
+}
+
+// reactRequest toggles the reaction of a client to a message
+type reactRequest struct {
+	client *Client
+	id     uint64 // id of the message
+	emoji  string
+}
+
+// WithReactions sets the emoji clients can react to messages with,
+// none disables reactions
+func WithReactions(emoji ...string) Option {
+	return func(h *Hub) {
+		h.reactions = emoji
+	}
+}
+
+// allowedReaction reports whether clients can react with the emoji
+func (h *Hub) allowedReaction(emoji string) bool {
+	for _, allowed := range h.reactions {
+		if allowed == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleReaction adds the reaction of the client to the message, or takes it back if it was
+// there already, and updates the reactions under the message on every page.
+// Reactions are not shared with the other instances (see Bridge)
+func (h *Hub) toggleReaction(req *reactRequest) {
+
+	if !h.allowedReaction(req.emoji) {
+		h.sendNotice(req.client, "you can't react with that")
+		return
+	}
+
+	// the reactions are kept with the message, so we need a store we can change
+	store, ok := h.store.(EditableStore)
+	if !ok {
+		h.sendNotice(req.client, "reactions aren't available here")
+		return
+	}
+
+	original, err := store.Get(h.room, req.id)
+	if err != nil {
+		h.logger.Error("reading message", "msg_id", req.id, "err", err)
+		h.sendNotice(req.client, "could not react to the message")
+		return
+	}
+	if original == nil || original.Deleted || (original.Kind != KindChat && original.Kind != "") {
+		h.sendNotice(req.client, "no such message")
+		return
+	}
+
+	// we change a copy, the original may still be read by other goroutines
+	reacted := *original
+	reacted.Reactions = toggled(original.Reactions, req.emoji, req.client.id)
+	if err := store.Update(h.room, &reacted); err != nil {
+		h.logger.Error("storing message", "msg_id", reacted.ID, "err", err)
+		h.sendNotice(req.client, "could not react to the message")
+		return
+	}
+
+	if rendered := getReactionsTemplate(&reacted); rendered != nil {
+		h.sendAll(rendered, nil)
+	}
+}
+
+// toggled returns a copy of the reactions with the reaction of the client added,
+// or removed if it was there already (an emoji nobody reacts with anymore is removed)
+func toggled(reactions []Reaction, emoji, client string) []Reaction {
+
+	result := make([]Reaction, 0, len(reactions)+1)
+	found := false
+	for _, reaction := range reactions {
+		if reaction.Emoji != emoji {
+			result = append(result, reaction)
+			continue
+		}
+
+		found = true
+		clients := make([]string, 0, len(reaction.Clients)+1)
+		reacted := false
+		for _, id := range reaction.Clients {
+			if id == client {
+				reacted = true
+				continue
+			}
+			clients = append(clients, id)
+		}
+		if !reacted {
+			clients = append(clients, client)
+		}
+		if len(clients) > 0 {
+			result = append(result, Reaction{Emoji: emoji, Clients: clients})
+		}
+	}
+
+	// the first reaction with the emoji
+	if !found {
+		result = append(result, Reaction{Emoji: emoji, Clients: []string{client}})
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestToggled(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions []Reaction
+		emoji     string
+		client    string
+		want      string
+	}{
+		{"first reaction", nil, "👍", "alice", "[{👍 [alice]}]"},
+		{"another client", []Reaction{{"👍", []string{"alice"}}}, "👍", "bob", "[{👍 [alice bob]}]"},
+		{"another emoji", []Reaction{{"👍", []string{"alice"}}}, "🎉", "alice", "[{👍 [alice]} {🎉 [alice]}]"},
+		{"toggled off", []Reaction{{"👍", []string{"alice", "bob"}}}, "👍", "alice", "[{👍 [bob]}]"},
+		{"last one off", []Reaction{{"👍", []string{"alice"}}, {"🎉", []string{"bob"}}}, "👍", "alice", "[{🎉 [bob]}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := fmt.Sprint(tt.reactions)
+			if got := fmt.Sprint(toggled(tt.reactions, tt.emoji, tt.client)); got != tt.want {
+				t.Errorf("toggled() = %s, want %s", got, tt.want)
+			}
+			// the reactions of the stored message are left alone
+			if after := fmt.Sprint(tt.reactions); after != before {
+				t.Errorf("the reactions were changed to %s", after)
+			}
+		})
+	}
+}
+
+func TestReactionsAreAggregated(t *testing.T) {
+	hub := NewHub()
+	alice, bob := pumpClient(hub, newFakeConn()), pumpClient(hub, newFakeConn())
+	addClient(hub, alice)
+	addClient(hub, bob)
+	msg := &Message{Kind: KindChat, ClientID: alice.id, Username: "alice", Text: "hello"}
+	hub.broadcastMessage(msg)
+	drain(alice)
+	drain(bob)
+
+	// react toggles the reaction of the client and returns what bob was sent
+	react := func(client *Client, id uint64, emoji string) string {
+		t.Helper()
+		hub.toggleReaction(&reactRequest{client: client, id: id, emoji: emoji})
+		if client != bob {
+			drain(client)
+		}
+		return string(bytes.Join(drain(bob), nil))
+	}
+
+	strip := fmt.Sprintf(`id="reactions-%d"`, msg.ID)
+	if frame := react(alice, msg.ID, "👍"); !strings.Contains(frame, strip) || !strings.Contains(frame, "👍 1") {
+		t.Errorf("the first reaction went out as:\n%s", frame)
+	}
+	if frame := react(bob, msg.ID, "👍"); !strings.Contains(frame, "👍 2") {
+		t.Errorf("the second reaction went out as:\n%s", frame)
+	}
+	react(bob, msg.ID, "🎉")
+	if frame := react(alice, msg.ID, "👍"); !strings.Contains(frame, "👍 1") || !strings.Contains(frame, "🎉 1") {
+		t.Errorf("taking a reaction back went out as:\n%s", frame)
+	}
+
+	// the history has the reactions as they are now, for the replays
+	stored, err := hub.store.(EditableStore).Get(hub.room, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(stored.Reactions), fmt.Sprintf("[{👍 [%[1]s]} {🎉 [%[1]s]}]", bob.id); got != want {
+		t.Errorf("the stored reactions are %s", got)
+	}
+
+	// the emoji that aren't allowed and the unknown messages only get the client an error
+	for _, req := range []*reactRequest{{client: alice, id: msg.ID, emoji: "💩"}, {client: alice, id: msg.ID + 100, emoji: "👍"}} {
+		hub.toggleReaction(req)
+		if frames := drain(alice); len(frames) != 1 || !bytes.Contains(frames[0], []byte("error")) {
+			t.Errorf("reacting with %s to %d got alice:\n%s", req.emoji, req.id, bytes.Join(frames, []byte("\n")))
+		}
+		if frames := drain(bob); len(frames) != 0 {
+			t.Errorf("reacting with %s to %d got bob:\n%s", req.emoji, req.id, bytes.Join(frames, []byte("\n")))
+		}
+	}
+}
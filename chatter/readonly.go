@@ -0,0 +1,102 @@
+package chatter
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// readOnlyNotice is what the clients sending a message to a read-only room are told
+	readOnlyNotice = "chat is in read-only mode"
+	// reopenedFor is how long the banner saying the room is open again stays up
+	reopenedFor = time.Minute
+)
+
+// modeRequest asks the hub to turn read-only mode on or off, done is closed once it did
+type modeRequest struct {
+	readOnly bool
+	done     chan struct{}
+}
+
+// SetReadOnly turns read-only mode on or off: while it is on the messages sent to the room
+// are bounced, everything else (connecting, the history, the announcements) keeps working.
+// The room gets a banner when it changes, and it is recorded in the audit log as taken by actor
+func (h *Hub) SetReadOnly(actor string, readOnly bool) error {
+	reason := "off"
+	if readOnly {
+		reason = "on"
+	}
+	req := &modeRequest{readOnly: readOnly, done: make(chan struct{})}
+
+	select {
+	case h.modes <- req:
+	case <-h.stop:
+		err := errors.New("hub is shut down")
+		h.audit.record(actor, AuditReadOnly, h.room, "", reason, err)
+		return err
+	}
+	<-req.done
+	h.audit.record(actor, AuditReadOnly, h.room, "", reason, nil)
+	return nil
+}
+
+// ReadOnly tells whether the room is in read-only mode, it is safe to call from any goroutine
+func (h *Hub) ReadOnly() bool {
+	return h.readOnly.Load()
+}
+
+// setMode handles a mode request on the hub goroutine: the banner saying the room is read-only
+// stays up until it is turned off, and then another one says it is open again for a while.
+// Turning it on (or off) twice changes nothing
+func (h *Hub) setMode(req *modeRequest) {
+	defer close(req.done)
+	if h.readOnly.Load() == req.readOnly {
+		return
+	}
+	h.readOnly.Store(req.readOnly)
+	h.logger.Info("read-only mode changed", "read_only", req.readOnly)
+	defer h.updateDashboards()
+
+	if req.readOnly {
+		h.readOnlyBanner = h.announce(&announceRequest{text: "Chat is in read-only mode, you can read but not send messages"}).ID
+		return
+	}
+	for i, announcement := range h.announced {
+		if announcement.ID == h.readOnlyBanner {
+			h.announced = append(h.announced[:i], h.announced[i+1:]...)
+			break
+		}
+	}
+	h.readOnlyBanner = 0
+	h.announce(&announceRequest{text: "Chat is open again", ttl: reopenedFor})
+}
+
+// readOnlyMiddleware bounces the messages (and edits) sent while the room is read-only,
+// the messages of the hub itself still go through
+func (h *Hub) readOnlyMiddleware(next MessageHandler) MessageHandler {
+	return func(msg *Message) {
+		if h.readOnly.Load() && msg.Kind != KindSystem {
+			msg.Reply(readOnlyNotice)
+			return
+		}
+		next(msg)
+	}
+}
+
+// ReadOnlyHandler handles PUT /admin/readonly (read-only mode on) and DELETE /admin/readonly
+// (off) with ?room=..., it should be wrapped in AdminAuth
+func ReadOnlyHandler(manager *HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub, err := manager.Get(roomName(r))
+		if err != nil {
+			serveRoomUnavailable(w, err)
+			return
+		}
+		if err := hub.SetReadOnly(auditActor(r), r.Method != http.MethodDelete); err != nil {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
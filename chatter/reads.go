@@ -0,0 +1,84 @@
+package chatter
+
+// readReceipt tells the hub up to which message a client has read
+type readReceipt struct {
+	client *Client
+	id     uint64 // id of the last message the client has seen
+}
+
+// Unread is what the unread indicator of a page is rendered from
+type Unread struct {
+	Count    int    // number of messages after the last one read
+	First    uint64 // id of the first of them (zero if there are none)
+	LastRead uint64 // id of the last message read
+}
+
+// lastRead returns the id of the last message the reader has read, zero if it never told us.
+// With a ReadStore it is kept along with the history, otherwise only while the hub is around
+func (h *Hub) lastRead(reader string) uint64 {
+	store, ok := h.store.(ReadStore)
+	if !ok {
+		return h.lastReads[reader]
+	}
+
+	id, err := store.LastRead(h.room, reader)
+	if err != nil {
+		h.logger.Error("reading the last read message", "err", err)
+	}
+	return id
+}
+
+// markRead records that the client read up to the message, and updates its unread indicator.
+// Reading never goes backwards, an older message than the last one read changes nothing
+func (h *Hub) markRead(req *readReceipt) {
+
+	reader := req.client.identity()
+	if req.id <= h.lastRead(reader) {
+		return
+	}
+
+	if store, ok := h.store.(ReadStore); ok {
+		if err := store.SetLastRead(h.room, reader, req.id); err != nil {
+			h.logger.Error("storing the last read message", "err", err)
+			return
+		}
+	} else {
+		h.lastReads[reader] = req.id
+	}
+
+	if _, ok := h.clients[req.client]; !ok {
+		return
+	}
+	if rendered := h.renderUnread(reader); rendered != nil {
+		deliverNotice(req.client, rendered)
+	}
+}
+
+// renderUnread renders the unread indicator of the reader, counting the messages of the
+// history after the last one it read. It returns nil if the reader never told us what it read
+func (h *Hub) renderUnread(reader string) []byte {
+
+	last := h.lastRead(reader)
+	if last == 0 {
+		return nil
+	}
+
+	messages, err := h.store.Since(h.room, last)
+	if err != nil {
+		h.logger.Error("reading the history", "err", err)
+		return nil
+	}
+
+	// only what people wrote counts, not the joins and leaves
+	unread := Unread{LastRead: last}
+	for _, msg := range messages {
+		if msg.Deleted || (msg.Kind != KindChat && msg.Kind != KindAction && msg.Kind != "") {
+			continue
+		}
+		if unread.Count == 0 {
+			unread.First = msg.ID
+		}
+		unread.Count++
+	}
+	return getUnreadTemplate(&unread)
+}
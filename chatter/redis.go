@@ -0,0 +1,175 @@
+package chatter
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisQueue is the number of messages waiting to be published before we start dropping them
+	redisQueue = 256
+	// redisHistory is the number of messages per room kept in the shared history
+	redisHistory = 1000
+	// redisMinBackoff and redisMaxBackoff bound how long we wait before subscribing again
+	redisMinBackoff = time.Second
+	redisMaxBackoff = 30 * time.Second
+)
+
+// RedisBridge is a Bridge using redis pub/sub, every room has a channel for its
+// messages and a list for its recent history. When redis can't be reached the
+// rooms carry on locally and we keep trying to reconnect
+type RedisBridge struct {
+	client   *redis.Client
+	instance string            // id of this instance, so we skip our own messages
+	queue    chan redisPayload // messages waiting to be published
+	done     chan struct{}     // closed when the publisher has returned
+}
+
+// redisEnvelope is a message as it travels through redis
+type redisEnvelope struct {
+	Room    string   `json:"room"`
+	Origin  string   `json:"origin"`
+	Message *Message `json:"message"`
+}
+
+// redisPayload is an encoded envelope waiting to be published
+type redisPayload struct {
+	room string
+	data []byte
+}
+
+// NewRedisBridge creates a bridge using the redis server at addr (host:port)
+func NewRedisBridge(addr string) *RedisBridge {
+	b := &RedisBridge{
+		client:   redis.NewClient(&redis.Options{Addr: addr}),
+		instance: uuid.New().String(),
+		queue:    make(chan redisPayload, redisQueue),
+		done:     make(chan struct{}),
+	}
+	go b.publish()
+	return b
+}
+
+// Close publishes the messages still queued and closes the connection to redis
+func (b *RedisBridge) Close() error {
+	close(b.queue)
+	<-b.done
+	return b.client.Close()
+}
+
+// Publish queues the message for the other instances, if redis is too slow
+// (or down) and the queue is full the message stays local
+func (b *RedisBridge) Publish(room string, msg *Message) {
+
+	// we encode the message right away, the hub keeps using it
+	data, err := json.Marshal(redisEnvelope{Room: room, Origin: b.instance, Message: msg})
+	if err != nil {
+		slog.Error("encoding message for redis", "room", room, "msg_id", msg.ID, "err", err)
+		return
+	}
+
+	select {
+	case b.queue <- redisPayload{room: room, data: data}:
+	default:
+		slog.Error("redis queue full, the message stays local", "room", room, "msg_id", msg.ID)
+	}
+}
+
+// publish sends the queued messages to redis until the queue is closed
+func (b *RedisBridge) publish() {
+	defer close(b.done)
+
+	for payload := range b.queue {
+		// we publish the message and keep it in the shared history at the same time
+		ctx, cancel := context.WithTimeout(context.Background(), bridgeTimeout)
+		_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Publish(ctx, redisChannel(payload.room), payload.data)
+			pipe.LPush(ctx, redisList(payload.room), payload.data)
+			pipe.LTrim(ctx, redisList(payload.room), 0, redisHistory-1)
+			return nil
+		})
+		cancel()
+		if err != nil {
+			slog.Error("publishing to redis", "room", payload.room, "err", err)
+		}
+	}
+}
+
+// Subscribe delivers the messages the other instances publish in the room until ctx is cancelled,
+// if redis goes away we subscribe again, waiting a bit longer every time
+func (b *RedisBridge) Subscribe(ctx context.Context, room string, deliver func(*Message)) {
+
+	pubsub := b.client.Subscribe(ctx, redisChannel(room))
+	defer pubsub.Close()
+
+	backoff := redisMinBackoff
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("redis subscription", "room", room, "err", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, redisMaxBackoff)
+			continue
+		}
+		backoff = redisMinBackoff
+
+		var envelope redisEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil || envelope.Message == nil {
+			slog.Error("decoding message from redis", "room", room, "err", err)
+			continue
+		}
+		// we already broadcast our own messages
+		if envelope.Origin == b.instance {
+			continue
+		}
+
+		envelope.Message.Origin = envelope.Origin
+		deliver(envelope.Message)
+	}
+}
+
+// Recent returns up to n of the most recent messages of the room kept in redis, oldest first
+func (b *RedisBridge) Recent(ctx context.Context, room string, n int) ([]*Message, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	payloads, err := b.client.LRange(ctx, redisList(room), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// the list is newest first
+	messages := make([]*Message, 0, len(payloads))
+	for i := len(payloads) - 1; i >= 0; i-- {
+		var envelope redisEnvelope
+		if err := json.Unmarshal([]byte(payloads[i]), &envelope); err != nil || envelope.Message == nil {
+			continue
+		}
+		envelope.Message.Origin = envelope.Origin
+		messages = append(messages, envelope.Message)
+	}
+	return messages, nil
+}
+
+// redisChannel is the channel the messages of the room are published on
+func redisChannel(room string) string {
+	return "chatter:room:" + room
+}
+
+// redisList is the list the recent messages of the room are kept in
+func redisList(room string) string {
+	return "chatter:history:" + room
+}
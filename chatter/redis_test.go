@@ -0,0 +1,88 @@
+//go:build redis
+
+package chatter_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// These tests need a redis server, they only build with the redis tag:
+//
+//	CHATTER_REDIS_ADDR=localhost:6379 go test -tags redis ./chatter/
+
+// redisAddr returns the address of the redis server of the tests, skipping the test if it can't be reached
+func redisAddr(t *testing.T) string {
+	t.Helper()
+	addr := os.Getenv("CHATTER_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("redis isn't reachable at %s: %v", addr, err)
+	}
+	return addr
+}
+
+// bridgedServer starts an instance of the chat sharing its rooms through redis at addr
+func bridgedServer(t *testing.T, addr string, opts ...chatter.Option) *chattertest.Server {
+	t.Helper()
+	bridge := chatter.NewRedisBridge(addr)
+	srv := chattertest.NewServer(t, append(opts, chatter.WithBridge(bridge))...)
+	// the bridge goes before the server checks for leaks, once the rooms are closed
+	t.Cleanup(func() {
+		srv.Manager.Close(5 * time.Second)
+		bridge.Close()
+	})
+	return srv
+}
+
+func TestInstancesShareTheChat(t *testing.T) {
+	addr := redisAddr(t)
+	room := "redis-" + uuid.New().String()
+	one, two := bridgedServer(t, addr), bridgedServer(t, addr)
+	alice := one.ConnectRoom(t, room, "alice")
+	bob := two.ConnectRoom(t, room, "bob")
+
+	alice.Send("hello from one")
+	bob.Expect("hello from one", waitTimeout)
+	bob.Send("hello from two")
+	alice.Expect("hello from two", waitTimeout)
+
+	// the messages aren't published back and forth
+	alice.ExpectNone("hello from one", 300*time.Millisecond)
+
+	// a new instance starts with the shared history
+	three := bridgedServer(t, addr, chatter.WithHistoryReplay(10))
+	carol := three.ConnectRoom(t, room, "carol")
+	carol.Expect("hello from two", waitTimeout)
+}
+
+func TestInstancesCarryOnWithoutRedis(t *testing.T) {
+	// nothing listens there
+	bridge := chatter.NewRedisBridge("127.0.0.1:1")
+	defer bridge.Close()
+	hub := chatter.NewHub(chatter.WithBridge(bridge))
+	go hub.Run(context.Background())
+	defer hub.Close(time.Second)
+
+	// the messages are still broadcast and kept, locally
+	if _, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "bot", Text: "still local"}, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := hub.History(0, 10)
+	if err != nil || len(messages) != 1 || messages[0].Text != "still local" {
+		t.Errorf("the history is %v (%v), want the local message", messages, err)
+	}
+}
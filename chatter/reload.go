@@ -0,0 +1,99 @@
+package chatter
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// templatePoll is how often WatchTemplates looks for changed templates
+const templatePoll = 500 * time.Millisecond
+
+// reload is the outcome of the last template reload, for the admin endpoint
+var reload struct {
+	sync.Mutex
+	at  time.Time // when the templates were last reloaded (or failed to)
+	err error     // why the last reload failed (nil if it worked)
+}
+
+// WatchTemplates reloads the templates from dir every time one of them changes, until ctx
+// is cancelled. It is meant for development: broken templates are logged and the last
+// good ones stay in use
+func WatchTemplates(ctx context.Context, dir string) {
+
+	fsys := os.DirFS(dir)
+	last := templatesModified(fsys)
+
+	ticker := time.NewTicker(templatePoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modified := templatesModified(fsys)
+		if modified.Equal(last) {
+			continue
+		}
+		last = modified
+
+		err := LoadTemplates(fsys)
+		if err != nil {
+			slog.Error("reloading templates, keeping the previous ones", "err", err)
+		} else {
+			slog.Info("templates reloaded", "dir", dir)
+		}
+
+		reload.Lock()
+		reload.at, reload.err = time.Now(), err
+		reload.Unlock()
+	}
+}
+
+// templatesModified returns the last time one of the templates in fsys changed
+func templatesModified(fsys fs.FS) time.Time {
+	var modified time.Time
+	files, _ := fs.Glob(fsys, "*.html")
+	for _, file := range files {
+		info, err := fs.Stat(fsys, file)
+		if err != nil {
+			// a file that goes away (editors save by renaming) counts as a change
+			continue
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified
+}
+
+// TemplatesHandler handles GET /admin/templates, it reports the outcome of the last
+// template reload (see WatchTemplates) and should be wrapped in AdminAuth
+func TemplatesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reload.Lock()
+		status := struct {
+			ReloadedAt *time.Time `json:"reloaded_at,omitempty"`
+			Error      string     `json:"error,omitempty"`
+		}{}
+		if !reload.at.IsZero() {
+			at := reload.at
+			status.ReloadedAt = &at
+		}
+		if reload.err != nil {
+			status.Error = reload.err.Error()
+		}
+		reload.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
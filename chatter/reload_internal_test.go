@@ -0,0 +1,97 @@
+package chatter
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editTemplate rewrites the template file with edit, moving its modification time on
+// so the change is seen whatever the resolution of the file system's clock
+func editTemplate(t *testing.T, path string, edit func(string) string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(edit(string(data))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplatesAreReloadedWhenTheyChange(t *testing.T) {
+	// the templates are watched in a copy of the shipped ones
+	dir := t.TempDir()
+	files, _ := fs.Glob(DefaultTemplates(), "*.html")
+	for _, file := range files {
+		data, err := fs.ReadFile(DefaultTemplates(), file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadTemplates(os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadTemplates(DefaultTemplates()) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchTemplates(ctx, dir)
+	// the watcher notes when the templates were modified as it starts, the edits come after
+	time.Sleep(templatePoll / 2)
+
+	msg := &Message{Kind: KindChat, Username: "alice", Text: "hello", Timestamp: time.Now()}
+	waitFor := func(markup string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * templatePoll)
+		for !strings.Contains(string(getMessageTemplate(msg, "", false)), markup) {
+			if time.Now().After(deadline) {
+				t.Fatalf("the message is still rendered as:\n%s", getMessageTemplate(msg, "", false))
+			}
+			time.Sleep(templatePoll / 10)
+		}
+	}
+
+	// the messages rendered after the change have the new markup
+	path := filepath.Join(dir, "message.html")
+	editTemplate(t, path, func(s string) string {
+		return strings.Replace(s, `class="text-base"`, `class="text-base reloaded"`, 1)
+	})
+	waitFor(`class="text-base reloaded"`)
+
+	// a broken template is reported, the last good one stays in use
+	editTemplate(t, path, func(s string) string { return s + "{{ end" })
+	deadline := time.Now().Add(10 * templatePoll)
+	var status struct {
+		Error string `json:"error"`
+	}
+	for status.Error == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("the broken template was never reported")
+		}
+		time.Sleep(templatePoll / 10)
+		rec := httptest.NewRecorder()
+		TemplatesHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/templates", nil))
+		json.NewDecoder(rec.Body).Decode(&status)
+	}
+	waitFor(`class="text-base reloaded"`)
+
+	// fixing it picks up the templates again
+	editTemplate(t, path, func(s string) string {
+		return strings.Replace(strings.TrimSuffix(s, "{{ end"), "reloaded", "fixed", 1)
+	})
+	waitFor(`class="text-base fixed"`)
+}
@@ -0,0 +1,61 @@
+package chatter
+
+import "unicode/utf8"
+
+// quoteLength is the number of characters of the parent quoted above a reply
+const quoteLength = 80
+
+// Quote is the message a reply is to, as shown above the reply
+type Quote struct {
+	ID       uint64 `json:"id"`
+	Room     string `json:"room"`
+	Username string `json:"username"`
+	Text     string `json:"text"`    // the beginning of the text
+	Missing  bool   `json:"missing"` // the message was deleted or isn't kept anymore
+}
+
+// quote returns the quote of the message with the id, a message the store doesn't
+// have (anymore) or that was deleted is missing
+func (h *Hub) quote(id uint64) *Quote {
+	quote := &Quote{ID: id, Room: h.room, Missing: true}
+
+	store, ok := h.store.(EditableStore)
+	if !ok {
+		return quote
+	}
+	parent, err := store.Get(h.room, id)
+	if err != nil {
+		h.logger.Error("reading message", "msg_id", id, "err", err)
+		return quote
+	}
+	if parent == nil || parent.Deleted {
+		return quote
+	}
+
+	quote.Username, quote.Text, quote.Missing = parent.Username, truncate(parent.Text, quoteLength), false
+	return quote
+}
+
+// truncate shortens the text to n characters, marking the cut with an ellipsis
+func truncate(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:n-1]) + "…"
+}
+
+// Replies returns up to limit replies to the message with the id parent, oldest first.
+// It is safe to call from any goroutine
+func (h *Hub) Replies(parent uint64, limit int) ([]*Message, error) {
+	threads, ok := h.store.(ThreadStore)
+	if !ok {
+		return nil, nil
+	}
+
+	messages, err := threads.Replies(h.room, parent, limit)
+	for i, msg := range messages {
+		messages[i] = h.formatted(msg)
+	}
+	return messages, err
+}
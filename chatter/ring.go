@@ -0,0 +1,98 @@
+package chatter
+
+import "sort"
+
+// ring is a bounded message history, once it is full every new message evicts the oldest one
+type ring struct {
+	buf   []*Message // messages, the oldest at start
+	start int        // index of the oldest message
+	size  int        // number of messages in the ring
+}
+
+// newRing creates a ring holding up to capacity messages
+func newRing(capacity int) *ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ring{buf: make([]*Message, capacity)}
+}
+
+// push adds the message, evicting the oldest message if the ring is full
+func (r *ring) push(msg *Message) {
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = msg
+		r.size++
+		return
+	}
+
+	// the ring is full, the new message takes the place of the oldest one
+	r.buf[r.start] = msg
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// at returns the i-th oldest message
+func (r *ring) at(i int) *Message {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// last returns a copy of the n most recent messages, oldest first
+func (r *ring) last(n int) []*Message {
+	if n > r.size {
+		n = r.size
+	}
+
+	messages := make([]*Message, n)
+	for i := range messages {
+		messages[i] = r.at(r.size - n + i)
+	}
+	return messages
+}
+
+// since returns a copy of the messages with an id greater than id, oldest first
+func (r *ring) since(id uint64) []*Message {
+
+	// the messages are ordered by id, so we look for the first one after id
+	for i := 0; i < r.size; i++ {
+		if r.at(i).ID > id {
+			return r.last(r.size - i)
+		}
+	}
+	return nil
+}
+
+// before returns a copy of the (up to) n most recent messages with an id lower than id, oldest first
+func (r *ring) before(id uint64, n int) []*Message {
+
+	// the messages are ordered by id, so we look for the last one before id
+	end := r.size
+	for end > 0 && r.at(end-1).ID >= id {
+		end--
+	}
+
+	begin := end - n
+	if begin < 0 {
+		begin = 0
+	}
+
+	messages := make([]*Message, 0, end-begin)
+	for i := begin; i < end; i++ {
+		messages = append(messages, r.at(i))
+	}
+	return messages
+}
+
+// find returns the index of the message with the id (the i-th oldest), or -1 if there is none
+func (r *ring) find(id uint64) int {
+
+	// the messages are ordered by id, so we can search them
+	i := sort.Search(r.size, func(i int) bool { return r.at(i).ID >= id })
+	if i < r.size && r.at(i).ID == id {
+		return i
+	}
+	return -1
+}
+
+// set replaces the i-th oldest message
+func (r *ring) set(i int, msg *Message) {
+	r.buf[(r.start+i)%len(r.buf)] = msg
+}
@@ -0,0 +1,110 @@
+package chatter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// MaxRoomNameLength is the longest name a room in a path (/r/{room}, /ws/{room}) can have
+const MaxRoomNameLength = 32
+
+// reservedRooms are the names a room in a path can't take, they are (or could be) our own pages
+var reservedRooms = []string{"admin", "api", "auth", "debug", "events", "healthz", "hooks", "login", "logout", "messages", "metrics", "r", "readyz", "room", "rooms", "static", "ws"}
+
+// ErrInvalidRoom is returned for a room name that can't be used in a path (see ValidateRoomName)
+var ErrInvalidRoom = errors.New("invalid room name")
+
+// RoomError is what the room error page is rendered from
+type RoomError struct {
+	Room    string // the room asked for
+	Reason  string // why it can't be opened
+	Private bool   // whether it is a private room the visitor wasn't let into
+}
+
+// ValidateRoomName checks the name of a room in a path: we create those rooms on demand,
+// so their names are kept short and readable (lowercase letters, digits, dashes and
+// underscores, starting with a letter or a digit) and away from the names of our pages.
+// The errors wrap ErrInvalidRoom
+func ValidateRoomName(name string) error {
+	if reason := roomNameProblem(name); reason != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidRoom, reason)
+	}
+	return nil
+}
+
+// roomNameProblem tells what is wrong with the name of a room, empty if nothing is
+func roomNameProblem(name string) string {
+	if name == "" {
+		return "a room needs a name"
+	}
+	if len(name) > MaxRoomNameLength {
+		return fmt.Sprintf("a room name is at most %d characters long", MaxRoomNameLength)
+	}
+	for i, c := range name {
+		alnum := c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+		if !alnum && (i == 0 || c != '-' && c != '_') {
+			return "a room name is made of lowercase letters, digits, dashes and underscores, and starts with a letter or a digit"
+		}
+	}
+	if slices.Contains(reservedRooms, name) {
+		return fmt.Sprintf("%q is reserved", name)
+	}
+	return ""
+}
+
+// RoomHandler serves the page of the room in the path (/r/{room}) with serve, once its name
+// checks out (see ValidateRoomName). Any other name (or none) gets a page telling the
+// visitor why, with a 404. A private room takes a member or an invite: a visitor following
+// an invite link is let in and sent to the page without it
+func RoomHandler(manager *HubManager, serve func(w http.ResponseWriter, r *http.Request, room string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room := r.PathValue("room")
+		if reason := roomNameProblem(room); reason != "" {
+			serveRoomError(w, http.StatusNotFound, &RoomError{Room: room, Reason: reason})
+			return
+		}
+
+		// (without a hub we're shutting down, the page says so itself)
+		if hub, err := manager.Get(room); err == nil {
+			ok, reason := hub.letIn(w, r)
+			if !ok {
+				serveRoomError(w, http.StatusForbidden, &RoomError{Room: room, Reason: reason, Private: true})
+				return
+			}
+			if query := r.URL.Query(); query.Has("invite") {
+				query.Del("invite")
+				target := r.URL.Path
+				if len(query) > 0 {
+					target += "?" + query.Encode()
+				}
+				http.Redirect(w, r, target, http.StatusSeeOther)
+				return
+			}
+		}
+		serve(w, r, room)
+	})
+}
+
+// serveRoomUnavailable answers a request for a room Get didn't return: a room whose name
+// doesn't check out isn't there, otherwise we're shutting down
+func serveRoomUnavailable(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidRoom) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+}
+
+// serveRoomError renders the page telling the visitor the room can't be opened
+func serveRoomError(w http.ResponseWriter, status int, page *RoomError) {
+	rendered := getRoomErrorTemplate(page)
+	if rendered == nil {
+		http.Error(w, page.Reason, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(rendered)
+}
@@ -0,0 +1,176 @@
+package chatter_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+var (
+	validRooms    = []string{"general", "lobby", "a", "9lives", "room-1", "b_2", strings.Repeat("x", chatter.MaxRoomNameLength)}
+	invalidRooms  = []string{"Lobby", "-dash", "_under", "two words", "café", "a.b", "a/b", strings.Repeat("x", chatter.MaxRoomNameLength+1)}
+	reservedRooms = []string{"admin", "api", "ws", "rooms", "metrics", "r"}
+)
+
+func TestValidateRoomName(t *testing.T) {
+	for _, room := range validRooms {
+		if err := chatter.ValidateRoomName(room); err != nil {
+			t.Errorf("%q is refused: %v", room, err)
+		}
+	}
+	for _, room := range append(append([]string{""}, invalidRooms...), reservedRooms...) {
+		if err := chatter.ValidateRoomName(room); !errors.Is(err, chatter.ErrInvalidRoom) {
+			t.Errorf("%q got %v", room, err)
+		}
+	}
+	if err := chatter.ValidateRoomName("admin"); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("admin is refused with %v", err)
+	}
+}
+
+// status returns the status code of the request to the server, the body isn't read
+func status(t *testing.T, srv *chattertest.Server, method, path string, body io.Reader) int {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, srv.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (an event stream would go on, we hang up on it)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRoomNamesAreCheckedByEveryEndpoint(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	endpoints := []struct {
+		name    string
+		request func(room string) int
+	}{
+		{"/ws/{room}", func(room string) int {
+			_, resp, err := srv.Dial(t, "/ws/"+url.PathEscape(room)+"?name=alice", nil)
+			if err == nil {
+				return http.StatusSwitchingProtocols
+			}
+			if resp == nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}},
+		{"/ws?room=", func(room string) int {
+			_, resp, err := srv.Dial(t, "/ws?"+url.Values{"room": {room}, "name": {"alice"}}.Encode(), nil)
+			if err == nil {
+				return http.StatusSwitchingProtocols
+			}
+			if resp == nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}},
+		{"/events?room=", func(room string) int {
+			return status(t, srv, http.MethodGet, "/events?"+url.Values{"room": {room}}.Encode(), nil)
+		}},
+		{"/messages?room=", func(room string) int {
+			form := url.Values{"from": {"bot"}, "text": {"hello"}}.Encode()
+			return status(t, srv, http.MethodPost, "/messages?"+url.Values{"room": {room}}.Encode(), strings.NewReader(form))
+		}},
+		{"/history?room=", func(room string) int {
+			return status(t, srv, http.MethodGet, "/history?"+url.Values{"room": {room}}.Encode(), nil)
+		}},
+	}
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint.name, func(t *testing.T) {
+			for _, room := range validRooms {
+				if code := endpoint.request(room); code >= 300 && code != http.StatusSwitchingProtocols {
+					t.Errorf("%q got %d", room, code)
+				}
+			}
+			for _, room := range append(invalidRooms, reservedRooms...) {
+				if code := endpoint.request(room); code != http.StatusNotFound {
+					t.Errorf("%q got %d, want %d", room, code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+
+	// none of the names refused got a room
+	for _, stats := range srv.Manager.Stats() {
+		if chatter.ValidateRoomName(stats.Room) != nil {
+			t.Errorf("the room %q was opened", stats.Room)
+		}
+	}
+	if _, err := srv.Manager.Get("admin"); !errors.Is(err, chatter.ErrInvalidRoom) {
+		t.Errorf("getting the admin room returned %v", err)
+	}
+}
+
+func TestHookTokensAreOnlyMadeForValidRooms(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("POST /rooms/{room}/hooks", chatter.HookTokensHandler(chatter.NewHookTokens(), "s3cret"))
+	create := func(room string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/rooms/"+url.PathEscape(room)+"/hooks", nil)
+		req.Header.Set("X-Chatter-Secret", "s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, room := range validRooms {
+		if rec := create(room); rec.Code != http.StatusCreated {
+			t.Errorf("%q got %d", room, rec.Code)
+		}
+	}
+	for _, room := range append(invalidRooms, reservedRooms...) {
+		rec := create(room)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), chatter.ErrInvalidRoom.Error()) {
+			t.Errorf("%q got %d: %s", room, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRoomPages(t *testing.T) {
+	if err := chatter.LoadTemplates(chatter.DefaultTemplates()); err != nil {
+		t.Fatal(err)
+	}
+	manager := chatter.NewHubManager(0)
+	defer manager.Close(0)
+	mux := http.NewServeMux()
+	mux.Handle("GET /r/{room}", chatter.RoomHandler(manager, func(w http.ResponseWriter, r *http.Request, room string) {
+		io.WriteString(w, "the page of "+room)
+	}))
+
+	get := func(room string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/r/"+url.PathEscape(room), nil))
+		return rec
+	}
+	for _, room := range validRooms {
+		if rec := get(room); rec.Code != http.StatusOK || rec.Body.String() != "the page of "+room {
+			t.Errorf("%q got %d: %s", room, rec.Code, rec.Body)
+		}
+	}
+	// the refused names get a page telling why
+	for _, room := range append(invalidRooms, reservedRooms...) {
+		rec := get(room)
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "We can't open it") {
+			t.Errorf("%q got %d: %s", room, rec.Code, rec.Body)
+		}
+	}
+}
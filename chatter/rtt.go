@@ -0,0 +1,57 @@
+package chatter
+
+import (
+	"strconv"
+	"time"
+)
+
+// rttWeight is the weight of a new sample in the smoothed round-trip time of a client,
+// the lower it is the less a single slow pong moves it
+const rttWeight = 0.2
+
+// pingPayload returns the payload of a ping written at now: the peer echoes it back in its pong,
+// which tells us which ping the pong answers
+func pingPayload(now time.Time) []byte {
+	return strconv.AppendInt(nil, now.UnixNano(), 10)
+}
+
+// pong records the round-trip time of the ping the pong answers, it is called by readPump.
+// A missed pong gives no sample, and neither does one without our payload (unsolicited,
+// or from a peer that doesn't echo it) or with one we can't have sent: no ping of ours goes
+// unanswered longer than the pong wait without the connection being closed
+func (c *Client) pong(appData string, now time.Time) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	sample := now.Sub(time.Unix(0, sent))
+	if sample < 0 || sample > c.hub.config.PongWait {
+		return
+	}
+	// zero means we have no sample yet
+	sample = max(sample, time.Nanosecond)
+
+	smoothed := time.Duration(c.rttNanos.Load())
+	if smoothed != 0 {
+		sample = time.Duration(float64(smoothed)*(1-rttWeight) + float64(sample)*rttWeight)
+	}
+	c.rttNanos.Store(int64(sample))
+}
+
+// rtt returns the smoothed round-trip time of the pings of the client, zero until it answered one
+func (c *Client) rtt() time.Duration {
+	return time.Duration(c.rttNanos.Load())
+}
+
+// rttMillis returns the smoothed round-trip time of the clients that answered a ping, in
+// milliseconds (rounded up) by name. The hub goroutine calls it freely, other goroutines
+// hold the read lock
+func (h *Hub) rttMillis() map[string]int {
+	rtts := make(map[string]int)
+	for client := range h.clients {
+		if rtt := client.rtt(); rtt > 0 {
+			rtts[client.name] = int((rtt + time.Millisecond - 1) / time.Millisecond)
+		}
+	}
+	return rtts
+}
@@ -0,0 +1,99 @@
+package chatter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextPing moves the clock to the next ping of the pumps on the connection, and returns its payload
+func nextPing(t *testing.T, clock *fakeClock, conn *fakeConn, after time.Duration) string {
+	t.Helper()
+	before := len(conn.Pings())
+	clock.Advance(after)
+	deadline := time.After(time.Second)
+	for {
+		if pings := conn.Pings(); len(pings) > before {
+			return pings[len(pings)-1]
+		}
+		select {
+		case <-conn.wrote:
+		case <-deadline:
+			t.Fatal("no ping was written")
+		}
+	}
+}
+
+// answer answers the ping of the payload after delay, the way the browser does
+func answer(t *testing.T, clock *fakeClock, conn *fakeConn, payload string, delay time.Duration) {
+	t.Helper()
+	clock.Advance(delay)
+	// readPump sets the pong handler once it starts
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		conn.mu.Lock()
+		ready := conn.pong != nil
+		conn.mu.Unlock()
+		if ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the pong handler was never set")
+		}
+	}
+	if err := conn.Pong(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTripTimesOfDelayedPongs(t *testing.T) {
+	clock := newFakeClock()
+	hub := runHub(t, NewHub(WithClock(clock)))
+	conn := newFakeConn()
+	alice := startClient(t, hub, conn, "alice")
+	period := hub.config.PingPeriod
+	// the sweep of the hub, and the pings of the client
+	clock.WaitTickers(t, 2)
+
+	steps := []struct {
+		name  string
+		delay time.Duration // how long the pong takes (-1 if it never comes)
+		want  time.Duration // the smoothed round-trip time once it came
+	}{
+		{"first pong", 80 * time.Millisecond, 80 * time.Millisecond},
+		// 0.8 * 80 + 0.2 * 180
+		{"slower pong", 180 * time.Millisecond, 100 * time.Millisecond},
+		{"missed pong", -1, 100 * time.Millisecond},
+		// 0.8 * 100 + 0.2 * 80
+		{"pong after a missed one", 80 * time.Millisecond, 96 * time.Millisecond},
+	}
+	elapsed := time.Duration(0) // since the last ping
+	for _, step := range steps {
+		payload := nextPing(t, clock, conn, period-elapsed)
+		elapsed = 0
+		if step.delay >= 0 {
+			answer(t, clock, conn, payload, step.delay)
+			elapsed = step.delay
+		}
+		if got := alice.rtt(); got != step.want {
+			t.Errorf("after the %s the round-trip time is %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// pongs that don't answer one of our pings give no sample
+	answer(t, clock, conn, "unsolicited", 0)
+	answer(t, clock, conn, "-1", 0)
+	if got := alice.rtt(); got != 96*time.Millisecond {
+		t.Errorf("after pongs that aren't answers the round-trip time is %v", got)
+	}
+
+	// the stats and the presence list show it, in milliseconds
+	if rtt := hub.Stats().RTT["alice"]; rtt != 96 {
+		t.Errorf("the stats show a round-trip time of %d ms, want 96", rtt)
+	}
+	hub.RLock()
+	presence := string(hub.renderPresence())
+	hub.RUnlock()
+	if !strings.Contains(presence, `<span data-rtt-ms="96">alice</span>`) {
+		t.Errorf("the presence list is:\n%s", presence)
+	}
+}
@@ -0,0 +1,107 @@
+package chatter
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// replayWindow is the number of recent broadcast frames a hub keeps, a client reconnecting
+// within them gets exactly the frames it missed instead of the history replay
+const replayWindow = 256
+
+// sequenced is a broadcast frame kept for the clients reconnecting, frame renders it for
+// a client the way fanOut did (false if the client doesn't get it)
+type sequenced struct {
+	seq   uint64
+	frame func(*Client) (Frame, bool)
+}
+
+// frameWindow holds the most recent broadcast frames, once it is full every new frame
+// evicts the oldest one
+type frameWindow struct {
+	buf   []sequenced // frames, the oldest at start
+	start int         // index of the oldest frame
+	size  int         // number of frames in the window
+}
+
+// newFrameWindow creates a window holding up to capacity frames
+func newFrameWindow(capacity int) *frameWindow {
+	return &frameWindow{buf: make([]sequenced, capacity)}
+}
+
+// push adds the frame, evicting the oldest frame if the window is full
+func (w *frameWindow) push(frame sequenced) {
+	if w.size < len(w.buf) {
+		w.buf[(w.start+w.size)%len(w.buf)] = frame
+		w.size++
+		return
+	}
+	w.buf[w.start] = frame
+	w.start = (w.start + 1) % len(w.buf)
+}
+
+// at returns the i-th oldest frame
+func (w *frameWindow) at(i int) sequenced {
+	return w.buf[(w.start+i)%len(w.buf)]
+}
+
+// sequence stamps the frames of a broadcast with the next sequence number of the room
+// and keeps the broadcast in the window
+func (h *Hub) sequence(frame func(*Client) (Frame, bool)) func(*Client) (Frame, bool) {
+	h.seq++
+	seq := h.seq
+	stamped := func(client *Client) (Frame, bool) {
+		f, ok := frame(client)
+		f.Seq = seq
+		return f, ok
+	}
+	h.window.push(sequenced{seq: seq, frame: stamped})
+	return stamped
+}
+
+// resume renders the frames the client missed since the sequence number it resumes from,
+// it returns false if the client didn't ask to resume or some of its frames already left
+// the window (the client then gets the history replay)
+func (h *Hub) resume(client *Client) ([]Frame, bool) {
+
+	// the sequence numbers of a hub start from the time it was created, so a client
+	// coming back from a hub that is gone (e.g. the server restarted) is too old or too new
+	from := client.resumeSeq
+	if from == 0 || from > h.seq {
+		return nil, false
+	}
+	// with nothing in the window we can't tell what the client missed, and before the
+	// first frame of the window it missed frames we no longer have
+	if h.window.size == 0 || from < h.window.at(0).seq-1 {
+		return nil, false
+	}
+
+	var replay []Frame
+	for i := 0; i < h.window.size; i++ {
+		sequenced := h.window.at(i)
+		if sequenced.seq <= from {
+			continue
+		}
+		if f, ok := sequenced.frame(client); ok && client.takes(f) {
+			replay = append(replay, f)
+		}
+	}
+	return replay, true
+}
+
+// resumeSeq returns the sequence number of the last frame a reconnecting page got (?seq=)
+func resumeSeq(r *http.Request) uint64 {
+	seq, err := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// seqMarker renders the element telling the page the sequence number of the last frame
+// it got, the page sends it back when it reconnects
+func seqMarker(seq uint64) []byte {
+	marker := []byte(`<div id="seq" data-seq="`)
+	marker = strconv.AppendUint(marker, seq, 10)
+	return append(marker, `" hx-swap-oob="true" hidden></div>`...)
+}
@@ -0,0 +1,39 @@
+package chatter
+
+import "testing"
+
+func TestResume(t *testing.T) {
+	frame := func(*Client) (Frame, bool) { return Frame{}, true }
+
+	empty := NewHub()
+	full := NewHub()
+	for i := 0; i < replayWindow+2; i++ {
+		full.sequence(frame)
+	}
+	first := full.window.at(0).seq
+
+	tests := []struct {
+		name    string
+		hub     *Hub
+		from    uint64
+		resumed bool
+		frames  int
+	}{
+		{name: "empty window", hub: empty, from: empty.seq - 1},
+		{name: "empty window up to date", hub: empty, from: empty.seq},
+		{name: "not resuming", hub: full, from: 0},
+		{name: "ahead of the hub", hub: full, from: full.seq + 1},
+		{name: "before the window", hub: full, from: first - 2},
+		{name: "right before the window", hub: full, from: first - 1, resumed: true, frames: replayWindow},
+		{name: "within the window", hub: full, from: full.seq - 3, resumed: true, frames: 3},
+		{name: "up to date", hub: full, from: full.seq, resumed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, resumed := tt.hub.resume(&Client{resumeSeq: tt.from})
+			if resumed != tt.resumed || len(frames) != tt.frames {
+				t.Errorf("got %d frames, resumed %v; want %d frames, resumed %v", len(frames), resumed, tt.frames, tt.resumed)
+			}
+		})
+	}
+}
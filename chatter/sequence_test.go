@@ -0,0 +1,87 @@
+package chatter_test
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// seqMarker finds the sequence number the page is at in a frame
+var seqMarker = regexp.MustCompile(`id="seq" data-seq="(\d+)"`)
+
+// lastSeq waits for a frame with substring and returns the sequence number of the page after it
+func lastSeq(t *testing.T, client *chattertest.Client, substring string) uint64 {
+	t.Helper()
+	frame := client.Expect(substring, waitTimeout)
+	matches := seqMarker.FindAllStringSubmatch(frame, -1)
+	if matches == nil {
+		t.Fatalf("the frame has no sequence number:\n%s", frame)
+	}
+	seq, _ := strconv.ParseUint(matches[len(matches)-1][1], 10, 64)
+	return seq
+}
+
+// reconnect connects bob again, resuming from seq, and returns what bob got until the frame with until
+func reconnect(t *testing.T, srv *chattertest.Server, seq uint64, until string) string {
+	t.Helper()
+	query := url.Values{"room": {chatter.DefaultRoom}, "name": {"bob"}, "seq": {strconv.FormatUint(seq, 10)}}
+	bob, _, err := srv.Dial(t, "/ws?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got strings.Builder
+	for !strings.Contains(got.String(), until) {
+		got.WriteString(bob.Next(waitTimeout))
+	}
+	return got.String()
+}
+
+func TestReconnectsResumeFromTheirSequenceNumber(t *testing.T) {
+	srv := chattertest.NewServer(t, chatter.WithHistoryReplay(10))
+	alice := srv.Connect(t, "alice")
+	bob := srv.Connect(t, "bob")
+	alice.Send("early bird")
+	seq := lastSeq(t, bob, "early bird")
+	bob.Close()
+
+	for i := 1; i <= 5; i++ {
+		alice.Send(fmt.Sprintf("missed %d", i))
+	}
+	alice.Expect("missed 5", waitTimeout)
+
+	// bob gets exactly the frames missed, in order, rather than the history
+	got := reconnect(t, srv, seq, "missed 5")
+	last := -1
+	for i := 1; i <= 5; i++ {
+		text := fmt.Sprintf("missed %d", i)
+		if n := strings.Count(got, text); n != 1 {
+			t.Errorf("%q came %d times", text, n)
+		}
+		at := strings.Index(got, text)
+		if at < last {
+			t.Errorf("%q came out of order", text)
+		}
+		last = at
+	}
+	if strings.Contains(got, "early bird") {
+		t.Errorf("the history was replayed:\n%s", got)
+	}
+}
+
+func TestReconnectsOutsideTheWindowGetTheHistory(t *testing.T) {
+	srv := chattertest.NewServer(t, chatter.WithHistoryReplay(10))
+	alice := srv.Connect(t, "alice")
+	alice.Send("early bird")
+	seq := lastSeq(t, alice, "early bird")
+
+	// a sequence number the room never got to (e.g. from before a restart)
+	if got := reconnect(t, srv, seq+1000, "early bird"); !strings.Contains(got, "early bird") {
+		t.Errorf("the history wasn't replayed:\n%s", got)
+	}
+}
@@ -0,0 +1,291 @@
+package chatter
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionCookie is the cookie the session of a signed in visitor is kept in
+	sessionCookie = "chatter_session"
+	// DefaultSessionTTL is how long a session lasts unless NewSessions is told otherwise
+	DefaultSessionTTL = 24 * time.Hour
+)
+
+// principalKey is the context key of the visitor a request was authenticated as
+type principalKey struct{}
+
+// principal is who a request was authenticated as, by Sessions or JWTAuth
+type principal struct {
+	name     string    // name the visitor goes by
+	identity string    // stable identity of the visitor, wherever it signed in from
+	avatar   string    // url of the picture of the visitor (empty if none)
+	expires  time.Time // when the credentials expire (zero if they don't matter once connected)
+}
+
+// withPrincipal returns the request authenticated as p
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// Sessions signs visitors in with a shared passphrase or the credentials of a users file,
+// and keeps them signed in with a signed session cookie until it expires or they sign out
+type Sessions struct {
+	key        []byte            // key the cookies are signed with, derived from the secret
+	passphrase string            // passphrase anyone can sign in with, under any name (empty if none)
+	users      map[string]string // passwords by name, from the users file
+	ttl        time.Duration     // how long a session lasts
+	github     *GitHubAuth       // signs visitors in with GitHub (nil if it doesn't)
+}
+
+// Login is what the login page is rendered from
+type Login struct {
+	Next      string // page the visitor goes back to once signed in
+	Failure   string // why signing in failed (empty if it didn't)
+	Passwords bool   // whether visitors can sign in with a password
+	GitHub    bool   // whether visitors can sign in with GitHub
+	CSRF      string // CSRF token the form is sent with
+}
+
+// NewSessions creates the sessions signing their cookies with a key of their own derived from
+// the secret (without one we make one up, the sessions are then lost when the server restarts). Visitors sign in with the
+// passphrase, or with their name and password from the users file (one "name:password" per line).
+// Without either they can only sign in with GitHub (see EnableGitHub)
+func NewSessions(secret, passphrase, usersFile string, ttl time.Duration) (*Sessions, error) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	key := []byte(secret)
+	if secret == "" {
+		slog.Warn("no session secret, everyone is signed out when the server restarts")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	s := &Sessions{key: deriveKey(key, purposeSession), passphrase: passphrase, ttl: ttl}
+	if usersFile != "" {
+		users, err := readUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		s.users = users
+	}
+	return s, nil
+}
+
+// readUsers reads the users file, one "name:password" per line (empty lines and lines
+// starting with # are skipped)
+func readUsers(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, password, ok := strings.Cut(line, ":")
+		if name = sanitizeName(name); !ok || name == "" || password == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:password", path, n)
+		}
+		users[name] = password
+	}
+	return users, scanner.Err()
+}
+
+// authenticate checks the credentials, in constant time so they can't be guessed byte by byte
+func (s *Sessions) authenticate(name, password string) bool {
+	if password == "" {
+		return false
+	}
+	if want, ok := s.users[name]; ok {
+		return equalSecrets(password, want)
+	}
+	return s.passphrase != "" && equalSecrets(password, s.passphrase)
+}
+
+// equalSecrets compares the secrets in constant time (hashing them first, so their length doesn't show)
+func equalSecrets(a, b string) bool {
+	x, y := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
+
+// session is what the session cookie of a signed in visitor holds
+type session struct {
+	User     string `json:"u"`           // name the visitor goes by
+	Identity string `json:"i"`           // stable identity of the visitor
+	Avatar   string `json:"a,omitempty"` // url of the picture of the visitor (empty if none)
+	Expires  int64  `json:"e"`           // when the session expires, in seconds since the epoch
+}
+
+// sign returns the cookie value of the session: the session and its signature
+func (s *Sessions) sign(sess session) string {
+	// a struct of strings and numbers always encodes
+	encoded, _ := json.Marshal(sess)
+	payload := base64.RawURLEncoding.EncodeToString(encoded)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(macOf(s.key, purposeSession, payload))
+}
+
+// verify returns the session of the cookie value, if we signed it and it hasn't expired
+func (s *Sessions) verify(value string) (session, bool) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return session{}, false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return session{}, false
+	}
+	if !hmac.Equal(sum, macOf(s.key, purposeSession, payload)) {
+		return session{}, false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return session{}, false
+	}
+	var sess session
+	if err := json.Unmarshal(decoded, &sess); err != nil || sess.User == "" || !time.Now().Before(time.Unix(sess.Expires, 0)) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// start signs the visitor in, giving it the cookie of the session
+func (s *Sessions) start(w http.ResponseWriter, r *http.Request, sess session) {
+	expires := time.Now().Add(s.ttl)
+	sess.Expires = expires.Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.sign(sess),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Require only lets signed in visitors through, the others get a 401 (pages are redirected
+// to the login page instead). Requests authenticated otherwise (see JWTAuth) go through too.
+// Nil sessions let everyone through
+func (s *Sessions) Require(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requestPrincipal(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if sess, ok := s.verify(cookie.Value); ok {
+				next.ServeHTTP(w, withPrincipal(r, principal{name: sess.User, identity: sess.Identity, avatar: sess.Avatar}))
+				return
+			}
+		}
+
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "sign in first", http.StatusUnauthorized)
+	})
+}
+
+// LoginHandler serves the login page (GET) and signs the visitor in (POST),
+// it then goes back to the page it came from (?next=)
+func (s *Sessions) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// we only go back to our own pages
+		next := localPath(r.FormValue("next"))
+
+		if r.Method != http.MethodPost {
+			s.serveLogin(w, r, http.StatusOK, next, "")
+			return
+		}
+
+		name := sanitizeName(r.PostFormValue("name"))
+		if name == "" || !s.authenticate(name, r.PostFormValue("password")) {
+			s.serveLogin(w, r, http.StatusUnauthorized, next, "wrong name or password")
+			return
+		}
+
+		s.start(w, r, session{User: name, Identity: "user:" + name})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+}
+
+// LogoutHandler signs the visitor out and sends it to the login page
+func (s *Sessions) LogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+}
+
+// serveLogin renders the login page, with the ways the visitor can sign in
+func (s *Sessions) serveLogin(w http.ResponseWriter, r *http.Request, status int, next, failure string) {
+	rendered := getLoginTemplate(&Login{
+		Next:      next,
+		Failure:   failure,
+		Passwords: s.passphrase != "" || len(s.users) > 0,
+		GitHub:    s.github != nil,
+		CSRF:      CSRFToken(r),
+	})
+	if rendered == nil {
+		http.Error(w, "Could not render the page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(rendered)
+}
+
+// requestPrincipal returns who the request was authenticated as,
+// false if it wasn't (it didn't go through Sessions.Require or JWTAuth.Require)
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// signIn makes the client of an authenticated request go by the name it signed in with,
+// and be the same person wherever it signed in from
+func (c *Client) signIn(r *http.Request) {
+	p, ok := requestPrincipal(r)
+	if !ok {
+		return
+	}
+	c.user = p.name
+	c.name = p.name
+	c.identityID = p.identity
+	c.avatar = p.avatar
+	c.expires = p.expires
+}
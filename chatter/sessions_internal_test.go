@@ -0,0 +1,49 @@
+package chatter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCookies(t *testing.T) {
+	s, err := NewSessions("s3cret", "open sesame", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := session{User: "alice", Identity: "user:alice", Expires: time.Now().Add(time.Hour).Unix()}
+	value := s.sign(sess)
+	if got, ok := s.verify(value); !ok || got != sess {
+		t.Fatalf("the session came back as %+v (%v)", got, ok)
+	}
+
+	// a payload signed with the secret but not for a session, the way the identities or the CSRF
+	// tokens were signed with it
+	forged, _ := json.Marshal(session{User: "admin", Identity: "user:admin", Expires: 4102444800})
+	payload := base64.RawURLEncoding.EncodeToString(forged)
+	sign := func(key []byte, purpose string) string {
+		return payload + "." + base64.RawURLEncoding.EncodeToString(macOf(key, purpose, payload))
+	}
+	signature := value[strings.Index(value, ".")+1:]
+	for name, value := range map[string]string{
+		"another payload":        payload + "." + signature,
+		"signed with the secret": sign([]byte("s3cret"), purposeSession),
+		"another purpose":        sign(deriveKey([]byte("s3cret"), purposeSession), purposeIdentity),
+		"another key":            sign(deriveKey([]byte("s3cret"), purposeIdentity), purposeSession),
+		"no signature":           payload,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got, ok := s.verify(value); ok {
+				t.Errorf("the forged session verified as %+v", got)
+			}
+		})
+	}
+
+	// nor does an expired one
+	sess.Expires = time.Now().Add(-time.Second).Unix()
+	if got, ok := s.verify(s.sign(sess)); ok {
+		t.Errorf("the expired session verified as %+v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package chatter
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowPolicy is what a hub does when a client can't keep up: its send buffer is full
+type SlowPolicy string
+
+const (
+	// SlowDisconnect disconnects the client, telling it it's too slow (the default)
+	SlowDisconnect SlowPolicy = "disconnect"
+	// SlowDrop drops the frame, the client misses it but stays connected
+	SlowDrop SlowPolicy = "drop"
+	// SlowBlock waits a little for room in the send buffer, and disconnects the client if there is none
+	SlowBlock SlowPolicy = "block"
+)
+
+// DefaultSlowWait is how long SlowBlock waits for room in the send buffers unless told otherwise
+const DefaultSlowWait = 50 * time.Millisecond
+
+// WithSlowPolicy sets what the hub does when a client's send buffer is full. With SlowBlock
+// it waits up to wait (DefaultSlowWait if zero) for room in the buffers of the clients of
+// a broadcast, all of them together, so a single broadcast never holds the room back longer
+func WithSlowPolicy(policy SlowPolicy, wait time.Duration) Option {
+	return func(h *Hub) {
+		switch policy {
+		case SlowDrop, SlowBlock:
+			h.slowPolicy = policy
+		default:
+			h.slowPolicy = SlowDisconnect
+		}
+		if wait <= 0 {
+			wait = DefaultSlowWait
+		}
+		h.slowWait = wait
+	}
+}
+
+// slowWindow returns a channel closed once a blocking send has waited long enough, and the
+// function releasing it. It is nil (nothing waits) unless the policy is SlowBlock
+func (h *Hub) slowWindow() (<-chan struct{}, func()) {
+	if h.slowPolicy != SlowBlock {
+		return nil, func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.slowWait)
+	return ctx.Done(), cancel
+}
+
+// offerResult is what became of a frame offered to a client
+type offerResult int
+
+const (
+	offerQueued  offerResult = iota // the frame is in the send buffer of the client
+	offerDropped                    // the frame was dropped, the client stays (SlowDrop)
+	offerTooSlow                    // the client has to be disconnected
+	offerSkipped                    // the frame isn't for the client (see Client.takes)
+)
+
+// offer queues the frame for the client, applying the slow consumer policy when its send
+// buffer is full (a blocking send waits until wait is closed). It is called from the hub
+// goroutine and from the fan-out workers
+func (h *Hub) offer(client *Client, frame Frame, wait <-chan struct{}) offerResult {
+	if !client.takes(frame) {
+		return offerSkipped
+	}
+	select {
+	case client.send <- frame:
+		return offerQueued
+	default:
+	}
+	if wait != nil {
+		select {
+		case client.send <- frame:
+			return offerQueued
+		case <-wait:
+		}
+	}
+
+	h.metrics.messageDropped()
+	h.roomMetrics.frameDropped()
+	if h.slowPolicy == SlowDrop {
+		client.dropped.Add(1)
+		h.droppedFrames.Add(1)
+		return offerDropped
+	}
+	return offerTooSlow
+}
+
+// drop disconnects a client that can't keep up. Its writePump would only send the close frame
+// once it wrote out its full send buffer, so we hang up on it right away
+func (h *Hub) drop(client *Client) {
+	if h.remove(client, websocket.CloseTryAgainLater, "too slow") {
+		dropped := h.dropped.Add(1)
+		client.logger.Warn("client dropped, its send buffer is full", "name", client.name, "dropped_so_far", dropped)
+		h.metrics.slowClientDropped()
+		h.announceLeave(client)
+		go client.hangUp(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// hangUp sends the close frame and closes the connection without waiting for the frames
+// still queued, which makes both pumps return
+func (c *Client) hangUp(code int, reason string) {
+	c.transport.hangUp(code, reason, time.Now().Add(c.hub.config.WriteWait))
+}
@@ -0,0 +1,178 @@
+package chatter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSlowClientsAtTheBufferBoundary(t *testing.T) {
+	const buffer = 3
+	tests := []struct {
+		policy      SlowPolicy
+		stays       bool   // whether the slow client is still in the room once its buffer overflowed
+		dropped     uint64 // clients dropped
+		missed      uint64 // frames the slow client missed
+		queuedAfter int    // frames in its buffer once it overflowed
+	}{
+		{SlowDisconnect, false, 1, 0, buffer},
+		{SlowDrop, true, 0, 1, buffer},
+		{SlowBlock, false, 1, 0, buffer},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			hub := NewHub(WithSendBuffer(buffer), WithSlowPolicy(tt.policy, 0))
+			slow := pumpClient(hub, newFakeConn())
+			slow.name = "slow"
+			fast := pumpClient(hub, newFakeConn())
+			fast.name = "fast"
+			addClient(hub, slow)
+			addClient(hub, fast)
+
+			// the slow client never reads, the fast one reads everything
+			broadcast := func(i int) {
+				hub.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d", i)})
+				for len(fast.send) > 0 {
+					<-fast.send
+				}
+			}
+
+			// a full buffer is fine
+			for i := 0; i < buffer; i++ {
+				broadcast(i)
+			}
+			if _, ok := hub.clients[slow]; !ok || len(slow.send) != buffer || hub.Dropped() != 0 {
+				t.Fatalf("with a full buffer: in the room %t, %d frames queued, %d dropped", ok, len(slow.send), hub.Dropped())
+			}
+
+			// one more frame is one too many
+			broadcast(buffer)
+			if _, ok := hub.clients[slow]; ok != tt.stays {
+				t.Errorf("the slow client is in the room: %t, want %t", ok, tt.stays)
+			}
+			if _, ok := hub.clients[fast]; !ok {
+				t.Error("the fast client was dropped")
+			}
+			if got := hub.Dropped(); got != tt.dropped {
+				t.Errorf("%d clients dropped, want %d", got, tt.dropped)
+			}
+			if got := slow.dropped.Load(); got != tt.missed {
+				t.Errorf("the slow client missed %d frames, want %d", got, tt.missed)
+			}
+
+			// what the slow client had queued is still there for it, nothing past it
+			queued := 0
+			for len(slow.send) > 0 {
+				if _, ok := <-slow.send; ok {
+					queued++
+				}
+			}
+			if queued != tt.queuedAfter {
+				t.Errorf("%d frames were queued for the slow client, want %d", queued, tt.queuedAfter)
+			}
+		})
+	}
+}
+
+func TestSlowPoliciesUnderLoad(t *testing.T) {
+	const (
+		buffer   = 64
+		fast     = 50
+		bursts   = 6
+		perBurst = buffer / 2
+	)
+	tests := []struct {
+		name   string
+		policy SlowPolicy
+		wait   time.Duration
+		stall  time.Duration // how long the writes of the slow client hang (forever if zero)
+		stays  bool          // whether the slow client is still connected in the end
+		missed bool          // whether it missed some of the messages
+	}{
+		{"disconnect", SlowDisconnect, 0, 100 * time.Millisecond, false, true},
+		{"drop", SlowDrop, 0, 100 * time.Millisecond, true, true},
+		{"block through a stall", SlowBlock, 5 * time.Second, 100 * time.Millisecond, true, false},
+		{"block a client that hung", SlowBlock, 20 * time.Millisecond, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := runHub(t, NewHub(WithSendBuffer(buffer), WithSlowPolicy(tt.policy, tt.wait)))
+			conns := make([]*fakeConn, fast)
+			for i := range conns {
+				conns[i] = newFakeConn()
+				startClient(t, hub, conns[i], fmt.Sprintf("fast-%d", i))
+			}
+
+			// the slow client's writes hang until the gate opens (at the latest when the test ends)
+			slow := newFakeConn()
+			slow.gate = make(chan struct{})
+			opened := make(chan struct{})
+			open := func() {
+				select {
+				case <-opened:
+				default:
+					close(opened)
+					close(slow.gate)
+				}
+			}
+			t.Cleanup(open)
+			slowClient := startClient(t, hub, slow, "slow")
+			for _, conn := range conns {
+				waitWritten(t, conn, "slow joined")
+			}
+			if tt.stall > 0 {
+				time.AfterFunc(tt.stall, open)
+			}
+
+			// the bursts are smaller than the send buffers, the fast clients keep up with them
+			texts := make([]string, 0, bursts*perBurst)
+			for burst := 0; burst < bursts; burst++ {
+				for i := 0; i < perBurst; i++ {
+					text := fmt.Sprintf("message %d", len(texts))
+					texts = append(texts, text)
+					if _, err := hub.Publish(&Message{Kind: KindChat, Username: "bot", Text: text}, 10*time.Second); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, conn := range conns {
+					waitWritten(t, conn, texts[len(texts)-1]+"<")
+				}
+			}
+
+			stats := hub.Stats()
+			if tt.stays {
+				// once its writes go through again, the slow client gets the new messages
+				<-opened
+				if _, err := hub.Publish(&Message{Kind: KindChat, Username: "bot", Text: "caught up"}, 10*time.Second); err != nil {
+					t.Fatal(err)
+				}
+				waitWritten(t, slow, "caught up")
+				if slow.Closed() {
+					t.Error("the slow client was disconnected")
+				}
+			} else {
+				waitClosed(t, slow)
+				if code, reason, _ := slow.CloseFrame(); code != websocket.CloseTryAgainLater || reason != "too slow" {
+					t.Errorf("the slow client was closed with %d %q", code, reason)
+				}
+				if dropped := hub.Stats().Dropped; dropped != 1 {
+					t.Errorf("the stats show %d clients dropped, want 1", dropped)
+				}
+			}
+			if missed := slowClient.dropped.Load(); tt.policy == SlowDrop && (missed == 0 || stats.DroppedFrames != missed) {
+				t.Errorf("the slow client missed %d frames, the stats show %d", missed, stats.DroppedFrames)
+			}
+			received := strings.Join(slow.Messages(), "")
+			missed := false
+			for _, text := range texts {
+				missed = missed || !strings.Contains(received, text+"<")
+			}
+			if missed != tt.missed {
+				t.Errorf("the slow client missed messages: %t, want %t", missed, tt.missed)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sseHeartbeat is how often we write a comment on an idle SSE stream,
+// so proxies don't time the stream out
+const sseHeartbeat = 15 * time.Second
+
+// serveEvents streams the room as Server-Sent Events, for clients that can't use websockets.
+// Every message is an event with the message id as its id, so a client reconnecting
+// with Last-Event-ID only gets the messages it missed
+func serveEvents(manager *HubManager, limit *ConnLimit, w http.ResponseWriter, r *http.Request) {
+
+	// once the server is draining the clients have to wait for it to restart (see serveWs)
+	if refuseDraining(manager, w, r) {
+		return
+	}
+
+	// we need to flush every event as soon as it is written
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// the rooms are only created with a name we'd give them (see serveWs)
+	room := roomName(r)
+	if err := ValidateRoomName(room); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the streams count against the connections an address can keep open (see serveWs),
+	// the stream is open as long as we're serving it
+	ip := clientIP(r)
+	if !limit.acquire(ip) {
+		slog.Warn("event stream rejected: too many connections", "remote_ip", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(connLimitRetry.Seconds())))
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer limit.release(ip)
+
+	// create the client, it works like a websocket client as far as the hub is concerned
+	client := &Client{
+		id:          uuid.New().String(),
+		transport:   sseTransport{},
+		identityID:  requestIdentity(r),
+		name:        sanitizeName(r.URL.Query().Get("name")),
+		ip:          ip,
+		ua:          r.UserAgent(),
+		constrained: isConstrained(r),
+		resumeFrom:  lastEventID(r),
+		connectedAt: time.Now(),
+		done:        make(chan struct{}),
+	}
+	client.signIn(r)
+
+	// a full room turns new clients away, as it does over websockets (see serveWs)
+	if hub, err := manager.Get(room); err == nil {
+		// and so does a private room (see serveWs)
+		if ok, reason := hub.letIn(w, r); !ok {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		if !hub.reserve(requestAdmin(r)) {
+			refuseFull(w, r, hub)
+			return
+		}
+		client.reserved = hub
+	}
+	if err := joinRoom(manager, client, room); err != nil {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	defer func() {
+		// unregister the client from the hub (unless the hub is already gone)
+		select {
+		case client.hub.unregister <- client:
+		case <-client.hub.stop:
+		}
+		close(client.done)
+		client.logClosed()
+	}()
+
+	client.logger.Info("client streaming events")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// we start with the history (or what the client missed)
+	if len(client.replay) > 0 {
+		client.bytesOut.Add(uint64(writeEvent(w, joinFrames(client.replay))))
+		client.sent.Add(1)
+		client.replay = nil
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case frame, ok := <-client.send:
+			// the hub removed us, there is nothing more to stream
+			if !ok {
+				return
+			}
+			client.bytesOut.Add(uint64(writeEvent(w, frame)))
+			client.sent.Add(1)
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			// comments are ignored by the browser but keep the connection busy
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			// the client went away
+			return
+		}
+	}
+}
+
+// writeEvent writes the frame as an SSE event and returns its size, the id is only set for
+// message frames so Last-Event-ID always points at a message
+func writeEvent(w http.ResponseWriter, frame Frame) int {
+	written := 0
+	if frame.ID > 0 {
+		n, _ := fmt.Fprintf(w, "id: %d\n", frame.ID)
+		written += n
+	}
+
+	// every line of the payload needs its own data field
+	for _, line := range bytes.Split(frame.Data, newline) {
+		n, _ := fmt.Fprintf(w, "data: %s\n", line)
+		written += n
+	}
+	n, _ := fmt.Fprint(w, "\n")
+	return written + n
+}
+
+// lastEventID returns the id of the last event the client has seen,
+// browsers send it in the Last-Event-ID header when they reconnect
+func lastEventID(r *http.Request) uint64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,140 @@
+package chatter_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// event is a Server-Sent Event, its data lines joined
+type event struct {
+	id   string
+	data string
+}
+
+// subscribe streams the events of the room of the server, the stream is closed when the test ends
+func subscribe(t *testing.T, srv *chattertest.Server, name, lastEventID string) <-chan event {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?name="+name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("subscribing got %d %q", resp.StatusCode, ct)
+	}
+
+	events := make(chan event, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(events)
+		var ev event
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			switch line := scanner.Text(); {
+			case line == "":
+				if ev.data != "" {
+					events <- ev
+				}
+				ev = event{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data += strings.TrimPrefix(line, "data: ") + "\n"
+			}
+		}
+	}()
+	// the stream has to be gone before the server checks for leaks
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+		<-done
+	})
+	return events
+}
+
+// expectEvent waits for an event whose data contains substr
+func expectEvent(t *testing.T, events <-chan event, substr string) event {
+	t.Helper()
+	deadline := time.After(waitTimeout)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("the stream ended before an event with %q", substr)
+			}
+			if strings.Contains(ev.data, substr) {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("no event with %q", substr)
+		}
+	}
+}
+
+func TestEventStreamsGetTheMessages(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	events := subscribe(t, srv, "carol", "")
+	alice := srv.Connect(t, "alice")
+
+	alice.Send("hello carol")
+	ev := expectEvent(t, events, "hello carol")
+	// the id of a message event is the id of the message
+	if id, err := strconv.ParseUint(ev.id, 10, 64); err != nil || id == 0 {
+		t.Errorf("the event of a message has the id %q", ev.id)
+	}
+
+	// the subscriber is a client of the room, over its own transport
+	var transport string
+	for _, stats := range srv.Manager.Clients("") {
+		if stats.Name == "carol" {
+			transport = stats.Transport
+		}
+	}
+	if transport != "sse" {
+		t.Errorf("the subscriber has the transport %q, want sse", transport)
+	}
+}
+
+func TestEventStreamsResumeAfterTheLastEvent(t *testing.T) {
+	srv := chattertest.NewServer(t, chatter.WithHistoryReplay(50))
+	alice := srv.Connect(t, "alice")
+
+	alice.Send("seen")
+	alice.Expect("seen", waitTimeout)
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := hub.History(0, 1)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("reading the history: %v %v", history, err)
+	}
+
+	// messages sent while the subscriber was away
+	alice.Send("missed one")
+	alice.Send("missed two")
+	alice.Expect("missed two", waitTimeout)
+
+	// reconnecting with the last id it got, the subscriber gets what it missed only
+	events := subscribe(t, srv, "carol", strconv.FormatUint(history[0].ID, 10))
+	replay := expectEvent(t, events, "missed two")
+	if strings.Contains(replay.data, ">seen<") || !strings.Contains(replay.data, "missed one") {
+		t.Errorf("the resumed stream didn't start after the last event:\n%s", replay.data)
+	}
+}
@@ -0,0 +1,250 @@
+package chatter
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// DefaultHistoryCapacity is the number of messages per room the in-memory store keeps by default
+const DefaultHistoryCapacity = 5000
+
+// MessageStore keeps the message history of every room
+type MessageStore interface {
+	// Append adds the message to the history of the room
+	Append(room string, msg *Message) error
+	// RecentN returns the n most recent messages of the room, oldest first
+	RecentN(room string, n int) ([]*Message, error)
+	// Since returns the messages of the room with an id greater than id, oldest first
+	Since(room string, id uint64) ([]*Message, error)
+	// Before returns the (up to) n most recent messages of the room with an id lower than id, oldest first
+	Before(room string, id uint64, n int) ([]*Message, error)
+}
+
+// EditableStore is a MessageStore whose messages can be changed after they were added,
+// the hub needs one to edit messages
+type EditableStore interface {
+	MessageStore
+	// Get returns the message of the room with the id, or nil if it isn't kept (anymore)
+	Get(room string, id uint64) (*Message, error)
+	// Update replaces the message of the room having the same id as msg,
+	// a message that isn't kept (anymore) is left alone
+	Update(room string, msg *Message) error
+}
+
+// ThreadStore is a MessageStore that can look up the replies to a message
+type ThreadStore interface {
+	MessageStore
+	// Replies returns the (up to) n first replies to the message of the room with the id parent, oldest first
+	Replies(room string, parent uint64, n int) ([]*Message, error)
+}
+
+// PinStore is a MessageStore that keeps the pinned messages of the rooms as well
+type PinStore interface {
+	MessageStore
+	// Pins returns the ids of the pinned messages of the room, in the order they were pinned
+	Pins(room string) ([]uint64, error)
+	// SetPins replaces the pinned messages of the room
+	SetPins(room string, ids []uint64) error
+}
+
+// ReadStore is a MessageStore that remembers up to which message the readers of the rooms have read,
+// a reader being the identity of a client
+type ReadStore interface {
+	MessageStore
+	// LastRead returns the id of the last message of the room the reader has read (zero if none)
+	LastRead(room, reader string) (uint64, error)
+	// SetLastRead sets the id of the last message of the room the reader has read
+	SetLastRead(room, reader string, id uint64) error
+}
+
+// PingStore is a MessageStore kept somewhere it can be unreachable from (e.g. a database),
+// the readiness probe checks it can still get to it
+type PingStore interface {
+	MessageStore
+	// Ping checks the store is reachable
+	Ping(ctx context.Context) error
+}
+
+// MemoryStore is a MessageStore keeping the history in memory,
+// each room keeps up to a fixed number of messages and the oldest are evicted.
+// The history is lost when the server stops
+type MemoryStore struct {
+	sync.RWMutex
+	capacity int                 // number of messages kept per room
+	rooms    map[string]*ring    // message history by room
+	pins     map[string][]uint64 // pinned messages by room
+	reads    map[string]uint64   // last message read by room and reader
+}
+
+// NewMemoryStore creates a new in-memory store keeping up to capacity messages per room
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity < 1 {
+		capacity = DefaultHistoryCapacity
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		rooms:    make(map[string]*ring),
+		pins:     make(map[string][]uint64),
+		reads:    make(map[string]uint64),
+	}
+}
+
+// Append adds the message to the history of the room, evicting the oldest message if it is full
+func (s *MemoryStore) Append(room string, msg *Message) error {
+	s.Lock()
+	defer s.Unlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		history = newRing(s.capacity)
+		s.rooms[room] = history
+	}
+	history.push(msg)
+
+	return nil
+}
+
+// RecentN returns the n most recent messages of the room, oldest first
+func (s *MemoryStore) RecentN(room string, n int) ([]*Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	return history.last(n), nil
+}
+
+// Since returns the messages of the room with an id greater than id, oldest first.
+// Messages that have been evicted are not returned
+func (s *MemoryStore) Since(room string, id uint64) ([]*Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	return history.since(id), nil
+}
+
+// Before returns the (up to) n most recent messages of the room with an id lower than id, oldest first.
+// Messages that have been evicted are not returned
+func (s *MemoryStore) Before(room string, id uint64, n int) ([]*Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	return history.before(id, n), nil
+}
+
+// Get returns the message of the room with the id, or nil if it has been evicted
+func (s *MemoryStore) Get(room string, id uint64) (*Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	if i := history.find(id); i >= 0 {
+		return history.at(i), nil
+	}
+	return nil, nil
+}
+
+// Update replaces the message of the room having the same id as msg.
+// The messages handed out before are left as they were, they may still be read
+func (s *MemoryStore) Update(room string, msg *Message) error {
+	s.Lock()
+	defer s.Unlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil
+	}
+	if i := history.find(msg.ID); i >= 0 {
+		history.set(i, msg)
+	}
+	return nil
+}
+
+// Replies returns the (up to) n first replies to the message of the room with the id parent, oldest first.
+// Replies that have been evicted are not returned
+func (s *MemoryStore) Replies(room string, parent uint64, n int) ([]*Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+
+	// replies come after their parent
+	var replies []*Message
+	for _, msg := range history.since(parent) {
+		if len(replies) == n {
+			break
+		}
+		if msg.ReplyTo == parent {
+			replies = append(replies, msg)
+		}
+	}
+	return replies, nil
+}
+
+// Pins returns the ids of the pinned messages of the room, in the order they were pinned
+func (s *MemoryStore) Pins(room string) ([]uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return slices.Clone(s.pins[room]), nil
+}
+
+// SetPins replaces the pinned messages of the room
+func (s *MemoryStore) SetPins(room string, ids []uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(ids) == 0 {
+		delete(s.pins, room)
+		return nil
+	}
+	s.pins[room] = slices.Clone(ids)
+	return nil
+}
+
+// LastRead returns the id of the last message of the room the reader has read (zero if none)
+func (s *MemoryStore) LastRead(room, reader string) (uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.reads[room+"\x00"+reader], nil
+}
+
+// SetLastRead sets the id of the last message of the room the reader has read
+func (s *MemoryStore) SetLastRead(room, reader string, id uint64) error {
+	s.Lock()
+	defer s.Unlock()
+	s.reads[room+"\x00"+reader] = id
+	return nil
+}
+
+// Len returns the number of messages kept for the room
+func (s *MemoryStore) Len(room string) int {
+	s.RLock()
+	defer s.RUnlock()
+
+	if history, ok := s.rooms[room]; ok {
+		return history.size
+	}
+	return 0
+}
+
+// Capacity returns the number of messages kept per room
+func (s *MemoryStore) Capacity() int {
+	return s.capacity
+}
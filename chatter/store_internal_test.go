@@ -0,0 +1,40 @@
+package chatter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHistoryEvictsTheOldestMessages(t *testing.T) {
+	const capacity, extra = 10, 4
+	store := NewMemoryStore(capacity)
+	hub := NewHub(WithStore(store))
+
+	var ids []uint64
+	for i := 0; i < capacity+extra; i++ {
+		msg := &Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: fmt.Sprintf("message %d", i)}
+		hub.broadcastMessage(msg)
+		ids = append(ids, msg.ID)
+	}
+
+	stats := hub.Stats()
+	if stats.HistoryLength != capacity || stats.HistoryCapacity != capacity {
+		t.Errorf("the history holds %d of %d messages, want %d of %d", stats.HistoryLength, stats.HistoryCapacity, capacity, capacity)
+	}
+
+	// the oldest messages are gone, the others are still in order
+	kept := ids[extra:]
+	messages, err := store.RecentN(hub.room, capacity+extra)
+	assertIDs(t, "RecentN", messages, err, kept...)
+	// a replay from the start only gets what is left
+	messages, err = store.Since(hub.room, 0)
+	assertIDs(t, "Since", messages, err, kept...)
+	// and paging back stops at the oldest message kept
+	messages, err = store.Before(hub.room, kept[2], capacity)
+	assertIDs(t, "Before", messages, err, kept[:2]...)
+	for _, id := range ids[:extra] {
+		if msg, _ := store.Get(hub.room, id); msg != nil {
+			t.Errorf("message %d is still kept", id)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package chatter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	// pure Go SQLite driver, registers itself as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// migrations are the statements bringing the schema up to date, in order.
+// The schema version is kept in SQLite's user_version, so a migration must never be changed
+// once released, add a new one instead
+var migrations = []string{
+	// 1: the message history
+	`CREATE TABLE messages (
+		room      TEXT    NOT NULL,
+		id        INTEGER NOT NULL,
+		client_id TEXT    NOT NULL,
+		username  TEXT    NOT NULL,
+		text      TEXT    NOT NULL,
+		ts        INTEGER NOT NULL,
+		PRIMARY KEY (room, id)
+	)`,
+	// 2: the kind of message (chat, system)
+	`ALTER TABLE messages ADD COLUMN kind TEXT NOT NULL DEFAULT 'chat'`,
+	// 3: whether the message has been edited
+	`ALTER TABLE messages ADD COLUMN edited INTEGER NOT NULL DEFAULT 0`,
+	// 4: whether the message has been deleted
+	`ALTER TABLE messages ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0`,
+	// 5: the reactions to the message, as JSON
+	`ALTER TABLE messages ADD COLUMN reactions TEXT NOT NULL DEFAULT ''`,
+	// 6: the message a message replies to
+	`ALTER TABLE messages ADD COLUMN reply_to INTEGER NOT NULL DEFAULT 0`,
+	// 7: looking up the replies to a message
+	`CREATE INDEX messages_reply_to ON messages (room, reply_to) WHERE reply_to != 0`,
+	// 8: the pinned messages
+	`CREATE TABLE pins (
+		room     TEXT    NOT NULL,
+		id       INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (room, id)
+	)`,
+	// 9: the last message each reader has read
+	`CREATE TABLE reads (
+		room   TEXT    NOT NULL,
+		reader TEXT    NOT NULL,
+		id     INTEGER NOT NULL,
+		PRIMARY KEY (room, reader)
+	)`,
+	// 10: the picture of the sender
+	`ALTER TABLE messages ADD COLUMN avatar TEXT NOT NULL DEFAULT ''`,
+}
+
+// SQLiteStore is a MessageStore keeping the history in a SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the database at path and migrates it to the latest schema
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	// SQLite only handles a single writer, so we don't let database/sql open more connections
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// migrate runs the migrations the database hasn't seen yet
+func (s *SQLiteStore) migrate() error {
+
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't take parameters, the version is an int so formatting it in is safe
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks the database can still be reached
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Append adds the message to the history of the room
+func (s *SQLiteStore) Append(room string, msg *Message) error {
+	_, err := s.db.Exec(
+		`INSERT INTO messages (room, id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		room, msg.ID, msg.Kind, msg.ClientID, msg.Username, msg.Text, msg.Timestamp.UnixNano(), msg.Edited, msg.Deleted, encodeReactions(msg.Reactions), msg.ReplyTo, msg.Avatar,
+	)
+	return err
+}
+
+// RecentN returns the n most recent messages of the room, oldest first
+func (s *SQLiteStore) RecentN(room string, n int) ([]*Message, error) {
+
+	// we take the most recent messages and flip them back in order
+	messages, err := s.query(
+		`SELECT id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar FROM messages WHERE room = ? ORDER BY id DESC LIMIT ?`,
+		room, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse(messages)
+	return messages, nil
+}
+
+// Before returns the (up to) n most recent messages of the room with an id lower than id, oldest first
+func (s *SQLiteStore) Before(room string, id uint64, n int) ([]*Message, error) {
+
+	// we take the most recent messages before id and flip them back in order
+	messages, err := s.query(
+		`SELECT id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar FROM messages WHERE room = ? AND id < ? ORDER BY id DESC LIMIT ?`,
+		room, id, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse(messages)
+	return messages, nil
+}
+
+// reverse reverses the messages in place
+func reverse(messages []*Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// Since returns the messages of the room with an id greater than id, oldest first
+func (s *SQLiteStore) Since(room string, id uint64) ([]*Message, error) {
+	return s.query(
+		`SELECT id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar FROM messages WHERE room = ? AND id > ? ORDER BY id`,
+		room, id,
+	)
+}
+
+// Get returns the message of the room with the id, or nil if there is none
+func (s *SQLiteStore) Get(room string, id uint64) (*Message, error) {
+	messages, err := s.query(
+		`SELECT id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar FROM messages WHERE room = ? AND id = ?`,
+		room, id,
+	)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+// Update replaces the text of the message of the room having the same id as msg
+func (s *SQLiteStore) Update(room string, msg *Message) error {
+	_, err := s.db.Exec(
+		`UPDATE messages SET text = ?, edited = ?, deleted = ?, reactions = ? WHERE room = ? AND id = ?`,
+		msg.Text, msg.Edited, msg.Deleted, encodeReactions(msg.Reactions), room, msg.ID,
+	)
+	return err
+}
+
+// Replies returns the (up to) n first replies to the message of the room with the id parent, oldest first
+func (s *SQLiteStore) Replies(room string, parent uint64, n int) ([]*Message, error) {
+	return s.query(
+		`SELECT id, kind, client_id, username, text, ts, edited, deleted, reactions, reply_to, avatar FROM messages WHERE room = ? AND reply_to = ? ORDER BY id LIMIT ?`,
+		room, parent, n,
+	)
+}
+
+// Pins returns the ids of the pinned messages of the room, in the order they were pinned
+func (s *SQLiteStore) Pins(room string) ([]uint64, error) {
+
+	rows, err := s.db.Query(`SELECT id FROM pins WHERE room = ? ORDER BY position`, room)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetPins replaces the pinned messages of the room
+func (s *SQLiteStore) SetPins(room string, ids []uint64) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM pins WHERE room = ?`, room); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.Exec(`INSERT INTO pins (room, id, position) VALUES (?, ?, ?)`, room, id, i); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastRead returns the id of the last message of the room the reader has read (zero if none)
+func (s *SQLiteStore) LastRead(room, reader string) (uint64, error) {
+	var id uint64
+	err := s.db.QueryRow(`SELECT id FROM reads WHERE room = ? AND reader = ?`, room, reader).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// SetLastRead sets the id of the last message of the room the reader has read
+func (s *SQLiteStore) SetLastRead(room, reader string, id uint64) error {
+	_, err := s.db.Exec(`INSERT INTO reads (room, reader, id) VALUES (?, ?, ?)
+		ON CONFLICT (room, reader) DO UPDATE SET id = excluded.id`, room, reader, id)
+	return err
+}
+
+// encodeReactions encodes the reactions for the reactions column, no reactions is empty
+func encodeReactions(reactions []Reaction) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	// a slice of plain structs always encodes
+	encoded, _ := json.Marshal(reactions)
+	return string(encoded)
+}
+
+// query runs a query selecting messages and scans them
+func (s *SQLiteStore) query(query string, args ...any) ([]*Message, error) {
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var ts int64
+		var reactions string
+		if err := rows.Scan(&msg.ID, &msg.Kind, &msg.ClientID, &msg.Username, &msg.Text, &ts, &msg.Edited, &msg.Deleted, &reactions, &msg.ReplyTo, &msg.Avatar); err != nil {
+			return nil, err
+		}
+		msg.Timestamp = time.Unix(0, ts)
+		if reactions != "" {
+			if err := json.Unmarshal([]byte(reactions), &msg.Reactions); err != nil {
+				return nil, fmt.Errorf("reactions of message %d: %w", msg.ID, err)
+			}
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
@@ -0,0 +1,151 @@
+package chatter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openSQLite opens a store on a new database of the test, closed when the test ends
+func openSQLite(t *testing.T) (*SQLiteStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chat.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+// appendMessages appends the messages with the ids from..to to the room
+func appendMessages(t *testing.T, store MessageStore, room string, from, to uint64) {
+	t.Helper()
+	for id := from; id <= to; id++ {
+		msg := &Message{ID: id, Kind: KindChat, ClientID: "alice-1", Username: "alice", Text: fmt.Sprintf("message %d", id), Timestamp: time.Unix(0, int64(id))}
+		if err := store.Append(room, msg); err != nil {
+			t.Fatalf("appending message %d: %v", id, err)
+		}
+	}
+}
+
+// assertIDs checks the messages have the ids, in order
+func assertIDs(t *testing.T, what string, messages []*Message, err error, want ...uint64) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	got := make([]uint64, len(messages))
+	for i, msg := range messages {
+		got[i] = msg.ID
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s returned the messages %v, want %v", what, got, want)
+	}
+}
+
+func TestSQLiteStoreMigrates(t *testing.T) {
+	store, path := openSQLite(t)
+
+	var version int
+	if err := store.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("the schema is at version %d, want %d", version, len(migrations))
+	}
+	appendMessages(t, store, "lobby", 1, 3)
+	store.Close()
+
+	// opening it again doesn't run the migrations again, and keeps the history
+	again, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopening the database: %v", err)
+	}
+	defer again.Close()
+	messages, err := again.RecentN("lobby", 10)
+	assertIDs(t, "RecentN after reopening", messages, err, 1, 2, 3)
+}
+
+func TestSQLiteStoreQueries(t *testing.T) {
+	store, _ := openSQLite(t)
+	appendMessages(t, store, "lobby", 1, 10)
+	appendMessages(t, store, "kitchen", 1, 2)
+
+	messages, err := store.RecentN("lobby", 3)
+	assertIDs(t, "RecentN", messages, err, 8, 9, 10)
+	messages, err = store.Before("lobby", 5, 2)
+	assertIDs(t, "Before", messages, err, 3, 4)
+	messages, err = store.Before("lobby", 1, 2)
+	assertIDs(t, "Before the first message", messages, err)
+	messages, err = store.Since("lobby", 7)
+	assertIDs(t, "Since", messages, err, 8, 9, 10)
+	messages, err = store.RecentN("kitchen", 10)
+	assertIDs(t, "RecentN of another room", messages, err, 1, 2)
+
+	// the messages come back the way they were stored
+	msg, err := store.Get("lobby", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil || msg.Username != "alice" || msg.Text != "message 4" || !msg.Timestamp.Equal(time.Unix(0, 4)) {
+		t.Fatalf("Get returned %+v", msg)
+	}
+	if missing, err := store.Get("lobby", 42); err != nil || missing != nil {
+		t.Errorf("Get of a missing message returned %+v, %v", missing, err)
+	}
+
+	msg.Text, msg.Edited = "edited", true
+	msg.Reactions = []Reaction{{Emoji: "👍", Clients: []string{"bob-1"}}}
+	if err := store.Update("lobby", msg); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := store.Get("lobby", 4); updated.Text != "edited" || !updated.Edited || len(updated.Reactions) != 1 {
+		t.Errorf("the update wasn't kept: %+v", updated)
+	}
+}
+
+func TestSQLiteStorePinsAndReads(t *testing.T) {
+	store, _ := openSQLite(t)
+
+	if err := store.SetPins("lobby", []uint64{7, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if pins, err := store.Pins("lobby"); err != nil || fmt.Sprint(pins) != "[7 3]" {
+		t.Errorf("Pins returned %v, %v, want [7 3]", pins, err)
+	}
+
+	if id, err := store.LastRead("lobby", "alice"); err != nil || id != 0 {
+		t.Errorf("LastRead of a new reader returned %d, %v, want 0", id, err)
+	}
+	store.SetLastRead("lobby", "alice", 3)
+	store.SetLastRead("lobby", "alice", 5)
+	if id, err := store.LastRead("lobby", "alice"); err != nil || id != 5 {
+		t.Errorf("LastRead returned %d, %v, want 5", id, err)
+	}
+}
+
+func TestStoreErrorsDontStopTheHub(t *testing.T) {
+	store, _ := openSQLite(t)
+	hub := runHub(t, NewHub(WithStore(store)))
+	conn := newFakeConn()
+	client := joinHub(t, hub, conn, "alice")
+	go client.writePump()
+	go client.readPump()
+
+	// the database goes away, the messages are still delivered
+	store.Close()
+	for i := 0; i < 3; i++ {
+		hub.broadcast <- &Message{Kind: KindChat, ClientID: "bob-1", Username: "bob", Text: fmt.Sprintf("still here %d", i)}
+	}
+	deadline := time.After(time.Second)
+	for strings.Count(strings.Join(conn.Messages(), ""), "still here") < 3 {
+		select {
+		case <-conn.wrote:
+		case <-deadline:
+			t.Fatalf("the messages weren't all delivered with a failing store:\n%s", strings.Join(conn.Messages(), "\n"))
+		}
+	}
+}
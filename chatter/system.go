@@ -0,0 +1,50 @@
+package chatter
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// departureGrace is how long the hub waits before announcing a client left,
+	// so a page refresh doesn't produce a leave and a join right after each other
+	departureGrace = 5 * time.Second
+	// sweepInterval is how often the hub looks for typing indicators that expired
+	// and departures that are due
+	sweepInterval = time.Second
+)
+
+// announceJoin broadcasts a system message saying the client joined,
+// unless it left recently in which case we just forget its departure
+func (h *Hub) announceJoin(client *Client) {
+	if _, ok := h.leaving[client.name]; ok {
+		delete(h.leaving, client.name)
+		return
+	}
+
+	h.broadcastMessage(&Message{
+		Kind: KindSystem,
+		Text: fmt.Sprintf("%s joined", client.name),
+	})
+}
+
+// announceLeave schedules the system message saying the client left,
+// it is only broadcast if the client doesn't come back within departureGrace
+func (h *Hub) announceLeave(client *Client) {
+	h.leaving[client.name] = h.clock.Now().Add(departureGrace)
+}
+
+// announceDepartures broadcasts the departures that are due
+func (h *Hub) announceDepartures(now time.Time) {
+	for name, due := range h.leaving {
+		if now.Before(due) {
+			continue
+		}
+
+		delete(h.leaving, name)
+		h.broadcastMessage(&Message{
+			Kind: KindSystem,
+			Text: fmt.Sprintf("%s left", name),
+		})
+	}
+}
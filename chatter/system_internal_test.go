@@ -0,0 +1,65 @@
+package chatter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// systemTexts returns the texts of the system messages in the history of the hub
+func systemTexts(t *testing.T, hub *Hub) []string {
+	t.Helper()
+	messages, err := hub.History(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, msg := range messages {
+		if msg.Kind == KindSystem {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+func TestJoinsAndDeparturesAreAnnounced(t *testing.T) {
+	clock := newFakeClock()
+	hub := NewHub(WithClock(clock))
+	alice := &Client{name: "alice"}
+
+	hub.announceJoin(alice)
+	hub.announceLeave(alice)
+	// the departure waits for the grace period
+	hub.announceDepartures(clock.Now())
+	if texts := systemTexts(t, hub); fmt.Sprint(texts) != "[alice joined]" {
+		t.Fatalf("the system messages are %q before the grace period", texts)
+	}
+	clock.Advance(departureGrace)
+	hub.announceDepartures(clock.Now())
+	if texts := systemTexts(t, hub); fmt.Sprint(texts) != "[alice joined alice left]" {
+		t.Errorf("the system messages are %q", texts)
+	}
+
+	// they're styled apart from the chat messages
+	messages, _ := hub.History(0, 1)
+	if rendered := string(getMessageTemplate(messages[0], "", false)); !strings.Contains(rendered, "italic") {
+		t.Errorf("the system message isn't rendered with its own template:\n%s", rendered)
+	}
+}
+
+func TestReconnectsAreNotAnnounced(t *testing.T) {
+	clock := newFakeClock()
+	hub := NewHub(WithClock(clock))
+	alice := &Client{name: "alice"}
+	hub.announceJoin(alice)
+
+	// a page refresh: alice leaves and comes back within the grace period
+	hub.announceLeave(alice)
+	hub.announceJoin(alice)
+	clock.Advance(2 * departureGrace)
+	hub.announceDepartures(clock.Now())
+
+	if texts := systemTexts(t, hub); fmt.Sprint(texts) != "[alice joined]" {
+		t.Errorf("the system messages are %q, want alice joined only", texts)
+	}
+}
@@ -0,0 +1,541 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// kinds of fragments rendered besides the messages (see KindChat, KindSystem, ...),
+// each kind is rendered with its own template
+const (
+	KindDirect    = "dm"        // a direct message
+	KindError     = "error"     // an error meant for a single client
+	KindTyping    = "typing"    // the typing indicator
+	KindPresence  = "presence"  // the list of clients in the room
+	KindHelp      = "help"      // the list of commands
+	KindEdit      = "edit"      // a message replacing the one with the same id
+	KindDeleted   = "deleted"   // what is left of a deleted message
+	KindTombstone = "tombstone" // a deleted message replacing the one with the same id
+	KindReactions = "reactions" // the reactions under a message
+	KindPinned    = "pinned"    // the pinned messages of the room
+	KindMention   = "mention"   // the notification of a client mentioned in a message
+	KindAck       = "ack"       // the acknowledgment of a message, sent to its sender
+	KindUnread    = "unread"    // the number of messages a client hasn't read yet
+	KindLogin     = "login"     // the login page
+	KindFull      = "full"      // the notice of a room that is full
+	KindIdle      = "idle"      // the notice of a client disconnected for inactivity
+	KindClients   = "clients"   // the table of the connected clients, for admins
+	KindAdmin     = "admin"     // the admin dashboard page
+	KindDashboard = "dashboard" // the live part of the admin dashboard, the room it watches
+	KindRooms     = "rooms"     // the directory of the rooms
+	// KindRoomError is the page telling a visitor the room they asked for can't be opened
+	KindRoomError = "room_error"
+	// KindAnnouncements is the banners of the announcements of the room
+	KindAnnouncements = "announcements"
+)
+
+// itemSuffix names the part of a message template rendering just the message ("chat item"),
+// without the swap around it, pages rendering the history themselves use it (see messageItem)
+const itemSuffix = " item"
+
+// compactSuffix marks the kinds rendered for constrained clients ("chat.compact"),
+// ownSuffix the kinds rendered for the sender of the message ("chat.own").
+// A kind without a template for the variant uses its regular one
+const (
+	compactSuffix = ".compact"
+	ownSuffix     = ".own"
+)
+
+// variant reports whether the kind is a variant of a regular kind, and returns the regular one
+func variant(kind string) (string, bool) {
+	for _, suffix := range []string{compactSuffix, ownSuffix} {
+		if regular, ok := strings.CutSuffix(kind, suffix); ok {
+			return regular, true
+		}
+	}
+	return kind, false
+}
+
+// defaultKind is the kind whose template renders the kinds nobody registered
+const defaultKind = KindChat
+
+// templateKinds is the name of the template rendering each kind
+var (
+	templateKindsMu sync.RWMutex
+	templateKinds   = map[string]string{
+		KindChat:                 "message.html",
+		KindChat + compactSuffix: "message_compact.html",
+		KindChat + ownSuffix:     "message_own.html",
+		KindSystem:               "system.html",
+		KindAction:               "action.html",
+		KindAnnouncement:         "announcement.html",
+		KindDirect:               "dm.html",
+		KindError:                "error.html",
+		KindTyping:               "typing.html",
+		KindPresence:             "presence.html",
+		KindHelp:                 "help.html",
+		KindEdit:                 "edit.html",
+		KindDeleted:              "deleted.html",
+		KindTombstone:            "tombstone.html",
+		KindReactions:            "reactions.html",
+		KindPinned:               "pinned.html",
+		KindMention:              "mention.html",
+		KindAck:                  "ack.html",
+		KindUnread:               "unread.html",
+		KindLogin:                "login.html",
+		KindFull:                 "full.html",
+		KindIdle:                 "idle.html",
+		KindClients:              "clients.html",
+		KindAdmin:                "admin.html",
+		KindDashboard:            "dashboard.html",
+		KindAnnouncements:        "announcements.html",
+		KindRooms:                "rooms.html",
+		KindRoomError:            "room_error.html",
+	}
+)
+
+// RegisterTemplate renders the kind with the template called name (the file name for
+// templates parsed by LoadTemplates), e.g. to render a kind of message of your own.
+// The variants of a kind ("chat.compact" for constrained clients, "chat.own" for the sender)
+// are optional
+func RegisterTemplate(kind, name string) {
+	templateKindsMu.Lock()
+	defer templateKindsMu.Unlock()
+	templateKinds[kind] = name
+
+	// the kinds may now be rendered with another template
+	if current := templates.Load(); current != nil {
+		current.forget()
+	}
+}
+
+// the template set, parsed the first time a message is rendered (unless LoadTemplates
+// was called before) and swapped in one go when it is reloaded.
+// We use html/template so anything a user types is escaped before it reaches other browsers.
+var (
+	templates     atomic.Pointer[templateSet]
+	templatesOnce sync.Once
+)
+
+// templateSet is a set of templates, along with the template each kind rendered so far
+// was rendered with (so we only look a kind up once, and only warn once about it)
+type templateSet struct {
+	set     *template.Template
+	lookups sync.Map // kind -> *template.Template
+}
+
+// forget forgets the templates the kinds were rendered with
+func (s *templateSet) forget() {
+	s.lookups.Range(func(kind, _ any) bool {
+		s.lookups.Delete(kind)
+		return true
+	})
+}
+
+// LoadTemplates parses every template (*.html) in fsys, which must have the files used by
+// the kinds (message.html, error.html, ... see DefaultTemplates), and uses them from now on.
+// If they can't be parsed the templates in use stay as they are
+func LoadTemplates(fsys fs.FS) error {
+	set, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "*.html")
+	if err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
+	}
+	return UseTemplates(set)
+}
+
+// UseTemplates renders the kinds with the templates of set from now on, the templates are
+// looked up by the names given to RegisterTemplate. Templates using the helpers of ours
+// (humanTime) need to be parsed with TemplateFuncs
+func UseTemplates(set *template.Template) error {
+
+	// every kind needs its template (variants are optional)
+	templateKindsMu.RLock()
+	defer templateKindsMu.RUnlock()
+	for kind, name := range templateKinds {
+		if _, optional := variant(kind); set.Lookup(name) == nil && !optional {
+			return fmt.Errorf("parsing templates: no template %s for kind %s", name, kind)
+		}
+	}
+
+	templates.Store(&templateSet{set: set})
+	return nil
+}
+
+// TemplateFuncs returns the helpers our templates use, for sets given to UseTemplates
+func TemplateFuncs() template.FuncMap {
+	return templateFuncs
+}
+
+// loadTemplates makes sure the templates are parsed, the default ones
+// unless LoadTemplates was called first
+func loadTemplates() {
+	templatesOnce.Do(func() {
+		if templates.Load() != nil {
+			return
+		}
+		if err := LoadTemplates(DefaultTemplates()); err != nil {
+			slog.Error("loading the default templates", "err", err)
+		}
+	})
+}
+
+// lookupTemplate returns the template rendering the kind, kinds nobody registered get
+// the default one (with a warning) and variants fall back to their regular template.
+// It returns nil if there are no templates
+func lookupTemplate(kind string) *template.Template {
+	loadTemplates()
+	current := templates.Load()
+	if current == nil {
+		return nil
+	}
+
+	if tmpl, ok := current.lookups.Load(kind); ok {
+		return tmpl.(*template.Template)
+	}
+	tmpl := current.resolve(kind)
+	if tmpl != nil {
+		current.lookups.Store(kind, tmpl)
+	}
+	return tmpl
+}
+
+// resolve finds the template rendering the kind in the set (see lookupTemplate)
+func (s *templateSet) resolve(kind string) *template.Template {
+	templateKindsMu.RLock()
+	name, ok := templateKinds[kind]
+	templateKindsMu.RUnlock()
+	if ok {
+		if tmpl := s.set.Lookup(name); tmpl != nil {
+			return tmpl
+		}
+	}
+
+	if regular, ok := variant(kind); ok {
+		return s.resolve(regular)
+	}
+	if kind == defaultKind {
+		return nil
+	}
+	slog.Warn("no template for the kind, using the default one", "kind", kind)
+	return s.resolve(defaultKind)
+}
+
+// templateFuncs are the helpers available in every template
+var templateFuncs = template.FuncMap{
+	"humanTime": humanTime,
+}
+
+// messageItem is added in init, it renders with the templates that use it (init cycle)
+func init() {
+	templateFuncs["messageItem"] = messageItem
+}
+
+// humanTime formats t for display: just the time for today, the date as well for older times
+func humanTime(t time.Time) string {
+	return formatTime(t, time.Now())
+}
+
+// formatTime formats t the way humanTime does, as seen at now
+func formatTime(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	t = t.In(now.Location())
+
+	// today we only show the time
+	if y, m, d := t.Date(); y == now.Year() && m == now.Month() && d == now.Day() {
+		return t.Format("15:04")
+	}
+	// this year we leave the year out
+	if t.Year() == now.Year() {
+		return t.Format("2 Jan 15:04")
+	}
+	return t.Format("2 Jan 2006 15:04")
+}
+
+// getMessageTemplate returns the message template as a byte array to be sent to the client
+// with the id recipient (empty if it isn't for a client in particular), the sender gets its
+// own variant and compact selects the lean variant for constrained clients.
+// It returns nil if the message could not be rendered.
+func getMessageTemplate(msg *Message, recipient string, compact bool) []byte {
+	return renderTemplate(lookupTemplate(messageKind(msg, recipient, compact)), msg)
+}
+
+// messageKind returns the kind of the template rendering the message for the recipient
+func messageKind(msg *Message, recipient string, compact bool) string {
+
+	// messages are rendered by kind (older messages don't have one, they're chat messages),
+	// whatever their kind only the tombstone of deleted messages is left
+	kind := msg.Kind
+	if kind == "" {
+		kind = KindChat
+	}
+	if msg.Deleted {
+		kind = KindDeleted
+	}
+
+	switch {
+	case compact:
+		return kind + compactSuffix
+	case recipient != "" && recipient == msg.ClientID:
+		return kind + ownSuffix
+	}
+	return kind
+}
+
+// variants renders a message for the clients it is sent to, each variant is only
+// rendered once however many clients get it (and only if a client gets it)
+type variants struct {
+	msg      *Message
+	rendered map[string][]byte // by kind
+	encoded  []byte            // for the JSON clients
+}
+
+// newVariants prepares the rendering of the message
+func newVariants(msg *Message) *variants {
+	return &variants{msg: msg, rendered: make(map[string][]byte, 2)}
+}
+
+// render returns the message rendered for the recipient (see getMessageTemplate),
+// or nil if it could not be rendered
+func (v *variants) render(recipient string, compact bool) []byte {
+	kind := messageKind(v.msg, recipient, compact)
+	if rendered, ok := v.rendered[kind]; ok {
+		return rendered
+	}
+
+	rendered := renderTemplate(lookupTemplate(kind), v.msg)
+	v.rendered[kind] = rendered
+	return rendered
+}
+
+// json returns the message encoded for the JSON clients (see JSONProtocol)
+func (v *variants) json() []byte {
+	if v.encoded == nil {
+		v.encoded = encodeJSON(v.msg, "")
+	}
+	return v.encoded
+}
+
+// prepare renders every variant the recipients can get up front, after that render only reads
+// the rendered variants so it is safe to call from the fan-out workers
+func (v *variants) prepare() {
+	v.render("", false)
+	v.render("", true)
+	v.render(v.msg.ClientID, false)
+	v.json()
+}
+
+// messageItem renders msg as the item of the list of messages, for pages rendering the
+// history server-side ({{ range .Messages }}{{ messageItem . }}{{ end }}).
+// Kinds without an item template are rendered as chat messages
+func messageItem(msg *Message) template.HTML {
+	loadTemplates()
+	current := templates.Load()
+	if current == nil {
+		return ""
+	}
+	set := current.set
+
+	kind := msg.Kind
+	if kind == "" {
+		kind = KindChat
+	}
+	if msg.Deleted {
+		kind = KindDeleted
+	}
+	tmpl := set.Lookup(kind + itemSuffix)
+	if tmpl == nil {
+		tmpl = set.Lookup(defaultKind + itemSuffix)
+	}
+
+	// the message has been escaped by the template already
+	return template.HTML(renderTemplate(tmpl, msg))
+}
+
+// getDirectTemplate returns the direct message template as a byte array,
+// it is rendered the same for the recipient and the sender.
+// It returns nil if the message could not be rendered.
+func getDirectTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindDirect), msg)
+}
+
+// getEditTemplate returns the edited message as a byte array, it replaces the message in place.
+// It returns nil if the message could not be rendered.
+func getEditTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindEdit), msg)
+}
+
+// getTombstoneTemplate returns the tombstone of a deleted message as a byte array,
+// it replaces the message in place.
+// It returns nil if the tombstone could not be rendered.
+func getTombstoneTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindTombstone), msg)
+}
+
+// getReactionsTemplate returns the reactions to the message as a byte array,
+// they replace the reactions under the message in place.
+// It returns nil if the reactions could not be rendered.
+func getReactionsTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindReactions), msg)
+}
+
+// getPinnedTemplate returns the pinned messages as a byte array.
+// It returns nil if the pinned messages could not be rendered.
+func getPinnedTemplate(pinned []*Quote) []byte {
+	return renderTemplate(lookupTemplate(KindPinned), struct{ Pinned []*Quote }{pinned})
+}
+
+// getMentionTemplate returns the notification of the message mentioning a client as a byte array,
+// it is only sent to the clients mentioned.
+// It returns nil if the notification could not be rendered.
+func getMentionTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindMention), msg)
+}
+
+// getAckTemplate returns the acknowledgment of a message as a byte array,
+// it is only sent to the client that sent the message.
+// It returns nil if the acknowledgment could not be rendered.
+func getAckTemplate(msg *Message) []byte {
+	return renderTemplate(lookupTemplate(KindAck), msg)
+}
+
+// getUnreadTemplate returns the unread indicator of a client as a byte array.
+// It returns nil if the indicator could not be rendered.
+func getUnreadTemplate(unread *Unread) []byte {
+	return renderTemplate(lookupTemplate(KindUnread), unread)
+}
+
+// getLoginTemplate returns the login page as a byte array.
+// It returns nil if the page could not be rendered.
+func getLoginTemplate(login *Login) []byte {
+	return renderTemplate(lookupTemplate(KindLogin), login)
+}
+
+// getFullTemplate returns the notice of a full room as a byte array.
+// It returns nil if the notice could not be rendered.
+func getFullTemplate(full *RoomFull) []byte {
+	return renderTemplate(lookupTemplate(KindFull), full)
+}
+
+// getIdleTemplate returns the notice of a client disconnected for inactivity as a byte array.
+// It returns nil if the notice could not be rendered.
+func getIdleTemplate(idle *Idle) []byte {
+	return renderTemplate(lookupTemplate(KindIdle), idle)
+}
+
+// getClientsTemplate returns the table of the connected clients as a byte array.
+// It returns nil if the table could not be rendered.
+func getClientsTemplate(clients []ClientStats) []byte {
+	return renderTemplate(lookupTemplate(KindClients), clients)
+}
+
+// getAdminTemplate returns the admin dashboard page as a byte array.
+// It returns nil if the page could not be rendered.
+func getAdminTemplate(page *Dashboard) []byte {
+	return renderTemplate(lookupTemplate(KindAdmin), page)
+}
+
+// getAnnouncementsTemplate returns the banners of the announcements as a byte array.
+// It returns nil if they could not be rendered.
+func getAnnouncementsTemplate(announcements []*Announcement) []byte {
+	return renderTemplate(lookupTemplate(KindAnnouncements), struct{ Announcements []*Announcement }{announcements})
+}
+
+// getDashboardTemplate returns the live part of the admin dashboard as a byte array.
+// It returns nil if it could not be rendered.
+func getDashboardTemplate(room *DashboardRoom) []byte {
+	return renderTemplate(lookupTemplate(KindDashboard), room)
+}
+
+// getErrorTemplate returns the error template for the text as a byte array,
+// errors are only ever sent to the client that caused them.
+// It returns nil if the error could not be rendered.
+func getErrorTemplate(text string) []byte {
+	return renderTemplate(lookupTemplate(KindError), struct{ Text string }{text})
+}
+
+// getHelpTemplate returns the list of commands as a byte array, it is only sent to the client asking.
+// It returns nil if the list could not be rendered.
+func getHelpTemplate(commands []string) []byte {
+	return renderTemplate(lookupTemplate(KindHelp), struct{ Commands []string }{commands})
+}
+
+// getTypingTemplate returns the typing indicator for the names as a byte array.
+// It returns nil if the indicator could not be rendered.
+func getTypingTemplate(names []string) []byte {
+	return renderTemplate(lookupTemplate(KindTyping), &Typing{Names: names})
+}
+
+// getPresenceTemplate returns the presence list as a byte array.
+// It returns nil if the list could not be rendered.
+func getPresenceTemplate(presence *Presence) []byte {
+	return renderTemplate(lookupTemplate(KindPresence), presence)
+}
+
+const (
+	// fragmentSize is the size we expect a rendered fragment to be, the render buffers start this big
+	fragmentSize = 1024
+	// maxPooledBuffer is the size above which a render buffer isn't reused
+	// (a huge fragment once shouldn't keep that much memory around)
+	maxPooledBuffer = 64 * 1024
+)
+
+// renderBuffers are the buffers we render the templates to, they're reused from one render to the next
+var renderBuffers = sync.Pool{
+	New: func() any { return bytes.NewBuffer(make([]byte, 0, fragmentSize)) },
+}
+
+// renderTemplate executes the template with data and returns the result,
+// or nil if the template could not be executed.
+// The result belongs to the caller, it can be shared by as many frames as needed
+func renderTemplate(tmpl *template.Template, data any) []byte {
+
+	// the templates could not be loaded, there is nothing we can render
+	if tmpl == nil {
+		return nil
+	}
+
+	// we take a buffer from the pool to write the template to,
+	// it goes back to the pool once we have copied what we rendered
+	rendered := renderBuffers.Get().(*bytes.Buffer)
+	rendered.Reset()
+	defer func() {
+		if rendered.Cap() <= maxPooledBuffer {
+			renderBuffers.Put(rendered)
+		}
+	}()
+
+	// we execute the template and write it to the buffer we took
+	err := tmpl.Execute(rendered, data)
+	// if there are any errors during the execution process, we log the error
+	// and skip the message instead of taking the whole server down
+	if err != nil {
+		slog.Error("executing template", "template", tmpl.Name(), "err", err)
+		return nil
+	}
+
+	// the buffer is reused as soon as we return, so the frames get a copy of their own
+	// (the copy is never written to again, every client can be sent the same one)
+	return bytes.Clone(rendered.Bytes())
+}
+
+// getRoomErrorTemplate returns the page telling a visitor the room can't be opened as a byte array.
+// It returns nil if the page could not be rendered.
+func getRoomErrorTemplate(page *RoomError) []byte {
+	return renderTemplate(lookupTemplate(KindRoomError), page)
+}
+
+// getRoomsTemplate returns the directory of the rooms as a byte array.
+// It returns nil if the directory could not be rendered.
+func getRoomsTemplate(rooms []RoomInfo) []byte {
+	return renderTemplate(lookupTemplate(KindRooms), rooms)
+}
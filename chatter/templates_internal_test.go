@@ -0,0 +1,248 @@
+package chatter
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchMessage is the message the rendering benchmarks render
+var benchMessage = &Message{ID: 42, Kind: KindChat, ClientID: "c1", Username: "alice", Text: "hello, world", Timestamp: time.Now()}
+
+// BenchmarkRenderMessage compares rendering a message with the parsed templates we keep
+// to parsing the template for every message, the way it was done at first
+func BenchmarkRenderMessage(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if getMessageTemplate(benchMessage, "", false) == nil {
+				b.Fatal("the message wasn't rendered")
+			}
+		}
+	})
+	b.Run("parsed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			set, err := template.New("").Funcs(templateFuncs).ParseFS(DefaultTemplates(), "message.html", "reactions.html")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if renderTemplate(set.Lookup("message.html"), benchMessage) == nil {
+				b.Fatal("the message wasn't rendered")
+			}
+		}
+	})
+}
+
+// BenchmarkPersonalizedRendering compares the fan-out of a message to 500 clients rendered once
+// for all of them to the personalized rendering, where the sender gets its own variant: once per
+// variant (the way the hub does it, see variants) and once per recipient
+func BenchmarkPersonalizedRendering(b *testing.B) {
+	recipients := make([]string, 500)
+	for i := range recipients {
+		recipients[i] = fmt.Sprintf("c%d", i)
+	}
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rendered := getMessageTemplate(benchMessage, "", false)
+			for range recipients {
+				if rendered == nil {
+					b.Fatal("the message wasn't rendered")
+				}
+			}
+		}
+	})
+	b.Run("variants", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			renders := newVariants(benchMessage)
+			for _, recipient := range recipients {
+				if renders.render(recipient, false) == nil {
+					b.Fatal("the message wasn't rendered")
+				}
+			}
+		}
+	})
+	b.Run("per recipient", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, recipient := range recipients {
+				if getMessageTemplate(benchMessage, recipient, false) == nil {
+					b.Fatal("the message wasn't rendered")
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkRenderBuffers compares rendering to a buffer of the pool to rendering to a new buffer,
+// the way every broadcast did before the pool
+func BenchmarkRenderBuffers(b *testing.B) {
+	tmpl := lookupTemplate(KindChat)
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if renderTemplate(tmpl, benchMessage) == nil {
+				b.Fatal("the message wasn't rendered")
+			}
+		}
+	})
+	b.Run("new buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var rendered bytes.Buffer
+			if err := tmpl.Execute(&rendered, benchMessage); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestConcurrentRendersAreIntact(t *testing.T) {
+	// messages of very different lengths, so a buffer reused too early shows
+	messages := make([]*Message, 20)
+	want := make([][]byte, len(messages))
+	for i := range messages {
+		messages[i] = &Message{ID: uint64(i + 1), Kind: KindChat, Username: "alice", Text: strings.Repeat(fmt.Sprintf("m%d ", i), 1+i*50), Timestamp: time.Now()}
+		want[i] = getMessageTemplate(messages[i], "", false)
+	}
+
+	var wg sync.WaitGroup
+	rendered := make([][][]byte, 8)
+	for g := range rendered {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < 50; round++ {
+				for _, msg := range messages {
+					rendered[g] = append(rendered[g], getMessageTemplate(msg, "", false))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every render is still what it was once all of them are done with their buffers
+	for g := range rendered {
+		for i, got := range rendered[g] {
+			if !bytes.Equal(got, want[i%len(messages)]) {
+				t.Fatalf("render %d of goroutine %d is mangled:\n%s", i, g, got)
+			}
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	now := time.Date(2024, time.March, 15, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    time.Time
+		now  time.Time
+		want string
+	}{
+		{"zero", time.Time{}, now, ""},
+		{"today", time.Date(2024, time.March, 15, 9, 5, 0, 0, time.UTC), now, "09:05"},
+		{"yesterday", time.Date(2024, time.March, 14, 23, 59, 0, 0, time.UTC), now, "14 Mar 23:59"},
+		{"last year", time.Date(2023, time.December, 31, 8, 0, 0, 0, time.UTC), now, "31 Dec 2023 08:00"},
+		// the time is shown where now is: 23:30 UTC is already tomorrow in Paris
+		{"another zone", time.Date(2024, time.March, 15, 23, 30, 0, 0, time.UTC), now.In(paris), "16 Mar 00:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTime(tt.t, tt.now); got != tt.want {
+				t.Errorf("formatTime() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// namedTemplates returns a set with a template for every registered kind,
+// rendering the name of the template
+func namedTemplates(t *testing.T) *template.Template {
+	t.Helper()
+	set := template.New("")
+	templateKindsMu.RLock()
+	defer templateKindsMu.RUnlock()
+	for _, name := range templateKinds {
+		template.Must(set.New(name).Parse(name))
+	}
+	return set
+}
+
+func TestKindsRouteToTheirTemplates(t *testing.T) {
+	if err := UseTemplates(namedTemplates(t)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadTemplates(DefaultTemplates()) })
+
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{KindChat, "message.html"},
+		{"", "message.html"},
+		{KindSystem, "system.html"},
+		{KindDirect, "dm.html"},
+		{KindError, "error.html"},
+		{KindPresence, "presence.html"},
+		{KindTyping, "typing.html"},
+		{KindChat + compactSuffix, "message_compact.html"},
+		{KindChat + ownSuffix, "message_own.html"},
+		// the variants nobody registered are rendered like the kind itself
+		{KindSystem + compactSuffix, "system.html"},
+		// and the unknown kinds like the chat messages
+		{"poll", "message.html"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("kind %q", tt.kind), func(t *testing.T) {
+			if got := string(renderTemplate(lookupTemplate(tt.kind), &Message{})); got != tt.want {
+				t.Errorf("the kind is rendered with %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// a kind of one's own is rendered with its template once it's registered
+	set := namedTemplates(t)
+	template.Must(set.New("poll.html").Parse("poll.html"))
+	if err := UseTemplates(set); err != nil {
+		t.Fatal(err)
+	}
+	RegisterTemplate("poll", "poll.html")
+	t.Cleanup(func() {
+		templateKindsMu.Lock()
+		delete(templateKinds, "poll")
+		templateKindsMu.Unlock()
+	})
+	if got := string(renderTemplate(lookupTemplate("poll"), &Message{})); got != "poll.html" {
+		t.Errorf("the registered kind is rendered with %q", got)
+	}
+
+	// a set missing a template of a kind is refused
+	if err := UseTemplates(template.Must(template.New("message.html").Parse("message.html"))); err == nil {
+		t.Error("a set without the system template was used")
+	}
+}
+
+func TestUnknownKindsDontBreakTheBroadcast(t *testing.T) {
+	hub := NewHub()
+	client := pumpClient(hub, newFakeConn())
+	addClient(hub, client)
+
+	hub.broadcastMessage(&Message{Kind: "poll", ClientID: "bot", Username: "bot", Text: "which one?"})
+	hub.broadcastMessage(&Message{Kind: KindChat, ClientID: "bot", Username: "bot", Text: "still here"})
+	frames := bytes.Join(drain(client), nil)
+	for _, want := range []string{"which one?", "still here"} {
+		if !bytes.Contains(frames, []byte(want)) {
+			t.Errorf("the client didn't get %q:\n%s", want, frames)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package chatter_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// unsafeMarkup matches the markup that would run a script in the page
+var unsafeMarkup = regexp.MustCompile(`(?i)<script|<[a-z][^>]*\son[a-z]+\s*=|javascript:`)
+
+func TestUserInputIsEscaped(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string // what the fragment shows of the text
+	}{
+		{"script tag", `<script>alert(1)</script>`, `alert(1)`},
+		{"event handler", `<img src=x onerror=alert(1)>`, ``},
+		{"broken entities", `fish &chips; &#xZZ; & &amp`, `fish &amp;chips; &amp;#xZZ; &amp; &amp;amp`},
+		{"unicode", `héllo 👋 世界 ‮`, `héllo 👋 世界`},
+		{"quotes", `"double" 'single'`, `&#34;double&#34; &#39;single&#39;`},
+	}
+	for _, markdown := range []bool{false, true} {
+		t.Run(fmt.Sprintf("markdown=%t", markdown), func(t *testing.T) {
+			srv := chattertest.NewServer(t, chatter.WithMarkdown(markdown))
+			alice := srv.Connect(t, "alice")
+			bob := srv.Connect(t, "bob")
+
+			for i, tt := range tests {
+				marker := fmt.Sprintf("m%d", i)
+				alice.Send(marker + " " + tt.text)
+				frame := bob.Expect(marker, waitTimeout)
+				if unsafeMarkup.MatchString(frame) {
+					t.Errorf("%s: the fragment runs a script:\n%s", tt.name, frame)
+				}
+				// markdown renders the quotes as they are, the plain text has them escaped
+				if markdown && tt.name == "quotes" {
+					continue
+				}
+				if !strings.Contains(frame, tt.want) {
+					t.Errorf("%s: the fragment doesn't show %q:\n%s", tt.name, tt.want, frame)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package chatter
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// typingDebounce is the minimum time between two typing events a client forwards to the hub,
+	// so a burst of keystrokes doesn't flood the hub
+	typingDebounce = time.Second
+	// typingExpiry is how long the typing indicator stays up after the last typing event
+	typingExpiry = 4 * time.Second
+)
+
+// Typing is what the typing template renders, the names of the clients that are typing
+type Typing struct {
+	Names []string
+}
+
+// startTyping marks the client as typing, the indicator is only broadcast
+// when the client wasn't already typing (otherwise we just push back the expiry)
+func (h *Hub) startTyping(client *Client) {
+
+	// the client may have disconnected in the meantime
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	_, already := h.typers[client]
+	h.typers[client] = h.clock.Now().Add(typingExpiry)
+	if !already {
+		h.broadcastTyping()
+	}
+}
+
+// stopTyping clears the typing indicator of the client with the id (e.g. once it sent its message)
+func (h *Hub) stopTyping(clientID string) {
+	for client := range h.typers {
+		if client.id == clientID {
+			delete(h.typers, client)
+			h.broadcastTyping()
+			return
+		}
+	}
+}
+
+// expireTyping clears the typing indicators that expired before now
+func (h *Hub) expireTyping(now time.Time) {
+
+	expired := false
+	for client, expiry := range h.typers {
+		if now.After(expiry) {
+			delete(h.typers, client)
+			expired = true
+		}
+	}
+
+	if expired {
+		h.broadcastTyping()
+	}
+}
+
+// broadcastTyping sends the current typing indicator to every client,
+// each client sees everyone typing but itself. Constrained clients don't get typing indicators
+func (h *Hub) broadcastTyping() {
+
+	// the indicator is the same for every client that isn't typing,
+	// so we render that one once and only render per client for the typers
+	var everyone []byte
+
+	for client := range h.clients {
+		if client.constrained || client.jsonFrames {
+			continue
+		}
+
+		var rendered []byte
+		if _, typing := h.typers[client]; typing {
+			rendered = getTypingTemplate(h.typingNames(client))
+		} else {
+			if everyone == nil {
+				everyone = getTypingTemplate(h.typingNames(nil))
+			}
+			rendered = everyone
+		}
+		if rendered == nil {
+			continue
+		}
+
+		// the indicator isn't worth dropping a slow client for, we just skip it
+		select {
+		case client.send <- Frame{Data: rendered}:
+		default:
+		}
+	}
+}
+
+// typingNames returns the sorted names of the clients typing, leaving out except
+func (h *Hub) typingNames(except *Client) []string {
+	names := make([]string, 0, len(h.typers))
+	for client := range h.typers {
+		if client != except {
+			names = append(names, client.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
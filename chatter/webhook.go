@@ -0,0 +1,170 @@
+package chatter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the body of a webhook post, keyed with the webhook secret
+	SignatureHeader = "X-Chatter-Signature"
+	// webhookQueue is the number of posts waiting to be sent before we start dropping them
+	webhookQueue = 256
+	// webhookWorkers is the number of posts sent at the same time
+	webhookWorkers = 4
+	// webhookTimeout is how long a single post may take
+	webhookTimeout = 5 * time.Second
+	// webhookRetries is how many times a failed post is tried again
+	webhookRetries = 3
+	// webhookBackoff is how long we wait before the first retry, it doubles every time
+	webhookBackoff = 500 * time.Millisecond
+)
+
+// WebhookPayload is what the outgoing webhooks post for every message, as JSON
+type WebhookPayload struct {
+	ID        uint64    `json:"id"`
+	Kind      string    `json:"kind"`
+	ClientID  string    `json:"client_id"`
+	Username  string    `json:"username"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"ts"`
+	Room      string    `json:"room"`
+}
+
+// Webhooks posts every message broadcast in the rooms to a set of URLs. The posts are
+// sent from a bounded queue by a few workers, so a slow endpoint never holds the hub up
+type Webhooks struct {
+	urls    []string
+	secret  []byte // key of the signature (empty sends no signature)
+	client  *http.Client
+	queue   chan webhookPost
+	metrics *Metrics
+	wg      sync.WaitGroup
+}
+
+// webhookPost is a payload to be posted to a single URL
+type webhookPost struct {
+	url  string
+	body []byte
+}
+
+// NewWebhooks creates the webhooks posting to urls and starts their workers,
+// the posts are signed with secret (if it isn't empty)
+func NewWebhooks(urls []string, secret string, metrics *Metrics) *Webhooks {
+	w := &Webhooks{
+		urls:    urls,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan webhookPost, webhookQueue),
+		metrics: metrics,
+	}
+
+	for i := 0; i < webhookWorkers; i++ {
+		w.wg.Add(1)
+		go w.work()
+	}
+	return w
+}
+
+// WithWebhooks posts every message broadcast by the hub to the webhooks
+func WithWebhooks(webhooks *Webhooks) Option {
+	return func(h *Hub) {
+		h.webhooks = webhooks
+	}
+}
+
+// Close sends the posts still queued and stops the workers
+func (w *Webhooks) Close() {
+	close(w.queue)
+	w.wg.Wait()
+}
+
+// Send queues the message to be posted to every URL, it never blocks:
+// if the queue is full the post is dropped (and counted as failed)
+func (w *Webhooks) Send(room string, msg *Message) {
+
+	body, err := json.Marshal(&WebhookPayload{
+		ID:        msg.ID,
+		Kind:      msg.Kind,
+		ClientID:  msg.ClientID,
+		Username:  msg.Username,
+		Text:      msg.Text,
+		Timestamp: msg.Timestamp,
+		Room:      room,
+	})
+	if err != nil {
+		slog.Error("encoding webhook payload", "msg_id", msg.ID, "err", err)
+		return
+	}
+
+	for _, url := range w.urls {
+		select {
+		case w.queue <- webhookPost{url: url, body: body}:
+		default:
+			slog.Error("webhook queue full, message not posted", "msg_id", msg.ID, "url", url)
+			w.metrics.webhookPosted(false)
+		}
+	}
+}
+
+// work sends the queued posts until the queue is closed
+func (w *Webhooks) work() {
+	defer w.wg.Done()
+
+	for post := range w.queue {
+		err := w.post(post)
+		// we try again a few times, waiting a bit longer every time
+		for retry, backoff := 0, webhookBackoff; err != nil && retry < webhookRetries; retry, backoff = retry+1, backoff*2 {
+			time.Sleep(backoff)
+			err = w.post(post)
+		}
+
+		if err != nil {
+			slog.Error("posting webhook", "url", post.url, "err", err)
+		}
+		w.metrics.webhookPosted(err == nil)
+	}
+}
+
+// post sends the post once, anything but a 2xx answer is an error
+func (w *Webhooks) post(post webhookPost) error {
+
+	req, err := http.NewRequest(http.MethodPost, post.url, bytes.NewReader(post.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, post.body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// we drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature of body sent in the SignatureHeader ("sha256=" and the hex encoded HMAC),
+// receivers compute it with the shared secret and compare it with hmac.Equal
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
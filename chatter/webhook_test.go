@@ -0,0 +1,107 @@
+package chatter_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webhookTimeout is how long the webhook tests wait for the retries to be done
+const webhookTimeout = 10 * time.Second
+
+// waitMetric waits for the metric to reach want
+func waitMetric(t *testing.T, reg *prometheus.Registry, name string, want float64) {
+	t.Helper()
+	deadline := time.Now().Add(webhookTimeout)
+	for metricValue(t, reg, name) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is %v, want %v", name, metricValue(t, reg, name), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhooksRetryFailedPosts(t *testing.T) {
+	var flakyCalls, brokenCalls atomic.Int32
+	payloads := make(chan chatter.WebhookPayload, 1)
+	// the flaky endpoint fails twice then takes the post, checking its signature
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flakyCalls.Add(1) <= 2 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(chatter.SignatureHeader) != chatter.Sign([]byte("s3cret"), body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var payload chatter.WebhookPayload
+		json.Unmarshal(body, &payload)
+		payloads <- payload
+	}))
+	defer flaky.Close()
+	// the broken one always fails
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenCalls.Add(1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	reg := prometheus.NewRegistry()
+	webhooks := chatter.NewWebhooks([]string{flaky.URL, broken.URL}, "s3cret", chatter.NewMetrics(reg))
+	defer webhooks.Close()
+	webhooks.Send("lobby", &chatter.Message{ID: 7, Kind: chatter.KindChat, ClientID: "alice-1", Username: "alice", Text: "hello"})
+
+	select {
+	case payload := <-payloads:
+		if payload.ID != 7 || payload.Room != "lobby" || payload.Username != "alice" || payload.Text != "hello" {
+			t.Errorf("the payload is %+v", payload)
+		}
+	case <-time.After(webhookTimeout):
+		t.Fatal("the flaky endpoint never got the post")
+	}
+
+	// the post is tried once and retried three times before being given up
+	waitMetric(t, reg, "chatter_webhook_posts_total", 2)
+	if calls := flakyCalls.Load(); calls != 3 {
+		t.Errorf("the flaky endpoint was called %d times, want 3", calls)
+	}
+	if calls := brokenCalls.Load(); calls != 4 {
+		t.Errorf("the broken endpoint was called %d times, want 4", calls)
+	}
+}
+
+func TestSlowWebhooksDontHoldTheHubUp(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	webhooks := chatter.NewWebhooks([]string{slow.URL}, "", nil)
+	// the endpoint has to let go of the workers before they can stop
+	defer webhooks.Close()
+	defer close(release)
+
+	hub := chatter.NewHub(chatter.WithWebhooks(webhooks))
+	go hub.Run(context.Background())
+	defer hub.Close(time.Second)
+
+	// more messages than there are workers, while every post hangs
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if _, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "bot", Text: "hello"}, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("broadcasting took %v with a hanging webhook", took)
+	}
+}
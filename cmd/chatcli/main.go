@@ -0,0 +1,198 @@
+// Command chatcli chats from the terminal: it joins a room of the chat over its websocket,
+// prints its messages as lines and sends the lines typed (or piped) on stdin.
+//
+//	go run ./cmd/chatcli -url ws://localhost:3000/ws -room general -name alice
+//
+// The commands of the chat (/me, /nick, /help, ...) work as they do on the page. If the
+// connection drops we connect again, waiting a bit longer every time; ctrl+c (or the end of
+// stdin) leaves the room.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// minBackoff and maxBackoff bound how long we wait before connecting again
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+	// writeWait is how long sending a frame to the server can take
+	writeWait = 10 * time.Second
+	// closeWait is how long leaving waits for the server to answer the close frame
+	closeWait = time.Second
+)
+
+// session is one connection to the room
+type session struct {
+	conn *websocket.Conn
+	page string // the url of the page the messages say they come from
+}
+
+// dial connects to the room
+func dial(ctx context.Context, endpoint *url.URL, origin string) (*session, error) {
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	// we ask for the messages as JSON rather than as the fragments of the page
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{chatter.JSONProtocol}
+	conn, resp, err := dialer.DialContext(ctx, endpoint.String(), header)
+	if err != nil {
+		// the server tells why it turned us away (banned, full, restarting, ...)
+		if resp != nil {
+			return nil, fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		return nil, err
+	}
+	if conn.Subprotocol() != chatter.JSONProtocol {
+		conn.Close()
+		return nil, errors.New("the server doesn't speak " + chatter.JSONProtocol)
+	}
+
+	page := *endpoint
+	page.Scheme = strings.Replace(page.Scheme, "ws", "http", 1)
+	page.Path = "/"
+	return &session{conn: conn, page: page.String()}, nil
+}
+
+// send sends the line as the form of the page does
+func (s *session) send(line string) error {
+	data, err := json.Marshal(&chatter.WSMessage{
+		Headers: chatter.WSHeaders{Request: "true", Trigger: "form", CurrentURL: s.page},
+		Text:    line,
+	})
+	if err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// run prints the messages of the room to out and sends it the lines until ctx is done
+// (it returns nil) or the connection drops (it returns why)
+func (s *session) run(ctx context.Context, lines <-chan string, seen printed, out io.Writer) error {
+	defer s.conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		for {
+			_, frame, err := s.conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			for _, line := range seen.render(frame) {
+				fmt.Fprintln(out, line)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// we say goodbye, and give the server a moment to say it back
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeWait))
+			select {
+			case <-done:
+			case <-time.After(closeWait):
+			}
+			return nil
+		case err := <-done:
+			return err
+		case line := <-lines:
+			if err := s.send(line); err != nil {
+				// the reader goes too before the next connection has its own
+				s.conn.Close()
+				<-done
+				return err
+			}
+		}
+	}
+}
+
+// readLines hands the lines of stdin over, and stops ctx when it ends
+func readLines(stop context.CancelFunc) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer stop()
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines <- line
+			}
+		}
+	}()
+	return lines
+}
+
+func main() {
+	target := flag.String("url", "ws://localhost:3000/ws", "websocket endpoint of the chat")
+	room := flag.String("room", chatter.DefaultRoom, "room to join")
+	name := flag.String("name", "", "name to chat as (empty for a guest name)")
+	origin := flag.String("origin", "", "Origin header of the connection (empty sends none)")
+	flag.Parse()
+
+	endpoint, err := url.Parse(*target)
+	if err != nil || (endpoint.Scheme != "ws" && endpoint.Scheme != "wss") {
+		log.Fatalf("chatcli: -url must be a ws:// or wss:// url")
+	}
+	query := endpoint.Query()
+	query.Set("room", *room)
+	if *name != "" {
+		query.Set("name", *name)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	chat(ctx, endpoint, *origin, readLines(stop), os.Stdout, minBackoff, maxBackoff)
+}
+
+// chat joins the room at endpoint, prints its messages to out and sends it the lines until
+// ctx is done. When the connection drops it connects again, waiting from minWait up to maxWait
+func chat(ctx context.Context, endpoint *url.URL, origin string, lines <-chan string, out io.Writer, minWait, maxWait time.Duration) {
+	seen := printed{}
+	backoff := minWait
+	for {
+		s, err := dial(ctx, endpoint, origin)
+		if err == nil {
+			log.Printf("connected to %s", endpoint)
+			backoff = minWait
+			err = s.run(ctx, lines, seen, out)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the server closing the connection on purpose (kicked, banned, shutting down) is worth telling apart
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && closeErr.Text != "" {
+			err = fmt.Errorf("closed by the server: %s", closeErr.Text)
+		}
+		log.Printf("disconnected: %v, connecting again in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxWait)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/aidk/go-htmx-chatter/chatter/chattertest"
+)
+
+// waitTimeout is how long the tests wait for something to happen
+const waitTimeout = 5 * time.Second
+
+// terminal is the output of chat, the tests wait for its lines
+type terminal struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	wrote chan struct{}
+}
+
+func newTerminal() *terminal {
+	return &terminal{wrote: make(chan struct{}, 1)}
+}
+
+func (term *terminal) Write(p []byte) (int, error) {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	select {
+	case term.wrote <- struct{}{}:
+	default:
+	}
+	return term.buf.Write(p)
+}
+
+// Count returns the number of lines printed holding substring
+func (term *terminal) Count(substring string) int {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	n := 0
+	for _, line := range strings.Split(term.buf.String(), "\n") {
+		if strings.Contains(line, substring) {
+			n++
+		}
+	}
+	return n
+}
+
+// Expect waits for a line holding substring to be printed
+func (term *terminal) Expect(t *testing.T, substring string) {
+	t.Helper()
+	timeout := time.After(waitTimeout)
+	for term.Count(substring) == 0 {
+		select {
+		case <-term.wrote:
+		case <-timeout:
+			term.mu.Lock()
+			defer term.mu.Unlock()
+			t.Fatalf("%q was never printed, the terminal shows:\n%s", substring, term.buf.String())
+		}
+	}
+}
+
+// startChat runs chat as name against the server until the test ends (or the returned
+// function stops it), the lines sent to the returned channel are typed in
+func startChat(t *testing.T, srv *chattertest.Server, name string) (*terminal, chan<- string, func()) {
+	t.Helper()
+	endpoint, err := url.Parse(strings.Replace(srv.URL, "http", "ws", 1) + "/ws?name=" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, lines := newTerminal(), make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		chat(ctx, endpoint, "", lines, term, 10*time.Millisecond, 40*time.Millisecond)
+	}()
+	stop := func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(waitTimeout):
+			t.Fatal("chat didn't return")
+		}
+	}
+	t.Cleanup(stop)
+	return term, lines, stop
+}
+
+func TestChatConnectsAgainWhenDisconnected(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	bob := srv.Connect(t, "bob")
+	bob.Send("before alice")
+	bob.Expect("before alice", waitTimeout)
+
+	term, lines, _ := startChat(t, srv, "alice")
+	term.Expect(t, "bob: before alice")
+	lines <- "hello from the terminal"
+	bob.Expect("hello from the terminal", waitTimeout)
+
+	// the connection drops, once the hub removed alice nothing reaches the old one
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Kick("test", "alice", "", false); err != nil {
+		t.Fatal(err)
+	}
+	bob.Send("after the kick")
+	term.Expect(t, "bob: after the kick")
+	lines <- "back again"
+	bob.Expect("back again", waitTimeout)
+
+	// the history replayed on the new connection isn't printed twice
+	for _, line := range []string{"bob: before alice", "alice: hello from the terminal"} {
+		if n := term.Count(line); n != 1 {
+			t.Errorf("%q was printed %d times", line, n)
+		}
+	}
+}
+
+func TestChatLeavesWhenStopped(t *testing.T) {
+	srv := chattertest.NewServer(t)
+	bob := srv.Connect(t, "bob")
+	term, _, stop := startChat(t, srv, "alice")
+	term.Expect(t, "alice joined")
+	hub, err := srv.Manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop()
+	deadline := time.Now().Add(waitTimeout)
+	for hub.Stats().Clients != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the room still has %d clients", hub.Stats().Clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bob.Send("still here")
+	bob.Expect("still here", waitTimeout)
+}
+
+func TestChatStopsWhileWaitingToConnectAgain(t *testing.T) {
+	// nobody listens on the address anymore
+	srv := chattertest.NewServer(t)
+	srv.Close()
+
+	_, _, stop := startChat(t, srv, "alice")
+	time.Sleep(100 * time.Millisecond)
+	stop()
+}
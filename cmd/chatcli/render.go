@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// printed are the lines printed so far by the message they show: connecting again replays
+// the history, the messages we already printed aren't printed twice (the edits are)
+type printed map[string]string
+
+// render turns a frame of the server (a message per line, see chatter.JSONProtocol)
+// into the lines to print
+func (p printed) render(frame []byte) []string {
+	var lines []string
+	for _, data := range bytes.Split(frame, []byte{'\n'}) {
+		var msg chatter.JSONMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		line := format(&msg)
+		// a restarted server counts the ids from the start again, the time the message was sent tells them apart
+		if msg.ID > 0 {
+			key := fmt.Sprintf("%d %s", msg.ID, msg.TS)
+			if p[key] == line {
+				continue
+			}
+			p[key] = line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// format turns a message into "15:04 alice: text", the messages of the hub into "15:04 * text"
+func format(msg *chatter.JSONMessage) string {
+	stamp := msg.TS.Local().Format("15:04")
+	switch msg.Kind {
+	case chatter.KindError:
+		return "! " + msg.Text
+	case chatter.KindSystem, chatter.KindAnnouncement:
+		return stamp + " * " + msg.Text
+	case chatter.KindAction:
+		return stamp + " * " + msg.From + " " + msg.Text
+	case chatter.KindDirect:
+		return stamp + " " + msg.From + " (direct): " + msg.Text
+	case chatter.KindEdit:
+		return stamp + " " + msg.From + " (edited): " + msg.Text
+	case chatter.KindDeleted:
+		return stamp + " * a message of " + msg.From + " was deleted"
+	}
+	return stamp + " " + msg.From + ": " + msg.Text
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// frame encodes the messages as the server sends them to the JSON clients, one per line
+func frame(t *testing.T, messages ...chatter.JSONMessage) []byte {
+	t.Helper()
+	var lines []string
+	for _, msg := range messages {
+		data, err := json.Marshal(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func TestFormat(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 15, 4, 0, 0, time.Local)
+	for _, tt := range []struct {
+		kind string
+		want string
+	}{
+		{chatter.KindChat, "15:04 alice: hello"},
+		{"", "15:04 alice: hello"},
+		{chatter.KindSystem, "15:04 * hello"},
+		{chatter.KindAnnouncement, "15:04 * hello"},
+		{chatter.KindAction, "15:04 * alice hello"},
+		{chatter.KindDirect, "15:04 alice (direct): hello"},
+		{chatter.KindEdit, "15:04 alice (edited): hello"},
+		{chatter.KindDeleted, "15:04 * a message of alice was deleted"},
+		{chatter.KindError, "! hello"},
+	} {
+		t.Run(tt.kind, func(t *testing.T) {
+			if got := format(&chatter.JSONMessage{ID: 1, From: "alice", Text: "hello", TS: ts, Kind: tt.kind}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSkipsTheMessagesAlreadyPrinted(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 15, 4, 0, 0, time.Local)
+	seen := printed{}
+	hello := chatter.JSONMessage{ID: 1, From: "alice", Text: "hello", TS: ts, Kind: chatter.KindChat}
+	notice := chatter.JSONMessage{From: "alice", Text: "you're rate limited", Kind: chatter.KindError}
+
+	got := seen.render(append(frame(t, hello, notice), "\nnot json"...))
+	if want := []string{"15:04 alice: hello", "! you're rate limited"}; !slices.Equal(got, want) {
+		t.Fatalf("the first frame printed %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame chatter.JSONMessage
+		want  []string
+	}{
+		// the history replayed when connecting again
+		{"replayed", hello, nil},
+		// the messages without an id are always printed
+		{"notice", notice, []string{"! you're rate limited"}},
+		{"edited", chatter.JSONMessage{ID: 1, From: "alice", Text: "hello there", TS: ts, Kind: chatter.KindEdit}, []string{"15:04 alice (edited): hello there"}},
+		// a restarted server counting from 1 again
+		{"restarted", chatter.JSONMessage{ID: 1, From: "bob", Text: "hi", TS: ts.Add(time.Hour), Kind: chatter.KindChat}, []string{"16:04 bob: hi"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seen.render(frame(t, tt.frame)); !slices.Equal(got, tt.want) {
+				t.Errorf("printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Command loadtest tells how many clients an instance of the chat handles: it opens websocket
+// connections to a room, has some of them send messages at a steady rate, and reports how long
+// the messages took to reach the other clients along with the errors and dropped connections.
+//
+//	go run ./cmd/loadtest -url ws://localhost:3000/ws -clients 1000 -senders 0.05 -rate 1 -ramp 30s -duration 1m
+//
+// The latency is measured from the time the sender put in the text, so the clients and the
+// server should share a clock (run it on the same host, or on hosts kept in sync).
+// Keep -rate under the rate limit of the server (5 messages per second by default).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// marker finds the messages of the load test in the fragments: the sender and when it sent them
+var marker = regexp.MustCompile(`loadtest (\d+) (\d+)`)
+
+// results are what the clients measured
+type results struct {
+	sync.Mutex
+	latencies []time.Duration // how long each message took to reach each of the other clients
+
+	connected  atomic.Int64 // connections opened
+	dialErrors atomic.Int64 // connections that couldn't be opened
+	sendErrors atomic.Int64 // messages that couldn't be sent
+	dropped    atomic.Int64 // connections that ended before the test did
+	sent       atomic.Int64 // messages sent
+}
+
+// received records the latency of a message of sender the client with the id got at now
+func (r *results) received(id int, fragment []byte, now time.Time) {
+	for _, match := range marker.FindAllSubmatch(fragment, -1) {
+		sender, err := strconv.Atoi(string(match[1]))
+		if err != nil || sender == id {
+			continue
+		}
+		sentAt, err := strconv.ParseInt(string(match[2]), 10, 64)
+		if err != nil {
+			continue
+		}
+		r.Lock()
+		r.latencies = append(r.latencies, now.Sub(time.Unix(0, sentAt)))
+		r.Unlock()
+	}
+}
+
+// client runs one connection of the load test until ctx is done
+func client(ctx context.Context, id int, target *url.URL, origin string, send bool, rate float64, res *results, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	u := *target
+	query := u.Query()
+	query.Set("name", fmt.Sprintf("load-%d", id))
+	u.RawQuery = query.Encode()
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		res.dialErrors.Add(1)
+		return
+	}
+	res.connected.Add(1)
+	defer conn.Close()
+
+	// we read everything the room sends, the connection ending before the test did is a drop
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, fragment, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() == nil {
+					res.dropped.Add(1)
+				}
+				return
+			}
+			res.received(id, fragment, time.Now())
+		}
+	}()
+
+	// the senders start at a random point of their period, so they don't all send at once
+	var ticks <-chan time.Time
+	if send && rate > 0 {
+		period := time.Duration(float64(time.Second) / rate)
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(period)))):
+		case <-ctx.Done():
+		case <-closed:
+			return
+		}
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			<-closed
+			return
+		case <-closed:
+			return
+		case <-ticks:
+			// the form of the page sends the text wrapped in JSON, as the htmx ws extension does
+			msg, _ := json.Marshal(map[string]any{
+				"text":    fmt.Sprintf("loadtest %d %d", id, time.Now().UnixNano()),
+				"HEADERS": map[string]string{"HX-Request": "true"},
+			})
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				res.sendErrors.Add(1)
+				continue
+			}
+			res.sent.Add(1)
+		}
+	}
+}
+
+// percentile returns the p-th percentile of the sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func main() {
+	target := flag.String("url", "ws://localhost:3000/ws", "websocket endpoint of the chat")
+	room := flag.String("room", "general", "room the clients join")
+	clients := flag.Int("clients", 100, "connections to open")
+	senders := flag.Float64("senders", 0.1, "fraction of the connections sending messages (0 to 1)")
+	rate := flag.Float64("rate", 1, "messages per second each sender sends")
+	ramp := flag.Duration("ramp", 10*time.Second, "how long opening the connections is spread over")
+	duration := flag.Duration("duration", 30*time.Second, "how long the test runs once every connection is open")
+	origin := flag.String("origin", "", "Origin header of the connections (empty sends none)")
+	flag.Parse()
+
+	if *clients <= 0 || *senders < 0 || *senders > 1 || *rate < 0 || *ramp < 0 || *duration <= 0 {
+		log.Fatalf("loadtest: -clients and -duration must be positive, -senders between 0 and 1, -rate and -ramp not negative")
+	}
+	u, err := url.Parse(*target)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		log.Fatalf("loadtest: -url must be a ws:// or wss:// url")
+	}
+	query := u.Query()
+	query.Set("room", *room)
+	u.RawQuery = query.Encode()
+
+	// ctrl+c stops the test early, the results so far are still reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	res := &results{}
+	var wg sync.WaitGroup
+	sending := int(float64(*clients)**senders + 0.5)
+	fmt.Printf("opening %d connections to %s over %s, %d of them sending %.2g messages per second\n", *clients, u, *ramp, sending, *rate)
+
+	// the clients stop when the test is over, the connections are opened evenly over the ramp
+	testCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	started := time.Now()
+	step := *ramp / time.Duration(*clients)
+	for i := 0; i < *clients && ctx.Err() == nil; i++ {
+		wg.Add(1)
+		go client(testCtx, i, u, *origin, i < sending, *rate, res, &wg)
+		if step > 0 {
+			select {
+			case <-time.After(step):
+			case <-ctx.Done():
+			}
+		}
+	}
+	fmt.Printf("%d connections open after %s (%d failed), running for %s\n", res.connected.Load(), time.Since(started).Round(time.Millisecond), res.dialErrors.Load(), *duration)
+
+	select {
+	case <-time.After(*duration):
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+
+	res.Lock()
+	latencies := slices.Clone(res.latencies)
+	res.Unlock()
+	slices.Sort(latencies)
+
+	fmt.Println()
+	fmt.Printf("connections: %d opened, %d failed, %d dropped\n", res.connected.Load(), res.dialErrors.Load(), res.dropped.Load())
+	fmt.Printf("messages:    %d sent, %d send errors, %d deliveries\n", res.sent.Load(), res.sendErrors.Load(), len(latencies))
+	if len(latencies) > 0 {
+		fmt.Printf("latency:     p50 %s, p95 %s, p99 %s, max %s\n",
+			percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99), latencies[len(latencies)-1])
+	}
+}
@@ -1,40 +1,402 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"html/template"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// roomTTL is how long a room can stay empty before it is removed
+	roomTTL = 10 * time.Minute
+	// shutdownTimeout is how long we wait for clients to disconnect on shutdown
+	shutdownTimeout = 5 * time.Second
 )
 
 func main() {
+	// the timeouts of the connections come from the environment, the flags have the last word
+	config, err := chatter.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	flag.DurationVar(&config.PongWait, "pong-wait", config.PongWait, "how long a websocket connection can stay without answering our pings before it is closed (CHATTER_PONG_WAIT)")
+	flag.DurationVar(&config.PingPeriod, "ping-period", config.PingPeriod, "how often the websocket clients are pinged, shorter than -pong-wait (CHATTER_PING_PERIOD, 0 for 9/10 of -pong-wait)")
+	flag.DurationVar(&config.WriteWait, "write-wait", config.WriteWait, "how long writing a frame to a websocket client can take (CHATTER_WRITE_WAIT)")
+	flag.Int64Var(&config.MaxMessageSize, "max-message-size", config.MaxMessageSize, "maximum size in bytes of a message sent by a client, the headers of htmx included (CHATTER_MAX_MESSAGE_SIZE)")
+	flag.IntVar(&config.MaxBatchSize, "max-batch-size", config.MaxBatchSize, "size in bytes past which the fragments queued for a websocket client are cut in several messages (CHATTER_MAX_BATCH_SIZE)")
+	flag.IntVar(&config.ReadBufferSize, "read-buffer-size", config.ReadBufferSize, "size in bytes of the read buffer of a websocket connection, larger messages take several reads (CHATTER_READ_BUFFER_SIZE)")
+	flag.IntVar(&config.WriteBufferSize, "write-buffer-size", config.WriteBufferSize, "size in bytes of the write buffers the websocket connections share, larger messages take several writes (CHATTER_WRITE_BUFFER_SIZE)")
+	flag.IntVar(&config.Compression, "compression-level", config.Compression, "how hard the messages are compressed for the clients supporting it, from 1 (fastest) to 9 (smallest) (CHATTER_COMPRESSION_LEVEL)")
+	flag.BoolVar(&config.NoCompression, "no-compression", config.NoCompression, "don't compress the messages, not even for the clients supporting it (CHATTER_NO_COMPRESSION)")
+
+	addr := flag.String("addr", "", "address to listen on (default :3000, :443 with -autocert-domain)")
+	tlsCert := flag.String("tls-cert", "", "file with the certificate to serve HTTPS with (along with -tls-key)")
+	tlsKey := flag.String("tls-key", "", "file with the private key of the certificate")
+	autocertDomain := flag.String("autocert-domain", "", "comma separated domains to get certificates for from Let's Encrypt (serves HTTPS, :80 redirects to it)")
+	autocertCache := flag.String("autocert-cache", "certs", "directory the certificates from Let's Encrypt are kept in")
+	storeKind := flag.String("store", "memory", "where the message history is kept (memory or sqlite)")
+	dbPath := flag.String("db", "chat.db", "path of the database when the store is sqlite")
+	postSecret := flag.String("post-secret", os.Getenv("CHATTER_POST_SECRET"), "shared secret required to POST /messages (empty allows anyone)")
+	markdown := flag.Bool("markdown", true, "render message text as markdown")
+	echo := flag.Bool("echo", true, "send the senders their own messages back (disable for pages showing them as they're sent)")
+	historyCapacity := flag.Int("history", chatter.DefaultHistoryCapacity, "messages kept per room when the store is memory")
+	allowedOrigins := flag.String("allowed-origins", os.Getenv("CHATTER_ALLOWED_ORIGINS"), "comma separated origins allowed to connect besides our own (e.g. https://example.com)")
+	redisAddr := flag.String("redis", os.Getenv("CHATTER_REDIS"), "address of a redis server (host:port) to share the rooms with other instances")
+	webhooks := flag.String("webhooks", os.Getenv("CHATTER_WEBHOOKS"), "comma separated URLs every message is posted to")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("CHATTER_WEBHOOK_SECRET"), "secret the webhook posts are signed with")
+	adminToken := flag.String("admin-token", os.Getenv("CHATTER_ADMIN_TOKEN"), "token required by the admin endpoints in the X-Admin-Token header (empty disables them)")
+	bansPath := flag.String("bans", "bans.json", "file the banned addresses are saved to")
+	auditPath := flag.String("audit-log", "", "file the moderation and admin actions are appended to as JSON lines (empty keeps the most recent in memory only)")
+	identitySecret := flag.String("identity-secret", os.Getenv("CHATTER_IDENTITY_SECRET"), "secret the identity cookies of the visitors are signed with (empty makes one up, the identities are then lost on restart)")
+	auth := flag.Bool("auth", false, "only let signed in visitors chat, they sign in with -auth-passphrase or -auth-users")
+	authPassphrase := flag.String("auth-passphrase", os.Getenv("CHATTER_AUTH_PASSPHRASE"), "passphrase visitors sign in with, under the name they pick")
+	authUsers := flag.String("auth-users", "", "file with the name:password of the visitors that can sign in")
+	githubClientID := flag.String("github-client-id", os.Getenv("CHATTER_GITHUB_CLIENT_ID"), "client id of the GitHub OAuth app visitors sign in with (empty disables signing in with GitHub)")
+	githubClientSecret := flag.String("github-client-secret", os.Getenv("CHATTER_GITHUB_CLIENT_SECRET"), "client secret of the GitHub OAuth app")
+	githubCallback := flag.String("github-callback-url", os.Getenv("CHATTER_GITHUB_CALLBACK_URL"), "url GitHub sends the visitors back to (https://<host>/auth/callback)")
+	githubOrg := flag.String("github-org", os.Getenv("CHATTER_GITHUB_ORG"), "GitHub org the visitors must be members of (empty lets anyone with an account in)")
+	sessionTTL := flag.Duration("session-ttl", chatter.DefaultSessionTTL, "how long visitors stay signed in")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("CHATTER_JWT_SECRET"), "secret the tokens websocket connections authenticate with are signed with (HS256)")
+	jwtJWKS := flag.String("jwt-jwks-url", os.Getenv("CHATTER_JWT_JWKS_URL"), "url of the JWKS the tokens websocket connections authenticate with are signed with")
+	jwtAudience := flag.String("jwt-audience", "", "audience the tokens must be issued for (empty accepts any)")
+	jwtGrace := flag.Duration("jwt-grace", 0, "how long a client stays connected after its token expired (0 keeps it connected)")
+	roomCapacity := flag.Int("room-capacity", 0, "clients a room holds at once, admins always get in (0 for no limit)")
+	closeWhenFull := flag.Bool("room-full-close", false, "accept the connections to a full room to tell them it's full over the websocket (instead of a 503)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "disconnect the clients that didn't send anything for this long (0 keeps them connected)")
+	slowPolicy := flag.String("slow-policy", string(chatter.SlowDisconnect), "what happens to the clients that can't keep up: disconnect them, drop the messages they can't take, or block a little before disconnecting them")
+	slowWait := flag.Duration("slow-wait", chatter.DefaultSlowWait, "how long -slow-policy block waits for the clients that can't keep up")
+	connsPerIP := flag.Int("max-conns-per-ip", chatter.DefaultConnsPerIP, "websocket connections an address can keep open at once (0 for no limit)")
+	drainTimeout := flag.Duration("drain-timeout", chatter.DefaultDrainTimeout, "how long the clients have to leave once POST /admin/drain puts the server in drain mode, the server then stops")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "how long /readyz fails on shutdown before the server stops accepting connections, so load balancers can notice")
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("CHATTER_TRUSTED_PROXIES"), "comma separated CIDRs of the proxies trusted to tell the client address in X-Forwarded-For or X-Real-IP")
+	profanity := flag.String("profanity", "", "file with the words the profanity filter catches (empty disables the filter)")
+	profanityMode := flag.String("profanity-mode", chatter.FilterMask, "what the profanity filter does: mask the words or reject the message")
+	reactions := flag.String("reactions", strings.Join(chatter.DefaultReactions, ","), "comma separated emoji clients can react to messages with (empty disables reactions)")
+	templatesDir := flag.String("templates", "", "directory to read the templates from instead of the embedded ones (e.g. chatter/templates)")
+	logLevel := flag.String("log-level", "info", "lowest level of the lines logged: debug (which logs what the clients send), info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of the lines logged: text or json")
+	debug := flag.Bool("debug", false, "serve /debug/stats without the admin token")
+	debugAddr := flag.String("debug-addr", "", "address of a separate listener serving pprof and /debug/stats, e.g. localhost:6060 (empty disables it)")
+	dev := flag.Bool("dev", false, "development mode: accept websocket connections from any origin and reload the templates when they change")
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	// everything logs through slog, including what still uses the log package
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("unknown log level %q", *logLevel)
+	}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	default:
+		log.Fatalf("unknown log format %q", *logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
 
-	// create a new hub (this will manage the clients and messages)
-	hub := NewHub()
-	// start the hub (this will listen for messages and broadcast them to clients)
-	go hub.Run()
+	// the templates are embedded in the binary, unless we're told to read them from a directory
+	// (in development we read them from the source tree so changes show up right away)
+	if *dev && *templatesDir == "" {
+		*templatesDir = "chatter/templates"
+	}
+	templates := chatter.DefaultTemplates()
+	if *templatesDir != "" {
+		templates = os.DirFS(*templatesDir)
+	}
 
-	// this will handle serving the landing page
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// parse the message templates up front so a broken template is caught at startup
+	if err := chatter.LoadTemplates(templates); err != nil {
+		log.Fatal(err)
+	}
 
-		// if the request is not for the root path, return a 404
-		if r.URL.Path != "/" {
-			http.Error(w, "404 not found.", http.StatusNotFound)
-			return
+	// parse the landing page, in development it is parsed again for every request
+	page := template.Must(parseIndex(templates))
+	index := func() (*template.Template, error) { return page, nil }
+	if *dev {
+		index = func() (*template.Template, error) { return parseIndex(templates) }
+	}
+
+	// open the store the message history is kept in
+	var store chatter.MessageStore
+	switch *storeKind {
+	case "memory":
+		store = chatter.NewMemoryStore(*historyCapacity)
+	case "sqlite":
+		sqlite, err := chatter.NewSQLiteStore(*dbPath)
+		if err != nil {
+			log.Fatalf("store: %v", err)
 		}
+		defer sqlite.Close()
+		store = sqlite
+	default:
+		log.Fatalf("unknown store %q", *storeKind)
+	}
+
+	// the metrics of the hubs, along with the usual process and Go runtime metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics := chatter.NewMetrics(registry)
+
+	// the banned addresses, kept from one run to the next
+	bans, err := chatter.NewBanList(*bansPath, metrics)
+	if err != nil {
+		log.Fatalf("bans: %v", err)
+	}
+
+	// the moderation and admin actions, written to the file on the way out
+	audit, err := chatter.NewAuditLog(chatter.DefaultAuditCapacity, *auditPath)
+	if err != nil {
+		log.Fatalf("audit: %v", err)
+	}
+	defer audit.Close()
 
-		// if the request method is not GET, return a 404
-		if r.Method != "GET" {
-			http.Error(w, "Method is not supported.", http.StatusNotFound)
-			return
+	// create a new hub manager (this will manage a hub per room),
+	// with redis the rooms are shared with the other instances
+	opts := []chatter.Option{chatter.WithStore(store), chatter.WithMetrics(metrics), chatter.WithMarkdown(*markdown), chatter.WithEcho(*echo), chatter.WithAudit(audit), chatter.WithConfig(config)}
+	switch policy := chatter.SlowPolicy(*slowPolicy); policy {
+	case chatter.SlowDisconnect, chatter.SlowDrop, chatter.SlowBlock:
+		opts = append(opts, chatter.WithSlowPolicy(policy, *slowWait))
+	default:
+		log.Fatalf("unknown slow policy %q", *slowPolicy)
+	}
+	var emoji []string
+	for _, e := range strings.Split(*reactions, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			emoji = append(emoji, e)
 		}
+	}
+	opts = append(opts, chatter.WithReactions(emoji...), chatter.WithCapacity(*roomCapacity, *closeWhenFull), chatter.WithIdleTimeout(*idleTimeout))
+	if *redisAddr != "" {
+		bridge := chatter.NewRedisBridge(*redisAddr)
+		defer bridge.Close()
+		opts = append(opts, chatter.WithBridge(bridge))
+	}
+	if *webhooks != "" {
+		hooks := chatter.NewWebhooks(strings.Split(*webhooks, ","), *webhookSecret, metrics)
+		defer hooks.Close()
+		opts = append(opts, chatter.WithWebhooks(hooks))
+	}
+	var filter *chatter.ProfanityFilter
+	if *profanity != "" {
+		if filter, err = chatter.NewProfanityFilter(*profanity, *profanityMode); err != nil {
+			log.Fatalf("profanity filter: %v", err)
+		}
+		opts = append(opts, chatter.WithMiddleware(filter.Middleware()))
+	}
+	// signing in with GitHub only lets signed in visitors in as well
+	var sessions *chatter.Sessions
+	var github *chatter.GitHubAuth
+	if *auth || *githubClientID != "" {
+		if !*auth && (*authPassphrase != "" || *authUsers != "") {
+			slog.Warn("-auth-passphrase and -auth-users are ignored without -auth")
+			*authPassphrase, *authUsers = "", ""
+		}
+		if *auth && *authPassphrase == "" && *authUsers == "" && *githubClientID == "" {
+			log.Fatalf("auth: signing in needs -auth-passphrase, -auth-users or a GitHub OAuth app")
+		}
+		if sessions, err = chatter.NewSessions(*identitySecret, *authPassphrase, *authUsers, *sessionTTL); err != nil {
+			log.Fatalf("auth: %v", err)
+		}
+		if *githubClientID != "" {
+			github = sessions.EnableGitHub(*githubClientID, *githubClientSecret, *githubCallback, *githubOrg)
+		}
+	}
+	var tokens *chatter.JWTAuth
+	if *jwtSecret != "" || *jwtJWKS != "" {
+		if tokens, err = chatter.NewJWTAuth(*jwtSecret, *jwtJWKS, *jwtAudience, *jwtGrace); err != nil {
+			log.Fatalf("jwt: %v", err)
+		}
+	}
+	manager := chatter.NewHubManager(roomTTL, opts...)
+	// start the hub manager (this will remove rooms nobody is in anymore),
+	// cancelling its context closes every room
+	managerCtx, closeRooms := context.WithCancel(context.Background())
+	managerDone := make(chan error, 1)
+	go func() { managerDone <- manager.Run(managerCtx, shutdownTimeout) }()
 
-		// serve the index.html file
-		http.ServeFile(w, r, "templates/index.html")
-	})
+	// we stop on SIGINT (ctrl+c) and SIGTERM (sent by most process managers)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// this will handle the websocket connection
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(hub, w, r)
-	})
+	// in development the message templates are reloaded as soon as they change
+	if *dev {
+		go chatter.WatchTemplates(ctx, *templatesDir)
+	}
+
+	// start the server in the background so we can wait for the signal
+	health := chatter.NewHealth(manager, store)
+	srv := &http.Server{Addr: *addr, Handler: chatter.AccessLog(newRouter(manager, index, routerConfig{
+		postSecret: *postSecret,
+		metrics:    promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		origins:    chatter.NewOriginPolicy(*allowedOrigins, *dev),
+		hooks:      chatter.NewHookTokens(),
+		adminToken: *adminToken,
+		bans:       bans,
+		filter:     filter,
+		identities: chatter.NewIdentities(*identitySecret),
+		sessions:   sessions,
+		github:     github,
+		tokens:     tokens,
+		csrf:       chatter.NewCSRF(*identitySecret),
+		connLimit:  chatter.NewConnLimit(*connsPerIP, metrics),
+		health:     health,
+		audit:      audit,
+		drainWait:  *drainTimeout,
+		debug:      *debug,
+	}))}
+	// behind a proxy the address of the client is in X-Forwarded-For
+	// (the requests are logged with it, the proxies come first)
+	// (only from the proxies we trust, anyone else could send the header)
+	if *trustedProxies != "" {
+		proxies, err := chatter.NewTrustedProxies(*trustedProxies)
+		if err != nil {
+			log.Fatalf("proxies: %v", err)
+		}
+		srv.Handler = proxies.Middleware(srv.Handler)
+	}
+
+	// with TLS the cookies are only sent over HTTPS and the websocket connections are wss,
+	// with Let's Encrypt :80 answers its challenges and redirects everything else to HTTPS
+	var redirect *http.Server
+	switch {
+	case *autocertDomain != "":
+		if *tlsCert != "" || *tlsKey != "" {
+			log.Fatalf("tls: -autocert-domain can't be used with -tls-cert and -tls-key")
+		}
+		certs := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(*autocertDomain, ",")...),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		srv.TLSConfig = certs.TLSConfig()
+		if srv.Addr == "" {
+			srv.Addr = ":443"
+		}
+		redirect = &http.Server{Addr: ":80", Handler: certs.HTTPHandler(nil)}
+	case *tlsCert != "" || *tlsKey != "":
+		// a certificate that doesn't go with its key is caught at startup
+		config, err := certificateConfig(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+		srv.TLSConfig = config
+	}
+	if srv.Addr == "" {
+		srv.Addr = ":3000"
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	if redirect != nil {
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// the profiles and the stats can be served on their own listener, kept off the public one
+	var debugSrv *http.Server
+	if *debugAddr != "" {
+		debugSrv = &http.Server{Addr: *debugAddr, Handler: newDebugRouter(manager)}
+		go func() {
+			if err := debugSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// we stop on the signal, or once a drain is over (the load balancers
+	// noticed we weren't ready when it started)
+	drained := false
+	select {
+	case <-ctx.Done():
+	case <-manager.Drained():
+		drained = true
+	}
+	slog.Info("shutting down")
+
+	// we stop being ready first, and give the load balancers a moment to notice
+	// before we stop accepting connections
+	health.ShuttingDown()
+	if *shutdownDelay > 0 && !drained {
+		time.Sleep(*shutdownDelay)
+	}
+
+	// as soon as the server stops accepting connections we close every room, which sends a
+	// close frame to every websocket client and ends the event streams (the server
+	// doesn't wait for hijacked websocket connections, but it does wait for the streams)
+	srv.RegisterOnShutdown(closeRooms)
+
+	// we stop accepting new connections and wait for the pending requests
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// the other listeners stop along with it, we don't return before they did
+	var others sync.WaitGroup
+	for _, other := range []*http.Server{redirect, debugSrv} {
+		if other == nil {
+			continue
+		}
+		others.Add(1)
+		go func(other *http.Server) {
+			defer others.Done()
+			if err := other.Shutdown(shutdownCtx); err != nil {
+				slog.Error("shutting a listener down", "addr", other.Addr, "err", err)
+			}
+		}(other)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutting the server down", "err", err)
+	}
+	others.Wait()
+	if err := <-managerDone; err != nil {
+		slog.Error("closing the rooms", "err", err)
+	}
+}
+
+// parseIndex parses the landing page of the templates, it needs the room name to connect
+// to the right hub and renders the recent messages with the message templates
+func parseIndex(templates fs.FS) (*template.Template, error) {
+	return template.New("index.html").Funcs(chatter.TemplateFuncs()).ParseFS(templates, "index.html")
+}
 
-	log.Fatal(http.ListenAndServe(":3000", nil))
+// certificateConfig returns the TLS config serving the certificate of the files,
+// it fails if the certificate doesn't go with the key
+func certificateConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/gorilla/websocket"
+)
+
+// selfSigned writes a self-signed certificate for 127.0.0.1 and its key to dir,
+// and returns the paths of the files along with the certificate
+func selfSigned(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServingHTTPS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := selfSigned(t, dir, "chat")
+	_, otherKey, _ := selfSigned(t, dir, "other")
+
+	// a certificate that doesn't go with its key is refused
+	if _, err := certificateConfig(certFile, otherKey); err == nil {
+		t.Error("the certificate was loaded with another key")
+	}
+	config, err := certificateConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, _ := newTestRouter(t, routerConfig{identities: chatter.NewIdentities("s3cret")})
+	srv := httptest.NewUnstartedServer(router)
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	clientTLS := &tls.Config{RootCAs: roots}
+
+	// the page is served over HTTPS, with cookies that are never sent over HTTP
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	resp, err := client.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("the page got %d", resp.StatusCode)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		t.Error("the page set no cookie")
+	}
+	for _, cookie := range cookies {
+		if !cookie.Secure {
+			t.Errorf("the cookie %s isn't secure", cookie.Name)
+		}
+	}
+
+	// and the websocket connections are wss
+	dialer := &websocket.Dialer{TLSClientConfig: clientTLS, HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.Dial("wss"+strings.TrimPrefix(srv.URL, "https")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("connecting over wss: %v", err)
+	}
+	conn.Close()
+}
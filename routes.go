@@ -0,0 +1,224 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+)
+
+// indexHistory is the number of messages the landing page is rendered with
+const indexHistory = 50
+
+// routerConfig holds the settings of the routes
+type routerConfig struct {
+	postSecret string                   // shared secret required to post messages over HTTP (empty means none)
+	metrics    http.Handler             // serves the prometheus metrics (nil disables /metrics)
+	origins    *chatter.OriginPolicy    // the pages allowed to open a websocket connection
+	hooks      *chatter.HookTokens      // tokens of the incoming webhooks
+	adminToken string                   // token of the admin endpoints (empty disables them)
+	bans       *chatter.BanList         // banned IP addresses
+	filter     *chatter.ProfanityFilter // the profanity filter (nil when disabled)
+	identities *chatter.Identities      // the identities of the visitors, kept in a cookie
+	sessions   *chatter.Sessions        // the sessions of the signed in visitors (nil lets anyone in)
+	tokens     *chatter.JWTAuth         // the tokens websocket connections authenticate with (nil if they don't)
+	github     *chatter.GitHubAuth      // signs the visitors in with GitHub (nil if it doesn't)
+	csrf       *chatter.CSRF            // keeps other sites from changing anything on behalf of our visitors (nil if nothing does)
+	connLimit  *chatter.ConnLimit       // caps the websocket connections per address (nil if they aren't)
+	health     *chatter.Health          // answers the liveness and readiness probes (nil disables them)
+	audit      *chatter.AuditLog        // records the moderation and admin actions (nil records nothing)
+	drainWait  time.Duration            // how long the clients have to leave once the server is draining
+	debug      bool                     // serve the debug endpoints without the admin token
+}
+
+// newRouter creates the router with all the routes of the chat,
+// index returns the landing page template
+func newRouter(manager *chatter.HubManager, index func() (*template.Template, error), cfg routerConfig) *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	// serveIndex renders the landing page for the room
+	serveIndex := func(w http.ResponseWriter, r *http.Request, room string) {
+
+		// the page comes with the most recent messages and the number of people in the room,
+		// the websocket connection then only replays what came after them
+		snapshot := chatter.Snapshot{Room: room}
+		if hub, err := manager.Get(room); err == nil {
+			if snapshot, err = hub.Snapshot(indexHistory); err != nil {
+				slog.Error("reading the history", "room", room, "err", err)
+				snapshot = chatter.Snapshot{Room: room}
+			}
+		}
+
+		// render the index.html template for the room
+		// (the name the visitor picked, if any, is passed on to the websocket connection,
+		// the CSRF token to the requests htmx sends)
+		data := struct {
+			chatter.Snapshot
+			Name string
+			CSRF string
+		}{snapshot, r.URL.Query().Get("name"), chatter.CSRFToken(r)}
+		page, err := index()
+		if err != nil {
+			slog.Error("parsing the landing page", "err", err)
+			http.Error(w, "Could not render the page", http.StatusInternalServerError)
+			return
+		}
+		if err := page.Execute(w, data); err != nil {
+			slog.Error("executing the landing page", "room", room, "err", err)
+		}
+	}
+
+	// the routes are method aware, so the mux answers anything that isn't a GET
+	// with a 405 (and an Allow header), and any other path with a 404
+
+	// the pages and the connections know who the visitor is (a first visit gets its identity),
+	// with sessions only signed in visitors get in
+	identify := func(next http.Handler) http.Handler {
+		return cfg.sessions.Require(cfg.identities.Middleware(next))
+	}
+
+	// the pages get the CSRF token, and the requests changing anything have to send it back
+	// (the websocket connections check their origin instead, and the requests authenticated
+	// with a token header or, like the incoming webhooks, in their path are exempt)
+	protect := cfg.csrf.Middleware
+
+	// this will handle serving the landing page
+	mux.Handle("GET /{$}", identify(protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveIndex(w, r, chatter.DefaultRoom)
+	}))))
+
+	// this will handle serving the landing page of a specific room, created on demand
+	// (a name we wouldn't create, or no name at all, gets a page saying why, and a private
+	// room takes an invite, see below)
+	mux.Handle("GET /r/{room...}", identify(protect(chatter.RoomHandler(manager, serveIndex))))
+
+	// the rooms used to be at /room/{name}, the links to them still work
+	mux.HandleFunc("GET /room/{name}", func(w http.ResponseWriter, r *http.Request) {
+		target := "/r/" + url.PathEscape(r.PathValue("name"))
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+
+	// this will handle the websocket connection
+	// (with tokens every connection needs one, admins get into full rooms)
+	// (the pages connect to /ws/{room}, the ?room= of /ws is there for the other clients)
+	ws := cfg.bans.Guard(cfg.tokens.Require(identify(chatter.AdminIdentify(cfg.adminToken, chatter.Handler(manager, cfg.origins, cfg.connLimit)))))
+	mux.Handle("GET /ws", ws)
+	mux.Handle("GET /ws/{room}", ws)
+
+	// this will handle streaming the room as server-sent events (for clients without websockets)
+	// (they authenticate and are limited the way the websocket connections are)
+	mux.Handle("GET /events", cfg.bans.Guard(cfg.tokens.Require(identify(chatter.AdminIdentify(cfg.adminToken, chatter.EventsHandler(manager, cfg.connLimit))))))
+
+	// this will handle the directory of the rooms, the landing page polls it
+	mux.Handle("GET /rooms", cfg.sessions.Require(chatter.RoomsHandler(manager)))
+
+	// this will handle creating the private rooms, and their invites
+	// (only the visitor who created a room, or an admin, manages its invites)
+	mux.Handle("POST /rooms", identify(protect(chatter.PrivateRoomHandler(manager))))
+	invites := identify(protect(chatter.InvitesHandler(manager, cfg.adminToken)))
+	mux.Handle("GET /rooms/{room}/invites", invites)
+	mux.Handle("POST /rooms/{room}/invites", invites)
+	mux.Handle("DELETE /rooms/{room}/invites/{token}", invites)
+
+	// this will handle fetching the message history without a websocket
+	mux.Handle("GET /messages", cfg.sessions.Require(chatter.HistoryHandler(manager)))
+
+	// this will handle posting messages without a websocket (bots, scripts, ...)
+	mux.Handle("POST /messages", protect(cfg.sessions.Require(chatter.PostHandler(manager, cfg.postSecret))))
+
+	// this will handle signing in and out
+	if cfg.sessions != nil {
+		login := protect(cfg.sessions.LoginHandler())
+		mux.Handle("GET /login", login)
+		mux.Handle("POST /login", login)
+		mux.Handle("POST /logout", protect(cfg.sessions.LogoutHandler()))
+	}
+	if cfg.github != nil {
+		mux.Handle("GET /auth/login", cfg.github.LoginHandler())
+		mux.Handle("GET /auth/callback", protect(cfg.github.CallbackHandler()))
+	}
+
+	// this will handle deleting any message (the authors delete theirs over the websocket)
+	mux.Handle("DELETE /messages/{id}", protect(chatter.AdminAuth(cfg.adminToken, chatter.DeleteHandler(manager))))
+
+	// this will handle pinning any message (the authors pin theirs with /pin)
+	pin := protect(chatter.AdminAuth(cfg.adminToken, chatter.PinHandler(manager)))
+	mux.Handle("PUT /messages/{id}/pin", pin)
+	mux.Handle("DELETE /messages/{id}/pin", pin)
+
+	// this will handle the incoming webhooks (Slack style), and managing their tokens
+	mux.Handle("POST /hooks/{token}", chatter.IncomingHandler(manager, cfg.hooks))
+	mux.Handle("POST /rooms/{room}/hooks", protect(chatter.HookTokensHandler(cfg.hooks, cfg.postSecret)))
+	mux.Handle("DELETE /hooks/{token}", protect(chatter.HookTokensHandler(cfg.hooks, cfg.postSecret)))
+
+	// this will handle the admin dashboard, watching a room live over its own websocket
+	mux.Handle("GET /admin", protect(chatter.DashboardHandler(manager, cfg.bans, cfg.adminToken)))
+	mux.Handle("POST /admin/login", protect(chatter.AdminLoginHandler(cfg.adminToken)))
+	mux.Handle("GET /admin/ws", chatter.AdminAuth(cfg.adminToken, chatter.DashboardSocketHandler(manager, cfg.origins)))
+
+	// this will handle the admin endpoints
+	mux.Handle("POST /admin/kick", protect(chatter.AdminAuth(cfg.adminToken, chatter.KickHandler(manager))))
+	bans := protect(chatter.AdminAuth(cfg.adminToken, chatter.BansHandler(manager, cfg.bans, cfg.audit)))
+	mux.Handle("GET /admin/bans", bans)
+	mux.Handle("POST /admin/bans", bans)
+	mux.Handle("DELETE /admin/bans/{ip}", bans)
+	mutes := protect(chatter.AdminAuth(cfg.adminToken, chatter.MutesHandler(manager)))
+	mux.Handle("GET /admin/mutes", mutes)
+	mux.Handle("POST /admin/mutes", mutes)
+	mux.Handle("DELETE /admin/mutes/{identity}", mutes)
+	mux.Handle("POST /admin/announce", protect(chatter.AdminAuth(cfg.adminToken, chatter.AnnounceHandler(manager))))
+	readOnly := protect(chatter.AdminAuth(cfg.adminToken, chatter.ReadOnlyHandler(manager)))
+	mux.Handle("PUT /admin/readonly", readOnly)
+	mux.Handle("DELETE /admin/readonly", readOnly)
+	mux.Handle("POST /admin/drain", protect(chatter.AdminAuth(cfg.adminToken, chatter.DrainHandler(manager, cfg.audit, cfg.drainWait))))
+	mux.Handle("GET /admin/clients", chatter.AdminAuth(cfg.adminToken, chatter.ClientsHandler(manager)))
+	mux.Handle("GET /admin/audit", chatter.AdminAuth(cfg.adminToken, chatter.AuditHandler(cfg.audit)))
+	mux.Handle("GET /admin/templates", chatter.AdminAuth(cfg.adminToken, chatter.TemplatesHandler()))
+	if cfg.filter != nil {
+		mux.Handle("POST /admin/profanity/reload", protect(chatter.AdminAuth(cfg.adminToken, cfg.filter.ReloadHandler(cfg.audit))))
+	}
+
+	// this will handle the snapshot of the internals of the chat, for admins
+	// (and anyone in debug mode)
+	debugStats := chatter.DebugStatsHandler(manager)
+	if !cfg.debug {
+		debugStats = chatter.AdminAuth(cfg.adminToken, debugStats)
+	}
+	mux.Handle("GET /debug/stats", debugStats)
+
+	// this will handle the liveness and readiness probes
+	if cfg.health != nil {
+		mux.Handle("GET /healthz", cfg.health.LiveHandler())
+		mux.Handle("GET /readyz", cfg.health.ReadyHandler())
+	}
+
+	// this will handle the prometheus metrics
+	if cfg.metrics != nil {
+		mux.Handle("GET /metrics", cfg.metrics)
+	}
+
+	return mux
+}
+
+// newDebugRouter creates the router of the debug listener: the profiles of net/http/pprof
+// and the snapshot of the chat, without any authentication (it isn't meant to be reachable
+// by anyone but us)
+func newDebugRouter(manager *chatter.HubManager) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/stats", chatter.DebugStatsHandler(manager))
+	return mux
+}
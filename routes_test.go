@@ -0,0 +1,328 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidk/go-htmx-chatter/chatter"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// newTestRouter returns the router with the config, the landing page only says which room it is
+func newTestRouter(t *testing.T, cfg routerConfig) (*http.ServeMux, *chatter.HubManager) {
+	t.Helper()
+	manager := chatter.NewHubManager(time.Hour)
+	t.Cleanup(func() { manager.Close(time.Second) })
+	page := template.Must(template.New("index.html").Parse(`room {{ .Room }}`))
+	return newRouter(manager, func() (*template.Template, error) { return page, nil }, cfg), manager
+}
+
+func TestRouterStatusCodes(t *testing.T) {
+	router, _ := newTestRouter(t, routerConfig{})
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string // the Allow header of a 405
+	}{
+		{http.MethodGet, "/", http.StatusOK, ""},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPut, "/", http.StatusMethodNotAllowed, "GET, HEAD"},
+		// a plain GET isn't a websocket handshake
+		{http.MethodGet, "/ws", http.StatusBadRequest, ""},
+		{http.MethodPost, "/ws", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPut, "/ws", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/nope", http.StatusNotFound, ""},
+		{http.MethodPost, "/nope", http.StatusNotFound, ""},
+		{http.MethodPut, "/nope", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("the status is %d, want %d", rec.Code, tt.status)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("the Allow header is %q, want %q", allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestTheEmbeddedPagesWorkFromAnyDirectory(t *testing.T) {
+	// run from a directory with no templates in it
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	templates := chatter.DefaultTemplates()
+	if err := chatter.LoadTemplates(templates); err != nil {
+		t.Fatal(err)
+	}
+	page, err := parseIndex(templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := chatter.NewHubManager(time.Hour)
+	t.Cleanup(func() { manager.Close(time.Second) })
+	router := newRouter(manager, func() (*template.Template, error) { return page, nil }, routerConfig{})
+
+	hub, err := manager.Get(chatter.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Publish(&chatter.Message{Kind: chatter.KindChat, Username: "bot", Text: "rendered from anywhere"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the page comes with the message rendered by the message templates
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("the landing page got %d %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{"<html", "rendered from anywhere"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("the landing page doesn't have %q:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestSignInConnectPostAndSignOut(t *testing.T) {
+	sessions, err := chatter.NewSessions("s3cret", "open sesame", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, _ := newTestRouter(t, routerConfig{sessions: sessions})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// the browser keeps its cookies and shows us the redirects
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	do := func(method, path, contentType, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set("Accept", "text/html")
+		resp, err := browser.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	post := func() int {
+		t.Helper()
+		return do(http.MethodPost, "/messages", "application/json", `{"from":"mallory","text":"hello from http"}`).StatusCode
+	}
+	dial := func() (*websocket.Conn, *http.Response, error) {
+		header := http.Header{}
+		for _, cookie := range jar.Cookies(&url.URL{Scheme: "http", Host: strings.TrimPrefix(srv.URL, "http://")}) {
+			header.Add("Cookie", cookie.String())
+		}
+		return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	}
+
+	// nobody gets in before signing in, the pages send the visitors to the login page
+	if resp := do(http.MethodGet, "/", "", ""); resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(resp.Header.Get("Location"), "/login") {
+		t.Errorf("the landing page got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if _, resp, err := dial(); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("connecting without a session got %v", resp)
+	}
+	if code := post(); code != http.StatusUnauthorized {
+		t.Errorf("posting without a session got %d", code)
+	}
+
+	// the wrong passphrase doesn't sign in, the right one does
+	form := func(password string) string {
+		return url.Values{"name": {"alice"}, "password": {password}, "next": {"/"}}.Encode()
+	}
+	if resp := do(http.MethodPost, "/login", "application/x-www-form-urlencoded", form("guess")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("signing in with the wrong passphrase got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/login", "application/x-www-form-urlencoded", form("open sesame")); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Fatalf("signing in got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// once signed in the visitor connects and posts under its name
+	conn, _, err := dial()
+	if err != nil {
+		t.Fatalf("connecting with the session: %v", err)
+	}
+	defer conn.Close()
+	if code := post(); code != http.StatusAccepted {
+		t.Fatalf("posting with the session got %d", code)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("the posted message never came: %v", err)
+		}
+		if frame := string(data); strings.Contains(frame, "hello from http") {
+			if !strings.Contains(frame, ">alice<") || strings.Contains(frame, "mallory") {
+				t.Errorf("the message isn't attributed to alice:\n%s", frame)
+			}
+			break
+		}
+	}
+
+	// signing out ends the session
+	if resp := do(http.MethodPost, "/logout", "", ""); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("signing out got %d", resp.StatusCode)
+	}
+	if code := post(); code != http.StatusUnauthorized {
+		t.Errorf("posting after signing out got %d", code)
+	}
+	if _, resp, err := dial(); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("connecting after signing out got %v", resp)
+	}
+}
+
+func TestEventStreamsAreAuthenticatedAndLimited(t *testing.T) {
+	tokens, err := chatter.NewJWTAuth("jwt-s3cret", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, _ := newTestRouter(t, routerConfig{tokens: tokens, connLimit: chatter.NewConnLimit(1, nil)})
+	// the server goes once the streams are closed (the cleanups run the other way round)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u42", "name": "alice", "exp": time.Now().Add(time.Hour).Unix()}).SignedString([]byte("jwt-s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := func(token string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/events?token=" + url.QueryEscape(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// the streams need a token, as the websocket connections do
+	if resp := stream(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("streaming without a token got %d", resp.StatusCode)
+	}
+	if resp := stream("not.a.token"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("streaming with an invalid token got %d", resp.StatusCode)
+	}
+	if resp := stream(token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("streaming with the token got %d", resp.StatusCode)
+	}
+
+	// and count against the connections an address can keep open
+	resp := stream(token)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("a stream over the limit got %d", resp.StatusCode)
+	}
+}
+
+func TestPagesCarryTheirCSRFToken(t *testing.T) {
+	page, err := parseIndex(chatter.DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := chatter.NewHubManager(time.Hour)
+	t.Cleanup(func() { manager.Close(time.Second) })
+	router := newRouter(manager, func() (*template.Template, error) { return page, nil }, routerConfig{csrf: chatter.NewCSRF("s3cret")})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// the page is rendered with the token, for htmx to send with its requests
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	resp, err := browser.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body strings.Builder
+	io.Copy(&body, resp.Body)
+	resp.Body.Close()
+	meta := regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)">`).FindStringSubmatch(body.String())
+	if meta == nil {
+		t.Fatalf("the page has no CSRF token:\n%s", body.String())
+	}
+	if !strings.Contains(body.String(), `"X-CSRF-Token": "`+meta[1]+`"`) {
+		t.Error("htmx isn't set up to send the token")
+	}
+
+	post := func(token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/messages", strings.NewReader(`{"text":"hello"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set(chatter.CSRFHeader, token)
+		}
+		resp, err := browser.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(""); code != http.StatusForbidden {
+		t.Errorf("posting without the token got %d", code)
+	}
+	if code := post(meta[1]); code != http.StatusAccepted {
+		t.Errorf("posting with the token got %d", code)
+	}
+
+	// the websocket connections check their origin instead
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("connecting without a token: %v", err)
+	}
+	conn.Close()
+}
+
+func TestProfilesAreOnlyOnTheDebugListener(t *testing.T) {
+	router, manager := newTestRouter(t, routerConfig{})
+	public := httptest.NewServer(router)
+	defer public.Close()
+	debug := httptest.NewServer(newDebugRouter(manager))
+	defer debug.Close()
+
+	get := func(srv *httptest.Server, path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, body := get(debug, "/debug/pprof/goroutine?debug=1"); code != http.StatusOK || !strings.Contains(body, "goroutine profile") {
+		t.Errorf("the debug listener got %d for the goroutines:\n%.200s", code, body)
+	}
+	if code, _ := get(debug, "/debug/stats"); code != http.StatusOK {
+		t.Errorf("the debug listener got %d for the stats", code)
+	}
+	for _, path := range []string{"/debug/pprof/goroutine?debug=1", "/debug/pprof/", "/debug/pprof/cmdline"} {
+		if code, _ := get(public, path); code != http.StatusNotFound {
+			t.Errorf("the public listener got %d for %s", code, path)
+		}
+	}
+}